@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Approvals
+// ---------------------------------------------------------------------------
+//
+// Asking the gate is open to anyone; approving is not. APPROVER_TOKENS lists
+// the people who may approve as name=token pairs, comma-separated:
+//
+//   APPROVER_TOKENS=alice=3f9c...,bob=a71e...
+//
+// An approval is a POST carrying one of the tokens as a bearer token, and is
+// recorded under the name the token belongs to, never one the caller picks.
+// Without APPROVER_TOKENS nothing can be approved, and deploys that need
+// approval wait for the budget to recover.
+
+// approvers maps approval tokens to the people they belong to.
+type approvers map[string]string
+
+func parseApprovers(s string) (approvers, error) {
+	out := make(approvers)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, "=")
+		if !ok || name == "" || token == "" {
+			return nil, errors.New("entries must be name=token")
+		}
+		if _, dup := out[token]; dup {
+			return nil, fmt.Errorf("%s shares a token with %s", name, out[token])
+		}
+		out[token] = name
+	}
+	return out, nil
+}
+
+// approver returns who token belongs to, or "" if it is nobody's. Every
+// token is compared, in constant time, so the answer takes as long either way.
+func (a approvers) approver(token string) string {
+	var name string
+	for t, n := range a {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			name = n
+		}
+	}
+	return name
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Error budgets from Prometheus
+// ---------------------------------------------------------------------------
+//
+// The budgets are the slo:error_budget:<sli>_remaining series recorded by
+// monitoring/prometheus/rules/slo-rules.yml, one per SLO of a service, told
+// apart by their slo_type label. Any SLO added to the spec is picked up
+// without changes here.
+
+const budgetQuery = `{__name__=~"slo:error_budget:[a-z0-9_]+_remaining",service=%q}`
+
+type budgetReader interface {
+	// budgets returns the remaining budget of each of service's SLOs, by
+	// SLO name. No SLOs and no error means the service has none recorded.
+	budgets(ctx context.Context, service string) (map[string]float64, error)
+}
+
+type promBudgets struct {
+	baseURL string
+	client  *http.Client
+}
+
+type promResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		Result []struct {
+			Metric map[string]string `json:"metric"`
+			Value  [2]any            `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func (p *promBudgets) budgets(ctx context.Context, service string) (map[string]float64, error) {
+	q := url.Values{"query": {fmt.Sprintf(budgetQuery, service)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(p.baseURL, "/")+"/api/v1/query?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body promResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("prometheus: %s: %w", resp.Status, err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("prometheus: %s: %s", body.ErrorType, body.Error)
+	}
+	out := make(map[string]float64, len(body.Data.Result))
+	for _, s := range body.Data.Result {
+		raw, _ := s.Value[1].(string)
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("prometheus: bad sample value %v", s.Value[1])
+		}
+		slo := s.Metric["slo_type"]
+		if slo == "" {
+			slo = strings.TrimSuffix(strings.TrimPrefix(s.Metric["__name__"], "slo:error_budget:"), "_remaining")
+		}
+		// A NaN budget (no traffic in the window) says nothing.
+		if math.IsNaN(v) {
+			continue
+		}
+		if old, ok := out[slo]; !ok || v < old {
+			out[slo] = v
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ---------------------------------------------------------------------------
+// Prometheus metrics
+// ---------------------------------------------------------------------------
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total number of HTTP requests processed.",
+		},
+		[]string{"method", "path", "status"},
+	)
+
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Duration of HTTP requests in seconds.",
+			Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0},
+		},
+		[]string{"method", "path"},
+	)
+
+	gateDecisionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "deploy_gate_decisions_total",
+			Help: "Deploy gate decisions, by service, change kind and decision (allow, deny, require_approval).",
+		},
+		[]string{"service", "change", "decision"},
+	)
+
+	gateApprovalsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "deploy_gate_approvals_total",
+			Help: "Deploys that needed approval and were let through with an approver named.",
+		},
+		[]string{"service", "change"},
+	)
+
+	gateBudgetRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "deploy_gate_budget_remaining",
+			Help: "Remaining error budget the last decision for a service was based on, by SLO.",
+		},
+		[]string{"service", "slo"},
+	)
+
+	gateBudgetErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "deploy_gate_budget_read_errors_total",
+			Help: "Failed reads of error budgets from Prometheus.",
+		},
+	)
+)
+
+// ---------------------------------------------------------------------------
+// Domain types
+// ---------------------------------------------------------------------------
+
+// gateDecision is the answer to whether a change to a service may roll out.
+type gateDecision struct {
+	Service     string             `json:"service"`
+	Change      string             `json:"change"`
+	Decision    decision           `json:"decision"`
+	Allowed     bool               `json:"allowed"`
+	Reason      string             `json:"reason"`
+	Remaining   *float64           `json:"budget_remaining"` // lowest across SLOs; null if unknown
+	Budgets     map[string]float64 `json:"budgets"`
+	ApprovedBy  string             `json:"approved_by,omitempty"`
+	EvaluatedAt time.Time          `json:"evaluated_at"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// ---------------------------------------------------------------------------
+// Server
+// ---------------------------------------------------------------------------
+
+type Server struct {
+	logger    *slog.Logger
+	policy    *policy
+	budgets   budgetReader
+	approvers approvers
+	services  map[string]bool // GATED_SERVICES
+	timeout   time.Duration   // for reading budgets
+	ready     atomic.Bool
+}
+
+// defaultServices are the services gated unless GATED_SERVICES says otherwise.
+const defaultServices = "order-service,payment-service,user-service"
+
+func newServer(logger *slog.Logger, p *policy, budgets budgetReader) *Server {
+	return &Server{logger: logger, policy: p, budgets: budgets, services: parseServices(defaultServices),
+		timeout: 5 * time.Second}
+}
+
+// parseServices reads a comma-separated list of service names. Only those
+// are gated: a name is a label on the gate's metrics, so the caller does not
+// get to make them up.
+func parseServices(s string) map[string]bool {
+	out := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			out[name] = true
+		}
+	}
+	return out
+}
+
+// decide reads the service's budgets and applies the policy to a change of
+// kind. An approver, who must have been authenticated by the caller, turns
+// require_approval into allow.
+func (s *Server) decide(ctx context.Context, service, kind, approver string) gateDecision {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	d := gateDecision{Service: service, Change: kind, EvaluatedAt: time.Now().UTC()}
+	budgets, err := s.budgets.budgets(ctx, service)
+	if err != nil {
+		gateBudgetErrorsTotal.Inc()
+		s.logger.Error("failed to read error budget", "service", service, "error", err)
+	}
+	d.Budgets = budgets
+	lowest := math.Inf(1)
+	for slo, v := range budgets {
+		gateBudgetRemaining.WithLabelValues(service, slo).Set(v)
+		lowest = math.Min(lowest, v)
+	}
+	known := len(budgets) > 0
+	if known {
+		d.Remaining = &lowest
+	}
+
+	d.Decision, d.Reason = s.policy.evaluate(kind, lowest, known)
+	if err != nil {
+		d.Reason += ": " + err.Error()
+	} else if !known {
+		d.Reason += ": no SLO budget recorded for " + service
+	}
+	switch d.Decision {
+	case decisionAllow:
+	case decisionDeny:
+		d.Reason += ", " + kind + " deploys are frozen"
+	case decisionRequireApproval:
+		d.Reason += ", " + kind + " deploys need approval"
+		if approver != "" {
+			d.Decision, d.ApprovedBy = decisionAllow, approver
+			d.Reason += " (approved by " + approver + ")"
+			gateApprovalsTotal.WithLabelValues(service, kind).Inc()
+		}
+	}
+	d.Allowed = d.Decision == decisionAllow
+	gateDecisionsTotal.WithLabelValues(service, kind, string(d.Decision)).Inc()
+	return d
+}
+
+// ---------------------------------------------------------------------------
+// main
+// ---------------------------------------------------------------------------
+
+func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	prometheus.MustRegister(
+		httpRequestsTotal, httpRequestDuration,
+		gateDecisionsTotal, gateApprovalsTotal, gateBudgetRemaining, gateBudgetErrorsTotal,
+	)
+
+	p := defaultPolicy()
+	if path := getEnv("DEPLOY_POLICY_FILE", ""); path != "" {
+		var err error
+		if p, err = loadPolicy(path); err != nil {
+			logger.Error("invalid deploy policy", "error", err)
+			os.Exit(2)
+		}
+	}
+	timeout, err := time.ParseDuration(getEnv("PROMETHEUS_TIMEOUT", "5s"))
+	if err != nil {
+		logger.Error("invalid configuration", "error", fmt.Errorf("PROMETHEUS_TIMEOUT: %w", err))
+		os.Exit(2)
+	}
+	budgets := &promBudgets{
+		baseURL: getEnv("PROMETHEUS_URL", "http://prometheus:9090"),
+		client:  &http.Client{},
+	}
+	srv := newServer(logger, p, budgets)
+	srv.timeout = timeout
+	if srv.approvers, err = parseApprovers(getEnv("APPROVER_TOKENS", "")); err != nil {
+		logger.Error("invalid configuration", "error", fmt.Errorf("APPROVER_TOKENS: %w", err))
+		os.Exit(2)
+	}
+	if srv.services = parseServices(getEnv("GATED_SERVICES", defaultServices)); len(srv.services) == 0 {
+		logger.Error("invalid configuration", "error", errors.New("GATED_SERVICES: no services"))
+		os.Exit(2)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(srv, os.Args[2:]))
+	}
+
+	port := getEnv("PORT", "8086")
+	httpServer := &http.Server{
+		Addr:         ":" + port,
+		Handler:      srv.routes(),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+	srv.ready.Store(true)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		logger.Info("deploy-gate starting", "port", port, "prometheus", budgets.baseURL, "tiers", len(p.Tiers),
+			"approvers", len(srv.approvers))
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-stop
+	logger.Info("shutting down")
+	srv.ready.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Error("forced shutdown", "error", err)
+	}
+	logger.Info("server stopped")
+}
+
+// runCheck is the check subcommand, for release tooling that would rather
+// run a command than call the API:
+//
+//	deploy-gate check [-change feature] <service>
+//
+// An approver approves by setting DEPLOY_APPROVAL_TOKEN to their token from
+// APPROVER_TOKENS; it is read from the environment to keep it out of process
+// listings. It prints the decision as JSON and exits 0 if the deploy may go
+// ahead, 1 if it is denied, 3 if it needs approval and 2 on bad usage.
+func runCheck(srv *Server, args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	kind := fs.String("change", "feature", "kind of change: "+strings.Join(changeKinds, ", "))
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || !validChange(*kind) {
+		fmt.Fprintln(os.Stderr, "usage: deploy-gate check [-change feature|fix|rollback] <service>")
+		return 2
+	}
+	if !srv.services[fs.Arg(0)] {
+		fmt.Fprintf(os.Stderr, "%s is not in GATED_SERVICES\n", fs.Arg(0))
+		return 2
+	}
+	var approver string
+	if token := getEnv("DEPLOY_APPROVAL_TOKEN", ""); token != "" {
+		if approver = srv.approvers.approver(token); approver == "" {
+			fmt.Fprintln(os.Stderr, "DEPLOY_APPROVAL_TOKEN is not in APPROVER_TOKENS")
+			return 2
+		}
+	}
+	d := srv.decide(context.Background(), fs.Arg(0), *kind, approver)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(d)
+	switch d.Decision {
+	case decisionAllow:
+		return 0
+	case decisionRequireApproval:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// routes builds the HTTP router. It is separate from main so tests can drive
+// the full middleware stack.
+func (s *Server) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.RealIP)
+	r.Use(middleware.RequestID)
+	r.Use(s.metricsMiddleware)
+	r.Use(middleware.Recoverer)
+
+	r.Get("/healthz", s.handleHealthz)
+	r.Get("/readyz", s.handleReadyz)
+	r.Handle("/metrics", promhttp.Handler())
+
+	r.Get("/v1/deploy-gate/{service}", s.handleDeployGate)
+	r.Post("/v1/deploy-gate/{service}/approvals", s.handleApproval)
+	r.Get("/v1/policy", s.handlePolicy)
+	return r
+}
+
+// ---------------------------------------------------------------------------
+// Middleware
+// ---------------------------------------------------------------------------
+
+func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		duration := time.Since(start).Seconds()
+		status := fmt.Sprintf("%d", ww.Status())
+		path := chi.RouteContext(r.Context()).RoutePattern()
+		if path == "" {
+			path = r.URL.Path
+		}
+		httpRequestsTotal.WithLabelValues(r.Method, path, status).Inc()
+		httpRequestDuration.WithLabelValues(r.Method, path).Observe(duration)
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Handlers
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	if !s.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// handleDeployGate answers GET /v1/deploy-gate/{service}?change=feature.
+// The decision is in the body; the status is 200 whatever it is, so callers
+// tell a denied deploy from a broken gate. Approvals are not taken here: a
+// GET reports require_approval for what needs it.
+func (s *Server) handleDeployGate(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("approved_by") {
+		writeError(w, "approve with POST /v1/deploy-gate/{service}/approvals and an approver token", http.StatusBadRequest)
+		return
+	}
+	s.respondDecision(w, r, "")
+}
+
+// handleApproval answers POST /v1/deploy-gate/{service}/approvals?change=fix,
+// which approves the deploy in the name of the bearer token's owner if it
+// needs approval. The decision is returned as for a GET.
+func (s *Server) handleApproval(w http.ResponseWriter, r *http.Request) {
+	if !s.services[chi.URLParam(r, "service")] {
+		writeError(w, "unknown service", http.StatusNotFound)
+		return
+	}
+	if len(s.approvers) == 0 {
+		writeError(w, "approvals are disabled: APPROVER_TOKENS is not set", http.StatusForbidden)
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	approver := s.approvers.approver(token)
+	if approver == "" {
+		s.logger.Warn("deploy approval rejected: unknown approver token",
+			"service", chi.URLParam(r, "service"), "request_id", middleware.GetReqID(r.Context()))
+		w.Header().Set("WWW-Authenticate", `Bearer realm="deploy-gate"`)
+		writeError(w, "an approver token is required", http.StatusUnauthorized)
+		return
+	}
+	s.respondDecision(w, r, approver)
+}
+
+func (s *Server) respondDecision(w http.ResponseWriter, r *http.Request, approver string) {
+	service := chi.URLParam(r, "service")
+	if !s.services[service] {
+		writeError(w, "unknown service", http.StatusNotFound)
+		return
+	}
+	kind := r.URL.Query().Get("change")
+	if kind == "" {
+		kind = "feature"
+	}
+	if !validChange(kind) {
+		writeError(w, fmt.Sprintf("unknown change %q (want %s)", kind, strings.Join(changeKinds, ", ")), http.StatusBadRequest)
+		return
+	}
+	d := s.decide(r.Context(), service, kind, approver)
+	s.logger.Info("deploy gate decision",
+		"service", service, "change", kind, "decision", d.Decision, "reason", d.Reason,
+		"approved_by", d.ApprovedBy, "request_id", middleware.GetReqID(r.Context()))
+	writeJSON(w, http.StatusOK, d)
+}
+
+// handlePolicy returns the policy in effect.
+func (s *Server) handlePolicy(w http.ResponseWriter, _ *http.Request) {
+	tiers := append([]policyTier(nil), s.policy.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Below > tiers[j].Below })
+	writeJSON(w, http.StatusOK, policy{Tiers: tiers, OnUnknown: s.policy.OnUnknown})
+}
+
+// ---------------------------------------------------------------------------
+// Helpers
+// ---------------------------------------------------------------------------
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, msg string, code int) {
+	writeJSON(w, code, ErrorResponse{Error: msg, Code: code})
+}
+
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeBudgets serves fixed budgets, or err.
+type fakeBudgets struct {
+	byService map[string]map[string]float64
+	err       error
+}
+
+func (f *fakeBudgets) budgets(_ context.Context, service string) (map[string]float64, error) {
+	return f.byService[service], f.err
+}
+
+func newTestServer(t *testing.T, budgets budgetReader) *Server {
+	t.Helper()
+	return newServer(slog.New(slog.NewTextHandler(io.Discard, nil)), defaultPolicy(), budgets)
+}
+
+func getDecision(t *testing.T, srv *Server, url string) (int, gateDecision) {
+	t.Helper()
+	req := httptest.NewRequest("GET", url, nil)
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, req)
+	var d gateDecision
+	if rr.Code == http.StatusOK {
+		if err := json.NewDecoder(rr.Body).Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rr.Code, d
+}
+
+func TestHealthzEndpoint(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newTestServer(t, &fakeBudgets{}).routes().ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestReadyzEndpoint(t *testing.T) {
+	srv := newTestServer(t, &fakeBudgets{})
+	rr := httptest.NewRecorder()
+	srv.handleReadyz(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("not ready: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	srv.ready.Store(true)
+	rr = httptest.NewRecorder()
+	srv.handleReadyz(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("ready: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestDeployGateEndpoint(t *testing.T) {
+	srv := newTestServer(t, &fakeBudgets{byService: map[string]map[string]float64{
+		"order-service":   {"availability": 0.9, "latency": 0.6},
+		"payment-service": {"availability": 0.2, "latency": 0.95},
+		"user-service":    {"availability": 0.05},
+	}})
+	srv.services["inventory-service"] = true
+
+	for _, tc := range []struct {
+		url  string
+		want decision
+	}{
+		{"/v1/deploy-gate/order-service", decisionAllow},
+		{"/v1/deploy-gate/payment-service?change=feature", decisionDeny},
+		{"/v1/deploy-gate/payment-service?change=fix", decisionAllow},
+		{"/v1/deploy-gate/user-service?change=fix", decisionRequireApproval},
+		{"/v1/deploy-gate/user-service?change=rollback", decisionAllow},
+		// No SLO recorded for the service: rollbacks still go ahead.
+		{"/v1/deploy-gate/inventory-service?change=fix", decisionRequireApproval},
+		{"/v1/deploy-gate/inventory-service?change=rollback", decisionAllow},
+	} {
+		code, d := getDecision(t, srv, tc.url)
+		if code != http.StatusOK {
+			t.Errorf("%s: status %d", tc.url, code)
+			continue
+		}
+		if d.Decision != tc.want || d.Allowed != (tc.want == decisionAllow) {
+			t.Errorf("%s: got %s (allowed=%v), want %s: %s", tc.url, d.Decision, d.Allowed, tc.want, d.Reason)
+		}
+	}
+
+	// A service is judged on its lowest budget.
+	_, d := getDecision(t, srv, "/v1/deploy-gate/payment-service")
+	if d.Remaining == nil || *d.Remaining != 0.2 || len(d.Budgets) != 2 {
+		t.Errorf("payment-service budgets: got %v from %v", d.Remaining, d.Budgets)
+	}
+	if want := "error budget 20% is below 25%, feature deploys are frozen"; d.Reason != want {
+		t.Errorf("reason: got %q, want %q", d.Reason, want)
+	}
+
+	// Naming an approver in the query approves nothing.
+	if code, _ := getDecision(t, srv, "/v1/deploy-gate/user-service?change=fix&approved_by=alice"); code != http.StatusBadRequest {
+		t.Errorf("approved_by on a GET: got %d, want 400", code)
+	}
+
+	if code, _ := getDecision(t, srv, "/v1/deploy-gate/order-service?change=hotfix"); code != http.StatusBadRequest {
+		t.Errorf("unknown change: got %d, want 400", code)
+	}
+
+	// Services not gated are turned away before anything is recorded.
+	decisions, budgets := testutil.CollectAndCount(gateDecisionsTotal), testutil.CollectAndCount(gateBudgetRemaining)
+	for _, url := range []string{"/v1/deploy-gate/made-up-service", "/v1/deploy-gate/made-up-service?change=rollback"} {
+		if code, _ := getDecision(t, srv, url); code != http.StatusNotFound {
+			t.Errorf("%s: got %d, want 404", url, code)
+		}
+	}
+	if testutil.CollectAndCount(gateDecisionsTotal) != decisions || testutil.CollectAndCount(gateBudgetRemaining) != budgets {
+		t.Error("unknown service added metric series")
+	}
+}
+
+func TestDeployGateApprovals(t *testing.T) {
+	srv := newTestServer(t, &fakeBudgets{byService: map[string]map[string]float64{
+		"user-service": {"availability": 0.05},
+	}})
+	approve := func(url, token string) (int, gateDecision) {
+		t.Helper()
+		req := httptest.NewRequest("POST", url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, req)
+		var d gateDecision
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&d); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, d
+	}
+
+	if code, _ := approve("/v1/deploy-gate/user-service/approvals?change=fix", "t-alice"); code != http.StatusForbidden {
+		t.Errorf("without APPROVER_TOKENS: got %d, want 403", code)
+	}
+
+	var err error
+	if srv.approvers, err = parseApprovers("alice=t-alice, bob=t-bob"); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"", "t-mallory"} {
+		if code, _ := approve("/v1/deploy-gate/user-service/approvals?change=fix", token); code != http.StatusUnauthorized {
+			t.Errorf("token %q: got %d, want 401", token, code)
+		}
+	}
+
+	// The token names the approver. It lets through what needs approval,
+	// not what is denied.
+	_, d := approve("/v1/deploy-gate/user-service/approvals?change=fix", "t-bob")
+	if d.Decision != decisionAllow || d.ApprovedBy != "bob" || !strings.Contains(d.Reason, "approved by bob") {
+		t.Errorf("approved fix: got %+v", d)
+	}
+	_, d = approve("/v1/deploy-gate/user-service/approvals?change=feature", "t-bob")
+	if d.Decision != decisionDeny || d.ApprovedBy != "" {
+		t.Errorf("approved feature: got %+v, want deny", d)
+	}
+
+	for _, bad := range []string{"alice", "alice=", "alice=t-1,bob=t-1"} {
+		if _, err := parseApprovers(bad); err == nil {
+			t.Errorf("APPROVER_TOKENS=%s: expected error", bad)
+		}
+	}
+}
+
+func TestDeployGateBudgetUnreadable(t *testing.T) {
+	srv := newTestServer(t, &fakeBudgets{err: errors.New("connection refused")})
+	_, d := getDecision(t, srv, "/v1/deploy-gate/order-service?change=feature")
+	if d.Decision != decisionRequireApproval || d.Remaining != nil {
+		t.Errorf("got %+v, want require_approval with no budget", d)
+	}
+	if !strings.Contains(d.Reason, "connection refused") {
+		t.Errorf("reason should say why the budget is unknown: %q", d.Reason)
+	}
+}
+
+func TestPromBudgets(t *testing.T) {
+	var query string
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"__name__":"slo:error_budget:availability_remaining","service":"order-service","slo_type":"availability"},"value":[1700000000,"0.42"]},
+			{"metric":{"__name__":"slo:error_budget:latency_remaining","service":"order-service","slo_type":"latency"},"value":[1700000000,"NaN"]},
+			{"metric":{"__name__":"slo:error_budget:errors_remaining","service":"order-service"},"value":[1700000000,"-0.5"]}
+		]}}`))
+	}))
+	defer prom.Close()
+
+	p := &promBudgets{baseURL: prom.URL + "/", client: http.DefaultClient}
+	got, err := p.budgets(context.Background(), "order-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, `service="order-service"`) || !strings.Contains(query, "_remaining") {
+		t.Errorf("unexpected query %s", query)
+	}
+	if len(got) != 2 || got["availability"] != 0.42 || got["errors"] != -0.5 {
+		t.Errorf("got %v, want availability 0.42 and errors -0.5 without the NaN latency", got)
+	}
+	if _, ok := got["latency"]; ok {
+		t.Errorf("NaN budget should be skipped: %v", got)
+	}
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+	}))
+	defer bad.Close()
+	p = &promBudgets{baseURL: bad.URL, client: http.DefaultClient}
+	if _, err := p.budgets(context.Background(), "order-service"); err == nil || !strings.Contains(err.Error(), "bad_data") {
+		t.Errorf("API error: got %v", err)
+	}
+}
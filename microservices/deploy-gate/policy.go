@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// ---------------------------------------------------------------------------
+// Error budget policy
+// ---------------------------------------------------------------------------
+//
+// A policy is a list of tiers, each applying below a level of remaining
+// error budget (1.0 = untouched, 0 = exhausted). The tier with the lowest
+// level still above the budget applies, and says what happens to each kind
+// of change; kinds it does not name are allowed. DEPLOY_POLICY_FILE names a
+// JSON file that replaces the default:
+//
+//   {
+//     "tiers": [
+//       {"below": 0.25, "decisions": {"feature": "deny"}},
+//       {"below": 0.10, "decisions": {"feature": "deny", "fix": "require_approval"}}
+//     ],
+//     "on_unknown": "require_approval"
+//   }
+//
+// A service is judged on its lowest budget across its SLOs. on_unknown is
+// the decision for features and fixes when no budget can be read, because
+// Prometheus is down or the service has no SLO. Rollbacks go ahead then
+// whatever it says: rolling back is how such an outage is usually ended.
+
+type decision string
+
+const (
+	decisionAllow           decision = "allow"
+	decisionDeny            decision = "deny"
+	decisionRequireApproval decision = "require_approval"
+)
+
+// changeKinds are the kinds of change a deploy can declare.
+var changeKinds = []string{"feature", "fix", "rollback"}
+
+type policyTier struct {
+	Below     float64             `json:"below"`
+	Decisions map[string]decision `json:"decisions"`
+}
+
+type policy struct {
+	Tiers     []policyTier `json:"tiers"`
+	OnUnknown decision     `json:"on_unknown"`
+}
+
+func defaultPolicy() *policy {
+	return &policy{
+		Tiers: []policyTier{
+			{Below: 0.10, Decisions: map[string]decision{"feature": decisionDeny, "fix": decisionRequireApproval}},
+			{Below: 0.25, Decisions: map[string]decision{"feature": decisionDeny}},
+		},
+		OnUnknown: decisionRequireApproval,
+	}
+}
+
+func loadPolicy(path string) (*policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.OnUnknown == "" {
+		p.OnUnknown = decisionRequireApproval
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *policy) validate() error {
+	if !p.OnUnknown.valid() {
+		return fmt.Errorf("on_unknown: unknown decision %q", p.OnUnknown)
+	}
+	seen := map[float64]bool{}
+	for i, t := range p.Tiers {
+		if t.Below <= 0 || t.Below > 1 || seen[t.Below] {
+			return fmt.Errorf("tiers[%d]: below must be unique and in (0, 1]", i)
+		}
+		seen[t.Below] = true
+		for kind, d := range t.Decisions {
+			if !validChange(kind) {
+				return fmt.Errorf("tiers[%d]: unknown change kind %q", i, kind)
+			}
+			if !d.valid() {
+				return fmt.Errorf("tiers[%d]: %s: unknown decision %q", i, kind, d)
+			}
+		}
+	}
+	// Evaluate from the strictest tier up.
+	sort.Slice(p.Tiers, func(i, j int) bool { return p.Tiers[i].Below < p.Tiers[j].Below })
+	return nil
+}
+
+func (d decision) valid() bool {
+	return d == decisionAllow || d == decisionDeny || d == decisionRequireApproval
+}
+
+func validChange(kind string) bool {
+	for _, k := range changeKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate decides on a change of kind given the lowest remaining budget.
+// known is false when no budget could be read.
+func (p *policy) evaluate(kind string, remaining float64, known bool) (decision, string) {
+	if !known {
+		if kind == "rollback" {
+			return decisionAllow, "error budget unknown"
+		}
+		return p.OnUnknown, "error budget unknown"
+	}
+	for _, t := range p.Tiers {
+		if remaining >= t.Below {
+			continue
+		}
+		d, ok := t.Decisions[kind]
+		if !ok {
+			d = decisionAllow
+		}
+		return d, fmt.Sprintf("error budget %s is below %s", percent(remaining), percent(t.Below))
+	}
+	return decisionAllow, fmt.Sprintf("error budget %s is healthy", percent(remaining))
+}
+
+// percent formats a ratio as a percentage to one decimal at most.
+func percent(f float64) string {
+	return strconv.FormatFloat(math.Round(f*1000)/10, 'f', -1, 64) + "%"
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	p := defaultPolicy()
+	for _, tc := range []struct {
+		kind      string
+		remaining float64
+		known     bool
+		want      decision
+		reason    string
+	}{
+		{"feature", 0.80, true, decisionAllow, "error budget 80% is healthy"},
+		{"feature", 0.25, true, decisionAllow, "error budget 25% is healthy"},
+		{"feature", 0.249, true, decisionDeny, "error budget 24.9% is below 25%"},
+		{"fix", 0.20, true, decisionAllow, "error budget 20% is below 25%"},
+		{"feature", 0.05, true, decisionDeny, "error budget 5% is below 10%"},
+		{"fix", 0.05, true, decisionRequireApproval, "error budget 5% is below 10%"},
+		{"fix", -0.3, true, decisionRequireApproval, "error budget -30% is below 10%"},
+		{"rollback", -0.3, true, decisionAllow, "error budget -30% is below 10%"},
+		{"fix", 0, false, decisionRequireApproval, "error budget unknown"},
+		{"rollback", 0, false, decisionAllow, "error budget unknown"},
+	} {
+		d, reason := p.evaluate(tc.kind, tc.remaining, tc.known)
+		if d != tc.want || reason != tc.reason {
+			t.Errorf("%s at %v (known=%v): got %s %q, want %s %q", tc.kind, tc.remaining, tc.known, d, reason, tc.want, tc.reason)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	write := func(body string) string {
+		path := filepath.Join(dir, "policy.json")
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	p, err := loadPolicy(write(`{"tiers": [
+		{"below": 0.5, "decisions": {"feature": "require_approval"}},
+		{"below": 0.2, "decisions": {"feature": "deny", "fix": "deny"}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.OnUnknown != decisionRequireApproval {
+		t.Errorf("on_unknown: got %q, want the require_approval default", p.OnUnknown)
+	}
+	// Tiers are applied strictest first whatever order the file lists them.
+	if d, _ := p.evaluate("fix", 0.1, true); d != decisionDeny {
+		t.Errorf("fix at 10%%: got %s, want deny", d)
+	}
+	if d, _ := p.evaluate("feature", 0.3, true); d != decisionRequireApproval {
+		t.Errorf("feature at 30%%: got %s, want require_approval", d)
+	}
+
+	for body, want := range map[string]string{
+		`{"tiers": [{"below": 0}]}`:                                      "below must be unique",
+		`{"tiers": [{"below": 0.2}, {"below": 0.2}]}`:                    "below must be unique",
+		`{"tiers": [{"below": 0.2, "decisions": {"hotfix": "deny"}}]}`:   `unknown change kind "hotfix"`,
+		`{"tiers": [{"below": 0.2, "decisions": {"feature": "block"}}]}`: `unknown decision "block"`,
+		`{"on_unknown": "maybe"}`:                                        `on_unknown: unknown decision "maybe"`,
+		`{"tiers": {}}`:                                                  "cannot unmarshal",
+	} {
+		if _, err := loadPolicy(write(body)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want error containing %q", body, err, want)
+		}
+	}
+}
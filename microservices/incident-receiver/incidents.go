@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------------------------------------------------------------------------
+// Incidents
+// ---------------------------------------------------------------------------
+//
+// Alertmanager POSTs a notification for a group of alerts each time the
+// group changes and again every repeat_interval, so the same alert arrives
+// many times. Each alert carries a fingerprint of its labels: the first
+// firing notification for a fingerprint opens an incident, later ones are
+// counted on it, and the first resolved one closes it. An alert that fires
+// again after being resolved opens a new incident.
+//
+// Time to acknowledge is measured from opening to POST
+// /api/incidents/{id}/ack, time to resolve from opening to the resolved
+// notification, both by severity. An incident resolved before anyone
+// acknowledged it counts towards MTTR only.
+
+type incidentStatus string
+
+const (
+	statusOpen         incidentStatus = "open"
+	statusAcknowledged incidentStatus = "acknowledged"
+	statusResolved     incidentStatus = "resolved"
+)
+
+// alertmanagerPayload is the body of an Alertmanager webhook notification,
+// version 4.
+type alertmanagerPayload struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []alert           `json:"alerts"`
+}
+
+type alert struct {
+	Status       string            `json:"status"` // firing or resolved
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+type incident struct {
+	ID             string            `json:"id"`
+	Fingerprint    string            `json:"fingerprint"`
+	AlertName      string            `json:"alertname"`
+	Severity       string            `json:"severity"`
+	Service        string            `json:"service,omitempty"`
+	Summary        string            `json:"summary,omitempty"`
+	Status         incidentStatus    `json:"status"`
+	Labels         map[string]string `json:"labels"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	GeneratorURL   string            `json:"generator_url,omitempty"`
+	StartsAt       time.Time         `json:"starts_at"` // when the alert started firing
+	OpenedAt       time.Time         `json:"opened_at"`
+	AcknowledgedAt *time.Time        `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string            `json:"acknowledged_by,omitempty"`
+	ResolvedAt     *time.Time        `json:"resolved_at,omitempty"`
+	Notifications  int               `json:"notifications"` // firing notifications received
+	LastNotifiedAt time.Time         `json:"last_notified_at"`
+	Diagnostics    []diagnostic      `json:"diagnostics,omitempty"` // from the alert's runbook
+}
+
+// ingestResult counts what a notification did, by alert.
+type ingestResult struct {
+	Opened     int `json:"opened"`
+	Duplicates int `json:"duplicates"`
+	Resolved   int `json:"resolved"`
+	Stale      int `json:"stale"` // resolved alerts with no open incident
+}
+
+var (
+	errNotFound       = errors.New("incident not found")
+	errAlreadyHandled = errors.New("incident already acknowledged or resolved")
+)
+
+type incidentTracker struct {
+	logger *slog.Logger
+	store  *incidentStore
+	now    func() time.Time
+	onOpen func(incident) // called with each new incident; must not block
+
+	mu        sync.RWMutex
+	incidents []*incident          // oldest first
+	byID      map[string]*incident // all incidents
+	open      map[string]*incident // unresolved, by fingerprint
+	seq       int
+}
+
+// newIncidentTracker resumes from the incidents loaded from the store.
+func newIncidentTracker(logger *slog.Logger, store *incidentStore, loaded []*incident) *incidentTracker {
+	t := &incidentTracker{
+		logger: logger,
+		store:  store,
+		now:    time.Now,
+		byID:   make(map[string]*incident),
+		open:   make(map[string]*incident),
+	}
+	for _, inc := range loaded {
+		t.incidents = append(t.incidents, inc)
+		t.byID[inc.ID] = inc
+		if inc.Status != statusResolved {
+			t.open[inc.Fingerprint] = inc
+			incidentsOpen.WithLabelValues(inc.Severity).Inc()
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(inc.ID, "inc-")); err == nil && n > t.seq {
+			t.seq = n
+		}
+	}
+	return t
+}
+
+func (p *alertmanagerPayload) validate() error {
+	if p.Version != "4" {
+		return fmt.Errorf("unsupported webhook version %q, want \"4\"", p.Version)
+	}
+	for i, a := range p.Alerts {
+		if a.Fingerprint == "" {
+			return fmt.Errorf("alerts[%d]: missing fingerprint", i)
+		}
+		if a.Status != "firing" && a.Status != "resolved" {
+			return fmt.Errorf("alerts[%d]: unknown status %q", i, a.Status)
+		}
+	}
+	return nil
+}
+
+// ingest applies a validated notification.
+func (t *incidentTracker) ingest(p *alertmanagerPayload) ingestResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var res ingestResult
+	now := t.now().UTC()
+	for _, a := range p.Alerts {
+		inc, isOpen := t.open[a.Fingerprint]
+		switch {
+		case a.Status == "firing" && isOpen:
+			inc.Notifications++
+			inc.LastNotifiedAt = now
+			inc.Annotations = a.Annotations
+			res.Duplicates++
+			alertsReceivedTotal.WithLabelValues(a.Status, "duplicate").Inc()
+
+		case a.Status == "firing":
+			t.seq++
+			inc = &incident{
+				ID:             fmt.Sprintf("inc-%06d", t.seq),
+				Fingerprint:    a.Fingerprint,
+				AlertName:      a.Labels["alertname"],
+				Severity:       a.Labels["severity"],
+				Service:        a.Labels["service"],
+				Summary:        a.Annotations["summary"],
+				Status:         statusOpen,
+				Labels:         a.Labels,
+				Annotations:    a.Annotations,
+				GeneratorURL:   a.GeneratorURL,
+				StartsAt:       a.StartsAt,
+				OpenedAt:       now,
+				Notifications:  1,
+				LastNotifiedAt: now,
+			}
+			if inc.Severity == "" {
+				inc.Severity = "none"
+			}
+			t.incidents = append(t.incidents, inc)
+			t.byID[inc.ID] = inc
+			t.open[a.Fingerprint] = inc
+			res.Opened++
+			alertsReceivedTotal.WithLabelValues(a.Status, "opened").Inc()
+			incidentsOpen.WithLabelValues(inc.Severity).Inc()
+			t.logger.Info("incident opened", "id", inc.ID, "alertname", inc.AlertName,
+				"severity", inc.Severity, "service", inc.Service, "fingerprint", inc.Fingerprint)
+			if t.onOpen != nil {
+				t.onOpen(*inc)
+			}
+
+		case isOpen:
+			inc.Status = statusResolved
+			inc.ResolvedAt = &now
+			delete(t.open, a.Fingerprint)
+			res.Resolved++
+			alertsReceivedTotal.WithLabelValues(a.Status, "resolved").Inc()
+			incidentsOpen.WithLabelValues(inc.Severity).Dec()
+			ttr := now.Sub(inc.OpenedAt)
+			timeToResolve.WithLabelValues(inc.Severity).Observe(ttr.Seconds())
+			t.logger.Info("incident resolved", "id", inc.ID, "alertname", inc.AlertName,
+				"time_to_resolve", ttr.Round(time.Second).String())
+
+		default:
+			res.Stale++
+			alertsReceivedTotal.WithLabelValues(a.Status, "stale").Inc()
+			continue
+		}
+		t.persist(inc)
+	}
+	return res
+}
+
+// acknowledge marks an open incident as being worked on.
+func (t *incidentTracker) acknowledge(id, by string) (incident, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	inc, ok := t.byID[id]
+	if !ok {
+		return incident{}, errNotFound
+	}
+	if inc.Status != statusOpen {
+		return *inc, errAlreadyHandled
+	}
+	now := t.now().UTC()
+	inc.Status = statusAcknowledged
+	inc.AcknowledgedAt = &now
+	inc.AcknowledgedBy = by
+	tta := now.Sub(inc.OpenedAt)
+	timeToAcknowledge.WithLabelValues(inc.Severity).Observe(tta.Seconds())
+	t.logger.Info("incident acknowledged", "id", inc.ID, "by", by,
+		"time_to_acknowledge", tta.Round(time.Second).String())
+	t.persist(inc)
+	return *inc, nil
+}
+
+// attach records the output of an incident's runbook.
+func (t *incidentTracker) attach(id string, diags []diagnostic) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	inc, ok := t.byID[id]
+	if !ok {
+		return
+	}
+	inc.Diagnostics = diags
+	t.persist(inc)
+}
+
+// persist writes inc to the store. The incident stays in memory if that
+// fails; the error is logged and counted.
+func (t *incidentTracker) persist(inc *incident) {
+	if err := t.store.put(inc); err != nil {
+		storeErrorsTotal.Inc()
+		t.logger.Error("failed to store incident", "id", inc.ID, "error", err)
+	}
+}
+
+// list returns copies of the incidents matching status and service (empty
+// matches all), newest first.
+func (t *incidentTracker) list(status incidentStatus, service string) []incident {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := []incident{}
+	for i := len(t.incidents) - 1; i >= 0; i-- {
+		inc := t.incidents[i]
+		if (status == "" || inc.Status == status) && (service == "" || inc.Service == service) {
+			out = append(out, *inc)
+		}
+	}
+	return out
+}
+
+func (t *incidentTracker) get(id string) (incident, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	inc, ok := t.byID[id]
+	if !ok {
+		return incident{}, false
+	}
+	return *inc, true
+}
+
+// incidentStats summarises the incidents the receiver has seen. The means
+// cover every incident, where the metrics only cover this process.
+type incidentStats struct {
+	Open         int     `json:"open"`
+	Acknowledged int     `json:"acknowledged"`
+	Resolved     int     `json:"resolved"`
+	MTTASeconds  float64 `json:"mtta_seconds"`
+	MTTRSeconds  float64 `json:"mttr_seconds"`
+}
+
+func (t *incidentTracker) stats() incidentStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var s incidentStats
+	var acked int
+	for _, inc := range t.incidents {
+		switch inc.Status {
+		case statusOpen:
+			s.Open++
+		case statusAcknowledged:
+			s.Acknowledged++
+		case statusResolved:
+			s.Resolved++
+			s.MTTRSeconds += inc.ResolvedAt.Sub(inc.OpenedAt).Seconds()
+		}
+		if inc.AcknowledgedAt != nil {
+			acked++
+			s.MTTASeconds += inc.AcknowledgedAt.Sub(inc.OpenedAt).Seconds()
+		}
+	}
+	if acked > 0 {
+		s.MTTASeconds /= float64(acked)
+	}
+	if s.Resolved > 0 {
+		s.MTTRSeconds /= float64(s.Resolved)
+	}
+	return s
+}
+
+// ---------------------------------------------------------------------------
+// Incident handlers
+// ---------------------------------------------------------------------------
+
+// handleWebhook receives Alertmanager notifications. Anything but a 2xx makes
+// Alertmanager retry, which deduplication makes harmless.
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	var p alertmanagerPayload
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&p); err != nil {
+		webhooksReceivedTotal.WithLabelValues("invalid").Inc()
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := p.validate(); err != nil {
+		webhooksReceivedTotal.WithLabelValues("invalid").Inc()
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	webhooksReceivedTotal.WithLabelValues("accepted").Inc()
+	if p.TruncatedAlerts > 0 {
+		s.logger.Warn("notification truncated, some alerts were not delivered",
+			"group_key", p.GroupKey, "truncated_alerts", p.TruncatedAlerts)
+	}
+	writeJSON(w, http.StatusOK, s.incidents.ingest(&p))
+}
+
+func (s *Server) handleListIncidents(w http.ResponseWriter, r *http.Request) {
+	status := incidentStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", statusOpen, statusAcknowledged, statusResolved:
+	default:
+		writeError(w, "status must be open, acknowledged or resolved", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.incidents.list(status, r.URL.Query().Get("service")))
+}
+
+func (s *Server) handleGetIncident(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.incidents.get(chi.URLParam(r, "incidentID"))
+	if !ok {
+		writeError(w, errNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, inc)
+}
+
+func (s *Server) handleAcknowledgeIncident(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		By string `json:"by"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.By == "" {
+		writeError(w, `body must be {"by": "<who>"}`, http.StatusBadRequest)
+		return
+	}
+	inc, err := s.incidents.acknowledge(chi.URLParam(r, "incidentID"), req.By)
+	switch {
+	case errors.Is(err, errNotFound):
+		writeError(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		writeError(w, fmt.Sprintf("incident is %s", inc.Status), http.StatusConflict)
+	default:
+		writeJSON(w, http.StatusOK, inc)
+	}
+}
+
+func (s *Server) handleIncidentStats(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.incidents.stats())
+}
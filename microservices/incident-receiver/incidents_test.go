@@ -0,0 +1,213 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClock is a settable clock for the tracker.
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func firing(fp, name string) alert   { return testAlert("firing", fp, name) }
+func resolved(fp, name string) alert { return testAlert("resolved", fp, name) }
+
+func notification(alerts ...alert) *alertmanagerPayload {
+	return &alertmanagerPayload{Version: "4", Status: alerts[0].Status, Alerts: alerts}
+}
+
+func testAlert(status, fp, name string) alert {
+	return alert{
+		Status:      status,
+		Fingerprint: fp,
+		Labels:      map[string]string{"alertname": name, "severity": "critical", "service": "order-service"},
+		Annotations: map[string]string{"summary": name + " on order-service"},
+		StartsAt:    time.Date(2024, 5, 1, 11, 58, 0, 0, time.UTC),
+	}
+}
+
+func newTestTracker(t *testing.T, store *incidentStore, loaded []*incident) (*incidentTracker, *testClock) {
+	t.Helper()
+	if store == nil {
+		store = &incidentStore{}
+	}
+	tr := newIncidentTracker(slog.New(slog.NewTextHandler(io.Discard, nil)), store, loaded)
+	clock := &testClock{time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	tr.now = clock.now
+	return tr, clock
+}
+
+func TestIngestDeduplicates(t *testing.T) {
+	tr, clock := newTestTracker(t, nil, nil)
+
+	res := tr.ingest(notification(firing("aaa", "HighErrorRate"), firing("bbb", "HighLatency")))
+	if res != (ingestResult{Opened: 2}) {
+		t.Fatalf("first notification: got %+v", res)
+	}
+	// A repeat of the group, with one alert resolved.
+	clock.advance(5 * time.Minute)
+	res = tr.ingest(notification(firing("aaa", "HighErrorRate"), resolved("bbb", "HighLatency")))
+	if res != (ingestResult{Duplicates: 1, Resolved: 1}) {
+		t.Fatalf("repeat notification: got %+v", res)
+	}
+	// Alertmanager resends resolved alerts too.
+	res = tr.ingest(notification(resolved("bbb", "HighLatency")))
+	if res != (ingestResult{Stale: 1}) {
+		t.Fatalf("repeated resolve: got %+v", res)
+	}
+	// A resolved alert that fires again is a new incident.
+	clock.advance(time.Hour)
+	res = tr.ingest(notification(firing("bbb", "HighLatency")))
+	if res != (ingestResult{Opened: 1}) {
+		t.Fatalf("refiring: got %+v", res)
+	}
+
+	all := tr.list("", "")
+	if len(all) != 3 {
+		t.Fatalf("got %d incidents, want 3", len(all))
+	}
+	if all[0].ID != "inc-000003" || all[0].Status != statusOpen {
+		t.Errorf("newest first: got %s %s", all[0].ID, all[0].Status)
+	}
+	first, _ := tr.get("inc-000001")
+	if first.Notifications != 2 || first.AlertName != "HighErrorRate" || first.Summary != "HighErrorRate on order-service" {
+		t.Errorf("inc-000001: got %+v", first)
+	}
+	second, _ := tr.get("inc-000002")
+	if second.Status != statusResolved || second.ResolvedAt.Sub(second.OpenedAt) != 5*time.Minute {
+		t.Errorf("inc-000002: got %+v", second)
+	}
+	if open := tr.list(statusOpen, "order-service"); len(open) != 2 {
+		t.Errorf("open incidents: got %d, want 2", len(open))
+	}
+	if other := tr.list("", "payment-service"); len(other) != 0 {
+		t.Errorf("payment-service incidents: got %d, want 0", len(other))
+	}
+}
+
+func TestAcknowledgeAndStats(t *testing.T) {
+	tr, clock := newTestTracker(t, nil, nil)
+	tr.ingest(notification(firing("aaa", "HighErrorRate"), firing("bbb", "HighLatency")))
+
+	clock.advance(2 * time.Minute)
+	inc, err := tr.acknowledge("inc-000001", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inc.Status != statusAcknowledged || inc.AcknowledgedBy != "alice" {
+		t.Errorf("got %+v", inc)
+	}
+	if _, err := tr.acknowledge("inc-000001", "bob"); err != errAlreadyHandled {
+		t.Errorf("second acknowledgement: got %v", err)
+	}
+	if _, err := tr.acknowledge("inc-999999", "bob"); err != errNotFound {
+		t.Errorf("unknown incident: got %v", err)
+	}
+
+	clock.advance(8 * time.Minute)
+	tr.ingest(notification(resolved("aaa", "HighErrorRate")))
+	clock.advance(10 * time.Minute)
+	tr.ingest(notification(resolved("bbb", "HighLatency")))
+	if _, err := tr.acknowledge("inc-000002", "bob"); err != errAlreadyHandled {
+		t.Errorf("acknowledging a resolved incident: got %v", err)
+	}
+
+	want := incidentStats{Resolved: 2, MTTASeconds: 120, MTTRSeconds: 900}
+	if got := tr.stats(); got != want {
+		t.Errorf("stats: got %+v, want %+v", got, want)
+	}
+}
+
+func TestValidatePayload(t *testing.T) {
+	for _, tc := range []struct {
+		p    alertmanagerPayload
+		want string
+	}{
+		{alertmanagerPayload{Version: "3"}, `unsupported webhook version "3"`},
+		{alertmanagerPayload{Version: "4", Alerts: []alert{{Status: "firing"}}}, "alerts[0]: missing fingerprint"},
+		{alertmanagerPayload{Version: "4", Alerts: []alert{{Status: "pending", Fingerprint: "a"}}}, `alerts[0]: unknown status "pending"`},
+	} {
+		if err := tc.p.validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("got %v, want %q", err, tc.want)
+		}
+	}
+	if err := notification(firing("a", "X")).validate(); err != nil {
+		t.Errorf("valid payload: %v", err)
+	}
+}
+
+func TestStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "incidents.jsonl")
+
+	store, loaded, err := openStore(slog.New(slog.NewTextHandler(io.Discard, nil)), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, clock := newTestTracker(t, store, loaded)
+	tr.ingest(notification(firing("aaa", "HighErrorRate"), firing("bbb", "HighLatency")))
+	clock.advance(time.Minute)
+	tr.acknowledge("inc-000001", "alice")
+	tr.ingest(notification(resolved("bbb", "HighLatency")))
+	store.close()
+
+	data, _ := os.ReadFile(path)
+	if n := strings.Count(string(data), "\n"); n != 4 {
+		t.Errorf("journal has %d lines, want one per change (4)", n)
+	}
+
+	store, loaded, err = openStore(slog.New(slog.NewTextHandler(io.Discard, nil)), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	data, _ = os.ReadFile(path)
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("compacted journal has %d lines, want one per incident (2)", n)
+	}
+
+	tr, _ = newTestTracker(t, store, loaded)
+	if inc, _ := tr.get("inc-000001"); inc.Status != statusAcknowledged || inc.AcknowledgedBy != "alice" {
+		t.Errorf("inc-000001 after restart: got %+v", inc)
+	}
+	// The open incident is still deduplicated against, and IDs carry on.
+	res := tr.ingest(notification(firing("aaa", "HighErrorRate"), firing("bbb", "HighLatency")))
+	if res != (ingestResult{Opened: 1, Duplicates: 1}) {
+		t.Errorf("after restart: got %+v", res)
+	}
+	if all := tr.list("", ""); all[0].ID != "inc-000003" {
+		t.Errorf("next ID: got %s, want inc-000003", all[0].ID)
+	}
+}
+
+func TestStoreRejectsCorruptJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "incidents.jsonl")
+	os.WriteFile(path, []byte("{\"id\":\"inc-000001\"}\nnot json\n{\"id\":\"inc-000002\"}\n"), 0o644)
+	if _, _, err := openStore(slog.New(slog.NewTextHandler(io.Discard, nil)), path); err == nil || !strings.Contains(err.Error(), "incidents.jsonl:2") {
+		t.Errorf("got %v, want an error naming line 2", err)
+	}
+}
+
+func TestStoreSkipsTornLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "incidents.jsonl")
+	os.WriteFile(path, []byte("{\"id\":\"inc-000001\"}\n{\"id\":\"inc-0000"), 0o644)
+	store, loaded, err := openStore(slog.New(slog.NewTextHandler(io.Discard, nil)), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	if len(loaded) != 1 || loaded[0].ID != "inc-000001" {
+		t.Errorf("loaded %+v, want inc-000001 only", loaded)
+	}
+	// Compaction removed the torn line, so the next record starts a line.
+	data, _ := os.ReadFile(path)
+	if strings.Count(string(data), "\n") != 1 || !strings.HasSuffix(string(data), "\n") || strings.Contains(string(data), "inc-0000\"") {
+		t.Errorf("journal after open: %q, want the one complete record", data)
+	}
+}
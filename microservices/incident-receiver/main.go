@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ---------------------------------------------------------------------------
+// Prometheus metrics
+// ---------------------------------------------------------------------------
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total number of HTTP requests processed.",
+		},
+		[]string{"method", "path", "status"},
+	)
+
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Duration of HTTP requests in seconds.",
+			Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0},
+		},
+		[]string{"method", "path"},
+	)
+
+	webhooksReceivedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "incident_webhooks_received_total",
+			Help: "Alertmanager notifications received, by result (accepted, invalid).",
+		},
+		[]string{"result"},
+	)
+
+	alertsReceivedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "incident_alerts_received_total",
+			Help: "Alerts in accepted notifications, by alert status and what they did (opened, duplicate, resolved, stale).",
+		},
+		[]string{"status", "result"},
+	)
+
+	incidentsOpen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "incidents_open",
+			Help: "Incidents not yet resolved, acknowledged or not, by severity.",
+		},
+		[]string{"severity"},
+	)
+
+	timeToAcknowledge = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "incident_time_to_acknowledge_seconds",
+			Help:    "Time from an incident opening to its acknowledgement (MTTA = _sum / _count).",
+			Buckets: []float64{30, 60, 120, 300, 600, 900, 1800, 3600, 7200, 14400},
+		},
+		[]string{"severity"},
+	)
+
+	timeToResolve = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "incident_time_to_resolve_seconds",
+			Help:    "Time from an incident opening to its alert resolving (MTTR = _sum / _count).",
+			Buckets: []float64{60, 300, 600, 1800, 3600, 7200, 14400, 28800, 86400},
+		},
+		[]string{"severity"},
+	)
+
+	runbookStepsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "incident_runbook_steps_total",
+			Help: "Diagnostic runbook steps run for new incidents, by step and result (ok, error).",
+		},
+		[]string{"step", "result"},
+	)
+
+	storeErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "incident_store_errors_total",
+			Help: "Failed writes to the incident store.",
+		},
+	)
+)
+
+// ---------------------------------------------------------------------------
+// Domain types
+// ---------------------------------------------------------------------------
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// ---------------------------------------------------------------------------
+// Server
+// ---------------------------------------------------------------------------
+
+type Server struct {
+	logger    *slog.Logger
+	incidents *incidentTracker
+	ready     atomic.Bool
+}
+
+func newServer(logger *slog.Logger, incidents *incidentTracker) *Server {
+	return &Server{logger: logger, incidents: incidents}
+}
+
+// ---------------------------------------------------------------------------
+// main
+// ---------------------------------------------------------------------------
+
+func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	prometheus.MustRegister(
+		httpRequestsTotal, httpRequestDuration,
+		webhooksReceivedTotal, alertsReceivedTotal, incidentsOpen,
+		timeToAcknowledge, timeToResolve, runbookStepsTotal, storeErrorsTotal,
+	)
+
+	storeFile := getEnv("STORE_FILE", "")
+	store, loaded, err := openStore(logger, storeFile)
+	if err != nil {
+		logger.Error("failed to open incident store", "file", storeFile, "error", err)
+		os.Exit(1)
+	}
+	defer store.close()
+
+	cfg, err := loadRunbookConfig()
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	runbooks := newRunbookRunner(logger, cfg)
+	incidents := newIncidentTracker(logger, store, loaded)
+	incidents.onOpen = func(inc incident) { runbooks.start(inc, incidents.attach) }
+	srv := newServer(logger, incidents)
+
+	port := getEnv("PORT", "8087")
+	httpServer := &http.Server{
+		Addr:         ":" + port,
+		Handler:      srv.routes(),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+	srv.ready.Store(true)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		logger.Info("incident-receiver starting", "port", port, "store", storeFile, "incidents", len(loaded))
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-stop
+	logger.Info("shutting down")
+	srv.ready.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Error("forced shutdown", "error", err)
+	}
+	runbooks.wait()
+	logger.Info("server stopped")
+}
+
+// loadRunbookConfig reads the runbook settings from the environment.
+func loadRunbookConfig() (runbookConfig, error) {
+	cfg := runbookConfig{
+		PrometheusURL: strings.TrimRight(getEnv("PROMETHEUS_URL", "http://prometheus:9090"), "/"),
+		LokiURL:       strings.TrimRight(getEnv("LOKI_URL", "http://loki:3100"), "/"),
+		LokiLabel:     getEnv("LOKI_SERVICE_LABEL", "container"),
+		AdminToken:    getEnv("ADMIN_TOKEN", ""),
+	}
+	var err error
+	if cfg.ServiceURLs, err = parseServiceURLs(getEnv("SERVICE_URLS",
+		"order-service=http://order-service:8081,payment-service=http://payment-service:8082,user-service=http://user-service:8083")); err != nil {
+		return cfg, fmt.Errorf("SERVICE_URLS: %w", err)
+	}
+	if cfg.Window, err = time.ParseDuration(getEnv("RUNBOOK_WINDOW", "15m")); err != nil || cfg.Window < time.Minute {
+		return cfg, fmt.Errorf("RUNBOOK_WINDOW must be a duration of at least 1m")
+	}
+	if cfg.StepTimeout, err = time.ParseDuration(getEnv("RUNBOOK_STEP_TIMEOUT", "10s")); err != nil || cfg.StepTimeout <= 0 {
+		return cfg, fmt.Errorf("RUNBOOK_STEP_TIMEOUT must be a positive duration")
+	}
+	return cfg, nil
+}
+
+// routes builds the HTTP router. It is separate from main so tests can drive
+// the full middleware stack.
+func (s *Server) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.RealIP)
+	r.Use(middleware.RequestID)
+	r.Use(s.metricsMiddleware)
+	r.Use(middleware.Recoverer)
+
+	r.Get("/healthz", s.handleHealthz)
+	r.Get("/readyz", s.handleReadyz)
+	r.Handle("/metrics", promhttp.Handler())
+
+	r.Get("/", s.handlePage)
+	r.Post("/webhook", s.handleWebhook)
+	r.Route("/api/incidents", func(r chi.Router) {
+		r.Get("/", s.handleListIncidents)
+		r.Get("/stats", s.handleIncidentStats)
+		r.Get("/{incidentID}", s.handleGetIncident)
+		r.Post("/{incidentID}/ack", s.handleAcknowledgeIncident)
+	})
+	return r
+}
+
+// ---------------------------------------------------------------------------
+// Middleware
+// ---------------------------------------------------------------------------
+
+func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		duration := time.Since(start).Seconds()
+		status := fmt.Sprintf("%d", ww.Status())
+		path := chi.RouteContext(r.Context()).RoutePattern()
+		if path == "" {
+			path = r.URL.Path
+		}
+		httpRequestsTotal.WithLabelValues(r.Method, path, status).Inc()
+		httpRequestDuration.WithLabelValues(r.Method, path).Observe(duration)
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Handlers
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	if !s.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// ---------------------------------------------------------------------------
+// Helpers
+// ---------------------------------------------------------------------------
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, msg string, code int) {
+	writeJSON(w, code, ErrorResponse{Error: msg, Code: code})
+}
+
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// alertmanagerNotification is a notification as Alertmanager 0.27 sends it.
+const alertmanagerNotification = `{
+  "receiver": "incident-receiver",
+  "status": "firing",
+  "alerts": [
+    {
+      "status": "firing",
+      "labels": {"alertname": "ErrorBudgetBurnRateCritical", "service": "payment-service", "severity": "critical", "slo": "availability"},
+      "annotations": {"summary": "payment-service is burning its error budget 14.4x too fast"},
+      "startsAt": "2024-05-01T11:58:00.000Z",
+      "endsAt": "0001-01-01T00:00:00Z",
+      "generatorURL": "http://prometheus:9090/graph?g0.expr=...",
+      "fingerprint": "6b2e1a7c9d0f3e45"
+    }
+  ],
+  "groupLabels": {"alertname": "ErrorBudgetBurnRateCritical", "service": "payment-service"},
+  "commonLabels": {"alertname": "ErrorBudgetBurnRateCritical", "service": "payment-service", "severity": "critical"},
+  "commonAnnotations": {},
+  "externalURL": "http://localhost:9093",
+  "version": "4",
+  "groupKey": "{}/{severity=\"critical\"}:{alertname=\"ErrorBudgetBurnRateCritical\", service=\"payment-service\"}",
+  "truncatedAlerts": 0
+}`
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	tr, _ := newTestTracker(t, nil, nil)
+	return newServer(tr.logger, tr)
+}
+
+func do(t *testing.T, srv *Server, method, url, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, httptest.NewRequest(method, url, strings.NewReader(body)))
+	return rr
+}
+
+func TestHealthzEndpoint(t *testing.T) {
+	if rr := do(t, newTestServer(t), "GET", "/healthz", ""); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	if rr := do(t, newTestServer(t), "GET", "/metrics", ""); rr.Code != http.StatusOK {
+		t.Errorf("metrics endpoint returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestWebhookEndpoint(t *testing.T) {
+	srv := newTestServer(t)
+
+	for i, want := range []ingestResult{{Opened: 1}, {Duplicates: 1}} {
+		rr := do(t, srv, "POST", "/webhook", alertmanagerNotification)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("delivery %d: status %d: %s", i, rr.Code, rr.Body)
+		}
+		var got ingestResult
+		json.NewDecoder(rr.Body).Decode(&got)
+		if got != want {
+			t.Errorf("delivery %d: got %+v, want %+v", i, got, want)
+		}
+	}
+
+	rr := do(t, srv, "GET", "/api/incidents?status=open&service=payment-service", "")
+	var incidents []incident
+	json.NewDecoder(rr.Body).Decode(&incidents)
+	if len(incidents) != 1 {
+		t.Fatalf("got %d open incidents, want 1", len(incidents))
+	}
+	inc := incidents[0]
+	if inc.ID != "inc-000001" || inc.AlertName != "ErrorBudgetBurnRateCritical" || inc.Severity != "critical" || inc.Notifications != 2 {
+		t.Errorf("got %+v", inc)
+	}
+
+	if rr := do(t, srv, "POST", "/api/incidents/inc-000001/ack", `{"by": "alice"}`); rr.Code != http.StatusOK {
+		t.Errorf("ack: status %d: %s", rr.Code, rr.Body)
+	}
+	if rr := do(t, srv, "POST", "/api/incidents/inc-000001/ack", `{"by": "bob"}`); rr.Code != http.StatusConflict {
+		t.Errorf("second ack: got %d, want 409", rr.Code)
+	}
+	if rr := do(t, srv, "POST", "/api/incidents/inc-000001/ack", `{}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("ack without by: got %d, want 400", rr.Code)
+	}
+	if rr := do(t, srv, "POST", "/api/incidents/inc-000009/ack", `{"by": "bob"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("ack unknown incident: got %d, want 404", rr.Code)
+	}
+
+	resolvedNotification := strings.ReplaceAll(alertmanagerNotification, `"status": "firing"`, `"status": "resolved"`)
+	do(t, srv, "POST", "/webhook", resolvedNotification)
+	rr = do(t, srv, "GET", "/api/incidents/inc-000001", "")
+	json.NewDecoder(rr.Body).Decode(&inc)
+	if inc.Status != statusResolved || inc.AcknowledgedBy != "alice" || inc.ResolvedAt == nil {
+		t.Errorf("after resolve: got %+v", inc)
+	}
+
+	rr = do(t, srv, "GET", "/", "")
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("page content type %q", ct)
+	}
+	for _, want := range []string{"inc-000001", "ErrorBudgetBurnRateCritical", "0 open, 0 acknowledged, 1 resolved", "alice"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("page is missing %q", want)
+		}
+	}
+}
+
+func TestWebhookRejectsBadPayloads(t *testing.T) {
+	srv := newTestServer(t)
+	for _, body := range []string{
+		`not json`,
+		strings.Replace(alertmanagerNotification, `"version": "4"`, `"version": "3"`, 1),
+		strings.Replace(alertmanagerNotification, `"fingerprint": "6b2e1a7c9d0f3e45"`, `"fingerprint": ""`, 1),
+	} {
+		if rr := do(t, srv, "POST", "/webhook", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("got %d, want 400 for %.40s", rr.Code, body)
+		}
+	}
+	if rr := do(t, srv, "GET", "/api/incidents?status=closed", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown status filter: got %d, want 400", rr.Code)
+	}
+	if n := len(srv.incidents.list("", "")); n != 0 {
+		t.Errorf("rejected payloads opened %d incidents", n)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Incident page
+// ---------------------------------------------------------------------------
+//
+// GET / is a plain HTML table of the incidents, newest first, for watching
+// the alert path during a test. It refreshes itself every 10 seconds.
+
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"labels":   sortedLabels,
+	"duration": duration,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>Incidents</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+.open { color: #c00; } .acknowledged { color: #c60; } .resolved { color: #080; }
+.labels { color: #666; font-size: 80%; }
+pre { margin: 0.3em 0; font-size: 85%; }
+</style>
+</head>
+<body>
+<h1>Incidents</h1>
+<p>{{.Stats.Open}} open, {{.Stats.Acknowledged}} acknowledged, {{.Stats.Resolved}} resolved.
+MTTA {{duration .Stats.MTTASeconds}}, MTTR {{duration .Stats.MTTRSeconds}}.</p>
+<table>
+<tr><th>ID</th><th>Status</th><th>Severity</th><th>Alert</th><th>Service</th><th>Opened</th><th>Acknowledged</th><th>Resolved</th><th>Notifications</th></tr>
+{{range .Incidents}}<tr>
+<td>{{.ID}}</td>
+<td class="{{.Status}}">{{.Status}}</td>
+<td>{{.Severity}}</td>
+<td>{{.AlertName}}{{with .Summary}}<br>{{.}}{{end}}<div class="labels">{{range labels .Labels}}{{.}} {{end}}</div>
+{{range .Diagnostics}}<details><summary>{{.Step}}{{if .Error}} (failed){{end}}</summary><pre>{{.Output}}{{.Error}}</pre></details>{{end}}</td>
+<td>{{.Service}}</td>
+<td>{{.OpenedAt.Format "2006-01-02 15:04:05"}}</td>
+<td>{{with .AcknowledgedAt}}{{.Format "15:04:05"}}{{end}} {{.AcknowledgedBy}}</td>
+<td>{{with .ResolvedAt}}{{.Format "15:04:05"}}{{end}}</td>
+<td>{{.Notifications}}</td>
+</tr>
+{{else}}<tr><td colspan="9">No incidents.</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (s *Server) handlePage(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := pageTemplate.Execute(w, struct {
+		Stats     incidentStats
+		Incidents []incident
+	}{s.incidents.stats(), s.incidents.list("", "")})
+	if err != nil {
+		s.logger.Error("failed to render incident page", "error", err)
+	}
+}
+
+// sortedLabels returns the label pairs in name order.
+func sortedLabels(labels map[string]string) []string {
+	out := make([]string, 0, len(labels))
+	for k, v := range labels {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// duration formats a duration in seconds for display, or "-" for none.
+func duration(seconds float64) string {
+	if seconds == 0 {
+		return "-"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
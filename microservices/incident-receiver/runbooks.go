@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Runbook automation
+// ---------------------------------------------------------------------------
+//
+// When an incident opens, the diagnostic steps registered for its alert run
+// in the background and their output is attached to the incident, so whoever
+// picks it up starts with the failing routes, circuit breakers and recent
+// error logs instead of just the alert name. A step that fails records its
+// error and the rest still run. Alerts with no runbook get no diagnostics.
+
+type diagnostic struct {
+	Step   string    `json:"step"`
+	Output string    `json:"output,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	RanAt  time.Time `json:"ran_at"`
+	TookMs int64     `json:"took_ms"`
+}
+
+type runbookStep struct {
+	name string
+	run  func(ctx context.Context, inc incident) (string, error)
+}
+
+type runbookConfig struct {
+	PrometheusURL string
+	LokiURL       string
+	LokiLabel     string            // Loki stream label holding the service or container name
+	ServiceURLs   map[string]string // base URL of each service, for /admin/breakers
+	AdminToken    string            // sent as a bearer token to /admin/breakers
+	Window        time.Duration     // how far back to look
+	StepTimeout   time.Duration
+}
+
+type runbookRunner struct {
+	logger *slog.Logger
+	cfg    runbookConfig
+	client *http.Client
+	steps  map[string][]runbookStep // by alertname
+	now    func() time.Time
+	wg     sync.WaitGroup
+}
+
+func newRunbookRunner(logger *slog.Logger, cfg runbookConfig) *runbookRunner {
+	r := &runbookRunner{
+		logger: logger,
+		cfg:    cfg,
+		client: &http.Client{},
+		now:    time.Now,
+	}
+
+	errorSteps := []runbookStep{
+		{"top failing routes", r.topFailingRoutes},
+		{"circuit breakers", r.circuitBreakers},
+		{"recent error logs", r.recentErrorLogs},
+	}
+	latencySteps := []runbookStep{
+		{"slowest routes", r.slowestRoutes},
+		{"circuit breakers", r.circuitBreakers},
+		{"recent error logs", r.recentErrorLogs},
+	}
+	crashLoopSteps := []runbookStep{
+		{"container restarts", r.containerRestarts},
+		{"recent container logs", r.recentContainerLogs},
+	}
+	r.steps = map[string][]runbookStep{
+		"HighErrorRate":                 errorSteps,
+		"ElevatedErrorRate":             errorSteps,
+		"ErrorBudgetBurnRateCritical":   errorSteps,
+		"ErrorBudgetSlowBurn":           errorSteps,
+		"HighLatency":                   latencySteps,
+		"ElevatedLatency":               latencySteps,
+		"LatencyBudgetBurnRateCritical": latencySteps,
+		"LatencyBudgetSlowBurn":         latencySteps,
+		"PodCrashLooping":               crashLoopSteps,
+	}
+	return r
+}
+
+// parseServiceURLs parses "name=url,name=url".
+func parseServiceURLs(s string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, u, ok := strings.Cut(pair, "=")
+		if !ok || name == "" || u == "" {
+			return nil, fmt.Errorf("%q is not name=url", pair)
+		}
+		out[name] = strings.TrimRight(u, "/")
+	}
+	return out, nil
+}
+
+// start runs the runbook for inc in the background and hands the result to
+// attach. It does nothing for an alert without a runbook.
+func (r *runbookRunner) start(inc incident, attach func(id string, diags []diagnostic)) {
+	if len(r.steps[inc.AlertName]) == 0 {
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		attach(inc.ID, r.run(context.Background(), inc))
+	}()
+}
+
+// wait blocks until every started runbook has finished.
+func (r *runbookRunner) wait() {
+	r.wg.Wait()
+}
+
+// run executes the steps for inc's alert in order.
+func (r *runbookRunner) run(ctx context.Context, inc incident) []diagnostic {
+	steps := r.steps[inc.AlertName]
+	diags := make([]diagnostic, 0, len(steps))
+	for _, step := range steps {
+		start := r.now()
+		stepCtx, cancel := context.WithTimeout(ctx, r.cfg.StepTimeout)
+		out, err := step.run(stepCtx, inc)
+		cancel()
+
+		d := diagnostic{Step: step.name, Output: out, RanAt: start.UTC(), TookMs: time.Since(start).Milliseconds()}
+		result := "ok"
+		if err != nil {
+			d.Error = err.Error()
+			result = "error"
+			r.logger.Warn("runbook step failed", "incident", inc.ID, "step", step.name, "error", err)
+		}
+		runbookStepsTotal.WithLabelValues(step.name, result).Inc()
+		diags = append(diags, d)
+	}
+	r.logger.Info("runbook finished", "incident", inc.ID, "alertname", inc.AlertName, "steps", len(diags))
+	return diags
+}
+
+// window is the look-back as a PromQL/LogQL duration.
+func (r *runbookRunner) window() string {
+	return strconv.Itoa(int(r.cfg.Window.Minutes())) + "m"
+}
+
+func serviceOf(inc incident) (string, error) {
+	if inc.Service == "" {
+		return "", fmt.Errorf("alert has no service label")
+	}
+	return inc.Service, nil
+}
+
+// ---------------------------------------------------------------------------
+// Steps
+// ---------------------------------------------------------------------------
+
+func (r *runbookRunner) topFailingRoutes(ctx context.Context, inc incident) (string, error) {
+	service, err := serviceOf(inc)
+	if err != nil {
+		return "", err
+	}
+	samples, err := r.queryPrometheus(ctx, fmt.Sprintf(
+		`topk(5, sum by (method, path, status) (increase(http_requests_total{service=%q,status=~"5.."}[%s])))`,
+		service, r.window()))
+	if err != nil {
+		return "", err
+	}
+	if len(samples) == 0 {
+		return fmt.Sprintf("no 5xx responses from %s in the last %s", service, r.window()), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "5xx responses from %s in the last %s:\n", service, r.window())
+	for _, s := range samples {
+		fmt.Fprintf(&b, "  %8.0f  %s %s %s\n", s.value, s.metric["method"], s.metric["path"], s.metric["status"])
+	}
+	return b.String(), nil
+}
+
+func (r *runbookRunner) slowestRoutes(ctx context.Context, inc incident) (string, error) {
+	service, err := serviceOf(inc)
+	if err != nil {
+		return "", err
+	}
+	samples, err := r.queryPrometheus(ctx, fmt.Sprintf(
+		`topk(5, histogram_quantile(0.99, sum by (method, path, le) (rate(http_request_duration_seconds_bucket{service=%q}[%s]))))`,
+		service, r.window()))
+	if err != nil {
+		return "", err
+	}
+	if len(samples) == 0 {
+		return fmt.Sprintf("no requests to %s in the last %s", service, r.window()), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "p99 latency of %s over the last %s:\n", service, r.window())
+	for _, s := range samples {
+		if math.IsNaN(s.value) {
+			continue
+		}
+		fmt.Fprintf(&b, "  %8s  %s %s\n", time.Duration(s.value*float64(time.Second)).Round(time.Millisecond), s.metric["method"], s.metric["path"])
+	}
+	return b.String(), nil
+}
+
+func (r *runbookRunner) circuitBreakers(ctx context.Context, inc incident) (string, error) {
+	service, err := serviceOf(inc)
+	if err != nil {
+		return "", err
+	}
+	base, ok := r.cfg.ServiceURLs[service]
+	if !ok {
+		return "", fmt.Errorf("no URL configured for %s", service)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/admin/breakers", nil)
+	if err != nil {
+		return "", err
+	}
+	if r.cfg.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.AdminToken)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// Services without ADMIN_TOKEN do not serve /admin/ either.
+		return service + " has no circuit breakers, or does not serve /admin/ (ADMIN_TOKEN unset)", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s/admin/breakers: %s", service, resp.Status)
+	}
+	var breakers []struct {
+		Name          string `json:"name"`
+		State         string `json:"state"`
+		Requests      uint32 `json:"requests"`
+		TotalFailures uint32 `json:"total_failures"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&breakers); err != nil {
+		return "", fmt.Errorf("%s/admin/breakers: %w", service, err)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "circuit breakers of %s:\n", service)
+	for _, cb := range breakers {
+		fmt.Fprintf(&b, "  %-20s %-10s %d/%d requests failed\n", cb.Name, cb.State, cb.TotalFailures, cb.Requests)
+	}
+	return b.String(), nil
+}
+
+func (r *runbookRunner) recentErrorLogs(ctx context.Context, inc incident) (string, error) {
+	service, err := serviceOf(inc)
+	if err != nil {
+		return "", err
+	}
+	return r.queryLoki(ctx, fmt.Sprintf(`{%s=%q} | json | level="ERROR"`, r.cfg.LokiLabel, service))
+}
+
+func (r *runbookRunner) containerRestarts(ctx context.Context, inc incident) (string, error) {
+	pod, ns := inc.Labels["pod"], inc.Labels["namespace"]
+	if pod == "" {
+		return "", fmt.Errorf("alert has no pod label")
+	}
+	samples, err := r.queryPrometheus(ctx, fmt.Sprintf(
+		`kube_pod_container_status_restarts_total{namespace=%q,pod=%q} * on (namespace, pod, container) group_left (reason) kube_pod_container_status_last_terminated_reason{namespace=%q,pod=%q}`,
+		ns, pod, ns, pod))
+	if err != nil {
+		return "", err
+	}
+	if len(samples) == 0 {
+		return fmt.Sprintf("no restart data for %s/%s", ns, pod), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "restarts of %s/%s:\n", ns, pod)
+	for _, s := range samples {
+		fmt.Fprintf(&b, "  %-20s %4.0f restarts, last terminated: %s\n", s.metric["container"], s.value, s.metric["reason"])
+	}
+	return b.String(), nil
+}
+
+func (r *runbookRunner) recentContainerLogs(ctx context.Context, inc incident) (string, error) {
+	container := inc.Labels["container"]
+	if container == "" {
+		return "", fmt.Errorf("alert has no container label")
+	}
+	return r.queryLoki(ctx, fmt.Sprintf(`{%s=%q}`, r.cfg.LokiLabel, container))
+}
+
+// ---------------------------------------------------------------------------
+// Prometheus and Loki queries
+// ---------------------------------------------------------------------------
+
+type promSample struct {
+	metric map[string]string
+	value  float64
+}
+
+// queryPrometheus runs an instant query and returns the samples in the order
+// Prometheus sorted them.
+func (r *runbookRunner) queryPrometheus(ctx context.Context, expr string) ([]promSample, error) {
+	var body struct {
+		Status    string `json:"status"`
+		ErrorType string `json:"errorType"`
+		Error     string `json:"error"`
+		Data      struct {
+			Result []struct {
+				Metric map[string]string `json:"metric"`
+				Value  [2]any            `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := r.get(ctx, r.cfg.PrometheusURL+"/api/v1/query?"+url.Values{"query": {expr}}.Encode(), &body); err != nil {
+		return nil, fmt.Errorf("prometheus: %w", err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("prometheus: %s: %s", body.ErrorType, body.Error)
+	}
+	out := make([]promSample, 0, len(body.Data.Result))
+	for _, s := range body.Data.Result {
+		raw, _ := s.Value[1].(string)
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("prometheus: bad sample value %v", s.Value[1])
+		}
+		out = append(out, promSample{s.Metric, v})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].value > out[j].value })
+	return out, nil
+}
+
+const maxLogLines = 20
+
+// queryLoki returns the newest log lines matching query within the window.
+func (r *runbookRunner) queryLoki(ctx context.Context, query string) (string, error) {
+	end := r.now()
+	q := url.Values{
+		"query":     {query},
+		"start":     {strconv.FormatInt(end.Add(-r.cfg.Window).UnixNano(), 10)},
+		"end":       {strconv.FormatInt(end.UnixNano(), 10)},
+		"limit":     {strconv.Itoa(maxLogLines)},
+		"direction": {"backward"},
+	}
+	var body struct {
+		Status string `json:"status"`
+		Data   struct {
+			Result []struct {
+				Values [][2]string `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := r.get(ctx, r.cfg.LokiURL+"/loki/api/v1/query_range?"+q.Encode(), &body); err != nil {
+		return "", fmt.Errorf("loki: %w", err)
+	}
+
+	// Lines come back per stream; merge them newest first.
+	var lines [][2]string
+	for _, s := range body.Data.Result {
+		lines = append(lines, s.Values...)
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i][0] > lines[j][0] })
+	if len(lines) > maxLogLines {
+		lines = lines[:maxLogLines]
+	}
+	if len(lines) == 0 {
+		return fmt.Sprintf("no log lines matching %s in the last %s", query, r.window()), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "newest %d log lines matching %s:\n", len(lines), query)
+	for _, l := range lines {
+		line := l[1]
+		if len(line) > 300 {
+			line = line[:300] + "..."
+		}
+		b.WriteString("  " + strings.TrimSpace(line) + "\n")
+	}
+	return b.String(), nil
+}
+
+// get fetches a JSON document into v. Prometheus explains errors in a JSON
+// body, Loki in plain text.
+func (r *runbookRunner) get(ctx context.Context, target string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+		}
+		return fmt.Errorf("%s: %w", resp.Status, err)
+	}
+	return nil
+}
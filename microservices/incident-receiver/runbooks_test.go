@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBackends stands in for Prometheus, Loki and order-service.
+func fakeBackends(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("query")
+		switch r.URL.Path {
+		case "/api/v1/query":
+			queries = append(queries, q)
+			if strings.Contains(q, "status=~") {
+				w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[
+					{"metric":{"method":"GET","path":"/api/orders","status":"500"},"value":[1700000000,"3"]},
+					{"metric":{"method":"POST","path":"/api/orders","status":"503"},"value":[1700000000,"41"]}
+				]}}`))
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"unexpected query"}`))
+		case "/loki/api/v1/query_range":
+			queries = append(queries, q)
+			w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[
+				{"stream":{"container":"order-service"},"values":[["1700000002000000000","{\"level\":\"ERROR\",\"msg\":\"payment failed\"}"]]},
+				{"stream":{"container":"order-service","level":"x"},"values":[["1700000001000000000","{\"level\":\"ERROR\",\"msg\":\"user lookup failed\"}"]]}
+			]}}`))
+		case "/admin/breakers":
+			if r.Header.Get("Authorization") != "Bearer test-token" {
+				http.Error(w, "missing or invalid admin token", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`[{"name":"payment-service","state":"open","requests":10,"total_failures":7},{"name":"user-service","state":"closed","requests":10,"total_failures":0}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &queries
+}
+
+func newTestRunner(backend string) *runbookRunner {
+	return newRunbookRunner(slog.New(slog.NewTextHandler(io.Discard, nil)), runbookConfig{
+		PrometheusURL: backend,
+		LokiURL:       backend,
+		LokiLabel:     "container",
+		ServiceURLs:   map[string]string{"order-service": backend},
+		AdminToken:    "test-token",
+		Window:        15 * time.Minute,
+		StepTimeout:   time.Second,
+	})
+}
+
+func TestErrorRateRunbook(t *testing.T) {
+	backend, queries := fakeBackends(t)
+	r := newTestRunner(backend.URL)
+
+	diags := r.run(context.Background(), incident{ID: "inc-000001", AlertName: "HighErrorRate", Service: "order-service"})
+	if len(diags) != 3 {
+		t.Fatalf("got %d diagnostics, want 3", len(diags))
+	}
+	for _, d := range diags {
+		if d.Error != "" {
+			t.Errorf("%s: %s", d.Step, d.Error)
+		}
+	}
+	for i, want := range []string{
+		"41  POST /api/orders 503\n         3  GET /api/orders 500",
+		"payment-service      open       7/10 requests failed",
+		"payment failed\"}\n  {\"level\":\"ERROR\",\"msg\":\"user lookup failed",
+	} {
+		if !strings.Contains(diags[i].Output, want) {
+			t.Errorf("%s: output\n%s\nwant it to contain\n%s", diags[i].Step, diags[i].Output, want)
+		}
+	}
+	for _, q := range *queries {
+		if !strings.Contains(q, `"order-service"`) {
+			t.Errorf("query not scoped to the service: %s", q)
+		}
+	}
+}
+
+func TestRunbookStepFailuresAreRecorded(t *testing.T) {
+	backend, _ := fakeBackends(t)
+	r := newTestRunner(backend.URL)
+
+	// The latency query is rejected and user-service has no URL; the log
+	// step still runs.
+	diags := r.run(context.Background(), incident{ID: "inc-000001", AlertName: "HighLatency", Service: "user-service"})
+	if len(diags) != 3 {
+		t.Fatalf("got %d diagnostics, want 3", len(diags))
+	}
+	if !strings.Contains(diags[0].Error, "bad_data: unexpected query") {
+		t.Errorf("slowest routes: got error %q", diags[0].Error)
+	}
+	if diags[1].Error != "no URL configured for user-service" {
+		t.Errorf("circuit breakers: got error %q", diags[1].Error)
+	}
+	if diags[2].Error != "" || diags[2].Output == "" {
+		t.Errorf("recent error logs: got %+v", diags[2])
+	}
+
+	if diags := r.run(context.Background(), incident{AlertName: "HighErrorRate"}); diags[0].Error != "alert has no service label" {
+		t.Errorf("no service label: got %q", diags[0].Error)
+	}
+	if diags := r.run(context.Background(), incident{AlertName: "NodeNotReady"}); len(diags) != 0 {
+		t.Errorf("alert without a runbook: got %d diagnostics", len(diags))
+	}
+}
+
+func TestRunbookAttachesToIncident(t *testing.T) {
+	backend, _ := fakeBackends(t)
+	r := newTestRunner(backend.URL)
+	tr, _ := newTestTracker(t, nil, nil)
+	tr.onOpen = func(inc incident) { r.start(inc, tr.attach) }
+
+	tr.ingest(notification(firing("aaa", "HighErrorRate"), firing("bbb", "TargetDown")))
+	r.wait()
+
+	inc, _ := tr.get("inc-000001")
+	if len(inc.Diagnostics) != 3 || inc.Diagnostics[0].Step != "top failing routes" {
+		t.Errorf("HighErrorRate incident: got diagnostics %+v", inc.Diagnostics)
+	}
+	if inc, _ := tr.get("inc-000002"); len(inc.Diagnostics) != 0 {
+		t.Errorf("TargetDown has no runbook, got %+v", inc.Diagnostics)
+	}
+}
+
+func TestParseServiceURLs(t *testing.T) {
+	got, err := parseServiceURLs("order-service=http://order-service:8081/, user-service=http://user-service:8083")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["order-service"] != "http://order-service:8081" || got["user-service"] != "http://user-service:8083" {
+		t.Errorf("got %v", got)
+	}
+	if _, err := parseServiceURLs("order-service"); err == nil {
+		t.Error("want an error for a pair without =")
+	}
+}
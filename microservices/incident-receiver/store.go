@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// Incident store
+// ---------------------------------------------------------------------------
+//
+// Incidents are kept in a journal: every change appends the incident's full
+// record to STORE_FILE as a JSON line, and the last line for an ID wins. The
+// journal is compacted to one line per incident when it is opened, so it
+// grows with the number of changes since the last restart only. With no
+// file the store keeps nothing across restarts.
+
+type incidentStore struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// openStore reads the incidents in path, compacts the journal and opens it
+// for appending. An empty path gives a store that persists nothing.
+func openStore(logger *slog.Logger, path string) (*incidentStore, []*incident, error) {
+	if path == "" {
+		return &incidentStore{}, nil, nil
+	}
+	incidents, err := readJournal(logger, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Write the compacted journal next to the old one and swap it in, so a
+	// crash half way leaves the old journal intact.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, nil, err
+	}
+	enc := json.NewEncoder(tmp)
+	for _, inc := range incidents {
+		if err := enc.Encode(inc); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, nil, err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return nil, nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &incidentStore{path: path, file: f}, incidents, nil
+}
+
+// readJournal returns the latest record of each incident in path, oldest
+// first. A missing file is an empty journal. A last line that does not parse
+// was most likely cut short by a crash while it was written: it is skipped
+// with a warning, and compaction then drops it from the file. A bad line
+// anywhere else is an error.
+func readJournal(logger *slog.Logger, path string) ([]*incident, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	byID := make(map[string]*incident)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	var bad error // the previous line's, an error only if another follows
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		if bad != nil {
+			return nil, bad
+		}
+		var inc incident
+		if err := json.Unmarshal(sc.Bytes(), &inc); err != nil {
+			bad = fmt.Errorf("%s:%d: %w", path, line, err)
+			continue
+		}
+		if inc.ID == "" {
+			bad = fmt.Errorf("%s:%d: incident without id", path, line)
+			continue
+		}
+		byID[inc.ID] = &inc
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if bad != nil {
+		logger.Warn("skipping torn last line of incident journal", "error", bad)
+	}
+
+	out := make([]*incident, 0, len(byID))
+	for _, inc := range byID {
+		out = append(out, inc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// put appends the incident's current record to the journal.
+func (s *incidentStore) put(inc *incident) error {
+	if s.file == nil {
+		return nil
+	}
+	line, err := json.Marshal(inc)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *incidentStore) close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,272 @@
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ---------------------------------------------------------------------------
+// Traffic capture
+// ---------------------------------------------------------------------------
+//
+// With CAPTURE_FILE set, requests to /api/ are appended to that file as JSON
+// lines that the load generator can replay (MODE=replay). Records are
+// sanitised before they leave the process: only allow-listed headers are
+// kept, and credential headers, JSON body fields and query parameters with
+// sensitive names are replaced with "[REDACTED]". Non-JSON bodies are not
+// recorded.
+
+const (
+	maxCaptureBody = 64 << 10
+	redacted       = "[REDACTED]"
+)
+
+// defaultCaptureHeaders are always kept; CAPTURE_HEADERS adds more.
+var defaultCaptureHeaders = []string{"Accept", "Content-Type", "User-Agent", "X-Request-Source"}
+
+// credentialHeaders are redacted even when CAPTURE_HEADERS lists them, as
+// are headers whose names match sensitiveKeys (X-API-Key, X-Auth-Token).
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// sensitiveKeys are matched as substrings of lower-cased field names.
+var sensitiveKeys = []string{"password", "passwd", "secret", "token", "authorization", "api_key", "apikey", "card_number", "cvv", "ssn"}
+
+type captureRecord struct {
+	Time        time.Time         `json:"time"`
+	GapMs       float64           `json:"gap_ms"` // since the previous captured request
+	Service     string            `json:"service"`
+	Method      string            `json:"method"`
+	Route       string            `json:"route"` // route pattern, e.g. /api/orders/{orderID}
+	Path        string            `json:"path"`  // path and sanitised query
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	BodyOmitted bool              `json:"body_omitted,omitempty"` // too large or not JSON
+	Status      int               `json:"status"`
+}
+
+type CaptureWriter struct {
+	logger     *slog.Logger
+	service    string
+	headers    []string
+	sampleRate float64
+	rng        *rand.Rand
+	file       *os.File
+	records    chan captureRecord
+	done       chan struct{}
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newCaptureWriter(logger *slog.Logger, service, path string, extraHeaders []string, sampleRate float64, rng *rand.Rand) (*CaptureWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	headers := append([]string{}, defaultCaptureHeaders...)
+	for _, h := range extraHeaders {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, http.CanonicalHeaderKey(h))
+		}
+	}
+	c := &CaptureWriter{
+		logger:     logger,
+		service:    service,
+		headers:    headers,
+		sampleRate: sampleRate,
+		rng:        rng,
+		file:       f,
+		records:    make(chan captureRecord, 1024),
+		done:       make(chan struct{}),
+	}
+	go c.loop()
+	logger.Info("capturing traffic", "file", path, "sample_rate", sampleRate, "headers", headers)
+	return c, nil
+}
+
+// Middleware records sampled /api/ requests once they have been served, so
+// the record carries the matched route and the response status.
+func (c *CaptureWriter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || c.sampleRate < 1 && c.rng.Float64() >= c.sampleRate {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := captureRecord{
+			Time:    time.Now().UTC(),
+			Service: c.service,
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Headers: make(map[string]string),
+		}
+		if q := sanitizeQuery(r.URL.Query()); q != "" {
+			rec.Path += "?" + q
+		}
+		for _, h := range c.headers {
+			if v := r.Header.Get(h); v != "" {
+				if isCredentialHeader(h) {
+					v = redacted
+				}
+				rec.Headers[h] = v
+			}
+		}
+		if r.Body != nil {
+			buf, err := io.ReadAll(io.LimitReader(r.Body, maxCaptureBody+1))
+			// The handler still sees the whole body.
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf), r.Body))
+			if err == nil && len(buf) > 0 {
+				body, ok := sanitizeBody(buf)
+				rec.Body, rec.BodyOmitted = body, !ok || len(buf) > maxCaptureBody
+				if rec.BodyOmitted {
+					rec.Body = ""
+				}
+			}
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		rec.Status = ww.Status()
+		rec.Route = chi.RouteContext(r.Context()).RoutePattern()
+		if rec.Route == "" {
+			rec.Route = r.URL.Path
+		}
+		c.enqueue(rec)
+	})
+}
+
+func (c *CaptureWriter) enqueue(rec captureRecord) {
+	c.mu.Lock()
+	if !c.last.IsZero() && rec.Time.After(c.last) {
+		rec.GapMs = float64(rec.Time.Sub(c.last).Microseconds()) / 1000
+	}
+	if rec.Time.After(c.last) {
+		c.last = rec.Time
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.records <- rec:
+	default:
+		captureRecordsTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+// loop writes records as they arrive and flushes whenever it catches up.
+func (c *CaptureWriter) loop() {
+	defer close(c.done)
+	w := bufio.NewWriter(c.file)
+	enc := json.NewEncoder(w)
+	for rec := range c.records {
+		if err := enc.Encode(rec); err != nil {
+			captureRecordsTotal.WithLabelValues("error").Inc()
+			c.logger.Error("writing capture record", "error", err)
+			continue
+		}
+		captureRecordsTotal.WithLabelValues("written").Inc()
+		if len(c.records) == 0 {
+			if err := w.Flush(); err != nil {
+				c.logger.Error("flushing capture file", "error", err)
+			}
+		}
+	}
+	w.Flush()
+}
+
+// Close writes out queued records and closes the file. The middleware must
+// not be serving requests any more.
+func (c *CaptureWriter) Close() error {
+	close(c.records)
+	<-c.done
+	return c.file.Close()
+}
+
+// sanitizeBody redacts sensitive fields of a JSON body. It reports false
+// for bodies that are not JSON.
+func sanitizeBody(body []byte) (string, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return "", false
+	}
+	out, err := json.Marshal(redactJSON(v))
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}
+
+func redactJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if isSensitive(k) {
+				t[k] = redacted
+			} else {
+				t[k] = redactJSON(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range t {
+			t[i] = redactJSON(child)
+		}
+	}
+	return v
+}
+
+func sanitizeQuery(q url.Values) string {
+	for k := range q {
+		if isSensitive(k) {
+			q[k] = []string{redacted}
+		}
+	}
+	return q.Encode()
+}
+
+func isCredentialHeader(name string) bool {
+	for _, h := range credentialHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return isSensitive(strings.ReplaceAll(name, "-", "_"))
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// CaptureFromEnv opens CAPTURE_FILE, or returns nil when capture is off.
+func CaptureFromEnv(logger *slog.Logger, service string, rng *rand.Rand) (*CaptureWriter, error) {
+	path := getEnv("CAPTURE_FILE", "")
+	if path == "" {
+		return nil, nil
+	}
+	rate, err := strconv.ParseFloat(getEnv("CAPTURE_SAMPLE_RATE", "1"), 64)
+	if err != nil || rate <= 0 || rate > 1 {
+		return nil, fmt.Errorf("CAPTURE_SAMPLE_RATE must be in (0, 1], got %q", getEnv("CAPTURE_SAMPLE_RATE", "1"))
+	}
+	return newCaptureWriter(logger, service, path, strings.Split(getEnv("CAPTURE_HEADERS", ""), ","), rate, rng)
+}
@@ -0,0 +1,99 @@
+package platform
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestCaptureMiddlewareSanitisesRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	c, err := newCaptureWriter(slog.New(slog.NewTextHandler(io.Discard, nil)), "order-service", path, []string{"x-tenant", "authorization", "x-api-key", "cookie"}, 1, NewRand(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handlerBody string
+	r := chi.NewRouter()
+	r.Use(c.Middleware)
+	r.Post("/api/orders/{orderID}/notes", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		handlerBody = string(b)
+		w.WriteHeader(http.StatusCreated)
+	})
+	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+	body := `{"note":"hi","password":"hunter2","card":{"card_number":"4111","type":"credit_card"}}`
+	req := httptest.NewRequest("POST", "/api/orders/ord-001/notes?limit=5&access_token=abc", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set("X-API-Key", "k-123")
+	req.Header.Set("Cookie", "session=abc")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	req = httptest.NewRequest("POST", "/api/orders/ord-002/notes", strings.NewReader("not json"))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if handlerBody != "not json" {
+		t.Errorf("handler read %q, capture must not consume the body", handlerBody)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var recs []captureRecord
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec captureRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("bad record %q: %v", sc.Text(), err)
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("captured %d records, want 2 (health checks are skipped)", len(recs))
+	}
+
+	rec := recs[0]
+	if rec.Route != "/api/orders/{orderID}/notes" || rec.Status != http.StatusCreated || rec.Service != "order-service" {
+		t.Errorf("record: %+v", rec)
+	}
+	if rec.Path != "/api/orders/ord-001/notes?access_token=%5BREDACTED%5D&limit=5" {
+		t.Errorf("path %q", rec.Path)
+	}
+	if rec.Headers["X-Tenant"] != "acme" || rec.Headers["Content-Type"] != "application/json" {
+		t.Errorf("headers %v", rec.Headers)
+	}
+	// Credentials are redacted even though CAPTURE_HEADERS asked for them.
+	for _, h := range []string{"Authorization", "X-Api-Key", "Cookie"} {
+		if v := rec.Headers[h]; v != redacted {
+			t.Errorf("header %s: got %q, want %s", h, v, redacted)
+		}
+	}
+	want := `{"card":{"card_number":"[REDACTED]","type":"credit_card"},"note":"hi","password":"[REDACTED]"}`
+	if rec.Body != want {
+		t.Errorf("body %s\nwant %s", rec.Body, want)
+	}
+
+	if !recs[1].BodyOmitted || recs[1].Body != "" {
+		t.Errorf("non-JSON body should be omitted: %+v", recs[1])
+	}
+	if recs[1].GapMs < 0 || recs[1].Time.Before(rec.Time) {
+		t.Errorf("inter-arrival: %+v after %+v", recs[1], rec)
+	}
+}
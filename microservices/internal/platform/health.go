@@ -0,0 +1,156 @@
+package platform
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Health checks
+// ---------------------------------------------------------------------------
+//
+// A service registers named checks of the things it depends on. Liveness
+// checks fail only when the process is wedged and restarting it would help,
+// such as a store whose lock can no longer be taken. Readiness checks fail
+// when the instance should get no traffic for now, such as while a
+// dependency it cannot work without is unavailable. /healthz runs the
+// liveness checks and /readyz runs every check, since an instance that is not
+// alive is not ready either.
+//
+// Each check runs concurrently under its own timeout. The body lists every
+// check with its status and latency, and health_check_status{check, kind}
+// records the last result of each.
+
+type CheckKind string
+
+const (
+	CheckLiveness  CheckKind = "liveness"
+	CheckReadiness CheckKind = "readiness"
+)
+
+type healthCheck struct {
+	name  string
+	kind  CheckKind
+	check func(ctx context.Context) error
+}
+
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"`
+	Status    string  `json:"status"` // pass or fail
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+type HealthRegistry struct {
+	Timeout time.Duration // per check
+
+	mu     sync.Mutex
+	checks []healthCheck
+}
+
+func NewHealthRegistry(timeout time.Duration) *HealthRegistry {
+	return &HealthRegistry{Timeout: timeout}
+}
+
+// Register adds a check. A check that outlives the timeout fails; its
+// goroutine is left to finish on its own.
+func (h *HealthRegistry) Register(name string, kind CheckKind, check func(ctx context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, healthCheck{name: name, kind: kind, check: check})
+}
+
+// run runs the liveness checks, and the readiness checks too for
+// CheckReadiness. It reports whether all of them passed.
+func (h *HealthRegistry) run(ctx context.Context, kind CheckKind) ([]CheckResult, bool) {
+	h.mu.Lock()
+	var checks []healthCheck
+	for _, c := range h.checks {
+		if c.kind == CheckLiveness || kind == CheckReadiness {
+			checks = append(checks, c)
+		}
+	}
+	h.mu.Unlock()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c healthCheck) {
+			defer wg.Done()
+			results[i] = h.runOne(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	ok := true
+	for _, r := range results {
+		if r.Status != "pass" {
+			ok = false
+		}
+	}
+	return results, ok
+}
+
+func (h *HealthRegistry) runOne(ctx context.Context, c healthCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.check(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %v", h.Timeout)
+	}
+
+	res := CheckResult{
+		Name:      c.name,
+		Kind:      string(c.kind),
+		Status:    "pass",
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		res.Status, res.Error = "fail", err.Error()
+		healthCheckStatus.WithLabelValues(c.name, string(c.kind)).Set(0)
+	} else {
+		healthCheckStatus.WithLabelValues(c.name, string(c.kind)).Set(1)
+	}
+	return res
+}
+
+// Serve runs the checks of kind and writes the report: 200 with okStatus if
+// they all passed, 503 with failStatus if not.
+func (h *HealthRegistry) Serve(w http.ResponseWriter, r *http.Request, kind CheckKind, okStatus, failStatus string) {
+	results, ok := h.run(r.Context(), kind)
+	report, code := HealthReport{Status: okStatus, Checks: results}, http.StatusOK
+	if !ok {
+		report.Status, code = failStatus, http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+// LockCheck passes if l can be taken, so a deadlocked store fails liveness
+// rather than hanging every request that touches it.
+func LockCheck(l sync.Locker) func(ctx context.Context) error {
+	return func(context.Context) error {
+		l.Lock()
+		l.Unlock()
+		return nil
+	}
+}
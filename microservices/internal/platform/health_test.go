@@ -0,0 +1,42 @@
+package platform
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthRegistry(t *testing.T) {
+	h := NewHealthRegistry(50 * time.Millisecond)
+	h.Register("store", CheckLiveness, func(context.Context) error { return nil })
+	h.Register("downstream", CheckReadiness, func(context.Context) error { return errors.New("down") })
+	h.Register("slow", CheckReadiness, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	results, ok := h.run(context.Background(), CheckLiveness)
+	if !ok || len(results) != 1 || results[0].Name != "store" || results[0].Status != "pass" {
+		t.Errorf("liveness: got %+v ok=%v, want store passing", results, ok)
+	}
+
+	start := time.Now()
+	results, ok = h.run(context.Background(), CheckReadiness)
+	if ok || len(results) != 3 {
+		t.Fatalf("readiness: got %+v ok=%v, want three checks failing", results, ok)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("readiness took %v, want the slow check cut off at its timeout", time.Since(start))
+	}
+	want := []struct{ name, status, err string }{
+		{"store", "pass", ""},
+		{"downstream", "fail", "down"},
+		{"slow", "fail", "timed out after 50ms"},
+	}
+	for i, w := range want {
+		if r := results[i]; r.Name != w.name || r.Status != w.status || r.Error != w.err {
+			t.Errorf("check %d: got %+v, want %s %s %q", i, r, w.name, w.status, w.err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package platform
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Latency models
+// ---------------------------------------------------------------------------
+//
+// Every simulated delay has a name: the route for a handler's own latency
+// (e.g. "GET /api/orders"), or the step for a dependency inside one (see
+// latencySites in each service's main.go). By default a delay is drawn from
+// a normal distribution with an occasional slow tail. LATENCY_MODELS names a
+// JSON file that replaces it for chosen names with a right-skewed model, e.g.
+// in order-service:
+//
+//   {
+//     "GET /api/orders":  {"type": "lognormal", "median_ms": 45, "sigma": 0.5},
+//     "POST /api/orders": {"type": "pareto", "min_ms": 150, "alpha": 2.5, "max_ms": 5000},
+//     "GET /api/orders/{orderID}": {"type": "bimodal", "p": 0.9,
+//         "fast": {"type": "lognormal", "median_ms": 3, "sigma": 0.3},
+//         "slow": {"type": "lognormal", "median_ms": 40, "sigma": 0.6}}
+//   }
+//
+// Types and their fields:
+//
+//   normal     mean_ms, stddev_ms, floored at 0.5ms
+//   lognormal  median_ms, sigma (of the underlying normal)
+//   pareto     min_ms (the scale), alpha (the shape; smaller is a heavier
+//              tail), max_ms (optional cap)
+//   bimodal    p, the probability of drawing from fast rather than slow,
+//              e.g. a cache hit ratio
+//   empirical  file, a histogram relative to the JSON file. Each line is an
+//              upper bound in seconds and the cumulative count of samples
+//              up to it, as in a Prometheus _bucket series; "+Inf" is
+//              allowed. Samples are spread evenly within a bucket, and
+//              those above the last finite bound take that bound.
+//
+// SIMULATED_CAPACITY makes delays grow with load: past that many requests
+// in flight, they share the capacity and each one slows down in proportion,
+// as when CPU or a connection pool is saturated. Unset, load has no effect.
+// This is an assumption about the service, not a measurement of it; see
+// Contention.
+
+type LatencyModel interface {
+	Sample(rng *rand.Rand) time.Duration
+}
+
+type NormalLatency struct{ Mean, Stddev float64 }
+
+func (m NormalLatency) Sample(rng *rand.Rand) time.Duration {
+	return msDuration(math.Max(0.5, m.Mean+m.Stddev*rng.NormFloat64()))
+}
+
+type logNormalLatency struct{ median, sigma float64 }
+
+func (m logNormalLatency) Sample(rng *rand.Rand) time.Duration {
+	return msDuration(m.median * math.Exp(m.sigma*rng.NormFloat64()))
+}
+
+type paretoLatency struct{ min, alpha, max float64 }
+
+func (m paretoLatency) Sample(rng *rand.Rand) time.Duration {
+	// Inverse transform; 1-Float64 is in (0, 1], so the power is finite.
+	v := m.min / math.Pow(1-rng.Float64(), 1/m.alpha)
+	if m.max > 0 && v > m.max {
+		v = m.max
+	}
+	return msDuration(v)
+}
+
+type bimodalLatency struct {
+	p          float64
+	fast, slow LatencyModel
+}
+
+func (m bimodalLatency) Sample(rng *rand.Rand) time.Duration {
+	if rng.Float64() < m.p {
+		return m.fast.Sample(rng)
+	}
+	return m.slow.Sample(rng)
+}
+
+// empiricalLatency samples a cumulative histogram; bounds are in ms.
+type empiricalLatency struct {
+	bounds     []float64
+	cumulative []float64
+}
+
+func (m empiricalLatency) Sample(rng *rand.Rand) time.Duration {
+	total := m.cumulative[len(m.cumulative)-1]
+	u := rng.Float64() * total
+	i := sort.Search(len(m.cumulative), func(i int) bool { return m.cumulative[i] > u })
+	if i == len(m.cumulative) {
+		i--
+	}
+	lower, below := 0.0, 0.0
+	if i > 0 {
+		lower, below = m.bounds[i-1], m.cumulative[i-1]
+	}
+	frac := (u - below) / (m.cumulative[i] - below)
+	return msDuration(lower + frac*(m.bounds[i]-lower))
+}
+
+func msDuration(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// contended stretches d for a service that can work on capacity requests at
+// once while inflight are running. A capacity of 0 is unlimited.
+func contended(d time.Duration, inflight, capacity int) time.Duration {
+	if capacity <= 0 || inflight <= capacity {
+		return d
+	}
+	return time.Duration(float64(d) * float64(inflight) / float64(capacity))
+}
+
+// Contention is the SIMULATED_CAPACITY model. It counts the requests inside
+// the handlers itself instead of asking the load-shedding limiter, so the
+// simulated service is the same whether shedding is on or off and the
+// limiter is measured against it, not against its own bookkeeping. A nil
+// Contention leaves delays alone.
+type Contention struct {
+	capacity int
+	inflight atomic.Int64
+}
+
+func NewContention(capacity int) *Contention {
+	if capacity <= 0 {
+		return nil
+	}
+	return &Contention{capacity: capacity}
+}
+
+func (c *Contention) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.inflight.Add(1)
+		defer c.inflight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stretch returns d as it would be with the requests now in flight.
+func (c *Contention) Stretch(d time.Duration) time.Duration {
+	if c == nil {
+		return d
+	}
+	return contended(d, int(c.inflight.Load()), c.capacity)
+}
+
+// latencySpec is one model as written in the LATENCY_MODELS file.
+type latencySpec struct {
+	Type     string       `json:"type"`
+	MeanMs   float64      `json:"mean_ms"`
+	StddevMs float64      `json:"stddev_ms"`
+	MedianMs float64      `json:"median_ms"`
+	Sigma    float64      `json:"sigma"`
+	MinMs    float64      `json:"min_ms"`
+	Alpha    float64      `json:"alpha"`
+	MaxMs    float64      `json:"max_ms"`
+	P        float64      `json:"p"`
+	Fast     *latencySpec `json:"fast"`
+	Slow     *latencySpec `json:"slow"`
+	File     string       `json:"file"`
+}
+
+func (sp *latencySpec) build(dir string) (LatencyModel, error) {
+	switch sp.Type {
+	case "normal":
+		if sp.MeanMs <= 0 || sp.StddevMs < 0 {
+			return nil, fmt.Errorf("normal needs mean_ms > 0 and stddev_ms >= 0")
+		}
+		return NormalLatency{sp.MeanMs, sp.StddevMs}, nil
+	case "lognormal":
+		if sp.MedianMs <= 0 || sp.Sigma < 0 {
+			return nil, fmt.Errorf("lognormal needs median_ms > 0 and sigma >= 0")
+		}
+		return logNormalLatency{sp.MedianMs, sp.Sigma}, nil
+	case "pareto":
+		if sp.MinMs <= 0 || sp.Alpha <= 0 || (sp.MaxMs != 0 && sp.MaxMs < sp.MinMs) {
+			return nil, fmt.Errorf("pareto needs min_ms > 0, alpha > 0 and max_ms unset or >= min_ms")
+		}
+		return paretoLatency{sp.MinMs, sp.Alpha, sp.MaxMs}, nil
+	case "bimodal":
+		if sp.P < 0 || sp.P > 1 || sp.Fast == nil || sp.Slow == nil {
+			return nil, fmt.Errorf("bimodal needs p in [0, 1], fast and slow")
+		}
+		fast, err := sp.Fast.build(dir)
+		if err != nil {
+			return nil, fmt.Errorf("fast: %w", err)
+		}
+		slow, err := sp.Slow.build(dir)
+		if err != nil {
+			return nil, fmt.Errorf("slow: %w", err)
+		}
+		return bimodalLatency{sp.P, fast, slow}, nil
+	case "empirical":
+		if sp.File == "" {
+			return nil, fmt.Errorf("empirical needs file")
+		}
+		path := sp.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		return loadHistogram(path)
+	default:
+		return nil, fmt.Errorf("unknown type %q (want normal, lognormal, pareto, bimodal or empirical)", sp.Type)
+	}
+}
+
+func loadHistogram(path string) (LatencyModel, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m empiricalLatency
+	var overflow float64
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.ReplaceAll(text, ",", " "))
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"<le seconds> <cumulative count>\"", path, line)
+		}
+		count, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if fields[0] == "+Inf" {
+			overflow = count
+			continue
+		}
+		le, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if n := len(m.bounds); n > 0 && (le*1000 <= m.bounds[n-1] || count < m.cumulative[n-1]) {
+			return nil, fmt.Errorf("%s:%d: bounds must increase and counts must not decrease", path, line)
+		}
+		m.bounds = append(m.bounds, le*1000)
+		m.cumulative = append(m.cumulative, count)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	n := len(m.bounds)
+	if n == 0 || m.cumulative[n-1] == 0 {
+		return nil, fmt.Errorf("%s: histogram is empty", path)
+	}
+	if overflow > m.cumulative[n-1] {
+		// Samples beyond the last finite bound get that bound.
+		m.bounds = append(m.bounds, m.bounds[n-1])
+		m.cumulative = append(m.cumulative, overflow)
+	}
+	return m, nil
+}
+
+// LatencyFromEnv loads LATENCY_MODELS, keyed by the names in sites.
+func LatencyFromEnv(sites []string) (map[string]LatencyModel, error) {
+	path := getEnv("LATENCY_MODELS", "")
+	if path == "" {
+		return nil, nil
+	}
+	return loadLatencyModels(path, sites)
+}
+
+func loadLatencyModels(path string, sites []string) (map[string]LatencyModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs map[string]*latencySpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	known := make(map[string]bool, len(sites))
+	for _, s := range sites {
+		known[s] = true
+	}
+	models := make(map[string]LatencyModel, len(specs))
+	for name, sp := range specs {
+		if !known[name] {
+			return nil, fmt.Errorf("%s: unknown latency site %q (have %s)", path, name, strings.Join(sites, ", "))
+		}
+		m, err := sp.build(filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		models[name] = m
+	}
+	return models, nil
+}
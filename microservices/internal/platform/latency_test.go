@@ -0,0 +1,132 @@
+package platform
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// quantiles draws n samples from m and returns the requested quantiles in ms.
+func quantiles(m LatencyModel, n int, qs ...float64) []float64 {
+	rng := NewRand(1)
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = float64(m.Sample(rng)) / float64(time.Millisecond)
+	}
+	sort.Float64s(samples)
+	out := make([]float64, len(qs))
+	for i, q := range qs {
+		out[i] = samples[int(q*float64(n-1))]
+	}
+	return out
+}
+
+// testSites stands in for a service's testSites.
+var testSites = []string{"GET /api/orders", "POST /api/orders"}
+
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance*want
+}
+
+func TestLatencyModelShapes(t *testing.T) {
+	// Log-normal: median as configured, P99 at median*exp(2.326*sigma).
+	q := quantiles(logNormalLatency{median: 40, sigma: 0.5}, 50000, 0.5, 0.99)
+	if !near(q[0], 40, 0.03) || !near(q[1], 40*math.Exp(2.326*0.5), 0.05) {
+		t.Errorf("lognormal p50 %.1f p99 %.1f", q[0], q[1])
+	}
+
+	// Pareto: P50 at min*2^(1/alpha), never below min or above max.
+	q = quantiles(paretoLatency{min: 100, alpha: 2, max: 1000}, 50000, 0, 0.5, 1)
+	if q[0] < 100 || !near(q[1], 100*math.Sqrt2, 0.03) || q[2] > 1000 {
+		t.Errorf("pareto min %.1f p50 %.1f max %.1f", q[0], q[1], q[2])
+	}
+
+	// Bimodal: 80% fast hits around 2ms, 20% slow misses around 50ms.
+	q = quantiles(bimodalLatency{p: 0.8, fast: NormalLatency{2, 0.1}, slow: NormalLatency{50, 1}}, 50000, 0.75, 0.85)
+	if q[0] > 3 || q[1] < 45 {
+		t.Errorf("bimodal p75 %.1f p85 %.1f", q[0], q[1])
+	}
+}
+
+func TestEmpiricalLatencyFollowsHistogram(t *testing.T) {
+	dir := t.TempDir()
+	hist := "# le count\n0.01 500\n0.05 900\n0.25 990\n1 1000\n+Inf 1000\n"
+	if err := os.WriteFile(filepath.Join(dir, "get.hist"), []byte(hist), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := `{"GET /api/orders": {"type": "empirical", "file": "get.hist"}}`
+	path := filepath.Join(dir, "latency.json")
+	if err := os.WriteFile(path, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	models, err := loadLatencyModels(path, testSites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Half the samples are under 10ms, 90% under 50ms, 99% under 250ms.
+	q := quantiles(models["GET /api/orders"], 50000, 0.5, 0.9, 0.99, 1)
+	if !near(q[0], 10, 0.05) || !near(q[1], 50, 0.05) || !near(q[2], 250, 0.05) || q[3] > 1000 {
+		t.Errorf("empirical quantiles %v", q)
+	}
+
+}
+
+func TestLoadLatencyModelsErrors(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "bad.hist"), []byte("0.1 10\n0.05 20\n"), 0o644)
+	tests := map[string]string{
+		`{"GET /api/ordres": {"type": "lognormal", "median_ms": 10, "sigma": 1}}`:                      "unknown latency site",
+		`{"GET /api/orders": {"type": "gamma"}}`:                                                       "unknown type",
+		`{"GET /api/orders": {"type": "pareto", "min_ms": 10, "alpha": 0}}`:                            "pareto needs",
+		`{"GET /api/orders": {"type": "bimodal", "p": 0.5, "fast": {"type": "normal", "mean_ms": 1}}}`: "bimodal needs",
+		`{"GET /api/orders": {"type": "empirical", "file": "bad.hist"}}`:                               "bounds must increase",
+		`{"GET /api/orders": {"type": "empirical", "file": "missing.hist"}}`:                           "no such file",
+	}
+	for cfg, want := range tests {
+		path := filepath.Join(dir, "latency.json")
+		os.WriteFile(path, []byte(cfg), 0o644)
+		if _, err := loadLatencyModels(path, testSites); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want error containing %q", cfg, err, want)
+		}
+	}
+}
+
+func TestContentionCountsItsOwnRequests(t *testing.T) {
+	var none *Contention
+	if NewContention(0) != nil || none.Stretch(10*time.Millisecond) != 10*time.Millisecond {
+		t.Error("without SIMULATED_CAPACITY delays should be unchanged")
+	}
+
+	c := NewContention(2)
+	release := make(chan struct{})
+	h := c.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-release }))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/orders", nil))
+		}()
+	}
+	for deadline := time.Now().Add(2 * time.Second); c.inflight.Load() != 4; {
+		if time.Now().After(deadline) {
+			t.Fatalf("in flight: got %d, want 4", c.inflight.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := c.Stretch(10 * time.Millisecond); got != 20*time.Millisecond {
+		t.Errorf("4 requests on capacity 2: got %v, want 20ms", got)
+	}
+	close(release)
+	wg.Wait()
+	if got := c.Stretch(10 * time.Millisecond); got != 10*time.Millisecond {
+		t.Errorf("idle: got %v, want 10ms", got)
+	}
+}
@@ -0,0 +1,181 @@
+package platform
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------------------------------------------------------------------------
+// Adaptive concurrency limiting
+// ---------------------------------------------------------------------------
+//
+// The limiter caps the requests in flight and sheds the rest with 503 and
+// Retry-After, so that a burst queues at the caller rather than slowing down
+// every request the service has already accepted.
+//
+// The limit follows latency, as in Netflix's gradient limiter. Each window
+// (at least a second and ten requests) compares the mean latency of the
+// window with its long-run average. While a window is at most twice as slow
+// as usual the limit grows by its square root; beyond that it shrinks in
+// proportion, by at most half. Both move a fifth of the way there each
+// window. Windows that used less than half the limit leave it alone, since
+// latency then says nothing about concurrency. Slow windows hardly move the
+// average, so that a burst does not become the new usual. Comparing against
+// the service's own average, rather than a fixed target, works for services
+// whose routes take 5ms and 500ms alike.
+//
+// Probes and scrapes bypass the limiter. Critical routes, those whose
+// failure loses a sale, may use the whole limit; the rest are shed once
+// they would take the last fifth of it. LOAD_SHEDDING=false keeps measuring
+// and moving the limit but admits everything, for comparison.
+
+const (
+	limitInitial    = 20
+	limitMin        = 5
+	limitMax        = 500
+	limitMinSamples = 10
+	limitTolerance  = 2.0 // how much slower than usual a window may be
+	limitSmoothing  = 0.2 // how far the limit moves towards its new value
+	limitReserve    = 0.2 // share of the limit kept for critical routes
+	latencyDecay    = 0.05
+)
+
+type ConcurrencyLimiter struct {
+	Shed     bool // false measures and moves the limit but admits everything
+	window   time.Duration
+	critical map[string]bool // by "METHOD /route/pattern"
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	longRTT  float64 // running average of window mean latencies, seconds
+	winStart time.Time
+	winSum   float64
+	winCount int
+	winPeak  int // most requests in flight during the window
+}
+
+// NewConcurrencyLimiter returns a limiter that treats the given routes, in
+// the form "POST /api/orders", as critical.
+func NewConcurrencyLimiter(critical ...string) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{
+		Shed:     true,
+		window:   time.Second,
+		critical: make(map[string]bool),
+		limit:    limitInitial,
+		winStart: time.Now(),
+	}
+	for _, route := range critical {
+		l.critical[route] = true
+	}
+	concurrencyLimit.Set(l.limit)
+	return l
+}
+
+// Middleware limits the requests to routes. It takes the router so that it
+// can match the route pattern before routing, both to find the priority and
+// to label the metrics of shed requests.
+func (l *ConcurrencyLimiter) Middleware(routes chi.Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			route := matchRoute(routes, r)
+			priority := "normal"
+			if l.critical[r.Method+" "+route.RoutePattern()] {
+				priority = "critical"
+			}
+			if !l.acquire(priority == "critical") {
+				labelRoute(r, route)
+				requestsShedTotal.WithLabelValues(priority).Inc()
+				w.Header().Set("Retry-After", "1")
+				writeError(w, "overloaded, retry later", http.StatusServiceUnavailable)
+				return
+			}
+			start := time.Now()
+			defer func() { l.release(time.Since(start)) }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// matchRoute matches r against routes without routing it, for middleware
+// that runs before the router.
+func matchRoute(routes chi.Routes, r *http.Request) *chi.Context {
+	rctx := chi.NewRouteContext()
+	routes.Match(rctx, r.Method, r.URL.Path)
+	return rctx
+}
+
+// labelRoute gives a request that middleware answered itself the route it
+// would have taken, so the metrics middleware labels it with the route
+// pattern rather than the raw path.
+func labelRoute(r *http.Request, route *chi.Context) {
+	if c := chi.RouteContext(r.Context()); c != nil {
+		c.RoutePatterns = route.RoutePatterns
+	}
+}
+
+// acquire admits a request if there is room for it under the limit.
+func (l *ConcurrencyLimiter) acquire(critical bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit := l.limit
+	if !critical {
+		limit *= 1 - limitReserve
+	}
+	if l.Shed && float64(l.inflight) >= limit {
+		return false
+	}
+	l.inflight++
+	l.winPeak = max(l.winPeak, l.inflight)
+	concurrencyLimitInFlight.Set(float64(l.inflight))
+	return true
+}
+
+// release records an admitted request's latency, and recomputes the limit
+// at the end of a window.
+func (l *ConcurrencyLimiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	concurrencyLimitInFlight.Set(float64(l.inflight))
+	l.winSum += latency.Seconds()
+	l.winCount++
+	if l.winCount < limitMinSamples || time.Since(l.winStart) < l.window {
+		return
+	}
+
+	short := l.winSum / float64(l.winCount)
+	if l.longRTT == 0 {
+		l.longRTT = short
+	}
+	gradient := math.Max(0.5, math.Min(1, limitTolerance*l.longRTT/short))
+	if float64(2*l.winPeak) >= l.limit {
+		next := l.limit*gradient + math.Sqrt(l.limit)
+		l.limit += limitSmoothing * (next - l.limit)
+		l.limit = math.Max(limitMin, math.Min(limitMax, l.limit))
+		concurrencyLimit.Set(l.limit)
+	}
+	// A slow window barely moves the average, or a long burst would become
+	// the new usual; it still moves, so a lasting change is learnt.
+	if gradient < 1 {
+		l.longRTT += latencyDecay / 20 * (short - l.longRTT)
+	} else {
+		l.longRTT += latencyDecay * (short - l.longRTT)
+	}
+	l.winStart, l.winSum, l.winCount, l.winPeak = time.Now(), 0, 0, l.inflight
+}
+
+// inFlight returns the requests admitted and not yet finished.
+func (l *ConcurrencyLimiter) inFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
@@ -0,0 +1,175 @@
+package platform
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// limiterRouter serves the order routes with api behind l, and records the
+// route pattern the metrics middleware would see for each request.
+func limiterRouter(l *ConcurrencyLimiter, api http.HandlerFunc, patterns chan<- string) http.Handler {
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			if patterns != nil {
+				patterns <- chi.RouteContext(r.Context()).RoutePattern()
+			}
+		})
+	})
+	r.Use(l.Middleware(r))
+	r.Get("/healthz", func(http.ResponseWriter, *http.Request) {})
+	r.Route("/api/orders", func(r chi.Router) {
+		r.Post("/", api)
+		r.Get("/{orderID}", api)
+	})
+	return r
+}
+
+func TestConcurrencyLimiterPriority(t *testing.T) {
+	l := NewConcurrencyLimiter("POST /api/orders")
+	l.limit = 5 // 4 for normal requests
+	release := make(chan struct{})
+	patterns := make(chan string, 10)
+	h := limiterRouter(l, func(http.ResponseWriter, *http.Request) { <-release }, patterns)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+	var wg sync.WaitGroup
+	hold := func(method, path string, n int) {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serve(method, path)
+			}()
+		}
+	}
+
+	hold("GET", "/api/orders/o-1", 4)
+	for deadline := time.Now().Add(2 * time.Second); l.inFlight() != 4; {
+		if time.Now().After(deadline) {
+			t.Fatalf("in flight: got %d, want 4", l.inFlight())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rr := serve("GET", "/api/orders/o-2")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("normal request over its share: got %d Retry-After %q, want 503 with Retry-After 1",
+			rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if p := <-patterns; p != "/api/orders/{orderID}" {
+		t.Errorf("shed request route: got %q, want /api/orders/{orderID}", p)
+	}
+
+	// Critical requests may use the reserve, but not go past the limit.
+	hold("POST", "/api/orders", 1)
+	for deadline := time.Now().Add(2 * time.Second); l.inFlight() != 5; {
+		if time.Now().After(deadline) {
+			t.Fatalf("critical request not admitted: in flight %d", l.inFlight())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if rr := serve("POST", "/api/orders"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("critical request over the limit: got %d, want 503", rr.Code)
+	}
+	if rr := serve("GET", "/healthz"); rr.Code != http.StatusOK {
+		t.Errorf("healthz at the limit: got %d, want 200", rr.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	if l.inFlight() != 0 {
+		t.Errorf("in flight after release: got %d, want 0", l.inFlight())
+	}
+}
+
+// burst runs a service that can work on 4 requests at once, each taking
+// 10ms when it has them to itself, through light load and then a burst of
+// 40 concurrent clients. It returns the 90th percentile latency of requests
+// served in the second half of the burst, and how many were shed.
+func burst(t *testing.T, shed bool) (time.Duration, int) {
+	t.Helper()
+	l := NewConcurrencyLimiter()
+	l.Shed = shed
+	l.window = 25 * time.Millisecond
+	c := NewContention(4)
+	h := limiterRouter(l, c.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		time.Sleep(c.Stretch(10 * time.Millisecond))
+	})).ServeHTTP, nil)
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		shedCount int
+	)
+	run := func(clients int, d, skip time.Duration) {
+		var wg sync.WaitGroup
+		start := time.Now()
+		for i := 0; i < clients; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for time.Since(start) < d {
+					begin := time.Now()
+					rr := httptest.NewRecorder()
+					h.ServeHTTP(rr, httptest.NewRequest("GET", "/api/orders/o-1", nil))
+					took := time.Since(begin)
+					if begin.Sub(start) < skip {
+						continue
+					}
+					mu.Lock()
+					if rr.Code == http.StatusServiceUnavailable {
+						shedCount++
+					} else {
+						latencies = append(latencies, took)
+					}
+					mu.Unlock()
+					if rr.Code == http.StatusServiceUnavailable {
+						time.Sleep(5 * time.Millisecond)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	run(2, 200*time.Millisecond, 200*time.Millisecond) // learn the usual latency
+	run(40, time.Second, 500*time.Millisecond)
+
+	if len(latencies) == 0 {
+		t.Fatal("no requests served during the burst")
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[len(latencies)*9/10], shedCount
+}
+
+// TestLoadSheddingUnderSimulatedContention checks that the limiter finds the
+// point where a contended service starts to slow down. The benefit depends on
+// the Contention model: a service whose latency does not grow with its own
+// concurrency gains nothing from shedding.
+func TestLoadSheddingUnderSimulatedContention(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs two one-second bursts")
+	}
+	unlimited, _ := burst(t, false)
+	limited, shed := burst(t, true)
+	t.Logf("p90 during burst: %v without shedding, %v with it (%d shed)", unlimited, limited, shed)
+	if shed == 0 {
+		t.Error("no requests shed during the burst")
+	}
+	// Without shedding every request takes ten times as long; with it the
+	// limit settles where latency is about twice the usual.
+	if limited > unlimited/2 {
+		t.Errorf("p90 with shedding %v, want well under %v without", limited, unlimited)
+	}
+}
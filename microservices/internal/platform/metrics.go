@@ -0,0 +1,95 @@
+package platform
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// ---------------------------------------------------------------------------
+// Prometheus metrics
+// ---------------------------------------------------------------------------
+//
+// The services register these alongside their own; see Collectors.
+
+var (
+	captureRecordsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_capture_records_total",
+			Help: "Requests recorded to CAPTURE_FILE, by result (written, dropped, error).",
+		},
+		[]string{"result"},
+	)
+
+	healthCheckStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "health_check_status",
+			Help: "Result of the last run of each health check (1=pass, 0=fail).",
+		},
+		[]string{"check", "kind"},
+	)
+
+	httpRequestsInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Requests being served, not counting probes and scrapes.",
+		},
+	)
+
+	httpRequestsAfterNotReady = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "http_requests_after_not_ready_total",
+			Help: "Requests received after readiness turned off for shutdown.",
+		},
+	)
+
+	shutdownPhase = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "shutdown_phase",
+			Help: "Shutdown progress (0=running, 1=pre-stop delay, 2=draining requests, 3=stopping background work).",
+		},
+	)
+
+	backgroundTasksRunning = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "background_tasks_running",
+			Help: "Background goroutines running, by task.",
+		},
+		[]string{"task"},
+	)
+
+	concurrencyLimit = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "concurrency_limit",
+			Help: "Adaptive limit on requests in flight, not counting probes and scrapes.",
+		},
+	)
+
+	concurrencyLimitInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "concurrency_limit_in_flight",
+			Help: "Requests admitted by the concurrency limiter and not yet finished.",
+		},
+	)
+
+	requestsShedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_shed_total",
+			Help: "Requests rejected with 503 by the concurrency limiter, by priority (critical, normal).",
+		},
+		[]string{"priority"},
+	)
+
+	rateLimitRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limit_requests_total",
+			Help: "Requests checked against the client's rate limit, by client, tier and result (allowed, limited).",
+		},
+		[]string{"client", "tier", "result"},
+	)
+)
+
+// Collectors returns the metrics above, for the service to register.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		captureRecordsTotal, healthCheckStatus,
+		httpRequestsInFlight, httpRequestsAfterNotReady, shutdownPhase, backgroundTasksRunning,
+		concurrencyLimit, concurrencyLimitInFlight, requestsShedTotal, rateLimitRequestsTotal,
+	}
+}
@@ -0,0 +1,29 @@
+// Package platform is the plumbing order-service, payment-service and
+// user-service share: health checks, graceful shutdown, load shedding, rate
+// limiting, traffic capture, latency models and seeded randomness. A fix
+// here lands in all three; each service keeps its own routes, stores and
+// business metrics.
+package platform
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+// writeError answers in the services' error format, {"error": ..., "code": ...}.
+func writeError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}{msg, code})
+}
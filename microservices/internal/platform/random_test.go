@@ -0,0 +1,20 @@
+package platform
+
+import "testing"
+
+func TestRandomSeedPrecedence(t *testing.T) {
+	t.Setenv("RANDOM_SEED", "11")
+	if seed, source, err := RandomSeed("5"); err != nil || seed != 5 || source != "flag" {
+		t.Errorf("flag: got %d %s %v", seed, source, err)
+	}
+	if seed, source, err := RandomSeed(""); err != nil || seed != 11 || source != "env" {
+		t.Errorf("env: got %d %s %v", seed, source, err)
+	}
+	t.Setenv("RANDOM_SEED", "")
+	if _, source, err := RandomSeed(""); err != nil || source != "clock" {
+		t.Errorf("clock: got %s %v", source, err)
+	}
+	if _, _, err := RandomSeed("abc"); err == nil {
+		t.Error("non-numeric seed: expected error")
+	}
+}
@@ -0,0 +1,333 @@
+package platform
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------------------------------------------------------------------------
+// Per-client rate limiting
+// ---------------------------------------------------------------------------
+//
+// RATE_LIMITS names a JSON file of quota tiers and the clients on them:
+//
+//   {
+//     "tiers": {
+//       "default":  {"rate": 20, "burst": 40},
+//       "internal": {"rate": 500, "burst": 1000}
+//     },
+//     "clients": [
+//       {"name": "order-service", "source": "order-service", "tier": "internal"},
+//       {"name": "partner-a", "api_key": "pk-4f1c", "tier": "internal"}
+//     ],
+//     "trusted_networks": ["172.28.1.0/24"]
+//   }
+//
+// A client matches on one of api_key, subject, source or ip. A request's
+// client is the first listed one among its X-API-Key, the sub claim of its
+// bearer JWT, its X-Request-Source and its address, in that order. A request
+// whose headers name no listed client is limited by its address on the
+// default tier, so that making up a new key or source does not buy a fresh
+// bucket. Every client has its own token bucket holding up to burst requests
+// and refilled at rate per second.
+//
+// Nothing here verifies the sub claim or X-Request-Source, so they count only
+// on connections from trusted_networks: the other services and the proxies in
+// front of this one. Only from those is X-Forwarded-For believed, too; the
+// address is the nearest hop in it outside trusted_networks. Anyone else is
+// known by their API key or the address the connection came from.
+//
+// Responses carry RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy, as in the IETF draft, and a 429 carries Retry-After.
+// rate_limit_requests_total counts each client's allowed and limited
+// requests; clients not listed are counted together as "other". Without
+// RATE_LIMITS nothing is limited.
+
+type rateTier struct {
+	Rate  float64 `json:"rate"`  // requests per second
+	Burst float64 `json:"burst"` // bucket size
+}
+
+type rateClient struct {
+	Name    string `json:"name"`
+	APIKey  string `json:"api_key,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Source  string `json:"source,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Tier    string `json:"tier"`
+}
+
+type tokenBucket struct {
+	tier   rateTier
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last request.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.tier.Burst, b.tokens+now.Sub(b.last).Seconds()*b.tier.Rate)
+	b.last = now
+}
+
+type RateLimiter struct {
+	tiers   map[string]rateTier
+	clients map[string]rateClient // by identity, e.g. "source:load-generator"
+	trusted []netip.Prefix        // trusted_networks
+	now     func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket // by identity
+	swept   time.Time
+}
+
+func RateLimiterFromEnv() (*RateLimiter, error) {
+	path := getEnv("RATE_LIMITS", "")
+	if path == "" {
+		return nil, nil
+	}
+	return loadRateLimits(path)
+}
+
+func loadRateLimits(path string) (*RateLimiter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Tiers   map[string]rateTier `json:"tiers"`
+		Clients []rateClient        `json:"clients"`
+		Trusted []string            `json:"trusted_networks"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, ok := cfg.Tiers["default"]; !ok {
+		return nil, fmt.Errorf("%s: no default tier", path)
+	}
+	for name, t := range cfg.Tiers {
+		if t.Rate <= 0 || t.Burst < 1 {
+			return nil, fmt.Errorf("%s: tier %s needs rate > 0 and burst >= 1", path, name)
+		}
+	}
+	l := &RateLimiter{
+		tiers:   cfg.Tiers,
+		clients: make(map[string]rateClient),
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+	for _, c := range cfg.Clients {
+		var ids []string
+		for kind, v := range map[string]string{"api_key": c.APIKey, "subject": c.Subject, "source": c.Source, "ip": c.IP} {
+			if v != "" {
+				ids = append(ids, kind+":"+v)
+			}
+		}
+		switch {
+		case c.Name == "":
+			return nil, fmt.Errorf("%s: client without a name", path)
+		case len(ids) != 1:
+			return nil, fmt.Errorf("%s: client %s needs exactly one of api_key, subject, source or ip", path, c.Name)
+		}
+		if _, ok := cfg.Tiers[c.Tier]; !ok {
+			return nil, fmt.Errorf("%s: client %s: unknown tier %q", path, c.Name, c.Tier)
+		}
+		if _, dup := l.clients[ids[0]]; dup {
+			return nil, fmt.Errorf("%s: client %s: %s is already listed", path, c.Name, ids[0])
+		}
+		l.clients[ids[0]] = c
+	}
+	for _, n := range cfg.Trusted {
+		prefix, err := netip.ParsePrefix(n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: trusted_networks: %w", path, err)
+		}
+		l.trusted = append(l.trusted, prefix.Masked())
+	}
+	return l, nil
+}
+
+type peerKey struct{}
+
+// RememberPeer keeps the address r's connection came from, which RealIP
+// replaces with one taken from the headers. It must run before RealIP.
+func RememberPeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), peerKey{}, r.RemoteAddr)))
+	})
+}
+
+// peer returns the host r's connection came from.
+func peer(r *http.Request) string {
+	addr, ok := r.Context().Value(peerKey{}).(string)
+	if !ok {
+		addr = r.RemoteAddr
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// trusts reports whether host is in trusted_networks.
+func (l *RateLimiter) trusts(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range l.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// identities returns the identities r could be limited by, most specific
+// first, e.g. "subject:usr-100". The last is always its address.
+func (l *RateLimiter) identities(r *http.Request) []string {
+	var ids []string
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		ids = append(ids, "api_key:"+key)
+	}
+	host := peer(r)
+	if !l.trusts(host) {
+		return append(ids, "ip:"+host)
+	}
+	if sub := bearerSubject(r.Header.Get("Authorization")); sub != "" {
+		ids = append(ids, "subject:"+sub)
+	}
+	if src := r.Header.Get("X-Request-Source"); src != "" {
+		ids = append(ids, "source:"+src)
+	}
+	// Each proxy appends the address it was called from, so the hops are
+	// believed from the right until the first one outside trusted_networks.
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		host = hop
+		if !l.trusts(hop) {
+			break
+		}
+	}
+	return append(ids, "ip:"+host)
+}
+
+// client returns the identity r is limited by and the client it belongs to:
+// the first listed identity, or else r's address on the default tier.
+func (l *RateLimiter) client(r *http.Request) (string, rateClient) {
+	ids := l.identities(r)
+	for _, id := range ids {
+		if c, ok := l.clients[id]; ok {
+			return id, c
+		}
+	}
+	return ids[len(ids)-1], rateClient{Name: "other", Tier: "default"}
+}
+
+// bearerSubject returns the sub claim of a bearer JWT, without checking its
+// signature, or "" if there is none.
+func bearerSubject(auth string) string {
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if json.Unmarshal(payload, &claims) != nil {
+		return ""
+	}
+	return claims.Sub
+}
+
+// Middleware limits each client's requests to routes. Like the concurrency
+// limiter it takes the router to label the requests it turns away.
+func (l *RateLimiter) Middleware(routes chi.Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id, client := l.client(r)
+			tier := l.tiers[client.Tier]
+			ok, tokens := l.take(id, tier)
+
+			// Reset is when the bucket will be full again.
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(int(tier.Burst)))
+			h.Set("RateLimit-Remaining", strconv.Itoa(int(tokens)))
+			h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil((tier.Burst-tokens)/tier.Rate))))
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", int(tier.Burst), int(math.Ceil(tier.Burst/tier.Rate))))
+			if !ok {
+				rateLimitRequestsTotal.WithLabelValues(client.Name, client.Tier, "limited").Inc()
+				labelRoute(r, matchRoute(routes, r))
+				h.Set("Retry-After", strconv.Itoa(int(math.Ceil((1-tokens)/tier.Rate))))
+				writeError(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			rateLimitRequestsTotal.WithLabelValues(client.Name, client.Tier, "allowed").Inc()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// take spends a token from id's bucket if it has one, and returns what is
+// left in the bucket.
+func (l *RateLimiter) take(id string, tier rateTier) (bool, float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &tokenBucket{tier: tier, tokens: tier.Burst, last: now}
+		l.buckets[id] = b
+	}
+	b.refill(now)
+	if b.tokens < 1 {
+		return false, b.tokens
+	}
+	b.tokens--
+	return true, b.tokens
+}
+
+// sweep forgets, once a minute, the buckets that have filled up again: a new
+// one would start full anyway. Every address would be kept otherwise.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for id, b := range l.buckets {
+		if b.refill(now); b.tokens >= b.tier.Burst {
+			delete(l.buckets, id)
+		}
+	}
+}
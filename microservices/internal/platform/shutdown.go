@@ -0,0 +1,166 @@
+package platform
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Graceful shutdown
+// ---------------------------------------------------------------------------
+//
+// On SIGTERM a service stops in phases, each logged with how long it took
+// and reported in shutdown_phase:
+//
+//  1. pre-stop: /readyz turns 503 but requests are still served for
+//     PRE_STOP_DELAY, the time it takes Kubernetes endpoints and load
+//     balancers to notice. Requests that arrive meanwhile are counted in
+//     http_requests_after_not_ready_total; if they still arrive at the end
+//     of the delay, it is too short.
+//  2. draining: the listener closes and requests in flight get until
+//     DRAIN_TIMEOUT to finish. Any still running then are cut off, and the
+//     log says how many.
+//  3. background: background goroutines are cancelled and waited for,
+//     within the same deadline. The log names any that did not stop.
+//
+// The service then flushes whatever it buffers with what is left of the
+// deadline. PRE_STOP_DELAY plus DRAIN_TIMEOUT must fit in the orchestrator's
+// grace period (30s in Kubernetes and in docker-compose.yml).
+
+const (
+	phaseRunning = iota
+	phasePreStop
+	phaseDraining
+	phaseBackground
+)
+
+type Drainer struct {
+	logger       *slog.Logger
+	ready        *atomic.Bool // the server's readiness
+	PreStopDelay time.Duration
+	DrainTimeout time.Duration
+
+	inflight atomic.Int64 // requests, not counting probes and scrapes
+	stopping atomic.Bool
+
+	bgCtx    context.Context
+	bgCancel context.CancelFunc
+	bgWG     sync.WaitGroup
+	bgMu     sync.Mutex
+	bgTasks  map[string]int // running goroutines, by task
+}
+
+func NewDrainer(logger *slog.Logger, ready *atomic.Bool) *Drainer {
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdownPhase.Set(phaseRunning)
+	return &Drainer{
+		logger:       logger,
+		ready:        ready,
+		PreStopDelay: 5 * time.Second,
+		DrainTimeout: 20 * time.Second,
+		bgCtx:        ctx,
+		bgCancel:     cancel,
+		bgTasks:      make(map[string]int),
+	}
+}
+
+// Middleware tracks the requests in flight.
+func (d *Drainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if d.stopping.Load() {
+			httpRequestsAfterNotReady.Inc()
+		}
+		d.inflight.Add(1)
+		httpRequestsInFlight.Inc()
+		defer func() {
+			d.inflight.Add(-1)
+			httpRequestsInFlight.Dec()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GoBackground runs fn in a goroutine that shutdown cancels and waits for.
+func (d *Drainer) GoBackground(task string, fn func(ctx context.Context)) {
+	d.bgMu.Lock()
+	d.bgTasks[task]++
+	d.bgMu.Unlock()
+	backgroundTasksRunning.WithLabelValues(task).Inc()
+	d.bgWG.Add(1)
+	go func() {
+		defer func() {
+			d.bgMu.Lock()
+			if d.bgTasks[task]--; d.bgTasks[task] == 0 {
+				delete(d.bgTasks, task)
+			}
+			d.bgMu.Unlock()
+			backgroundTasksRunning.WithLabelValues(task).Dec()
+			d.bgWG.Done()
+		}()
+		fn(d.bgCtx)
+	}()
+}
+
+// PreStop takes the server out of rotation and keeps serving for the
+// pre-stop delay.
+func (d *Drainer) PreStop() {
+	d.ready.Store(false)
+	d.stopping.Store(true)
+	shutdownPhase.Set(phasePreStop)
+	d.logger.Info("shutdown: not ready, waiting for traffic to stop",
+		"phase", "pre_stop", "delay", d.PreStopDelay.String(), "in_flight", d.inflight.Load())
+	time.Sleep(d.PreStopDelay)
+}
+
+// Drain stops the server, letting requests in flight finish, then stops the
+// background goroutines. Both share ctx's deadline.
+func (d *Drainer) Drain(ctx context.Context, srv *http.Server) {
+	shutdownPhase.Set(phaseDraining)
+	start := time.Now()
+	d.logger.Info("shutdown: draining requests", "phase", "draining", "in_flight", d.inflight.Load())
+	if err := srv.Shutdown(ctx); err != nil {
+		// Handlers still running are abandoned when the process exits.
+		d.logger.Error("shutdown: requests cut off", "phase", "draining",
+			"in_flight", d.inflight.Load(), "took", time.Since(start).String(), "error", err)
+		srv.Close()
+	} else {
+		d.logger.Info("shutdown: requests drained", "phase", "draining", "took", time.Since(start).String())
+	}
+
+	shutdownPhase.Set(phaseBackground)
+	start = time.Now()
+	d.bgCancel()
+	done := make(chan struct{})
+	go func() {
+		d.bgWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		d.logger.Info("shutdown: background work stopped", "phase", "background", "took", time.Since(start).String())
+	case <-ctx.Done():
+		d.logger.Error("shutdown: background work did not stop", "phase", "background",
+			"tasks", d.running(), "took", time.Since(start).String())
+	}
+}
+
+// running returns the background tasks still running, sorted.
+func (d *Drainer) running() []string {
+	d.bgMu.Lock()
+	defer d.bgMu.Unlock()
+	tasks := make([]string, 0, len(d.bgTasks))
+	for t := range d.bgTasks {
+		tasks = append(tasks, t)
+	}
+	sort.Strings(tasks)
+	return tasks
+}
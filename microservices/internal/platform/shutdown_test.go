@@ -0,0 +1,113 @@
+package platform
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startDrainServer serves /slow, which blocks until release is closed, and
+// /fast behind d's middleware.
+func startDrainServer(t *testing.T, d *Drainer, release chan struct{}) (*http.Server, string) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) { <-release })
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &http.Server{Handler: d.Middleware(mux)}
+	go hs.Serve(ln)
+	return hs, "http://" + ln.Addr().String()
+}
+
+func waitInFlight(t *testing.T, d *Drainer, n int64) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); d.inflight.Load() != n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("in flight: got %d, want %d", d.inflight.Load(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDrainerFinishesInFlightWork(t *testing.T) {
+	var ready atomic.Bool
+	ready.Store(true)
+	d := NewDrainer(slog.New(slog.NewTextHandler(io.Discard, nil)), &ready)
+	d.PreStopDelay = 10 * time.Millisecond
+	release := make(chan struct{})
+	hs, url := startDrainServer(t, d, release)
+
+	var bgStopped atomic.Bool
+	d.GoBackground("ticker", func(ctx context.Context) {
+		<-ctx.Done()
+		bgStopped.Store(true)
+	})
+	slow := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		resp.Body.Close()
+		slow <- resp.StatusCode
+	}()
+	waitInFlight(t, d, 1)
+
+	// Not ready, but still serving while load balancers catch up.
+	d.PreStop()
+	if ready.Load() {
+		t.Error("still ready after PreStop")
+	}
+	resp, err := http.Get(url + "/fast")
+	if err != nil {
+		t.Fatalf("request during pre-stop delay: %v", err)
+	}
+	resp.Body.Close()
+
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	d.Drain(ctx, hs)
+
+	if code := <-slow; code != http.StatusOK {
+		t.Errorf("in-flight request: got %d, want 200", code)
+	}
+	if !bgStopped.Load() || len(d.running()) != 0 {
+		t.Errorf("background work: stopped=%v running=%v", bgStopped.Load(), d.running())
+	}
+	if _, err := http.Get(url + "/fast"); err == nil {
+		t.Error("server still accepting requests after drain")
+	}
+}
+
+func TestDrainerDeadline(t *testing.T) {
+	var ready atomic.Bool
+	d := NewDrainer(slog.New(slog.NewTextHandler(io.Discard, nil)), &ready)
+	release := make(chan struct{})
+	defer close(release)
+	hs, url := startDrainServer(t, d, release)
+
+	d.GoBackground("stuck", func(context.Context) { <-release })
+	go http.Get(url + "/slow")
+	waitInFlight(t, d, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	d.Drain(ctx, hs)
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("drain took %v, want it cut off at the deadline", took)
+	}
+	if got := d.running(); len(got) != 1 || got[0] != "stuck" {
+		t.Errorf("running: got %v, want [stuck]", got)
+	}
+}
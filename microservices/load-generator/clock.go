@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Virtual clock
+// ---------------------------------------------------------------------------
+//
+// The daily traffic and session curves follow a virtual clock instead of the
+// wall clock, so a day of seasonality can be played in a CI-length run. The
+// same settings are read by the load generator and user-service, and both
+// should be given the same values:
+//
+//   CLOCK_COMPRESSION  how many times faster than real time the clock runs;
+//                      60 plays a day in 24 minutes (default 1)
+//   CLOCK_ORIGIN       the real time, in RFC 3339, at which the clock reads
+//                      CLOCK_START (default: when the process started)
+//   CLOCK_START        where the clock starts: an RFC 3339 time, or a time of
+//                      day such as 06:00 on the origin's date (default: the
+//                      origin)
+//   CLOCK_TIMEZONE     IANA zone the curves are evaluated in (default: TZ)
+//
+// Processes that start at different times only agree on the virtual time
+// if they share CLOCK_ORIGIN; without it each runs from its own start.
+//
+// Only the curves use it. Timeouts, bursts, timestamps and metrics stay on
+// real time.
+
+type virtualClock struct {
+	origin      time.Time // real time the clock started
+	start       time.Time // virtual time at origin
+	compression float64
+	real        func() time.Time
+}
+
+// newVirtualClock returns a clock that reads start at the real time origin.
+func newVirtualClock(origin, start time.Time, compression float64, real func() time.Time) *virtualClock {
+	return &virtualClock{origin: origin, start: start, compression: compression, real: real}
+}
+
+// Now returns the virtual time. A nil clock is the wall clock.
+func (c *virtualClock) Now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	elapsed := c.real().Sub(c.origin)
+	return c.start.Add(time.Duration(float64(elapsed) * c.compression))
+}
+
+// clockFromEnv builds the clock from the CLOCK_* variables.
+func clockFromEnv() (*virtualClock, error) {
+	compression, err := strconv.ParseFloat(getEnv("CLOCK_COMPRESSION", "1"), 64)
+	if err != nil || compression <= 0 {
+		return nil, fmt.Errorf("CLOCK_COMPRESSION must be a positive number, got %q", getEnv("CLOCK_COMPRESSION", "1"))
+	}
+	loc := time.Local
+	if name := getEnv("CLOCK_TIMEZONE", ""); name != "" {
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("CLOCK_TIMEZONE: %w", err)
+		}
+	}
+	origin := time.Now()
+	if s := getEnv("CLOCK_ORIGIN", ""); s != "" {
+		if origin, err = time.Parse(time.RFC3339, s); err != nil {
+			return nil, fmt.Errorf("CLOCK_ORIGIN must be an RFC 3339 time, got %q", s)
+		}
+	}
+	start, err := parseClockStart(getEnv("CLOCK_START", ""), origin.In(loc))
+	if err != nil {
+		return nil, err
+	}
+	return newVirtualClock(origin, start, compression, time.Now), nil
+}
+
+// parseClockStart reads an RFC 3339 time, or a time of day on now's date.
+func parseClockStart(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.In(now.Location()), nil
+	}
+	if t, err := time.Parse("15:04", s); err == nil {
+		y, m, d := now.Date()
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, now.Location()), nil
+	}
+	return time.Time{}, fmt.Errorf("CLOCK_START must be an RFC 3339 time or HH:MM, got %q", s)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeTime is a real clock the test moves by hand.
+type fakeTime struct{ t time.Time }
+
+func (f *fakeTime) now() time.Time { return f.t }
+
+func TestVirtualClockCompressesADay(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	real := &fakeTime{t: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)}
+	start, err := parseClockStart("03:00", real.t.In(loc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newVirtualClock(real.t, start, 60, real.now)
+
+	// A day in 24 real minutes: 03:00 is the trough, 12:00 the peak.
+	if got := clock.Now(); got.Hour() != 3 || got.Location() != loc {
+		t.Fatalf("start: %v", got)
+	}
+	trough := diurnalMultiplier(clock.Now())
+	real.t = real.t.Add(9 * time.Minute)
+	if got := clock.Now(); got.Hour() != 12 {
+		t.Fatalf("after 9 real minutes: %v, want 12:00", got)
+	}
+	peak := diurnalMultiplier(clock.Now())
+	real.t = real.t.Add(15 * time.Minute)
+	if got := clock.Now(); got.Hour() != 3 || got.Day() != start.Day()+1 {
+		t.Fatalf("after 24 real minutes: %v, want 03:00 the next day", got)
+	}
+	if peak != 1 || trough >= 0.35 {
+		t.Errorf("peak %g, trough %g", peak, trough)
+	}
+
+	var wall *virtualClock
+	if d := time.Since(wall.Now()); d < 0 || d > time.Second {
+		t.Errorf("nil clock is %v off the wall clock", d)
+	}
+}
+
+func TestVirtualClockSharedOrigin(t *testing.T) {
+	t.Setenv("CLOCK_COMPRESSION", "60")
+	t.Setenv("CLOCK_ORIGIN", time.Now().Add(-10*time.Minute).UTC().Format(time.RFC3339))
+	t.Setenv("CLOCK_START", "2024-03-01T03:00:00Z")
+	t.Setenv("CLOCK_TIMEZONE", "UTC")
+
+	// A process started ten real minutes after the origin reads the same
+	// time as one started at it: ten virtual hours after the start. RFC 3339
+	// drops the origin's fraction of a second, up to a virtual minute.
+	clock, err := clockFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	if d := clock.Now().Sub(want); d < 0 || d > 2*time.Minute {
+		t.Errorf("virtual time %v, want about %v", clock.Now(), want)
+	}
+
+	t.Setenv("CLOCK_ORIGIN", "yesterday")
+	if _, err := clockFromEnv(); err == nil {
+		t.Error("CLOCK_ORIGIN=yesterday: expected error")
+	}
+}
+
+func TestParseClockStart(t *testing.T) {
+	now := time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"":                     now,
+		"06:15":                time.Date(2024, 3, 1, 6, 15, 0, 0, time.UTC),
+		"2024-06-01T00:00:00Z": time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for in, want := range tests {
+		if got, err := parseClockStart(in, now); err != nil || !got.Equal(want) {
+			t.Errorf("%q: got %v, %v, want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseClockStart("6am", now); err == nil {
+		t.Error("6am: expected error")
+	}
+}
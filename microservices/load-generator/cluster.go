@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Distributed mode
+// ---------------------------------------------------------------------------
+//
+// MODE=coordinator runs no traffic itself. It owns the traffic phase (active
+// scenario, and per target: paused, base RPS and burst) and pushes it to the
+// WORKERS over HTTP, splitting each target's RPS evenly across the healthy
+// workers. It pushes whenever the phase or the set of healthy workers
+// changes, and at least every CLUSTER_SYNC_INTERVAL so a restarted worker
+// catches up. Burst end times are absolute, so workers with synchronised
+// clocks burst together.
+//
+// MODE=worker starts paused and generates only what its latest assignment
+// says. If no assignment arrives for three sync intervals it pauses again,
+// so a lost coordinator never leaves traffic running unattended.
+//
+// Workers keep HDR latency histograms per target; the coordinator's
+// /control/report merges them into one report.
+
+const (
+	modeStandalone  = "standalone"
+	modeCoordinator = "coordinator"
+	modeWorker      = "worker"
+	modeReplay      = "replay" // see replay.go
+)
+
+var errStaleAssignment = errors.New("assignment is older than the one applied")
+
+// assignment is what a coordinator pushes to one worker.
+type assignment struct {
+	Epoch    int64                  `json:"epoch"`   // coordinator start time, unix nanos
+	Version  int64                  `json:"version"` // increases with every push within an epoch
+	Worker   int                    `json:"worker"`
+	Workers  int                    `json:"workers"` // healthy workers sharing the load
+	Scenario string                 `json:"scenario"`
+	Lease    string                 `json:"lease"` // pause if no newer assignment arrives within this duration
+	Targets  map[string]targetPhase `json:"targets"`
+}
+
+type targetPhase struct {
+	Paused          bool      `json:"paused"`
+	RPS             float64   `json:"rps"` // this worker's share of the base rate
+	BurstMultiplier float64   `json:"burst_multiplier,omitempty"`
+	BurstEnd        time.Time `json:"burst_end"`
+}
+
+// workerState is the assignment a worker is currently following.
+type workerState struct {
+	mu        sync.Mutex
+	current   assignment
+	expiresAt time.Time
+}
+
+// applyAssignment makes a the worker's phase. Assignments from an older push
+// of the same coordinator are rejected.
+func (lg *loadGenerator) applyAssignment(a assignment, now time.Time) error {
+	lease, err := time.ParseDuration(a.Lease)
+	if err != nil || lease <= 0 {
+		return fmt.Errorf("invalid lease %q", a.Lease)
+	}
+	if _, ok := lg.scenarios[a.Scenario]; !ok {
+		return fmt.Errorf("unknown scenario %q", a.Scenario)
+	}
+
+	ws := &lg.worker
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if a.Epoch == ws.current.Epoch && a.Version <= ws.current.Version {
+		return errStaleAssignment
+	}
+
+	if lg.activeScenario().Name != a.Scenario {
+		lg.setScenario(a.Scenario)
+	}
+	for name, st := range lg.states {
+		p, ok := a.Targets[name]
+		if !ok {
+			p = targetPhase{Paused: true}
+		}
+		st.mu.Lock()
+		st.paused = p.Paused
+		st.baseRPS = p.RPS
+		st.burstMult = p.BurstMultiplier
+		st.burstEnd = p.BurstEnd
+		st.mu.Unlock()
+		targetPaused.WithLabelValues(name).Set(boolFloat(p.Paused))
+	}
+	if a.Epoch != ws.current.Epoch {
+		lg.logger.Info("following coordinator", "epoch", a.Epoch, "worker", a.Worker, "workers", a.Workers)
+	}
+	ws.current = a
+	ws.expiresAt = now.Add(lease)
+	return nil
+}
+
+// watchAssignment pauses every target once the current assignment's lease
+// runs out.
+func (lg *loadGenerator) watchAssignment(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ws := &lg.worker
+			ws.mu.Lock()
+			expired := !ws.expiresAt.IsZero() && now.After(ws.expiresAt)
+			if expired {
+				ws.expiresAt = time.Time{}
+			}
+			ws.mu.Unlock()
+			if !expired {
+				continue
+			}
+			lg.logger.Warn("assignment lease expired, pausing until the coordinator is back")
+			for name, st := range lg.states {
+				st.mu.Lock()
+				st.paused = true
+				st.mu.Unlock()
+				targetPaused.WithLabelValues(name).Set(1)
+			}
+		}
+	}
+}
+
+func (lg *loadGenerator) handleAssignment(w http.ResponseWriter, r *http.Request) {
+	if lg.cfg.Mode != modeWorker {
+		writeJSONError(w, "not running as a worker", http.StatusConflict)
+		return
+	}
+	var a assignment
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		writeJSONError(w, "invalid assignment", http.StatusBadRequest)
+		return
+	}
+	err := lg.applyAssignment(a, time.Now())
+	switch {
+	case errors.Is(err, errStaleAssignment):
+		writeJSONError(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"version": a.Version})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Reports
+// ---------------------------------------------------------------------------
+
+// workerReport is the raw, mergeable form of one process's stats.
+type workerReport struct {
+	Worker  int                     `json:"worker"`
+	Version int64                   `json:"version"`
+	Targets map[string]targetReport `json:"targets"`
+}
+
+type targetReport struct {
+	Sent         int64             `json:"sent"`
+	Succeeded    int64             `json:"succeeded"`
+	Invalid      int64             `json:"invalid"`
+	ClientErrors int64             `json:"client_errors"`
+	ServerErrors int64             `json:"server_errors"`
+	Failed       int64             `json:"failed"`
+	Latency      histogramSnapshot `json:"latency"`
+}
+
+// targetSummary is one target's line of a merged report.
+type targetSummary struct {
+	Sent         int64          `json:"sent"`
+	Succeeded    int64          `json:"succeeded"`
+	Invalid      int64          `json:"invalid"`
+	ClientErrors int64          `json:"client_errors"`
+	ServerErrors int64          `json:"server_errors"`
+	Failed       int64          `json:"failed"`
+	Latency      latencySummary `json:"latency"`
+}
+
+type workerSummary struct {
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+	Sent    int64  `json:"sent"`
+	Error   string `json:"error,omitempty"`
+}
+
+type clusterReport struct {
+	Mode     string                   `json:"mode"`
+	Scenario string                   `json:"scenario"`
+	Workers  []workerSummary          `json:"workers,omitempty"`
+	Targets  map[string]targetSummary `json:"targets"`
+}
+
+func (lg *loadGenerator) localReport() workerReport {
+	lg.worker.mu.Lock()
+	rep := workerReport{Worker: lg.worker.current.Worker, Version: lg.worker.current.Version}
+	lg.worker.mu.Unlock()
+	rep.Targets = make(map[string]targetReport, len(lg.states))
+	for name, st := range lg.states {
+		rep.Targets[name] = targetReport{
+			Sent:         st.stats.sent.Load(),
+			Succeeded:    st.stats.succeeded.Load(),
+			Invalid:      st.stats.invalid.Load(),
+			ClientErrors: st.stats.clientErrors.Load(),
+			ServerErrors: st.stats.serverErrors.Load(),
+			Failed:       st.stats.failed.Load(),
+			Latency:      st.stats.latency.snapshot(),
+		}
+	}
+	return rep
+}
+
+// mergeReports sums the counters and merges the latency histograms of
+// several processes.
+func mergeReports(reports []workerReport) (map[string]targetSummary, error) {
+	hists := make(map[string]*histogram)
+	out := make(map[string]targetSummary)
+	for _, rep := range reports {
+		for name, t := range rep.Targets {
+			s := out[name]
+			s.Sent += t.Sent
+			s.Succeeded += t.Succeeded
+			s.Invalid += t.Invalid
+			s.ClientErrors += t.ClientErrors
+			s.ServerErrors += t.ServerErrors
+			s.Failed += t.Failed
+			out[name] = s
+			if hists[name] == nil {
+				hists[name] = &histogram{}
+			}
+			if err := hists[name].merge(t.Latency); err != nil {
+				return nil, fmt.Errorf("worker %d, %s: %w", rep.Worker, name, err)
+			}
+		}
+	}
+	for name, h := range hists {
+		s := out[name]
+		s.Latency = h.summary()
+		out[name] = s
+	}
+	return out, nil
+}
+
+func (lg *loadGenerator) handleWorkerReport(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, lg.localReport())
+}
+
+// handleReport serves the merged report: of every worker on a coordinator,
+// of this process otherwise.
+func (lg *loadGenerator) handleReport(w http.ResponseWriter, r *http.Request) {
+	rep := clusterReport{Mode: lg.cfg.Mode, Scenario: lg.activeScenario().Name}
+	reports := []workerReport{lg.localReport()}
+	if lg.coord != nil {
+		reports, rep.Workers = lg.coord.collectReports(r.Context())
+	}
+	targets, err := mergeReports(reports)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	rep.Targets = targets
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// ---------------------------------------------------------------------------
+// Coordinator
+// ---------------------------------------------------------------------------
+
+type coordinator struct {
+	lg       *loadGenerator
+	workers  []string
+	client   *http.Client
+	epoch    int64
+	interval time.Duration
+
+	mu      sync.Mutex
+	version int64
+	healthy map[string]bool
+}
+
+func newCoordinator(lg *loadGenerator, workers []string) *coordinator {
+	c := &coordinator{
+		lg:       lg,
+		workers:  workers,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		epoch:    time.Now().UnixNano(),
+		interval: lg.cfg.ClusterSyncInterval,
+		healthy:  make(map[string]bool, len(workers)),
+	}
+	if c.interval <= 0 {
+		c.interval = 5 * time.Second
+	}
+	for _, w := range workers {
+		c.healthy[w] = true // until a push says otherwise
+	}
+	return c
+}
+
+// run ticks every target once a second, which is what starts random bursts
+// in this mode, and pushes the phase to the workers when it changes.
+func (c *coordinator) run(ctx context.Context) {
+	c.lg.logger.Info("coordinating workers", "workers", c.workers, "sync_interval", c.interval)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastPhase, lastSync := c.phaseKey(), time.Now()
+	c.sync(ctx, lastSync)
+	for {
+		select {
+		case <-ctx.Done():
+			c.stopWorkers()
+			return
+		case <-c.lg.phaseChanged:
+		case <-ticker.C:
+		}
+		now := time.Now()
+		for _, st := range c.lg.states {
+			st.tick(now, c.lg.cfg)
+		}
+		phase := c.phaseKey()
+		if phase != lastPhase || now.Sub(lastSync) >= c.interval {
+			c.sync(ctx, now)
+			// Health may have changed during the push; the next tick will
+			// notice and redistribute.
+			lastPhase, lastSync = phase, now
+		}
+	}
+}
+
+// phaseKey identifies everything an assignment is derived from.
+func (c *coordinator) phaseKey() string {
+	var b strings.Builder
+	b.WriteString(c.lg.activeScenario().Name)
+	for _, name := range c.stateNames() {
+		st := c.lg.states[name]
+		st.mu.Lock()
+		fmt.Fprintf(&b, "|%s:%t:%g:%g:%d", name, st.paused, st.baseRPS, st.burstMult, st.burstEnd.UnixNano())
+		st.mu.Unlock()
+	}
+	c.mu.Lock()
+	for _, w := range c.workers {
+		fmt.Fprintf(&b, "|%s:%t", w, c.healthy[w])
+	}
+	c.mu.Unlock()
+	return b.String()
+}
+
+func (c *coordinator) stateNames() []string {
+	names := make([]string, 0, len(c.lg.states))
+	for name := range c.lg.states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// assignments returns each worker's assignment for the current phase.
+// Unhealthy workers get a paused one: if it lands, they count as healthy
+// again and receive a share from the next push.
+func (c *coordinator) assignments(now time.Time) map[string]assignment {
+	c.mu.Lock()
+	c.version++
+	version := c.version
+	active := 0
+	for _, w := range c.workers {
+		if c.healthy[w] {
+			active++
+		}
+	}
+	healthy := make(map[string]bool, len(c.healthy))
+	for w, h := range c.healthy {
+		healthy[w] = h
+	}
+	c.mu.Unlock()
+
+	share := make(map[string]targetPhase, len(c.lg.states))
+	paused := make(map[string]targetPhase, len(c.lg.states))
+	for name, st := range c.lg.states {
+		st.mu.Lock()
+		p := targetPhase{Paused: st.paused}
+		if active > 0 {
+			p.RPS = st.baseRPS / float64(active)
+		}
+		if now.Before(st.burstEnd) {
+			p.BurstMultiplier, p.BurstEnd = st.burstMult, st.burstEnd
+		}
+		st.mu.Unlock()
+		share[name] = p
+		paused[name] = targetPhase{Paused: true}
+	}
+
+	out := make(map[string]assignment, len(c.workers))
+	for i, w := range c.workers {
+		a := assignment{
+			Epoch:    c.epoch,
+			Version:  version,
+			Worker:   i,
+			Workers:  active,
+			Scenario: c.lg.activeScenario().Name,
+			Lease:    (3 * c.interval).String(),
+			Targets:  share,
+		}
+		if !healthy[w] {
+			a.Targets = paused
+		}
+		out[w] = a
+	}
+	return out
+}
+
+func (c *coordinator) sync(ctx context.Context, now time.Time) {
+	c.pushAll(ctx, c.assignments(now))
+}
+
+// stopWorkers pauses every worker when the coordinator shuts down.
+func (c *coordinator) stopWorkers() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	as := c.assignments(time.Now())
+	for w, a := range as {
+		paused := make(map[string]targetPhase, len(a.Targets))
+		for name := range a.Targets {
+			paused[name] = targetPhase{Paused: true}
+		}
+		a.Targets = paused
+		as[w] = a
+	}
+	c.pushAll(ctx, as)
+	c.lg.logger.Info("paused all workers")
+}
+
+func (c *coordinator) pushAll(ctx context.Context, as map[string]assignment) {
+	var wg sync.WaitGroup
+	for w, a := range as {
+		wg.Add(1)
+		go func(w string, a assignment) {
+			defer wg.Done()
+			err := c.push(ctx, w, a)
+			result := "success"
+			if err != nil {
+				result = "error"
+			}
+			clusterPushesTotal.WithLabelValues(result).Inc()
+
+			c.mu.Lock()
+			was := c.healthy[w]
+			c.healthy[w] = err == nil
+			c.mu.Unlock()
+			switch {
+			case was && err != nil:
+				c.lg.logger.Warn("worker unreachable, redistributing its share", "worker", w, "error", err)
+			case !was && err == nil:
+				c.lg.logger.Info("worker back, redistributing load", "worker", w)
+			}
+		}(w, a)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	healthy := 0
+	for _, h := range c.healthy {
+		if h {
+			healthy++
+		}
+	}
+	c.mu.Unlock()
+	clusterWorkers.WithLabelValues("healthy").Set(float64(healthy))
+	clusterWorkers.WithLabelValues("unhealthy").Set(float64(len(c.workers) - healthy))
+}
+
+// push sends one assignment. A stale-assignment conflict still proves the
+// worker is reachable and following this coordinator.
+func (c *coordinator) push(ctx context.Context, worker string, a assignment) error {
+	body, _ := json.Marshal(a)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, worker+"/cluster/assignment", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.authorize(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("assignment rejected with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// collectReports fetches every worker's raw report. Unreachable workers are
+// listed with their error and left out of the merge.
+func (c *coordinator) collectReports(ctx context.Context) ([]workerReport, []workerSummary) {
+	reports := make([]*workerReport, len(c.workers))
+	summaries := make([]workerSummary, len(c.workers))
+	var wg sync.WaitGroup
+	for i, w := range c.workers {
+		wg.Add(1)
+		go func(i int, w string) {
+			defer wg.Done()
+			c.mu.Lock()
+			summaries[i] = workerSummary{URL: w, Healthy: c.healthy[w]}
+			c.mu.Unlock()
+			rep, err := c.fetchReport(ctx, w)
+			if err != nil {
+				summaries[i].Error = err.Error()
+				return
+			}
+			for _, t := range rep.Targets {
+				summaries[i].Sent += t.Sent
+			}
+			reports[i] = rep
+		}(i, w)
+	}
+	wg.Wait()
+
+	out := make([]workerReport, 0, len(reports))
+	for _, rep := range reports {
+		if rep != nil {
+			out = append(out, *rep)
+		}
+	}
+	return out, summaries
+}
+
+func (c *coordinator) fetchReport(ctx context.Context, worker string) (*workerReport, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, worker+"/cluster/report", nil)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("report returned status %d", resp.StatusCode)
+	}
+	var rep workerReport
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		return nil, fmt.Errorf("decoding report: %w", err)
+	}
+	return &rep, nil
+}
+
+// authorize uses the shared CONTROL_TOKEN, which workers check on /cluster.
+func (c *coordinator) authorize(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+c.lg.cfg.ControlToken)
+}
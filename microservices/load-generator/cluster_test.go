@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// clusterNode is one load generator process with its control API served on
+// a local port, as it would run on a developer machine.
+type clusterNode struct {
+	lg  *loadGenerator
+	srv *httptest.Server
+}
+
+func startClusterNode(t *testing.T, cfg config, targetURL string) *clusterNode {
+	t.Helper()
+	cfg.DataDir = t.TempDir()
+	lg, err := newLoadGenerator(slog.New(slog.NewTextHandler(io.Discard, nil)), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range lg.targets {
+		lg.targets[i].BaseURL = targetURL
+	}
+	mux := http.NewServeMux()
+	lg.registerControlRoutes(mux)
+	n := &clusterNode{lg: lg, srv: httptest.NewServer(mux)}
+	t.Cleanup(n.srv.Close)
+	return n
+}
+
+// waitFor polls cond until it holds or the deadline passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func baseRPSOf(n *clusterNode, target string) (float64, bool) {
+	st := n.lg.states[target]
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.baseRPS, st.paused
+}
+
+func TestDistributedLoadGeneration(t *testing.T) {
+	var received atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		w.Write([]byte(`{}`))
+	}))
+	defer target.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	var workers []*clusterNode
+	var urls []string
+	for i := 0; i < 3; i++ {
+		w := startClusterNode(t, config{Mode: modeWorker, ControlToken: "test-token"}, target.URL)
+		workers = append(workers, w)
+		urls = append(urls, w.srv.URL)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.lg.run(ctx)
+		}()
+	}
+	coord := startClusterNode(t, config{
+		Mode:                modeCoordinator,
+		ControlToken:        "test-token",
+		Workers:             urls,
+		BaseRPS:             60,
+		ClusterSyncInterval: 500 * time.Millisecond,
+	}, target.URL)
+	coordCtx, stopCoord := context.WithCancel(ctx)
+	coordDone := make(chan struct{})
+	go func() {
+		defer close(coordDone)
+		coord.lg.run(coordCtx)
+	}()
+
+	// Each worker gets a third of the base rate.
+	waitFor(t, "workers to receive their share", func() bool {
+		for _, w := range workers {
+			if rps, paused := baseRPSOf(w, "order-service"); rps != 20 || paused {
+				return false
+			}
+		}
+		return true
+	})
+	waitFor(t, "every worker to send traffic", func() bool {
+		for _, w := range workers {
+			if w.lg.states["user-service"].stats.sent.Load() == 0 {
+				return false
+			}
+		}
+		return true
+	})
+
+	call := func(method, url string) *http.Response {
+		req, _ := http.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Workers refuse local phase changes.
+	resp := call("POST", workers[0].srv.URL+"/control/targets/all/pause")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("pause on a worker: status %d, want 409", resp.StatusCode)
+	}
+
+	// A phase change on the coordinator reaches every worker.
+	call("POST", coord.srv.URL+"/control/targets/all/pause").Body.Close()
+	waitFor(t, "workers to pause", func() bool {
+		for _, w := range workers {
+			if _, paused := baseRPSOf(w, "order-service"); !paused {
+				return false
+			}
+		}
+		return true
+	})
+	time.Sleep(200 * time.Millisecond) // let in-flight requests finish
+
+	// The merged report adds up every worker's traffic.
+	resp = call("GET", coord.srv.URL+"/control/report")
+	var rep clusterReport
+	json.NewDecoder(resp.Body).Decode(&rep)
+	resp.Body.Close()
+	var sent, succeeded, latencyCount int64
+	for _, ts := range rep.Targets {
+		sent += ts.Sent
+		succeeded += ts.Succeeded + ts.Invalid // the stub target's {} fails the scenario's schemas
+		latencyCount += ts.Latency.Count
+	}
+	if sent != received.Load() || succeeded != sent || latencyCount != sent {
+		t.Errorf("report: sent %d succeeded %d latency samples %d, target received %d",
+			sent, succeeded, latencyCount, received.Load())
+	}
+	if len(rep.Workers) != 3 {
+		t.Fatalf("report lists %d workers, want 3", len(rep.Workers))
+	}
+	for _, ws := range rep.Workers {
+		if !ws.Healthy || ws.Sent == 0 {
+			t.Errorf("worker %s: %+v", ws.URL, ws)
+		}
+	}
+
+	// Losing a worker redistributes its share over the other two.
+	call("POST", coord.srv.URL+"/control/targets/all/resume").Body.Close()
+	workers[2].srv.Close()
+	waitFor(t, "share redistribution", func() bool {
+		for _, w := range workers[:2] {
+			if rps, paused := baseRPSOf(w, "order-service"); rps != 30 || paused {
+				return false
+			}
+		}
+		return true
+	})
+
+	// Stopping the coordinator pauses the workers.
+	stopCoord()
+	<-coordDone
+	for _, w := range workers[:2] {
+		if _, paused := baseRPSOf(w, "order-service"); !paused {
+			t.Errorf("worker %s still running after the coordinator stopped", w.srv.URL)
+		}
+	}
+}
+
+func TestApplyAssignmentRejectsStaleVersions(t *testing.T) {
+	lg, err := newLoadGenerator(slog.New(slog.NewTextHandler(io.Discard, nil)), config{Mode: modeWorker, ControlToken: "test-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	a := assignment{Epoch: 1, Version: 2, Scenario: "read-heavy", Lease: "15s",
+		Targets: map[string]targetPhase{"order-service": {RPS: 4}}}
+	if err := lg.applyAssignment(a, now); err != nil {
+		t.Fatal(err)
+	}
+	if lg.activeScenario().Name != "read-heavy" {
+		t.Errorf("scenario %q, want read-heavy", lg.activeScenario().Name)
+	}
+	if rps, paused := baseRPSOf(&clusterNode{lg: lg}, "order-service"); rps != 4 || paused {
+		t.Errorf("order-service: rps %g paused %t", rps, paused)
+	}
+	if _, paused := baseRPSOf(&clusterNode{lg: lg}, "user-service"); !paused {
+		t.Error("targets missing from the assignment should be paused")
+	}
+
+	a.Version = 1
+	if err := lg.applyAssignment(a, now); err != errStaleAssignment {
+		t.Errorf("older version: got %v, want errStaleAssignment", err)
+	}
+	a.Epoch = 2 // a restarted coordinator starts again from version 1
+	if err := lg.applyAssignment(a, now); err != nil {
+		t.Errorf("new epoch: %v", err)
+	}
+	a.Version, a.Lease = 2, "never"
+	if err := lg.applyAssignment(a, now); err == nil {
+		t.Error("invalid lease: expected error")
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Runtime traffic control
+// ---------------------------------------------------------------------------
+//
+// Every target service, plus the journey runner under the name "journeys",
+// has a targetState that the traffic loop consults on each tick. The control
+// API on the metrics server mutates it, so game days can pause, resume,
+// re-rate and burst traffic without restarting the container.
+
+const journeysTarget = "journeys"
+
+type targetState struct {
+	name         string
+	logger       *slog.Logger
+	randomBursts bool
+	rng          *rand.Rand // schedule, bursts and endpoint choices; see random.go
+
+	mu        sync.Mutex
+	paused    bool
+	baseRPS   float64
+	burstMult float64
+	burstEnd  time.Time
+
+	stats targetStats
+}
+
+// targetStats are cumulative since startup.
+type targetStats struct {
+	sent         atomic.Int64
+	succeeded    atomic.Int64 // valid 2xx/3xx responses, or successful journeys
+	invalid      atomic.Int64 // 2xx/3xx responses failing their expectation
+	clientErrors atomic.Int64
+	serverErrors atomic.Int64
+	failed       atomic.Int64 // connection errors, or failed journeys
+	latencyNanos atomic.Int64
+	latency      histogram // response latency, or duration of successful journeys
+}
+
+func (ts *targetStats) recordResponse(code int, d time.Duration, valid bool) {
+	ts.latencyNanos.Add(int64(d))
+	ts.latency.record(d)
+	switch {
+	case code >= 500:
+		ts.serverErrors.Add(1)
+	case code >= 400:
+		ts.clientErrors.Add(1)
+	case !valid:
+		ts.invalid.Add(1)
+	default:
+		ts.succeeded.Add(1)
+	}
+}
+
+func newTargetState(name string, logger *slog.Logger, baseRPS float64, randomBursts bool, rng *rand.Rand) *targetState {
+	return &targetState{name: name, logger: logger, baseRPS: baseRPS, randomBursts: randomBursts, rng: rng}
+}
+
+// tick returns the rate to generate at now, starting and ending bursts as it
+// goes. A zero rate means the target is paused or idle.
+func (st *targetState) tick(now time.Time, cfg config) float64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.paused {
+		return 0
+	}
+	rps := st.baseRPS * diurnalMultiplier(cfg.Clock.Now())
+
+	if !st.burstEnd.IsZero() && !now.Before(st.burstEnd) {
+		st.burstEnd = time.Time{}
+		st.logger.Info("burst traffic ended", "service", st.name)
+	}
+	if st.burstEnd.IsZero() && st.randomBursts && st.rng.Float64() < cfg.BurstProbability {
+		st.startBurstLocked(now, cfg.BurstMultiplier, cfg.BurstDuration, "random")
+	}
+	if !st.burstEnd.IsZero() {
+		rps *= st.burstMult
+	}
+	return rps
+}
+
+func (st *targetState) isPaused() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.paused
+}
+
+func (st *targetState) startBurstLocked(now time.Time, mult float64, d time.Duration, trigger string) {
+	st.burstMult = mult
+	st.burstEnd = now.Add(d)
+	st.logger.Warn("burst traffic started",
+		"service", st.name,
+		"multiplier", mult,
+		"duration", d,
+		"trigger", trigger)
+}
+
+type targetStatus struct {
+	Name           string  `json:"name"`
+	Paused         bool    `json:"paused"`
+	BaseRPS        float64 `json:"base_rps"`
+	CurrentRPS     float64 `json:"current_rps"`
+	BurstRemaining string  `json:"burst_remaining,omitempty"`
+	Sent           int64   `json:"sent"`
+	Succeeded      int64   `json:"succeeded"`
+	Invalid        int64   `json:"invalid"`
+	ClientErrors   int64   `json:"client_errors"`
+	ServerErrors   int64   `json:"server_errors"`
+	Failed         int64   `json:"failed"`
+	AvgLatencyMs   float64 `json:"avg_latency_ms"`
+}
+
+// status reports the target at now, with diurnal the current multiplier.
+func (st *targetState) status(now time.Time, diurnal float64) targetStatus {
+	st.mu.Lock()
+	s := targetStatus{Name: st.name, Paused: st.paused, BaseRPS: st.baseRPS}
+	if !st.paused {
+		s.CurrentRPS = st.baseRPS * diurnal
+		if now.Before(st.burstEnd) {
+			s.CurrentRPS *= st.burstMult
+			s.BurstRemaining = st.burstEnd.Sub(now).Round(time.Second).String()
+		}
+	}
+	st.mu.Unlock()
+
+	s.Sent = st.stats.sent.Load()
+	s.Succeeded = st.stats.succeeded.Load()
+	s.Invalid = st.stats.invalid.Load()
+	s.ClientErrors = st.stats.clientErrors.Load()
+	s.ServerErrors = st.stats.serverErrors.Load()
+	s.Failed = st.stats.failed.Load()
+	if done := s.Succeeded + s.Invalid + s.ClientErrors + s.ServerErrors; done > 0 {
+		s.AvgLatencyMs = float64(st.stats.latencyNanos.Load()) / float64(done) / 1e6
+	}
+	return s
+}
+
+// ---------------------------------------------------------------------------
+// Control API
+// ---------------------------------------------------------------------------
+
+// registerControlRoutes mounts the control API. Without CONTROL_TOKEN only
+// the read-only routes are mounted: anyone who could reach the port could
+// otherwise shape the traffic.
+func (lg *loadGenerator) registerControlRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /control/stats", lg.controlAuth(lg.handleStats))
+	mux.HandleFunc("GET /control/report", lg.controlAuth(lg.handleReport))
+	mux.HandleFunc("GET /control/scenarios", lg.controlAuth(lg.handleListScenarios))
+	mux.HandleFunc("GET /cluster/report", lg.controlAuth(lg.handleWorkerReport))
+	if lg.cfg.ControlToken == "" {
+		return
+	}
+
+	mux.HandleFunc("POST /control/targets/{target}/pause", lg.controlAuth(lg.phaseChange(lg.handlePause)))
+	mux.HandleFunc("POST /control/targets/{target}/resume", lg.controlAuth(lg.phaseChange(lg.handleResume)))
+	mux.HandleFunc("PUT /control/targets/{target}/rps", lg.controlAuth(lg.phaseChange(lg.handleSetRPS)))
+	mux.HandleFunc("POST /control/targets/{target}/burst", lg.controlAuth(lg.phaseChange(lg.handleBurst)))
+	mux.HandleFunc("PUT /control/scenario", lg.controlAuth(lg.phaseChange(lg.handleSetScenario)))
+	mux.HandleFunc("PUT /cluster/assignment", lg.controlAuth(lg.handleAssignment))
+}
+
+// controlAuth requires "Authorization: Bearer $CONTROL_TOKEN" when a token is
+// configured, compared in constant time.
+func (lg *loadGenerator) controlAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		want := "Bearer " + lg.cfg.ControlToken
+		if lg.cfg.ControlToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			writeJSONError(w, "missing or invalid control token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// phaseChange wraps handlers that change the traffic phase. Workers refuse
+// them, since their next assignment would undo the change; a coordinator is
+// woken to push the change out straight away.
+func (lg *loadGenerator) phaseChange(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if lg.cfg.Mode == modeWorker {
+			writeJSONError(w, "traffic is managed by the coordinator", http.StatusConflict)
+			return
+		}
+		next(w, r)
+		select {
+		case lg.phaseChanged <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// selectTargets resolves the {target} path value; "all" selects every target.
+func (lg *loadGenerator) selectTargets(w http.ResponseWriter, r *http.Request) ([]*targetState, bool) {
+	name := r.PathValue("target")
+	if name == "all" {
+		out := make([]*targetState, 0, len(lg.states))
+		for _, st := range lg.states {
+			out = append(out, st)
+		}
+		return out, true
+	}
+	st, ok := lg.states[name]
+	if !ok {
+		writeJSONError(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+		return nil, false
+	}
+	return []*targetState{st}, true
+}
+
+func (lg *loadGenerator) handleStats(w http.ResponseWriter, _ *http.Request) {
+	now, virtual := time.Now(), lg.cfg.Clock.Now()
+	diurnal := diurnalMultiplier(virtual)
+	targets := make([]targetStatus, 0, len(lg.states))
+	for _, st := range lg.states {
+		targets = append(targets, st.status(now, diurnal))
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"scenario":           lg.activeScenario().Name,
+		"virtual_time":       virtual.Format(time.RFC3339),
+		"diurnal_multiplier": diurnal,
+		"targets":            targets,
+	})
+}
+
+func (lg *loadGenerator) handlePause(w http.ResponseWriter, r *http.Request) {
+	lg.setPaused(w, r, true)
+}
+
+func (lg *loadGenerator) handleResume(w http.ResponseWriter, r *http.Request) {
+	lg.setPaused(w, r, false)
+}
+
+func (lg *loadGenerator) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	states, ok := lg.selectTargets(w, r)
+	if !ok {
+		return
+	}
+	for _, st := range states {
+		st.mu.Lock()
+		st.paused = paused
+		st.mu.Unlock()
+		targetPaused.WithLabelValues(st.name).Set(boolFloat(paused))
+		lg.logger.Info("traffic control", "service", st.name, "paused", paused)
+	}
+	lg.handleStats(w, r)
+}
+
+func (lg *loadGenerator) handleSetRPS(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RPS *float64 `json:"rps"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RPS == nil {
+		writeJSONError(w, `body must be {"rps": <number>}`, http.StatusBadRequest)
+		return
+	}
+	if *req.RPS < 0 || *req.RPS > 10000 {
+		writeJSONError(w, "rps must be between 0 and 10000", http.StatusBadRequest)
+		return
+	}
+	states, ok := lg.selectTargets(w, r)
+	if !ok {
+		return
+	}
+	for _, st := range states {
+		st.mu.Lock()
+		st.baseRPS = *req.RPS
+		st.mu.Unlock()
+		lg.logger.Info("traffic control", "service", st.name, "base_rps", *req.RPS)
+	}
+	lg.handleStats(w, r)
+}
+
+func (lg *loadGenerator) handleBurst(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Multiplier float64 `json:"multiplier"`
+		Duration   string  `json:"duration"`
+	}{Multiplier: lg.cfg.BurstMultiplier, Duration: lg.cfg.BurstDuration.String()}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d <= 0 || d > time.Hour {
+		writeJSONError(w, "duration must be a Go duration up to 1h", http.StatusBadRequest)
+		return
+	}
+	if req.Multiplier < 1 || req.Multiplier > 100 {
+		writeJSONError(w, "multiplier must be between 1 and 100", http.StatusBadRequest)
+		return
+	}
+	states, ok := lg.selectTargets(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	for _, st := range states {
+		st.mu.Lock()
+		st.startBurstLocked(now, req.Multiplier, d, "control_api")
+		st.mu.Unlock()
+	}
+	lg.handleStats(w, r)
+}
+
+func (lg *loadGenerator) handleListScenarios(w http.ResponseWriter, _ *http.Request) {
+	type scenarioInfo struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Active      bool   `json:"active"`
+	}
+	active := lg.activeScenario().Name
+	out := make([]scenarioInfo, 0, len(lg.scenarios))
+	for _, name := range scenarioNames(lg.scenarios) {
+		out = append(out, scenarioInfo{Name: name, Description: lg.scenarios[name].Description, Active: name == active})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (lg *loadGenerator) handleSetScenario(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, `body must be {"name": "<scenario>"}`, http.StatusBadRequest)
+		return
+	}
+	if err := lg.setScenario(req.Name); err != nil {
+		writeJSONError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	lg.handleStats(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, msg string, code int) {
+	writeJSON(w, code, map[string]interface{}{"error": msg, "code": code})
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func controlRequest(t *testing.T, lg *loadGenerator, method, path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	mux := http.NewServeMux()
+	lg.registerControlRoutes(mux)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if lg.cfg.ControlToken != "" {
+		req.Header.Set("Authorization", "Bearer "+lg.cfg.ControlToken)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var out map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &out)
+	return rec, out
+}
+
+func TestControlPauseResume(t *testing.T) {
+	lg := newTestLoadGenerator(t, nil)
+	st := lg.states["order-service"]
+	st.baseRPS = 10
+
+	if rec, _ := controlRequest(t, lg, "POST", "/control/targets/order-service/pause", ""); rec.Code != http.StatusOK {
+		t.Fatalf("pause: status %d", rec.Code)
+	}
+	if rate := st.tick(time.Now(), lg.cfg); rate != 0 {
+		t.Errorf("paused target ticked at %g rps, want 0", rate)
+	}
+	if lg.states["payment-service"].paused {
+		t.Error("pausing one target paused another")
+	}
+
+	controlRequest(t, lg, "POST", "/control/targets/order-service/resume", "")
+	if rate := st.tick(time.Now(), lg.cfg); rate <= 0 {
+		t.Errorf("resumed target ticked at %g rps, want > 0", rate)
+	}
+
+	controlRequest(t, lg, "POST", "/control/targets/all/pause", "")
+	for name, st := range lg.states {
+		if !st.paused {
+			t.Errorf("%s not paused by target all", name)
+		}
+	}
+
+	if rec, _ := controlRequest(t, lg, "POST", "/control/targets/nope/pause", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown target: status %d, want 404", rec.Code)
+	}
+}
+
+func TestControlSetRPSAndBurst(t *testing.T) {
+	lg := newTestLoadGenerator(t, nil)
+	for body, want := range map[string]int{
+		`{"rps": 42}`:    http.StatusOK,
+		`{"rps": -1}`:    http.StatusBadRequest,
+		`{"rps": 20000}`: http.StatusBadRequest,
+		`{}`:             http.StatusBadRequest,
+		`not json`:       http.StatusBadRequest,
+	} {
+		if rec, _ := controlRequest(t, lg, "PUT", "/control/targets/user-service/rps", body); rec.Code != want {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, want)
+		}
+	}
+	if got := lg.states["user-service"].baseRPS; got != 42 {
+		t.Errorf("base rps %g, want 42", got)
+	}
+
+	rec, _ := controlRequest(t, lg, "POST", "/control/targets/user-service/burst", `{"multiplier": 3, "duration": "1m"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("burst: status %d: %s", rec.Code, rec.Body)
+	}
+	st := lg.states["user-service"]
+	if st.burstMult != 3 || time.Until(st.burstEnd) <= 0 {
+		t.Errorf("burst not started: multiplier %g, ends %v", st.burstMult, st.burstEnd)
+	}
+	if rec, _ := controlRequest(t, lg, "POST", "/control/targets/user-service/burst", `{"multiplier": 0.5}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("multiplier below 1: status %d, want 400", rec.Code)
+	}
+}
+
+func TestControlScenarioSwap(t *testing.T) {
+	lg := newTestLoadGenerator(t, nil)
+	rec, out := controlRequest(t, lg, "PUT", "/control/scenario", `{"name": "read-heavy"}`)
+	if rec.Code != http.StatusOK || out["scenario"] != "read-heavy" {
+		t.Fatalf("swap: status %d, body %v", rec.Code, out)
+	}
+	for svc, eps := range lg.activeScenario().Endpoints {
+		for _, ep := range eps {
+			if ep.Method != "GET" {
+				t.Errorf("read-heavy scenario sends %s %s to %s", ep.Method, ep.Path, svc)
+			}
+		}
+	}
+	if rec, _ := controlRequest(t, lg, "PUT", "/control/scenario", `{"name": "nope"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown scenario: status %d, want 404", rec.Code)
+	}
+	if got := lg.activeScenario().Name; got != "read-heavy" {
+		t.Errorf("failed swap changed the scenario to %q", got)
+	}
+}
+
+func TestControlRequiresToken(t *testing.T) {
+	lg := newTestLoadGenerator(t, nil)
+	lg.cfg.ControlToken = "s3cret"
+
+	mux := http.NewServeMux()
+	lg.registerControlRoutes(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/control/stats", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("no token: status %d, want 401", rec.Code)
+	}
+
+	if rec, _ := controlRequest(t, lg, "GET", "/control/stats", ""); rec.Code != http.StatusOK {
+		t.Errorf("with token: status %d, want 200", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/control/targets/all/pause", nil)
+	req.Header.Set("Authorization", "Bearer s3cre")
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d, want 401", rec.Code)
+	}
+}
+
+func TestControlWithoutTokenIsReadOnly(t *testing.T) {
+	lg := newTestLoadGenerator(t, nil)
+	lg.cfg.ControlToken = ""
+
+	if rec, _ := controlRequest(t, lg, "GET", "/control/stats", ""); rec.Code != http.StatusOK {
+		t.Errorf("stats: status %d, want 200", rec.Code)
+	}
+	for _, c := range []struct{ method, path string }{
+		{"POST", "/control/targets/all/pause"},
+		{"PUT", "/control/targets/all/rps"},
+		{"POST", "/control/targets/all/burst"},
+		{"PUT", "/control/scenario"},
+		{"PUT", "/cluster/assignment"},
+	} {
+		if rec, _ := controlRequest(t, lg, c.method, c.path, "{}"); rec.Code < 400 {
+			t.Errorf("%s %s without a token: status %d, want it refused", c.method, c.path, rec.Code)
+		}
+	}
+	if lg.states["order-service"].paused {
+		t.Error("pause went through without a token")
+	}
+
+	for _, mode := range []string{modeCoordinator, modeWorker} {
+		_, err := newLoadGenerator(slog.New(slog.NewTextHandler(io.Discard, nil)),
+			config{Mode: mode, Workers: []string{"http://localhost:9101"}})
+		if err == nil || !strings.Contains(err.Error(), "CONTROL_TOKEN") {
+			t.Errorf("%s without CONTROL_TOKEN: got %v, want an error", mode, err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// HDR latency histogram
+// ---------------------------------------------------------------------------
+//
+// A fixed-layout High Dynamic Range histogram (the HdrHistogram bucketing
+// scheme) recording microseconds from 1µs to 1h at 3 significant figures.
+// Every process uses the same layout, so worker histograms merge exactly by
+// adding counts, and percentiles of the merged histogram are as accurate as
+// those of a single process.
+
+const (
+	hdrSignificantFigures = 3
+	hdrHighestMicros      = int64(time.Hour / time.Microsecond)
+)
+
+var (
+	hdrSubBucketHalfCountMagnitude int // log2 of half the sub-buckets per bucket
+	hdrSubBucketHalfCount          int
+	hdrSubBucketMask               int64
+	hdrCountsLen                   int
+)
+
+func init() {
+	// Enough sub-buckets to distinguish 2 * 10^figures values per bucket.
+	largestSingleUnit := 2 * int64(math.Pow10(hdrSignificantFigures))
+	subBucketCountMagnitude := int(math.Ceil(math.Log2(float64(largestSingleUnit))))
+	hdrSubBucketHalfCountMagnitude = subBucketCountMagnitude - 1
+	subBucketCount := int64(1) << subBucketCountMagnitude
+	hdrSubBucketHalfCount = int(subBucketCount / 2)
+	hdrSubBucketMask = subBucketCount - 1
+
+	buckets := 1
+	for smallestUntrackable := subBucketCount; smallestUntrackable <= hdrHighestMicros; smallestUntrackable <<= 1 {
+		buckets++
+	}
+	hdrCountsLen = (buckets + 1) * hdrSubBucketHalfCount
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []int64
+	total  int64
+	max    int64
+}
+
+// histogramSnapshot is the wire form of a histogram: the layout it was
+// recorded with and its non-zero counts as [index, count] pairs.
+type histogramSnapshot struct {
+	SignificantFigures int        `json:"significant_figures"`
+	HighestMicros      int64      `json:"highest_micros"`
+	Total              int64      `json:"total"`
+	MaxMicros          int64      `json:"max_micros"`
+	Counts             [][2]int64 `json:"counts"`
+}
+
+func (h *histogram) record(d time.Duration) {
+	v := d.Microseconds()
+	if v < 1 {
+		v = 1
+	}
+	if v > hdrHighestMicros {
+		v = hdrHighestMicros
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.counts == nil {
+		h.counts = make([]int64, hdrCountsLen)
+	}
+	h.counts[hdrCountsIndex(v)]++
+	h.total++
+	if v > h.max {
+		h.max = v
+	}
+}
+
+func (h *histogram) snapshot() histogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := histogramSnapshot{
+		SignificantFigures: hdrSignificantFigures,
+		HighestMicros:      hdrHighestMicros,
+		Total:              h.total,
+		MaxMicros:          h.max,
+		Counts:             [][2]int64{},
+	}
+	for i, c := range h.counts {
+		if c > 0 {
+			s.Counts = append(s.Counts, [2]int64{int64(i), c})
+		}
+	}
+	return s
+}
+
+// merge adds the counts of s, which must have been recorded with the same
+// layout.
+func (h *histogram) merge(s histogramSnapshot) error {
+	if s.SignificantFigures != hdrSignificantFigures || s.HighestMicros != hdrHighestMicros {
+		return fmt.Errorf("histogram layout %d figures/%dµs, want %d/%dµs",
+			s.SignificantFigures, s.HighestMicros, hdrSignificantFigures, hdrHighestMicros)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.counts == nil {
+		h.counts = make([]int64, hdrCountsLen)
+	}
+	for _, pair := range s.Counts {
+		i, c := pair[0], pair[1]
+		if i < 0 || i >= int64(len(h.counts)) || c < 0 {
+			return fmt.Errorf("histogram count index %d out of range", i)
+		}
+		h.counts[i] += c
+		h.total += c
+	}
+	if s.MaxMicros > h.max {
+		h.max = s.MaxMicros
+	}
+	return nil
+}
+
+// quantile returns the smallest recorded value v such that a fraction q of
+// values are <= v, rounded up to the end of its bucket.
+func (h *histogram) quantile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.total == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(q * float64(h.total)))
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			v := hdrHighestEquivalentValue(hdrValueFromIndex(i))
+			if v > h.max {
+				v = h.max
+			}
+			return time.Duration(v) * time.Microsecond
+		}
+	}
+	return time.Duration(h.max) * time.Microsecond
+}
+
+// latencySummary is the reported form of a histogram.
+type latencySummary struct {
+	Count  int64   `json:"count"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	P999Ms float64 `json:"p999_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+func (h *histogram) summary() latencySummary {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	h.mu.Lock()
+	count, max := h.total, h.max
+	h.mu.Unlock()
+	return latencySummary{
+		Count:  count,
+		P50Ms:  ms(h.quantile(0.50)),
+		P90Ms:  ms(h.quantile(0.90)),
+		P99Ms:  ms(h.quantile(0.99)),
+		P999Ms: ms(h.quantile(0.999)),
+		MaxMs:  float64(max) / 1000,
+	}
+}
+
+func hdrBucketIndex(v int64) int {
+	// Position of the highest set bit above the sub-bucket range.
+	return 64 - bits.LeadingZeros64(uint64(v|hdrSubBucketMask)) - (hdrSubBucketHalfCountMagnitude + 1)
+}
+
+func hdrCountsIndex(v int64) int {
+	bucket := hdrBucketIndex(v)
+	sub := int(v >> uint(bucket))
+	return (bucket+1)<<uint(hdrSubBucketHalfCountMagnitude) + sub - hdrSubBucketHalfCount
+}
+
+func hdrValueFromIndex(i int) int64 {
+	bucket := (i >> uint(hdrSubBucketHalfCountMagnitude)) - 1
+	sub := (i & (hdrSubBucketHalfCount - 1)) + hdrSubBucketHalfCount
+	if bucket < 0 {
+		sub -= hdrSubBucketHalfCount
+		bucket = 0
+	}
+	return int64(sub) << uint(bucket)
+}
+
+func hdrHighestEquivalentValue(v int64) int64 {
+	return v + int64(1)<<uint(hdrBucketIndex(v)) - 1
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantiles(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 10000; i++ {
+		h.record(time.Duration(i) * time.Microsecond)
+	}
+	for q, want := range map[float64]float64{0.5: 5000, 0.9: 9000, 0.99: 9900, 0.999: 9990, 1: 10000} {
+		got := float64(h.quantile(q).Microseconds())
+		if math.Abs(got-want)/want > 0.001 {
+			t.Errorf("q%g: got %gµs want %gµs within 0.1%%", q, got, want)
+		}
+	}
+	if s := h.summary(); s.Count != 10000 || s.MaxMs != 10 {
+		t.Errorf("summary: %+v", s)
+	}
+
+	var big histogram
+	big.record(2 * time.Hour)
+	if got := big.quantile(1); got != time.Hour {
+		t.Errorf("values above the range should clamp to 1h, got %v", got)
+	}
+}
+
+func TestHistogramMergeMatchesSingleRecording(t *testing.T) {
+	var a, b, all histogram
+	for i := 1; i <= 5000; i++ {
+		d := time.Duration(i*i) * time.Microsecond
+		all.record(d)
+		if i%2 == 0 {
+			a.record(d)
+		} else {
+			b.record(d)
+		}
+	}
+	var merged histogram
+	for _, h := range []*histogram{&a, &b} {
+		if err := merged.merge(h.snapshot()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := merged.summary(), all.summary(); got != want {
+		t.Errorf("merged %+v, want %+v", got, want)
+	}
+
+	bad := a.snapshot()
+	bad.SignificantFigures = 2
+	if err := merged.merge(bad); err == nil {
+		t.Error("merging a different layout: expected error")
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Multi-step user journeys
+// ---------------------------------------------------------------------------
+//
+// A journey is a scripted session: each step is one request whose path,
+// headers and body are request templates that can read earlier results as
+// {{.name}}. Steps extract values
+// from their JSON response into variables with a JSONPath subset ($.a.b,
+// $[0].id, $.items[-1], $['odd.key']) and assert on the status code and on
+// response fields. The first failing step ends the journey.
+
+type journeyStep struct {
+	Name    string
+	Service string // name of the targetService the request goes to
+	Method  string
+	Path    string
+	Headers map[string]string
+	Body    string
+
+	// Expect lists the acceptable status codes; empty means any 2xx.
+	Expect []int
+	// Extract maps variable names to JSONPath expressions.
+	Extract map[string]string
+	// Assert maps JSONPath expressions to the value they must have. Values
+	// may reference variables.
+	Assert map[string]string
+	// ThinkTime is the mean pause before the step, jittered by +/-50%.
+	ThinkTime time.Duration
+}
+
+type journey struct {
+	Name   string
+	Weight float64
+	Steps  []journeyStep
+}
+
+// stepError records why a journey stopped.
+type stepError struct {
+	Step   string
+	Reason string
+	Err    error
+}
+
+func (e *stepError) Error() string {
+	return fmt.Sprintf("step %s: %s: %v", e.Step, e.Reason, e.Err)
+}
+
+func defaultJourneys() []journey {
+	return []journey{
+		{
+			Name:   "checkout",
+			Weight: 3,
+			Steps: []journeyStep{
+				{
+					Name: "authenticate", Service: "user-service",
+					Method: "POST", Path: "/api/users/auth",
+					Body:    `{"username":"usr-100","password":"load-test"}`,
+					Expect:  []int{200},
+					Extract: map[string]string{"token": "$.token"},
+				},
+				{
+					Name: "create_order", Service: "order-service",
+					Method: "POST", Path: "/api/orders",
+					Headers:   map[string]string{"Authorization": "Bearer {{.token}}"},
+					Body:      `{"user_id":"usr-100","items":["item-a","item-c"]}`,
+					Expect:    []int{201, 202},
+					Extract:   map[string]string{"order_id": "$.id"},
+					ThinkTime: 500 * time.Millisecond,
+				},
+				{
+					Name: "read_order", Service: "order-service",
+					Method: "GET", Path: "/api/orders/{{.order_id}}",
+					Headers:   map[string]string{"Authorization": "Bearer {{.token}}"},
+					Assert:    map[string]string{"$.id": "{{.order_id}}", "$.user_id": "usr-100"},
+					ThinkTime: 300 * time.Millisecond,
+				},
+				{
+					Name: "list_payments", Service: "payment-service",
+					Method: "GET", Path: "/api/payments?limit=10",
+					Headers:   map[string]string{"Authorization": "Bearer {{.token}}"},
+					ThinkTime: 300 * time.Millisecond,
+				},
+			},
+		},
+		{
+			Name:   "browse",
+			Weight: 2,
+			Steps: []journeyStep{
+				{
+					Name: "profile", Service: "user-service",
+					Method: "GET", Path: "/api/users/usr-100",
+					Assert: map[string]string{"$.id": "usr-100"},
+				},
+				{
+					Name: "list_orders", Service: "order-service",
+					Method: "GET", Path: "/api/orders?limit=5",
+					Extract:   map[string]string{"first_order": "$[0].id"},
+					ThinkTime: 400 * time.Millisecond,
+				},
+				{
+					Name: "order_detail", Service: "order-service",
+					Method: "GET", Path: "/api/orders/{{.first_order}}",
+					Assert:    map[string]string{"$.id": "{{.first_order}}"},
+					ThinkTime: 600 * time.Millisecond,
+				},
+			},
+		},
+	}
+}
+
+// selectJourney picks a weighted-random journey.
+func selectJourney(rng *rand.Rand, journeys []journey) journey {
+	total := 0.0
+	for _, j := range journeys {
+		total += j.Weight
+	}
+	r := rng.Float64() * total
+	cumulative := 0.0
+	for _, j := range journeys {
+		cumulative += j.Weight
+		if r <= cumulative {
+			return j
+		}
+	}
+	return journeys[len(journeys)-1]
+}
+
+// generateJourneys starts journeys of the active scenario at the journeys
+// target's rate (JOURNEY_RPS initially), shaped by the same diurnal curve as
+// request traffic. Journeys run concurrently.
+func (lg *loadGenerator) generateJourneys(ctx context.Context) {
+	lg.logger.Info("starting journey generation", "journey_rps", lg.cfg.JourneyRPS)
+	st := lg.states[journeysTarget]
+	for {
+		rate := st.tick(time.Now(), lg.cfg)
+		currentRPS.WithLabelValues(journeysTarget).Set(rate)
+		interval := 250 * time.Millisecond
+		if rate > 0 {
+			interval = time.Duration(float64(time.Second) / rate)
+		}
+		select {
+		case <-ctx.Done():
+			lg.logger.Info("stopping journey generation")
+			return
+		case <-time.After(interval):
+		}
+		journeys := lg.activeScenario().Journeys
+		if rate <= 0 || len(journeys) == 0 {
+			continue
+		}
+		go lg.runJourney(ctx, selectJourney(st.rng, journeys))
+	}
+}
+
+// runJourney executes every step of j in order and records the outcome.
+func (lg *loadGenerator) runJourney(ctx context.Context, j journey) error {
+	start := time.Now()
+	stats := lg.statsFor(journeysTarget)
+	stats.sent.Add(1)
+	vars := make(map[string]string)
+	for _, step := range j.Steps {
+		if step.ThinkTime > 0 {
+			pause := time.Duration(float64(step.ThinkTime) * (0.5 + lg.rng.Float64()))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(pause):
+			}
+		}
+		if err := lg.runStep(ctx, step, vars); err != nil {
+			journeyStepFailures.WithLabelValues(j.Name, err.Step, err.Reason).Inc()
+			journeysTotal.WithLabelValues(j.Name, "failed").Inc()
+			stats.failed.Add(1)
+			if lg.rng.Float64() < 0.05 {
+				lg.logger.Warn("journey failed", "journey", j.Name, "error", err)
+			}
+			return err
+		}
+	}
+	elapsed := time.Since(start)
+	journeysTotal.WithLabelValues(j.Name, "success").Inc()
+	journeyDuration.WithLabelValues(j.Name).Observe(elapsed.Seconds())
+	stats.succeeded.Add(1)
+	stats.latencyNanos.Add(int64(elapsed))
+	stats.latency.record(elapsed)
+	return nil
+}
+
+func (lg *loadGenerator) runStep(ctx context.Context, step journeyStep, vars map[string]string) *stepError {
+	fail := func(reason string, err error) *stepError {
+		return &stepError{Step: step.Name, Reason: reason, Err: err}
+	}
+
+	baseURL, ok := lg.baseURL(step.Service)
+	if !ok {
+		return fail("config", fmt.Errorf("unknown service %q", step.Service))
+	}
+	req, err := lg.buildRequest(baseURL, step.Method, step.Path, step.Body, step.Headers, vars)
+	if err != nil {
+		return fail("template", err)
+	}
+	req = req.WithContext(ctx)
+
+	requestsSentTotal.WithLabelValues(step.Service, step.Method, routeLabel(step.Path)).Inc()
+	stats := lg.statsFor(step.Service)
+	stats.sent.Add(1)
+	start := time.Now()
+	resp, errType, err := lg.do(step.Service, req)
+	duration := time.Since(start)
+	requestDuration.WithLabelValues(step.Service).Observe(duration.Seconds())
+	if err != nil {
+		requestErrors.WithLabelValues(step.Service, errType).Inc()
+		stats.failed.Add(1)
+		return fail("connection", err)
+	}
+	defer resp.Body.Close()
+	responsesReceivedTotal.WithLabelValues(step.Service, strconv.Itoa(resp.StatusCode)).Inc()
+	stats.recordResponse(resp.StatusCode, duration, true)
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fail("connection", err)
+	}
+
+	if !statusExpected(step.Expect, resp.StatusCode) {
+		return fail("status", fmt.Errorf("got %d, want %v", resp.StatusCode, step.expectString()))
+	}
+	if len(step.Extract) == 0 && len(step.Assert) == 0 {
+		return nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fail("extract", fmt.Errorf("decoding response: %w", err))
+	}
+	for name, path := range step.Extract {
+		v, err := extractJSONPath(doc, path)
+		if err != nil {
+			return fail("extract", fmt.Errorf("%s: %w", name, err))
+		}
+		vars[name] = jsonString(v)
+	}
+	for path, want := range step.Assert {
+		v, err := extractJSONPath(doc, path)
+		if err != nil {
+			return fail("assert", err)
+		}
+		want, err := lg.templates.render(want, vars)
+		if err != nil {
+			return fail("template", err)
+		}
+		if got := jsonString(v); got != want {
+			return fail("assert", fmt.Errorf("%s = %q, want %q", path, got, want))
+		}
+	}
+	return nil
+}
+
+func (lg *loadGenerator) baseURL(service string) (string, bool) {
+	for _, t := range lg.targets {
+		if t.Name == service {
+			return t.BaseURL, true
+		}
+	}
+	return "", false
+}
+
+func statusExpected(expect []int, code int) bool {
+	if len(expect) == 0 {
+		return code >= 200 && code < 300
+	}
+	return slices.Contains(expect, code)
+}
+
+func (step journeyStep) expectString() string {
+	if len(step.Expect) == 0 {
+		return "2xx"
+	}
+	return fmt.Sprint(step.Expect)
+}
+
+// extractJSONPath evaluates a JSONPath subset against a decoded JSON value:
+// "$" followed by any mix of .field, ['field'] and [index] (negative indexes
+// count from the end).
+func extractJSONPath(doc interface{}, path string) (interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("jsonpath %q must start with $", path)
+	}
+	cur := doc
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			var err error
+			if cur, err = jsonField(cur, rest[:end], path); err != nil {
+				return nil, err
+			}
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("jsonpath %q: unterminated [", path)
+			}
+			sel := rest[1:end]
+			rest = rest[end+1:]
+			if len(sel) >= 2 && sel[0] == '\'' && sel[len(sel)-1] == '\'' {
+				var err error
+				if cur, err = jsonField(cur, sel[1:len(sel)-1], path); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			idx, err := strconv.Atoi(sel)
+			if err != nil {
+				return nil, fmt.Errorf("jsonpath %q: bad index %q", path, sel)
+			}
+			arr, ok := cur.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("jsonpath %q: indexing a non-array", path)
+			}
+			if idx < 0 {
+				idx += len(arr)
+			}
+			if idx < 0 || idx >= len(arr) {
+				return nil, fmt.Errorf("jsonpath %q: index %s out of range (len %d)", path, sel, len(arr))
+			}
+			cur = arr[idx]
+		default:
+			return nil, fmt.Errorf("jsonpath %q: unexpected %q", path, rest[0])
+		}
+	}
+	return cur, nil
+}
+
+func jsonField(cur interface{}, key, path string) (interface{}, error) {
+	obj, ok := cur.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("jsonpath %q: field %q of a non-object", path, key)
+	}
+	v, ok := obj[key]
+	if !ok {
+		return nil, fmt.Errorf("jsonpath %q: no field %q", path, key)
+	}
+	return v, nil
+}
+
+// jsonString renders an extracted value for use in a template: strings
+// verbatim, everything else as JSON.
+func jsonString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
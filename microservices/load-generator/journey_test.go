@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestLoadGenerator(t *testing.T, targets map[string]string) *loadGenerator {
+	t.Helper()
+	lg, err := newLoadGenerator(slog.New(slog.NewTextHandler(io.Discard, nil)), config{ControlToken: "test-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg.targets = nil
+	for name, url := range targets {
+		lg.targets = append(lg.targets, targetService{Name: name, BaseURL: url})
+	}
+	return lg
+}
+
+func TestExtractJSONPath(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{"id":"ord-1","total":12.5,"items":["a","b","c"],"user":{"id":"usr-1"},"odd.key":true}`), &doc)
+
+	tests := map[string]string{
+		"$.id":         "ord-1",
+		"$.total":      "12.5",
+		"$.items[0]":   "a",
+		"$.items[-1]":  "c",
+		"$.user.id":    "usr-1",
+		"$['odd.key']": "true",
+		"$.user['id']": "usr-1",
+		"$.items":      `["a","b","c"]`,
+	}
+	for path, want := range tests {
+		v, err := extractJSONPath(doc, path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if got := jsonString(v); got != want {
+			t.Errorf("%s: got %q want %q", path, got, want)
+		}
+	}
+
+	for _, path := range []string{"id", "$.missing", "$.items[3]", "$.id[0]", "$.items[x]", "$.user.id.x"} {
+		if _, err := extractJSONPath(doc, path); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
+
+func TestRunJourneyThreadsVariables(t *testing.T) {
+	var gotAuth string
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"token":"tok-123"}`))
+	})
+	mux.HandleFunc("POST /orders", func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"ord-42"}`))
+	})
+	mux.HandleFunc("GET /orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"id": r.PathValue("id")})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	lg := newTestLoadGenerator(t, map[string]string{"svc": srv.URL})
+	j := journey{Name: "test", Steps: []journeyStep{
+		{Name: "auth", Service: "svc", Method: "POST", Path: "/auth", Extract: map[string]string{"token": "$.token"}},
+		{Name: "create", Service: "svc", Method: "POST", Path: "/orders", Body: `{}`,
+			Headers: map[string]string{"Authorization": "Bearer {{.token}}"},
+			Expect:  []int{201}, Extract: map[string]string{"order_id": "$.id"}},
+		{Name: "read", Service: "svc", Method: "GET", Path: "/orders/{{.order_id}}",
+			Assert: map[string]string{"$.id": "{{.order_id}}"}},
+	}}
+
+	if err := lg.runJourney(context.Background(), j); err != nil {
+		t.Fatalf("runJourney: %v", err)
+	}
+	if gotAuth != "Bearer tok-123" {
+		t.Errorf("Authorization header: got %q want %q", gotAuth, "Bearer tok-123")
+	}
+}
+
+func TestRunJourneyStopsAtFailingStep(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"id":"other"}`))
+	}))
+	defer srv.Close()
+
+	lg := newTestLoadGenerator(t, map[string]string{"svc": srv.URL})
+	tests := map[string]journeyStep{
+		"status":   {Name: "s", Service: "svc", Method: "GET", Path: "/", Expect: []int{201}},
+		"extract":  {Name: "s", Service: "svc", Method: "GET", Path: "/", Extract: map[string]string{"x": "$.missing"}},
+		"assert":   {Name: "s", Service: "svc", Method: "GET", Path: "/", Assert: map[string]string{"$.id": "ord-1"}},
+		"template": {Name: "s", Service: "svc", Method: "GET", Path: "/{{.unset}}"},
+	}
+	for reason, step := range tests {
+		calls = 0
+		j := journey{Name: "test", Steps: []journeyStep{step, {Name: "never", Service: "svc", Method: "GET", Path: "/"}}}
+		err := lg.runJourney(context.Background(), j)
+		var se *stepError
+		if !errors.As(err, &se) || se.Reason != reason {
+			t.Errorf("%s: got error %v, want step failure with reason %q", reason, err, reason)
+		}
+		wantCalls := 1
+		if reason == "template" {
+			wantCalls = 0 // fails before sending
+		}
+		if calls != wantCalls {
+			t.Errorf("%s: %d requests sent, want the journey to stop after %d", reason, calls, wantCalls)
+		}
+	}
+}
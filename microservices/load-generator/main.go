@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
@@ -58,6 +59,31 @@ var (
 		[]string{"service", "error_type"},
 	)
 
+	validationFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "loadgen_validation_failures_total",
+			Help: "Responses failing an endpoint expectation, by check (status, schema, contains, latency).",
+		},
+		[]string{"service", "path", "check"},
+	)
+
+	requestPhaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "loadgen_request_phase_duration_seconds",
+			Help:    "Time spent in each phase of a request (dns, dial, tls, ttfb).",
+			Buckets: []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0},
+		},
+		[]string{"service", "phase"},
+	)
+
+	connectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "loadgen_connections_total",
+			Help: "Connections requests were sent on, by whether they were reused from the pool.",
+		},
+		[]string{"service", "reused"},
+	)
+
 	currentRPS = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "loadgen_current_rps",
@@ -65,22 +91,81 @@ var (
 		},
 		[]string{"service"},
 	)
+
+	targetPaused = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "loadgen_target_paused",
+			Help: "Whether traffic to a target is paused through the control API (1) or running (0).",
+		},
+		[]string{"service"},
+	)
+
+	journeysTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "loadgen_journeys_total",
+			Help: "Total journeys run by the load generator, by outcome.",
+		},
+		[]string{"journey", "result"},
+	)
+
+	journeyDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "loadgen_journey_duration_seconds",
+			Help:    "End-to-end duration of successful journeys, including think time.",
+			Buckets: []float64{0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 20.0, 30.0},
+		},
+		[]string{"journey"},
+	)
+
+	journeyStepFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "loadgen_journey_step_failures_total",
+			Help: "Journey steps that failed, by step and reason (template, connection, status, extract, assert).",
+		},
+		[]string{"journey", "step", "reason"},
+	)
+
+	clusterWorkers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "loadgen_cluster_workers",
+			Help: "Workers known to the coordinator, by state (healthy, unhealthy).",
+		},
+		[]string{"state"},
+	)
+
+	replayLag = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "loadgen_replay_lag_seconds",
+			Help: "How far replay is behind the captured schedule.",
+		},
+	)
+
+	clusterPushesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "loadgen_cluster_assignment_pushes_total",
+			Help: "Assignments pushed by the coordinator to workers, by result.",
+		},
+		[]string{"result"},
+	)
 )
 
 // ---------------------------------------------------------------------------
 // Target service definition
 // ---------------------------------------------------------------------------
 
+// endpoint paths, headers and bodies are request templates (see template.go).
 type endpoint struct {
-	Method string
-	Path   string
-	Weight float64 // relative probability of being chosen
+	Method  string
+	Path    string
+	Body    string
+	Headers map[string]string
+	Weight  float64      // relative probability of being chosen
+	Expect  *expectation // response checks, see validate.go; nil accepts any response
 }
 
 type targetService struct {
-	Name      string
-	BaseURL   string
-	Endpoints []endpoint
+	Name    string
+	BaseURL string
 }
 
 // ---------------------------------------------------------------------------
@@ -91,11 +176,27 @@ type config struct {
 	OrderServiceURL   string
 	PaymentServiceURL string
 	UserServiceURL    string
-	BaseRPS           float64   // base requests per second per service
-	BurstMultiplier   float64   // how much to multiply during bursts
-	BurstProbability  float64   // probability of a burst each cycle
+	BaseRPS           float64 // base requests per second per service
+	JourneyRPS        float64 // multi-step journeys started per second (0 disables)
+	BurstMultiplier   float64 // how much to multiply during bursts
+	BurstProbability  float64 // probability of a burst each cycle
 	BurstDuration     time.Duration
 	MetricsPort       string
+	DataDir           string // CSV files for the csv/csvRow template functions
+	Scenario          string // scenario active at startup
+	ControlToken      string // bearer token for the control API; empty leaves it read-only
+	Transport         transportConfig
+
+	Mode                string   // standalone, coordinator, worker or replay
+	Workers             []string // worker base URLs, coordinator mode only
+	ClusterSyncInterval time.Duration
+
+	ReplayFiles []string // capture files or globs, replay mode only
+	ReplaySpeed float64  // 1 keeps the captured timing, 2 is twice as fast
+	ReplayLoop  bool
+
+	Seed  int64         // seeds every random choice, see random.go
+	Clock *virtualClock // time of day for the diurnal curve; nil is the wall clock
 }
 
 func loadConfig() config {
@@ -103,16 +204,39 @@ func loadConfig() config {
 	burstMult, _ := strconv.ParseFloat(getEnv("BURST_MULTIPLIER", "5"), 64)
 	burstProb, _ := strconv.ParseFloat(getEnv("BURST_PROBABILITY", "0.02"), 64)
 	burstDurSec, _ := strconv.Atoi(getEnv("BURST_DURATION_SEC", "30"))
+	journeyRPS, _ := strconv.ParseFloat(getEnv("JOURNEY_RPS", "1"), 64)
+	syncInterval, _ := time.ParseDuration(getEnv("CLUSTER_SYNC_INTERVAL", "5s"))
+
+	replaySpeed, _ := strconv.ParseFloat(getEnv("REPLAY_SPEED", "1"), 64)
+	replayLoop, _ := strconv.ParseBool(getEnv("REPLAY_LOOP", "false"))
+
+	var workers []string
+	for _, w := range splitList(getEnv("WORKERS", "")) {
+		workers = append(workers, strings.TrimRight(w, "/"))
+	}
 
 	return config{
 		OrderServiceURL:   getEnv("ORDER_SERVICE_URL", "http://order-service:8081"),
 		PaymentServiceURL: getEnv("PAYMENT_SERVICE_URL", "http://payment-service:8082"),
 		UserServiceURL:    getEnv("USER_SERVICE_URL", "http://user-service:8083"),
 		BaseRPS:           baseRPS,
+		JourneyRPS:        journeyRPS,
 		BurstMultiplier:   burstMult,
 		BurstProbability:  burstProb,
 		BurstDuration:     time.Duration(burstDurSec) * time.Second,
 		MetricsPort:       getEnv("METRICS_PORT", "8090"),
+		DataDir:           getEnv("DATA_DIR", "/data"),
+		Scenario:          getEnv("SCENARIO", "default"),
+		ControlToken:      getEnv("CONTROL_TOKEN", ""),
+		Transport:         loadTransportConfig(),
+
+		Mode:                getEnv("MODE", modeStandalone),
+		Workers:             workers,
+		ClusterSyncInterval: syncInterval,
+
+		ReplayFiles: splitList(getEnv("REPLAY_FILE", "")),
+		ReplaySpeed: replaySpeed,
+		ReplayLoop:  replayLoop,
 	}
 }
 
@@ -121,60 +245,148 @@ func loadConfig() config {
 // ---------------------------------------------------------------------------
 
 type loadGenerator struct {
-	logger   *slog.Logger
-	cfg      config
-	client   *http.Client
-	targets  []targetService
+	logger    *slog.Logger
+	cfg       config
+	client    *http.Client
+	transport transportConfig
+	targets   []targetService
+	templates *templateSet
+	rng       *rand.Rand // shared by template values, think times and log sampling
+	scenarios map[string]*scenario
+	states    map[string]*targetState // by target name, plus journeysTarget
+
+	mu     sync.RWMutex
+	active *scenario
+
+	phaseChanged chan struct{}  // signalled by the control API; read by the coordinator
+	coord        *coordinator   // coordinator mode only
+	worker       workerState    // worker mode only
+	replay       []replayRecord // replay mode only, ordered by time
 }
 
-func newLoadGenerator(logger *slog.Logger, cfg config) *loadGenerator {
+func newLoadGenerator(logger *slog.Logger, cfg config) (*loadGenerator, error) {
 	targets := []targetService{
-		{
-			Name:    "order-service",
-			BaseURL: cfg.OrderServiceURL,
-			Endpoints: []endpoint{
-				{Method: "GET", Path: "/api/orders", Weight: 5},
-				{Method: "POST", Path: "/api/orders", Weight: 3},
-				{Method: "GET", Path: "/api/orders/ord-001", Weight: 2},
-				{Method: "GET", Path: "/healthz", Weight: 1},
-			},
-		},
-		{
-			Name:    "payment-service",
-			BaseURL: cfg.PaymentServiceURL,
-			Endpoints: []endpoint{
-				{Method: "GET", Path: "/api/payments", Weight: 4},
-				{Method: "POST", Path: "/api/payments", Weight: 5},
-				{Method: "GET", Path: "/api/payments/pay-001", Weight: 2},
-				{Method: "GET", Path: "/healthz", Weight: 1},
-			},
-		},
-		{
-			Name:    "user-service",
-			BaseURL: cfg.UserServiceURL,
-			Endpoints: []endpoint{
-				{Method: "GET", Path: "/api/users", Weight: 3},
-				{Method: "GET", Path: "/api/users/usr-100", Weight: 4},
-				{Method: "POST", Path: "/api/users/auth", Weight: 3},
-				{Method: "GET", Path: "/api/users/validate", Weight: 2},
-				{Method: "POST", Path: "/api/users", Weight: 1},
-				{Method: "GET", Path: "/healthz", Weight: 1},
-			},
-		},
+		{Name: "order-service", BaseURL: cfg.OrderServiceURL},
+		{Name: "payment-service", BaseURL: cfg.PaymentServiceURL},
+		{Name: "user-service", BaseURL: cfg.UserServiceURL},
 	}
 
-	return &loadGenerator{
-		logger:  logger,
-		cfg:     cfg,
-		client:  &http.Client{Timeout: 10 * time.Second},
-		targets: targets,
+	client, err := newHTTPClient(cfg.Transport)
+	if err != nil {
+		return nil, err
+	}
+
+	rng := deriveRand(cfg.Seed, "")
+	lg := &loadGenerator{
+		logger:    logger,
+		cfg:       cfg,
+		client:    client,
+		transport: cfg.Transport,
+		targets:   targets,
+		rng:       rng,
+		templates: newTemplateSet(cfg.DataDir, rng),
+		scenarios: builtinScenarios(),
+		states:    make(map[string]*targetState),
+
+		phaseChanged: make(chan struct{}, 1),
 	}
+	for _, t := range targets {
+		lg.states[t.Name] = newTargetState(t.Name, logger, cfg.BaseRPS, true, deriveRand(cfg.Seed, t.Name))
+	}
+	lg.states[journeysTarget] = newTargetState(journeysTarget, logger, cfg.JourneyRPS, false, deriveRand(cfg.Seed, journeysTarget))
+
+	switch cfg.Mode {
+	case "", modeStandalone:
+		lg.cfg.Mode = modeStandalone
+	case modeCoordinator:
+		if len(cfg.Workers) == 0 {
+			return nil, fmt.Errorf("coordinator mode needs WORKERS")
+		}
+		if cfg.ControlToken == "" {
+			return nil, fmt.Errorf("coordinator mode needs CONTROL_TOKEN")
+		}
+		lg.coord = newCoordinator(lg, cfg.Workers)
+	case modeWorker:
+		if cfg.ControlToken == "" {
+			return nil, fmt.Errorf("worker mode needs CONTROL_TOKEN to accept assignments")
+		}
+		// Workers send nothing until a coordinator assigns them a share.
+		for _, st := range lg.states {
+			st.paused = true
+			st.randomBursts = false
+		}
+	case modeReplay:
+		if cfg.ReplaySpeed <= 0 {
+			return nil, fmt.Errorf("REPLAY_SPEED must be positive, got %g", cfg.ReplaySpeed)
+		}
+		if len(cfg.ReplayFiles) == 0 {
+			return nil, fmt.Errorf("replay mode needs REPLAY_FILE")
+		}
+		records, err := loadReplay(cfg.ReplayFiles)
+		if err != nil {
+			return nil, err
+		}
+		lg.replay = records
+	default:
+		return nil, fmt.Errorf("unknown MODE %q (want %s, %s, %s or %s)",
+			cfg.Mode, modeStandalone, modeCoordinator, modeWorker, modeReplay)
+	}
+
+	if cfg.Scenario == "" {
+		cfg.Scenario = "default"
+	}
+	if err := lg.setScenario(cfg.Scenario); err != nil {
+		return nil, err
+	}
+	if err := lg.checkTemplates(); err != nil {
+		return nil, err
+	}
+	return lg, nil
 }
 
-// diurnalMultiplier returns a traffic multiplier based on time of day,
-// simulating realistic business-hours traffic patterns.
-func diurnalMultiplier() float64 {
-	hour := time.Now().Hour()
+// checkTemplates parses every endpoint and journey template, and compiles
+// every response schema, up front so a typo fails at startup instead of on
+// every request.
+func (lg *loadGenerator) checkTemplates() error {
+	check := func(where, src string) error {
+		if _, err := lg.templates.parse(src); err != nil {
+			return fmt.Errorf("%s: %w", where, err)
+		}
+		return nil
+	}
+	for _, sc := range lg.scenarios {
+		for svc, eps := range sc.Endpoints {
+			for _, ep := range eps {
+				where := sc.Name + ": " + svc + " " + ep.Method + " " + ep.Path
+				if err := ep.Expect.compile(); err != nil {
+					return fmt.Errorf("%s: %w", where, err)
+				}
+				for _, src := range append([]string{ep.Path, ep.Body}, mapValues(ep.Headers)...) {
+					if err := check(where, src); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		for _, j := range sc.Journeys {
+			for _, step := range j.Steps {
+				where := sc.Name + ": journey " + j.Name + " step " + step.Name
+				srcs := append([]string{step.Path, step.Body}, mapValues(step.Headers)...)
+				for _, src := range append(srcs, mapValues(step.Assert)...) {
+					if err := check(where, src); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// diurnalMultiplier returns a traffic multiplier based on the time of day at
+// t, simulating realistic business-hours traffic patterns.
+func diurnalMultiplier(t time.Time) float64 {
+	hour := t.Hour()
 	// Model a smooth curve: peak at 10-14, trough at 2-5 AM.
 	// Using a shifted cosine for a realistic shape.
 	// Peak ~ 12:00, trough ~ 03:00
@@ -185,12 +397,12 @@ func diurnalMultiplier() float64 {
 }
 
 // selectEndpoint picks a weighted-random endpoint.
-func selectEndpoint(endpoints []endpoint) endpoint {
+func selectEndpoint(rng *rand.Rand, endpoints []endpoint) endpoint {
 	totalWeight := 0.0
 	for _, ep := range endpoints {
 		totalWeight += ep.Weight
 	}
-	r := rand.Float64() * totalWeight
+	r := rng.Float64() * totalWeight
 	cumulative := 0.0
 	for _, ep := range endpoints {
 		cumulative += ep.Weight
@@ -202,7 +414,23 @@ func selectEndpoint(endpoints []endpoint) endpoint {
 }
 
 func (lg *loadGenerator) run(ctx context.Context) {
+	if lg.coord != nil {
+		lg.coord.run(ctx)
+		return
+	}
+	if lg.cfg.Mode == modeReplay {
+		lg.replayTraffic(ctx)
+		return
+	}
+
 	var wg sync.WaitGroup
+	if lg.cfg.Mode == modeWorker {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lg.watchAssignment(ctx)
+		}()
+	}
 
 	for _, target := range lg.targets {
 		wg.Add(1)
@@ -212,109 +440,126 @@ func (lg *loadGenerator) run(ctx context.Context) {
 		}(target)
 	}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		lg.generateJourneys(ctx)
+	}()
+
 	wg.Wait()
 }
 
 func (lg *loadGenerator) generateTraffic(ctx context.Context, target targetService) {
 	lg.logger.Info("starting traffic generation", "service", target.Name, "base_rps", lg.cfg.BaseRPS)
-
-	inBurst := false
-	burstEnd := time.Time{}
+	st := lg.states[target.Name]
 
 	for {
+		rps := st.tick(time.Now(), lg.cfg)
+		currentRPS.WithLabelValues(target.Name).Set(rps)
+
+		// Paused or idle targets re-check their state a few times a second.
+		sleepDuration := 250 * time.Millisecond
+		if rps > 0 {
+			// Add some jitter to the interval.
+			interval := time.Duration(float64(time.Second) / rps)
+			jitter := time.Duration(float64(interval) * 0.3 * st.rng.NormFloat64())
+			sleepDuration = interval + jitter
+			if sleepDuration < time.Millisecond {
+				sleepDuration = time.Millisecond
+			}
+		}
+
 		select {
 		case <-ctx.Done():
 			lg.logger.Info("stopping traffic generation", "service", target.Name)
 			return
-		default:
-		}
-
-		// Calculate current RPS.
-		rps := lg.cfg.BaseRPS * diurnalMultiplier()
-
-		// Check for burst.
-		now := time.Now()
-		if !inBurst && rand.Float64() < lg.cfg.BurstProbability {
-			inBurst = true
-			burstEnd = now.Add(lg.cfg.BurstDuration)
-			lg.logger.Warn("burst traffic started",
-				"service", target.Name,
-				"multiplier", lg.cfg.BurstMultiplier,
-				"duration", lg.cfg.BurstDuration)
+		case <-time.After(sleepDuration):
 		}
-		if inBurst {
-			if now.After(burstEnd) {
-				inBurst = false
-				lg.logger.Info("burst traffic ended", "service", target.Name)
-			} else {
-				rps *= lg.cfg.BurstMultiplier
-			}
-		}
-
-		currentRPS.WithLabelValues(target.Name).Set(rps)
-
-		// Add some jitter to the interval.
-		interval := time.Duration(float64(time.Second) / rps)
-		jitter := time.Duration(float64(interval) * 0.3 * rand.NormFloat64())
-		sleepDuration := interval + jitter
-		if sleepDuration < time.Millisecond {
-			sleepDuration = time.Millisecond
+		if rps <= 0 {
+			continue
 		}
 
-		time.Sleep(sleepDuration)
-
 		// Send a request.
-		ep := selectEndpoint(target.Endpoints)
-		go lg.sendRequest(target, ep)
+		endpoints := lg.activeScenario().Endpoints[target.Name]
+		if len(endpoints) == 0 {
+			continue
+		}
+		go lg.sendRequest(target, selectEndpoint(st.rng, endpoints))
 	}
 }
 
 func (lg *loadGenerator) sendRequest(target targetService, ep endpoint) {
-	url := target.BaseURL + ep.Path
-	requestsSentTotal.WithLabelValues(target.Name, ep.Method, ep.Path).Inc()
-
-	start := time.Now()
-
-	var body io.Reader
-	if ep.Method == "POST" {
-		// Send a minimal JSON body for POST requests.
-		body = strings.NewReader(`{"source":"load-generator"}`)
-	}
-
-	req, err := http.NewRequest(ep.Method, url, body)
+	route := routeLabel(ep.Path)
+	req, err := lg.buildRequest(target.BaseURL, ep.Method, ep.Path, ep.Body, ep.Headers, nil)
 	if err != nil {
 		requestErrors.WithLabelValues(target.Name, "request_creation").Inc()
-		lg.logger.Error("failed to create request", "error", err, "service", target.Name)
+		lg.logger.Error("failed to create request", "error", err, "service", target.Name, "path", route)
 		return
 	}
-	if ep.Method == "POST" {
-		req.Header.Set("Content-Type", "application/json")
-	}
-	req.Header.Set("User-Agent", "sre-load-generator/1.0")
-	req.Header.Set("X-Request-Source", "load-generator")
+	lg.send(target, route, req, ep.Expect)
+}
+
+// send issues req and records it against target, checking the response
+// against expect if it is set. route is the metric label for the request's
+// path.
+func (lg *loadGenerator) send(target targetService, route string, req *http.Request, expect *expectation) {
+	requestsSentTotal.WithLabelValues(target.Name, req.Method, route).Inc()
+	stats := lg.statsFor(target.Name)
+	stats.sent.Add(1)
+
+	start := time.Now()
 
-	resp, err := lg.client.Do(req)
+	resp, errType, err := lg.do(target.Name, req)
 	duration := time.Since(start)
 	requestDuration.WithLabelValues(target.Name).Observe(duration.Seconds())
 
 	if err != nil {
-		requestErrors.WithLabelValues(target.Name, "connection").Inc()
+		requestErrors.WithLabelValues(target.Name, errType).Inc()
+		stats.failed.Add(1)
 		// Only log connection errors occasionally to avoid spam.
-		if rand.Float64() < 0.01 {
-			lg.logger.Error("request failed", "error", err, "service", target.Name, "path", ep.Path)
+		if lg.rng.Float64() < 0.01 {
+			lg.logger.Error("request failed", "error", err, "service", target.Name, "path", route)
 		}
 		return
 	}
 	defer resp.Body.Close()
-	io.Copy(io.Discard, resp.Body) // drain body to reuse connection
+	var body []byte
+	if expect.needsBody() {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxValidatedBody))
+	}
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body) // drain body to reuse connection
+	}
+	if err != nil {
+		// The headers arrived but the body did not.
+		requestErrors.WithLabelValues(target.Name, classifyError(err)).Inc()
+		stats.failed.Add(1)
+		return
+	}
 
 	statusCode := fmt.Sprintf("%d", resp.StatusCode)
 	responsesReceivedTotal.WithLabelValues(target.Name, statusCode).Inc()
+	failures := expect.check(resp.StatusCode, body, time.Since(start))
+	stats.recordResponse(resp.StatusCode, duration, len(failures) == 0)
+	if resp.StatusCode >= 500 {
+		requestErrors.WithLabelValues(target.Name, "server_error").Inc()
+	}
+	if len(failures) > 0 {
+		requestErrors.WithLabelValues(target.Name, "assertion").Inc()
+		for _, f := range failures {
+			validationFailures.WithLabelValues(target.Name, route, f.Check).Inc()
+		}
+		if lg.rng.Float64() < 0.1 {
+			lg.logger.Warn("response failed validation",
+				"service", target.Name, "path", route,
+				"status", resp.StatusCode, "check", failures[0].Check, "detail", failures[0].Detail)
+		}
+	}
 
 	if resp.StatusCode >= 500 {
-		if rand.Float64() < 0.1 {
+		if lg.rng.Float64() < 0.1 {
 			lg.logger.Warn("server error response",
-				"service", target.Name, "path", ep.Path,
+				"service", target.Name, "path", route,
 				"status", resp.StatusCode, "duration_ms", duration.Milliseconds())
 		}
 	}
@@ -329,11 +574,37 @@ func main() {
 
 	prometheus.MustRegister(
 		requestsSentTotal, responsesReceivedTotal,
-		requestDuration, requestErrors, currentRPS,
+		requestDuration, requestErrors, validationFailures, requestPhaseDuration, connectionsTotal,
+		currentRPS, targetPaused,
+		journeysTotal, journeyDuration, journeyStepFailures,
+		clusterWorkers, clusterPushesTotal, replayLag,
 	)
 
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(logger, os.Args[2:]))
+	}
+
+	seedFlag := flag.String("seed", "", "random seed (overrides RANDOM_SEED; default: from the clock)")
+	flag.Parse()
 	cfg := loadConfig()
-	lg := newLoadGenerator(logger, cfg)
+	seed, seedSource, err := randomSeed(*seedFlag)
+	if err != nil {
+		logger.Error("invalid load generator configuration", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("random seed", "seed", seed, "source", seedSource)
+	cfg.Seed = seed
+	if cfg.Clock, err = clockFromEnv(); err != nil {
+		logger.Error("invalid load generator configuration", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("virtual clock",
+		"start", cfg.Clock.Now().Format(time.RFC3339), "compression", cfg.Clock.compression)
+	lg, err := newLoadGenerator(logger, cfg)
+	if err != nil {
+		logger.Error("invalid load generator configuration", "error", err)
+		os.Exit(1)
+	}
 
 	// Expose load generator's own metrics.
 	mux := http.NewServeMux()
@@ -342,6 +613,10 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"healthy"}`))
 	})
+	lg.registerControlRoutes(mux)
+	if cfg.ControlToken == "" {
+		logger.Warn("CONTROL_TOKEN is not set; the control API is read-only")
+	}
 
 	metricsServer := &http.Server{
 		Addr:    ":" + cfg.MetricsPort,
@@ -371,6 +646,11 @@ func main() {
 		"payment_service", cfg.PaymentServiceURL,
 		"user_service", cfg.UserServiceURL,
 		"base_rps", cfg.BaseRPS,
+		"journey_rps", cfg.JourneyRPS,
+		"scenario", cfg.Scenario,
+		"mode", lg.cfg.Mode,
+		"http_protocol", cfg.Transport.Protocol,
+		"connection_mode", cfg.Transport.ConnectionMode,
 		"burst_multiplier", cfg.BurstMultiplier,
 		"burst_probability", cfg.BurstProbability,
 	)
@@ -392,6 +672,72 @@ func main() {
 // Helpers
 // ---------------------------------------------------------------------------
 
+// buildRequest renders the path, body and headers templates with vars and
+// builds the request. POST bodies default to a minimal JSON object.
+func (lg *loadGenerator) buildRequest(baseURL, method, path, body string, headers, vars map[string]string) (*http.Request, error) {
+	path, err := lg.templates.render(path, vars)
+	if err != nil {
+		return nil, fmt.Errorf("path: %w", err)
+	}
+	if body == "" && method == "POST" {
+		body = `{"source":"load-generator"}`
+	}
+	var r io.Reader
+	if body != "" {
+		rendered, err := lg.templates.render(body, vars)
+		if err != nil {
+			return nil, fmt.Errorf("body: %w", err)
+		}
+		r = strings.NewReader(rendered)
+	}
+
+	req, err := http.NewRequest(method, baseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "sre-load-generator/1.0")
+	req.Header.Set("X-Request-Source", "load-generator")
+	for k, v := range headers {
+		rendered, err := lg.templates.render(v, vars)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", k, err)
+		}
+		req.Header.Set(k, rendered)
+	}
+	return req, nil
+}
+
+// statsFor returns the live stats of a target. Unknown names get a throwaway
+// value so callers never need to check.
+func (lg *loadGenerator) statsFor(name string) *targetStats {
+	if st, ok := lg.states[name]; ok {
+		return &st.stats
+	}
+	return &targetStats{}
+}
+
+func mapValues(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	return out
+}
+
+// splitList splits a comma-separated setting, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func getEnv(key, fallback string) string {
 	if v, ok := os.LookupEnv(key); ok {
 		return v
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Seeded randomness
+// ---------------------------------------------------------------------------
+//
+// Every random decision the load generator makes is drawn from generators
+// seeded by -seed or RANDOM_SEED, which is logged at startup. Each target
+// gets its own generator for its schedule, bursts and endpoint choices, so
+// the same seed gives the same sequence of endpoints per target however the
+// goroutines interleave. Template values, journey think times and log
+// sampling share one further generator. UUIDs stay truly random: they are
+// idempotency keys and must not repeat between runs. In distributed mode
+// give each worker its own seed, or they all send the same sequence.
+
+// lockedSource makes a rand.Source safe for concurrent use, as the source
+// behind the package-level math/rand functions is.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// newRand returns a generator for seed that is safe for concurrent use,
+// except for Read.
+func newRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+// randomSeed picks the seed from the -seed flag, then RANDOM_SEED, then the
+// clock. source says which, for the startup log.
+func randomSeed(flagValue string) (seed int64, source string, err error) {
+	v, source := flagValue, "flag"
+	if v == "" {
+		v, source = os.Getenv("RANDOM_SEED"), "env"
+	}
+	if v == "" {
+		return time.Now().UnixNano(), "clock", nil
+	}
+	seed, err = strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid random seed %q: %w", v, err)
+	}
+	return seed, source, nil
+}
+
+// deriveRand returns a generator for name that depends only on seed and
+// name, so adding a target does not change the others' sequences.
+func deriveRand(seed int64, name string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return newRand(seed ^ int64(h.Sum64()))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// draws records the endpoint choices and rendered bodies a load generator
+// makes for order-service with the given seed.
+func draws(t *testing.T, seed int64) []string {
+	t.Helper()
+	lg, err := newLoadGenerator(slog.New(slog.NewTextHandler(io.Discard, nil)), config{Seed: seed, DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := lg.states["order-service"]
+	endpoints := lg.activeScenario().Endpoints["order-service"]
+	var out []string
+	for i := 0; i < 50; i++ {
+		ep := selectEndpoint(st.rng, endpoints)
+		path, err := lg.templates.render(ep.Path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, ep.Method+" "+path)
+	}
+	return out
+}
+
+func TestSameSeedSameSequence(t *testing.T) {
+	a, b, c := draws(t, 42), draws(t, 42), draws(t, 43)
+	same := func(x, y []string) bool {
+		for i := range x {
+			if x[i] != y[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if !same(a, b) {
+		t.Errorf("seed 42 gave different sequences:\n%v\n%v", a, b)
+	}
+	if same(a, c) {
+		t.Error("seeds 42 and 43 gave the same sequence")
+	}
+}
+
+func TestDeriveRandIsPerName(t *testing.T) {
+	if deriveRand(1, "order-service").Int63() == deriveRand(1, "user-service").Int63() {
+		t.Error("targets share a sequence")
+	}
+	if deriveRand(1, "order-service").Int63() != deriveRand(1, "order-service").Int63() {
+		t.Error("same seed and name gave different sequences")
+	}
+}
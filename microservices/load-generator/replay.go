@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Replay
+// ---------------------------------------------------------------------------
+//
+// MODE=replay sends the requests recorded by the services' CAPTURE_FILE
+// instead of synthesising traffic. REPLAY_FILE lists capture files or globs,
+// comma-separated; records from all of them are merged by time, so captures
+// of several services replay interleaved as they happened. REPLAY_SPEED
+// scales the original timing (2 replays twice as fast). Redacted values are
+// sent as captured, so requests that needed a secret will fail the same way
+// each time.
+
+// replayRecord is the part of a capture record that replay needs.
+type replayRecord struct {
+	Time    time.Time         `json:"time"`
+	Service string            `json:"service"`
+	Method  string            `json:"method"`
+	Route   string            `json:"route"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// loadReplay reads every capture file matching patterns, ordered by time.
+func loadReplay(patterns []string) ([]replayRecord, error) {
+	var files []string
+	for _, p := range patterns {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("REPLAY_FILE %q: %w", p, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("REPLAY_FILE %q matches no files", p)
+		}
+		files = append(files, matches...)
+	}
+
+	var records []replayRecord
+	for _, name := range files {
+		recs, err := readCaptureFile(name)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, recs...)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no requests in %v", files)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+func readCaptureFile(name string) ([]replayRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []replayRecord
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var rec replayRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		if rec.Time.IsZero() || rec.Service == "" || rec.Method == "" || !strings.HasPrefix(rec.Path, "/") {
+			return nil, fmt.Errorf("%s:%d: record needs time, service, method and path", name, line)
+		}
+		out = append(out, rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return out, nil
+}
+
+// replayTraffic sends the loaded records on their original schedule, scaled
+// by REPLAY_SPEED, once or in a loop. Records for paused targets are skipped.
+func (lg *loadGenerator) replayTraffic(ctx context.Context) {
+	targets := make(map[string]targetService, len(lg.targets))
+	for _, t := range lg.targets {
+		targets[t.Name] = t
+	}
+	span := lg.replay[len(lg.replay)-1].Time.Sub(lg.replay[0].Time)
+	lg.logger.Info("starting replay",
+		"requests", len(lg.replay),
+		"captured_span", span,
+		"speed", lg.cfg.ReplaySpeed,
+		"loop", lg.cfg.ReplayLoop)
+
+	for pass := 1; ; pass++ {
+		start := time.Now()
+		t0 := lg.replay[0].Time
+		sent, skipped := 0, 0
+		for _, rec := range lg.replay {
+			due := start.Add(time.Duration(float64(rec.Time.Sub(t0)) / lg.cfg.ReplaySpeed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-ctx.Done():
+					lg.logger.Info("stopping replay", "pass", pass, "sent", sent)
+					return
+				case <-time.After(wait):
+				}
+				replayLag.Set(0)
+			} else {
+				replayLag.Set(-wait.Seconds())
+			}
+
+			target, ok := targets[rec.Service]
+			if !ok || lg.states[rec.Service].isPaused() {
+				skipped++
+				continue
+			}
+			req, err := replayRequest(target.BaseURL, rec)
+			if err != nil {
+				requestErrors.WithLabelValues(target.Name, "request_creation").Inc()
+				skipped++
+				continue
+			}
+			sent++
+			route := rec.Route
+			if route == "" {
+				route = rec.Path
+			}
+			go lg.send(target, route, req, nil)
+		}
+		lg.logger.Info("replay pass finished",
+			"pass", pass, "sent", sent, "skipped", skipped, "took", time.Since(start).Round(time.Millisecond))
+		if !lg.cfg.ReplayLoop || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func replayRequest(baseURL string, rec replayRecord) (*http.Request, error) {
+	var body io.Reader
+	if rec.Body != "" {
+		body = strings.NewReader(rec.Body)
+	}
+	req, err := http.NewRequest(rec.Method, baseURL+rec.Path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range rec.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("X-Request-Source", "load-generator-replay")
+	return req, nil
+}
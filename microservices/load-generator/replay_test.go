@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplayKeepsOrderAndScaledTiming(t *testing.T) {
+	type hit struct {
+		at     time.Time
+		method string
+		path   string
+		body   string
+		tenant string
+	}
+	var mu sync.Mutex
+	var hits []hit
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		hits = append(hits, hit{time.Now(), r.Method, r.URL.RequestURI(), string(b), r.Header.Get("X-Tenant")})
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	// Two services captured separately, interleaved 200ms apart.
+	dir := t.TempDir()
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	line := func(i int, service, method, path, body string) string {
+		return fmt.Sprintf(`{"time":%q,"service":%q,"method":%q,"route":"/api/x","path":%q,"headers":{"X-Tenant":"acme"},"body":%q}`+"\n",
+			base.Add(time.Duration(i)*200*time.Millisecond).Format(time.RFC3339Nano), service, method, path, body)
+	}
+	orders := line(0, "order-service", "GET", "/api/orders?limit=10", "") +
+		line(2, "order-service", "POST", "/api/orders", `{"user_id":"usr-100"}`)
+	users := line(1, "user-service", "GET", "/api/users/usr-100", "") +
+		line(3, "user-service", "GET", "/api/users/usr-101", "")
+	os.WriteFile(filepath.Join(dir, "order-service.jsonl"), []byte(orders), 0o644)
+	os.WriteFile(filepath.Join(dir, "user-service.jsonl"), []byte(users), 0o644)
+
+	lg, err := newLoadGenerator(slog.New(slog.NewTextHandler(io.Discard, nil)), config{
+		Mode:        modeReplay,
+		ReplayFiles: []string{filepath.Join(dir, "*.jsonl")},
+		ReplaySpeed: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range lg.targets {
+		lg.targets[i].BaseURL = srv.URL
+	}
+
+	start := time.Now()
+	lg.run(context.Background())
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(hits)
+		mu.Unlock()
+		if n == 4 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"GET /api/orders?limit=10", "GET /api/users/usr-100", "POST /api/orders", "GET /api/users/usr-101"}
+	if len(hits) != len(want) {
+		t.Fatalf("replayed %d requests, want %d", len(hits), len(want))
+	}
+	for i, h := range hits {
+		if got := h.method + " " + h.path; got != want[i] {
+			t.Errorf("request %d: %s, want %s", i, got, want[i])
+		}
+		if h.tenant != "acme" {
+			t.Errorf("request %d: captured header not replayed", i)
+		}
+	}
+	if hits[2].body != `{"user_id":"usr-100"}` {
+		t.Errorf("body %q", hits[2].body)
+	}
+	// 600ms captured at 2x speed.
+	if span := hits[3].at.Sub(hits[0].at); span < 250*time.Millisecond || span > 600*time.Millisecond {
+		t.Errorf("replay spanned %v, want about 300ms", span)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("replay took %v", took)
+	}
+}
+
+func TestLoadReplayErrors(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.jsonl")
+	os.WriteFile(bad, []byte(`{"time":"2026-03-01T12:00:00Z","service":"order-service","method":"GET","path":"/x"}`+"\nnot json\n"), 0o644)
+	for _, patterns := range [][]string{{filepath.Join(dir, "missing*.jsonl")}, {bad}} {
+		if _, err := loadReplay(patterns); err == nil {
+			t.Errorf("%v: expected error", patterns)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Scenarios
+// ---------------------------------------------------------------------------
+//
+// A scenario is a named traffic mix: the weighted endpoints sent to each
+// target service and the journeys run alongside them. SCENARIO picks the one
+// active at startup; the control API can swap it while running.
+
+type scenario struct {
+	Name        string
+	Description string
+	Endpoints   map[string][]endpoint // by target service name
+	Journeys    []journey
+}
+
+// Seeded ID ranges in the services, and the Zipf exponent used to pick users
+// so that a few hot users dominate, as they do in production.
+const (
+	orderIDTemplate   = `{{printf "ord-%03d" (randInt 1 250)}}`
+	paymentIDTemplate = `{{printf "pay-%03d" (randInt 1 250)}}`
+	userIDTemplate    = `{{printf "usr-%03d" (zipf 100 999 1.2)}}`
+	paymentTypeChoice = `{{weighted "credit_card" 5 "debit_card" 3 "digital_wallet" 2 "bank_transfer" 1}}`
+)
+
+// Response shapes the default endpoints are checked against. They list only
+// the fields clients rely on, so adding a field never fails validation.
+const (
+	orderSchema   = `{"type":"object","required":["id","user_id","status","total","created_at"],"properties":{"id":{"type":"string"},"total":{"type":"number"}}}`
+	paymentSchema = `{"type":"object","required":["id","amount","status","type"],"properties":{"amount":{"type":"number"}}}`
+	userSchema    = `{"type":"object","required":["id","username","status"],"properties":{"id":{"type":"string"}}}`
+)
+
+// readLatency is the slowest a read may be before it counts as failed.
+const readLatency = 2 * time.Second
+
+func listOf(item string) string { return `{"type":"array","items":` + item + `}` }
+
+func defaultScenario() *scenario {
+	healthy := &expectation{Status: []int{200}, Contains: []string{`"healthy"`}, MaxLatency: readLatency}
+	return &scenario{
+		Name:        "default",
+		Description: "Production-like mix of reads, writes and journeys",
+		Endpoints: map[string][]endpoint{
+			"order-service": {
+				{Method: "GET", Path: `/api/orders?limit={{choice "10" "20" "50"}}`, Weight: 5,
+					Expect: &expectation{Status: []int{200}, Schema: listOf(orderSchema), MaxLatency: readLatency}},
+				{Method: "POST", Path: "/api/orders", Weight: 3,
+					Body:   `{"user_id":"` + userIDTemplate + `","items":["item-a","item-b"],"request_id":"{{uuid}}"}`,
+					Expect: &expectation{Status: []int{201, 202, 402, 422}, Schema: orderSchema}},
+				{Method: "GET", Path: "/api/orders/" + orderIDTemplate, Weight: 2,
+					Expect: &expectation{Status: []int{200, 404}, Schema: orderSchema, MaxLatency: readLatency}},
+				{Method: "GET", Path: "/healthz", Weight: 1, Expect: healthy},
+			},
+			"payment-service": {
+				{Method: "GET", Path: `/api/payments?created_after={{ago "24h"}}`, Weight: 4,
+					Expect: &expectation{Status: []int{200}, Schema: listOf(paymentSchema), MaxLatency: readLatency}},
+				{Method: "POST", Path: "/api/payments", Weight: 5,
+					Body:   `{"type":"` + paymentTypeChoice + `","amount":{{randInt 1 999}}.{{printf "%02d" (randInt 0 99)}}}`,
+					Expect: &expectation{Status: []int{201, 202, 402}, Schema: paymentSchema}},
+				{Method: "GET", Path: "/api/payments/" + paymentIDTemplate, Weight: 2,
+					Expect: &expectation{Status: []int{200, 404}, Schema: paymentSchema, MaxLatency: readLatency}},
+				{Method: "GET", Path: "/healthz", Weight: 1, Expect: healthy},
+			},
+			"user-service": {
+				{Method: "GET", Path: "/api/users", Weight: 3,
+					Expect: &expectation{Status: []int{200}, Schema: listOf(userSchema), MaxLatency: readLatency}},
+				{Method: "GET", Path: "/api/users/" + userIDTemplate, Weight: 4,
+					Expect: &expectation{Status: []int{200, 404}, Schema: userSchema, MaxLatency: readLatency}},
+				{Method: "POST", Path: "/api/users/auth", Weight: 3,
+					Body:   `{"username":"` + userIDTemplate + `","password":"load-test"}`,
+					Expect: &expectation{Status: []int{200, 401, 403, 429}}},
+				{Method: "GET", Path: "/api/users/validate?user_id=" + userIDTemplate, Weight: 2,
+					Expect: &expectation{Status: []int{200, 403, 404, 423}, Schema: `{"type":"object","required":["valid","user_id"]}`}},
+				{Method: "POST", Path: "/api/users", Weight: 1,
+					Body:   `{"username":"lg-{{uuid}}","created_at":"{{now}}"}`,
+					Expect: &expectation{Status: []int{201}, Schema: userSchema}},
+				{Method: "GET", Path: "/healthz", Weight: 1, Expect: healthy},
+			},
+		},
+		Journeys: defaultJourneys(),
+	}
+}
+
+// builtinScenarios derives the alternative mixes from the default one.
+func builtinScenarios() map[string]*scenario {
+	def := defaultScenario()
+
+	readHeavy := &scenario{
+		Name:        "read-heavy",
+		Description: "GET requests and browse journeys only",
+		Endpoints:   make(map[string][]endpoint),
+	}
+	writeHeavy := &scenario{
+		Name:        "write-heavy",
+		Description: "Writes weighted 5x and checkout journeys only",
+		Endpoints:   make(map[string][]endpoint),
+	}
+	for svc, eps := range def.Endpoints {
+		for _, ep := range eps {
+			if ep.Method == "GET" {
+				readHeavy.Endpoints[svc] = append(readHeavy.Endpoints[svc], ep)
+				writeHeavy.Endpoints[svc] = append(writeHeavy.Endpoints[svc], ep)
+				continue
+			}
+			ep.Weight *= 5
+			writeHeavy.Endpoints[svc] = append(writeHeavy.Endpoints[svc], ep)
+		}
+	}
+	for _, j := range def.Journeys {
+		switch j.Name {
+		case "browse":
+			readHeavy.Journeys = append(readHeavy.Journeys, j)
+		case "checkout":
+			writeHeavy.Journeys = append(writeHeavy.Journeys, j)
+		}
+	}
+
+	return map[string]*scenario{
+		def.Name:        def,
+		readHeavy.Name:  readHeavy,
+		writeHeavy.Name: writeHeavy,
+	}
+}
+
+func scenarioNames(scenarios map[string]*scenario) []string {
+	names := make([]string, 0, len(scenarios))
+	for name := range scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// activeScenario returns the scenario traffic is currently drawn from.
+func (lg *loadGenerator) activeScenario() *scenario {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+	return lg.active
+}
+
+func (lg *loadGenerator) setScenario(name string) error {
+	sc, ok := lg.scenarios[name]
+	if !ok {
+		return fmt.Errorf("unknown scenario %q (have %v)", name, scenarioNames(lg.scenarios))
+	}
+	lg.mu.Lock()
+	lg.active = sc
+	lg.mu.Unlock()
+	lg.logger.Info("scenario activated", "scenario", name)
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Request templates
+// ---------------------------------------------------------------------------
+//
+// Paths, headers and bodies are text/template sources. Journey variables are
+// available as {{.name}}; the generator functions below produce values per
+// request:
+//
+//   {{randInt 1 250}}                        uniform integer in [1, 250]
+//   {{zipf 100 999 1.2}}                     Zipf-distributed integer, 100 most frequent
+//   {{uuid}}                                 random version 4 UUID
+//   {{choice "a" "b" "c"}}                   uniform choice
+//   {{weighted "credit_card" 5 "debit_card" 3}}  weighted choice of value/weight pairs
+//   {{csv "users.csv" "user_id"}}            column of a random row of DATA_DIR/users.csv
+//   {{with csvRow "users.csv"}}{{.user_id}} {{.email}}{{end}}   one row, several columns
+//   {{now}} / {{unixMillis}} / {{ago "24h"}} RFC 3339 timestamps and epoch millis
+//
+// plus the text/template builtins, e.g. {{printf "usr-%03d" (zipf 100 999 1.2)}}.
+
+type templateSet struct {
+	funcs   template.FuncMap
+	dataDir string
+	rng     *mrand.Rand
+
+	cache sync.Map // source -> *template.Template
+
+	mu    sync.Mutex
+	zipfs map[string]*mrand.Zipf
+	csvs  map[string][]map[string]string
+}
+
+func newTemplateSet(dataDir string, rng *mrand.Rand) *templateSet {
+	ts := &templateSet{
+		dataDir: dataDir,
+		rng:     rng,
+		zipfs:   make(map[string]*mrand.Zipf),
+		csvs:    make(map[string][]map[string]string),
+	}
+	ts.funcs = template.FuncMap{
+		"randInt":    ts.randInt,
+		"zipf":       ts.zipf,
+		"uuid":       newUUID,
+		"choice":     ts.choice,
+		"weighted":   ts.weighted,
+		"csv":        ts.csvColumn,
+		"csvRow":     ts.csvRow,
+		"now":        func() string { return time.Now().UTC().Format(time.RFC3339Nano) },
+		"unixMillis": func() int64 { return time.Now().UnixMilli() },
+		"ago":        ago,
+	}
+	return ts
+}
+
+// parse compiles src, caching the result. Missing variables are errors rather
+// than the text "<no value>".
+func (ts *templateSet) parse(src string) (*template.Template, error) {
+	if t, ok := ts.cache.Load(src); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New("").Funcs(ts.funcs).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	ts.cache.Store(src, t)
+	return t, nil
+}
+
+// render executes src with vars as its data.
+func (ts *templateSet) render(src string, vars map[string]string) (string, error) {
+	if !strings.Contains(src, "{{") {
+		return src, nil
+	}
+	t, err := ts.parse(src)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// routeLabel replaces every template action with {param} so rendered IDs
+// never reach a metric label.
+func routeLabel(src string) string {
+	var b strings.Builder
+	for {
+		open := strings.Index(src, "{{")
+		if open < 0 {
+			break
+		}
+		end := strings.Index(src[open:], "}}")
+		if end < 0 {
+			break
+		}
+		b.WriteString(src[:open])
+		b.WriteString("{param}")
+		src = src[open+end+2:]
+	}
+	b.WriteString(src)
+	return b.String()
+}
+
+// ---------------------------------------------------------------------------
+// Generators
+// ---------------------------------------------------------------------------
+
+func (ts *templateSet) randInt(min, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("randInt: max %d < min %d", max, min)
+	}
+	return min + ts.rng.Intn(max-min+1), nil
+}
+
+// zipf returns an integer in [min, max] whose rank follows a Zipf law with
+// exponent s (> 1): min is the most frequent value, min+1 the next, and so on.
+func (ts *templateSet) zipf(min, max int, s float64) (int, error) {
+	if max < min || s <= 1 {
+		return 0, fmt.Errorf("zipf: need min <= max and s > 1, got %d, %d, %g", min, max, s)
+	}
+	key := fmt.Sprintf("%d/%d/%g", min, max, s)
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	z, ok := ts.zipfs[key]
+	if !ok {
+		z = mrand.NewZipf(ts.rng, s, 1, uint64(max-min))
+		ts.zipfs[key] = z
+	}
+	return min + int(z.Uint64()), nil
+}
+
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func (ts *templateSet) choice(values ...string) (string, error) {
+	if len(values) == 0 {
+		return "", fmt.Errorf("choice: no values")
+	}
+	return values[ts.rng.Intn(len(values))], nil
+}
+
+// weighted takes value/weight pairs: weighted "a" 3 "b" 1.
+func (ts *templateSet) weighted(pairs ...interface{}) (string, error) {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return "", fmt.Errorf("weighted: want value/weight pairs, got %d arguments", len(pairs))
+	}
+	values := make([]string, 0, len(pairs)/2)
+	weights := make([]float64, 0, len(pairs)/2)
+	total := 0.0
+	for i := 0; i < len(pairs); i += 2 {
+		w, err := toFloat(pairs[i+1])
+		if err != nil || w < 0 {
+			return "", fmt.Errorf("weighted: bad weight %v for %v", pairs[i+1], pairs[i])
+		}
+		values = append(values, fmt.Sprint(pairs[i]))
+		weights = append(weights, w)
+		total += w
+	}
+	r := ts.rng.Float64() * total
+	cumulative := 0.0
+	for i, w := range weights {
+		cumulative += w
+		if r < cumulative {
+			return values[i], nil
+		}
+	}
+	return values[len(values)-1], nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	default:
+		return math.NaN(), fmt.Errorf("not a number: %v", v)
+	}
+}
+
+// ago returns the RFC 3339 time d before now, e.g. {{ago "24h"}}.
+func ago(d string) (string, error) {
+	dur, err := time.ParseDuration(d)
+	if err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Add(-dur).Format(time.RFC3339), nil
+}
+
+// csvRow returns a random row of a CSV file in the data directory, keyed by
+// the header row. Files are read once and kept in memory.
+func (ts *templateSet) csvRow(name string) (map[string]string, error) {
+	rows, err := ts.loadCSV(name)
+	if err != nil {
+		return nil, err
+	}
+	return rows[ts.rng.Intn(len(rows))], nil
+}
+
+func (ts *templateSet) csvColumn(name, column string) (string, error) {
+	row, err := ts.csvRow(name)
+	if err != nil {
+		return "", err
+	}
+	v, ok := row[column]
+	if !ok {
+		return "", fmt.Errorf("csv %s: no column %q", name, column)
+	}
+	return v, nil
+}
+
+func (ts *templateSet) loadCSV(name string) ([]map[string]string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if rows, ok := ts.csvs[name]; ok {
+		return rows, nil
+	}
+
+	f, err := os.Open(filepath.Join(ts.dataDir, filepath.Clean("/"+name)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("csv %s: %w", name, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("csv %s: need a header and at least one row", name)
+	}
+	header := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(rec) {
+				row[col] = rec[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	ts.csvs[name] = rows
+	return rows, nil
+}
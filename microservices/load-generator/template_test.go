@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	ts := newTemplateSet(t.TempDir(), newRand(1))
+	tests := map[string]*regexp.Regexp{
+		`/api/orders/{{.order_id}}`:               regexp.MustCompile(`^/api/orders/ord-7$`),
+		`{{printf "usr-%03d" (randInt 100 149)}}`: regexp.MustCompile(`^usr-1[0-4]\d$`),
+		`{{uuid}}`:                          regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		`{{choice "a" "b"}}`:                regexp.MustCompile(`^[ab]$`),
+		`{{weighted "never" 0 "always" 1}}`: regexp.MustCompile(`^always$`),
+		`{{now}}`:                           regexp.MustCompile(`^\d{4}-\d\d-\d\dT`),
+		`{{ago "1h"}}`:                      regexp.MustCompile(`^\d{4}-\d\d-\d\dT`),
+		`{"ts":{{unixMillis}},"n":{{zipf 1 10 1.5}}}`: regexp.MustCompile(`^\{"ts":\d{13},"n":([1-9]|10)\}$`),
+	}
+	for src, want := range tests {
+		for i := 0; i < 20; i++ {
+			got, err := ts.render(src, map[string]string{"order_id": "ord-7"})
+			if err != nil {
+				t.Fatalf("%s: %v", src, err)
+			}
+			if !want.MatchString(got) {
+				t.Fatalf("%s: rendered %q, want match for %s", src, got, want)
+			}
+		}
+	}
+}
+
+func TestRenderTemplateErrors(t *testing.T) {
+	ts := newTemplateSet(t.TempDir(), newRand(1))
+	for _, src := range []string{
+		`{{.missing}}`,
+		`{{randInt 5 1}}`,
+		`{{zipf 1 10 1}}`,
+		`{{weighted "a"}}`,
+		`{{csv "nope.csv" "id"}}`,
+		`{{unknownFunc}}`,
+	} {
+		if _, err := ts.render(src, map[string]string{}); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+func TestZipfFavoursLowRanks(t *testing.T) {
+	ts := newTemplateSet(t.TempDir(), newRand(1))
+	counts := make(map[int]int)
+	for i := 0; i < 5000; i++ {
+		n, err := ts.zipf(100, 149, 1.2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 100 || n > 149 {
+			t.Fatalf("zipf value %d outside [100, 149]", n)
+		}
+		counts[n]++
+	}
+	if counts[100] <= counts[101] || counts[101] <= counts[110] {
+		t.Errorf("expected decreasing frequency by rank, got 100:%d 101:%d 110:%d", counts[100], counts[101], counts[110])
+	}
+}
+
+func TestCSVRowKeepsColumnsTogether(t *testing.T) {
+	dir := t.TempDir()
+	data := "user_id,email\nusr-1,one@example.com\nusr-2,two@example.com\n"
+	if err := os.WriteFile(filepath.Join(dir, "users.csv"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ts := newTemplateSet(dir, newRand(1))
+	for i := 0; i < 20; i++ {
+		got, err := ts.render(`{{with csvRow "users.csv"}}{{.user_id}} {{.email}}{{end}}`, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "usr-1 one@example.com" && got != "usr-2 two@example.com" {
+			t.Fatalf("columns from different rows: %q", got)
+		}
+	}
+	if _, err := ts.render(`{{csv "../users.csv" "user_id"}}`, nil); err != nil {
+		t.Errorf("path outside the data dir should resolve inside it: %v", err)
+	}
+	if _, err := ts.render(`{{csv "users.csv" "phone"}}`, nil); err == nil {
+		t.Error("unknown column: expected error")
+	}
+}
+
+func TestRouteLabel(t *testing.T) {
+	got := routeLabel(`/api/users/validate?user_id={{printf "usr-%03d" (zipf 100 999 1.2)}}&x={{uuid}}`)
+	if want := "/api/users/validate?user_id={param}&x={param}"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if strings.Contains(routeLabel("/api/orders"), "{") {
+		t.Error("plain path should be unchanged")
+	}
+}
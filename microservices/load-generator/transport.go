@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// ---------------------------------------------------------------------------
+// Transports
+// ---------------------------------------------------------------------------
+//
+// HTTP_PROTOCOL picks how requests reach the services:
+//
+//   http1  HTTP/1.1 only, even against TLS servers offering HTTP/2 (default)
+//   h2     HTTP/2 negotiated over TLS, falling back to HTTP/1.1
+//   h2c    HTTP/2 over cleartext with prior knowledge
+//
+// CONNECTION_MODE=keepalive reuses pooled connections; CONNECTION_MODE=new
+// opens a fresh connection for every request, to measure connection churn.
+// Every request is traced, and the time spent in each phase is recorded in
+// loadgen_request_phase_duration_seconds.
+
+const (
+	protocolHTTP1 = "http1"
+	protocolH2    = "h2"
+	protocolH2C   = "h2c"
+
+	connKeepAlive = "keepalive"
+	connNew       = "new"
+)
+
+type transportConfig struct {
+	Protocol            string
+	ConnectionMode      string
+	MaxIdleConnsPerHost int // HTTP/1.1 idle pool size per service
+	MaxConnsPerHost     int // 0 is unlimited; HTTP/2 multiplexes over one
+	IdleConnTimeout     time.Duration
+
+	DialTimeout  time.Duration
+	TLSTimeout   time.Duration
+	TTFBTimeout  time.Duration // request written to first response byte
+	TotalTimeout time.Duration
+
+	CAFile             string // PEM bundle trusted in addition to the system roots
+	CertFile           string // client certificate for mutual TLS
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+func loadTransportConfig() transportConfig {
+	atoi := func(key, def string) int {
+		n, _ := strconv.Atoi(getEnv(key, def))
+		return n
+	}
+	dur := func(key, def string) time.Duration {
+		d, _ := time.ParseDuration(getEnv(key, def))
+		return d
+	}
+	insecure, _ := strconv.ParseBool(getEnv("TLS_INSECURE_SKIP_VERIFY", "false"))
+	return transportConfig{
+		Protocol:            getEnv("HTTP_PROTOCOL", protocolHTTP1),
+		ConnectionMode:      getEnv("CONNECTION_MODE", connKeepAlive),
+		MaxIdleConnsPerHost: atoi("MAX_IDLE_CONNS_PER_HOST", "100"),
+		MaxConnsPerHost:     atoi("MAX_CONNS_PER_HOST", "0"),
+		IdleConnTimeout:     dur("IDLE_CONN_TIMEOUT", "90s"),
+		DialTimeout:         dur("DIAL_TIMEOUT", "5s"),
+		TLSTimeout:          dur("TLS_HANDSHAKE_TIMEOUT", "5s"),
+		TTFBTimeout:         dur("TTFB_TIMEOUT", "10s"),
+		TotalTimeout:        dur("REQUEST_TIMEOUT", "10s"),
+		CAFile:              getEnv("TLS_CA_FILE", ""),
+		CertFile:            getEnv("TLS_CERT_FILE", ""),
+		KeyFile:             getEnv("TLS_KEY_FILE", ""),
+		ServerName:          getEnv("TLS_SERVER_NAME", ""),
+		InsecureSkipVerify:  insecure,
+	}
+}
+
+// newHTTPClient builds the client traffic is sent with.
+func newHTTPClient(tc transportConfig) (*http.Client, error) {
+	switch tc.ConnectionMode {
+	case "", connKeepAlive, connNew:
+	default:
+		return nil, fmt.Errorf("unknown CONNECTION_MODE %q (want %s or %s)", tc.ConnectionMode, connKeepAlive, connNew)
+	}
+	for name, d := range map[string]time.Duration{
+		"DIAL_TIMEOUT": tc.DialTimeout, "TLS_HANDSHAKE_TIMEOUT": tc.TLSTimeout,
+		"TTFB_TIMEOUT": tc.TTFBTimeout, "REQUEST_TIMEOUT": tc.TotalTimeout,
+	} {
+		if d < 0 {
+			return nil, fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if tc.Protocol == protocolH2C {
+		// h2c has no TLS to configure and a single multiplexed connection
+		// per host to cap; say so rather than run without them.
+		switch {
+		case tc.MaxConnsPerHost > 0:
+			return nil, errors.New("MAX_CONNS_PER_HOST does not apply to HTTP_PROTOCOL=h2c")
+		case tc.CAFile != "" || tc.CertFile != "" || tc.KeyFile != "" || tc.ServerName != "" || tc.InsecureSkipVerify:
+			return nil, errors.New("TLS settings do not apply to HTTP_PROTOCOL=h2c, which is cleartext")
+		}
+	}
+	tlsConfig, err := tc.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: tc.DialTimeout, KeepAlive: 30 * time.Second}
+
+	var rt http.RoundTripper
+	switch tc.Protocol {
+	case "", protocolHTTP1, protocolH2:
+		t := &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         dialer.DialContext,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: tc.TLSTimeout,
+			MaxIdleConns:        0, // bounded per host instead
+			MaxIdleConnsPerHost: tc.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     tc.MaxConnsPerHost,
+			IdleConnTimeout:     tc.IdleConnTimeout,
+			DisableKeepAlives:   tc.ConnectionMode == connNew,
+			ForceAttemptHTTP2:   tc.Protocol == protocolH2,
+		}
+		if tc.Protocol != protocolH2 {
+			// A non-nil empty map turns off the HTTP/2 upgrade.
+			t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+		rt = t
+	case protocolH2C:
+		rt = &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			IdleConnTimeout: tc.IdleConnTimeout,
+		}
+	default:
+		return nil, fmt.Errorf("unknown HTTP_PROTOCOL %q (want %s, %s or %s)", tc.Protocol, protocolHTTP1, protocolH2, protocolH2C)
+	}
+	return &http.Client{Transport: rt, Timeout: tc.TotalTimeout}, nil
+}
+
+func (tc transportConfig) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         tc.ServerName,
+		InsecureSkipVerify: tc.InsecureSkipVerify,
+	}
+	if tc.CAFile != "" {
+		pem, err := os.ReadFile(tc.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("TLS_CA_FILE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS_CA_FILE %s: no certificates found", tc.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if (tc.CertFile == "") != (tc.KeyFile == "") {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if tc.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// ---------------------------------------------------------------------------
+// Request tracing
+// ---------------------------------------------------------------------------
+
+// phaseTrace records the phases of one request. Transport callbacks can run
+// on other goroutines, hence the lock.
+type phaseTrace struct {
+	service string
+
+	mu          sync.Mutex
+	phase       string // the phase in progress, for classifying errors
+	dnsStart    time.Time
+	connStart   time.Time
+	tlsStart    time.Time
+	wrote       time.Time
+	ttfbTimer   *time.Timer
+	ttfbExpired bool
+}
+
+// do sends req for service with a TTFB deadline, recording phase durations
+// and whether the connection was reused. Errors are returned with their
+// class (see classifyError).
+func (lg *loadGenerator) do(service string, req *http.Request) (*http.Response, string, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	pt := &phaseTrace{service: service, phase: "connect"}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { pt.begin("dns", &pt.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { pt.end("dns", &pt.dnsStart) },
+		ConnectStart: func(string, string) {
+			pt.begin("dial", &pt.connStart)
+		},
+		ConnectDone:       func(string, string, error) { pt.end("dial", &pt.connStart) },
+		TLSHandshakeStart: func() { pt.begin("tls", &pt.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { pt.end("tls", &pt.tlsStart) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			connectionsTotal.WithLabelValues(service, fmt.Sprint(info.Reused)).Inc()
+			pt.set("write")
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			pt.mu.Lock()
+			pt.phase, pt.wrote = "ttfb", time.Now()
+			if lg.transport.TTFBTimeout > 0 {
+				pt.ttfbTimer = time.AfterFunc(lg.transport.TTFBTimeout, func() {
+					pt.mu.Lock()
+					pt.ttfbExpired = true
+					pt.mu.Unlock()
+					cancel()
+				})
+			}
+			pt.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			pt.mu.Lock()
+			if pt.ttfbTimer != nil {
+				pt.ttfbTimer.Stop()
+			}
+			if !pt.wrote.IsZero() {
+				requestPhaseDuration.WithLabelValues(service, "ttfb").Observe(time.Since(pt.wrote).Seconds())
+			}
+			pt.phase = "body"
+			pt.mu.Unlock()
+		},
+	}
+
+	if lg.transport.ConnectionMode == connNew {
+		req.Close = true
+	}
+	resp, err := lg.client.Do(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+	if err != nil {
+		cancel()
+		pt.stopTimer()
+		return nil, pt.classify(err), err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel, pt: pt}
+	return resp, "", nil
+}
+
+func (pt *phaseTrace) begin(phase string, start *time.Time) {
+	pt.mu.Lock()
+	pt.phase, *start = phase, time.Now()
+	pt.mu.Unlock()
+}
+
+func (pt *phaseTrace) end(phase string, start *time.Time) {
+	pt.mu.Lock()
+	if !start.IsZero() {
+		requestPhaseDuration.WithLabelValues(pt.service, phase).Observe(time.Since(*start).Seconds())
+	}
+	pt.mu.Unlock()
+}
+
+func (pt *phaseTrace) set(phase string) {
+	pt.mu.Lock()
+	pt.phase = phase
+	pt.mu.Unlock()
+}
+
+func (pt *phaseTrace) stopTimer() {
+	pt.mu.Lock()
+	if pt.ttfbTimer != nil {
+		pt.ttfbTimer.Stop()
+	}
+	pt.mu.Unlock()
+}
+
+// classify names the failure by its error, falling back to the phase it
+// happened in. An expired TTFB deadline shows up as a cancellation, so it is
+// recognised by the flag its timer set.
+func (pt *phaseTrace) classify(err error) string {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.ttfbExpired {
+		return "timeout"
+	}
+	class := classifyError(err)
+	if class == "other" {
+		switch pt.phase {
+		case "dns":
+			class = "dns"
+		case "dial":
+			class = "connect"
+		case "tls":
+			class = "tls"
+		}
+	}
+	return class
+}
+
+// classifyError maps a transport error to the error_type label of
+// loadgen_request_errors_total: dns, connect, tls, timeout, reset, eof or
+// other. It also applies to errors reading a response body.
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "reset"
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "connect"
+	case errors.As(err, &certErr), errors.As(err, &alertErr), errors.As(err, &recordErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostErr):
+		return "tls"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case strings.Contains(err.Error(), "tls: "):
+		return "tls"
+	default:
+		return "other"
+	}
+}
+
+// cancelOnClose releases the request's context once the body is consumed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	pt     *phaseTrace
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.pt.stopTimer()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// protoServer answers with the HTTP major version it was reached over and
+// counts the connections it accepts.
+func protoServer(conns *atomic.Int64) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{byte('0' + r.ProtoMajor)})
+	}))
+	srv.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	return srv
+}
+
+func newTransportTestGenerator(t *testing.T, tc transportConfig) *loadGenerator {
+	t.Helper()
+	lg, err := newLoadGenerator(slog.New(slog.NewTextHandler(io.Discard, nil)), config{Transport: tc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lg
+}
+
+// fetch sends n GETs and returns the protocol of the last response.
+func fetch(t *testing.T, lg *loadGenerator, url string, n int) string {
+	t.Helper()
+	var body []byte
+	for i := 0; i < n; i++ {
+		req, _ := http.NewRequest("GET", url, nil)
+		resp, errType, err := lg.do("test", req)
+		if err != nil {
+			t.Fatalf("request %d: %s: %v", i, errType, err)
+		}
+		body, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	return string(body)
+}
+
+func TestTransportProtocolsAndConnectionModes(t *testing.T) {
+	tests := []struct {
+		protocol, mode string
+		tls            bool
+		wantProto      string
+		wantConns      int64
+	}{
+		{protocolHTTP1, connKeepAlive, false, "1", 1},
+		{protocolHTTP1, connNew, false, "1", 3},
+		{protocolH2C, connKeepAlive, false, "2", 1},
+		{protocolH2C, connNew, false, "2", 3},
+		{protocolHTTP1, connKeepAlive, true, "1", 1},
+		{protocolH2, connKeepAlive, true, "2", 1},
+		{protocolH2, connNew, true, "2", 3},
+	}
+	for _, tt := range tests {
+		name := tt.protocol + "/" + tt.mode
+		if tt.tls {
+			name += "/tls"
+		}
+		t.Run(name, func(t *testing.T) {
+			var conns atomic.Int64
+			srv := protoServer(&conns)
+			tc := transportConfig{Protocol: tt.protocol, ConnectionMode: tt.mode, MaxIdleConnsPerHost: 10}
+			if tt.tls {
+				srv.EnableHTTP2 = true
+				srv.StartTLS()
+				tc.CAFile = writeCertPEM(t, srv.Certificate())
+			} else {
+				srv.Config.Handler = h2c.NewHandler(srv.Config.Handler, &http2.Server{})
+				srv.Start()
+			}
+			defer srv.Close()
+
+			lg := newTransportTestGenerator(t, tc)
+			if got := fetch(t, lg, srv.URL, 3); got != tt.wantProto {
+				t.Errorf("served over HTTP/%s, want HTTP/%s", got, tt.wantProto)
+			}
+			if got := conns.Load(); got != tt.wantConns {
+				t.Errorf("%d connections for 3 requests, want %d", got, tt.wantConns)
+			}
+		})
+	}
+}
+
+func TestTransportClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCert(t, dir)
+
+	var conns atomic.Int64
+	srv := protoServer(&conns)
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+	ca := writeCertPEM(t, srv.Certificate())
+
+	lg := newTransportTestGenerator(t, transportConfig{CAFile: ca, CertFile: certFile, KeyFile: keyFile})
+	fetch(t, lg, srv.URL, 1)
+
+	lg = newTransportTestGenerator(t, transportConfig{CAFile: ca})
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	if _, errType, err := lg.do("test", req); err == nil || errType != "tls" && errType != "reset" && errType != "eof" {
+		t.Errorf("without a client certificate: got %q, %v", errType, err)
+	}
+
+	if _, err := newHTTPClient(transportConfig{CertFile: certFile}); err == nil {
+		t.Error("certificate without key: expected error")
+	}
+}
+
+func TestTransportErrorClassification(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer slow.Close()
+	lg := newTransportTestGenerator(t, transportConfig{TTFBTimeout: 50 * time.Millisecond})
+	req, _ := http.NewRequest("GET", slow.URL, nil)
+	if _, errType, err := lg.do("test", req); errType != "timeout" {
+		t.Errorf("slow server: got %q, %v, want timeout", errType, err)
+	}
+
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	req, _ = http.NewRequest("GET", "http://"+addr, nil)
+	if _, errType, err := lg.do("test", req); errType != "connect" {
+		t.Errorf("closed port: got %q, %v, want connect", errType, err)
+	}
+
+	if _, err := newHTTPClient(transportConfig{Protocol: "spdy"}); err == nil {
+		t.Error("unknown protocol: expected error")
+	}
+	for _, tc := range []transportConfig{
+		{Protocol: protocolH2C, MaxConnsPerHost: 4},
+		{Protocol: protocolH2C, CAFile: "ca.pem"},
+		{Protocol: protocolH2C, InsecureSkipVerify: true},
+	} {
+		if _, err := newHTTPClient(tc); err == nil {
+			t.Errorf("%+v: expected error for a setting h2c ignores", tc)
+		}
+	}
+}
+
+func writeCertPEM(t *testing.T, cert *x509.Certificate) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeClientCert creates a self-signed client certificate and key.
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "load-generator"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile, cert
+}
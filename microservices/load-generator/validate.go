@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Response validation
+// ---------------------------------------------------------------------------
+//
+// An endpoint's expectation turns "got a response" into "got a correct
+// response". Status and latency are checked on every response below 500; the
+// schema and body substrings only on 2xx responses, since error bodies have
+// their own shape. A response failing any check counts as an assertion error.
+// A 5xx is a server error rather than a wrong answer, so it is left to the
+// server_error class instead of being counted twice.
+
+// maxValidatedBody caps how much of a response is read for validation.
+const maxValidatedBody = 1 << 20
+
+type expectation struct {
+	Status     []int         // accepted status codes; empty accepts any
+	Schema     string        // JSON Schema subset, see jsonSchema
+	Contains   []string      // substrings a 2xx body must contain
+	MaxLatency time.Duration // including reading the body; 0 disables
+
+	schema *jsonSchema // compiled from Schema at startup
+}
+
+// checkFailure is one failed check: status, schema, contains or latency.
+type checkFailure struct {
+	Check  string
+	Detail string
+}
+
+func (e *expectation) needsBody() bool {
+	return e != nil && (e.Schema != "" || len(e.Contains) > 0)
+}
+
+// compile parses the schema; it is called once, before traffic starts.
+func (e *expectation) compile() error {
+	if e == nil || e.Schema == "" || e.schema != nil {
+		return nil
+	}
+	s, err := compileSchema(e.Schema)
+	if err != nil {
+		return err
+	}
+	e.schema = s
+	return nil
+}
+
+// check returns every check the response fails.
+func (e *expectation) check(status int, body []byte, latency time.Duration) []checkFailure {
+	if e == nil || status >= 500 {
+		return nil
+	}
+	var out []checkFailure
+	if len(e.Status) > 0 && !statusExpected(e.Status, status) {
+		out = append(out, checkFailure{"status", fmt.Sprintf("status %d, want one of %v", status, e.Status)})
+	}
+	if e.MaxLatency > 0 && latency > e.MaxLatency {
+		out = append(out, checkFailure{"latency", fmt.Sprintf("took %v, limit %v", latency.Round(time.Millisecond), e.MaxLatency)})
+	}
+	if status < 200 || status > 299 {
+		return out
+	}
+	for _, s := range e.Contains {
+		if !bytes.Contains(body, []byte(s)) {
+			out = append(out, checkFailure{"contains", fmt.Sprintf("body lacks %q", s)})
+		}
+	}
+	if e.schema != nil {
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			out = append(out, checkFailure{"schema", "body is not JSON: " + err.Error()})
+		} else if err := e.schema.validate(doc, "$"); err != nil {
+			out = append(out, checkFailure{"schema", err.Error()})
+		}
+	}
+	return out
+}
+
+// ---------------------------------------------------------------------------
+// JSON Schema subset
+// ---------------------------------------------------------------------------
+
+// jsonSchema supports the keywords the default scenarios need: type,
+// required, properties, items, enum and minItems.
+type jsonSchema struct {
+	Type       string                 `json:"type"`
+	Required   []string               `json:"required"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Items      *jsonSchema            `json:"items"`
+	Enum       []interface{}          `json:"enum"`
+	MinItems   *int                   `json:"minItems"`
+}
+
+var schemaTypes = map[string]bool{
+	"": true, "object": true, "array": true, "string": true,
+	"number": true, "integer": true, "boolean": true, "null": true,
+}
+
+func compileSchema(src string) (*jsonSchema, error) {
+	dec := json.NewDecoder(strings.NewReader(src))
+	dec.DisallowUnknownFields()
+	var s jsonSchema
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	if err := s.checkTypes("$"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *jsonSchema) checkTypes(path string) error {
+	if !schemaTypes[s.Type] {
+		return fmt.Errorf("schema %s: unknown type %q", path, s.Type)
+	}
+	for name, p := range s.Properties {
+		if err := p.checkTypes(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.checkTypes(path + "[]")
+	}
+	return nil
+}
+
+// validate reports the first way v does not match s.
+func (s *jsonSchema) validate(v interface{}, path string) error {
+	if got := jsonType(v); s.Type != "" && got != s.Type && !(s.Type == "number" && got == "integer") {
+		return fmt.Errorf("%s: %s, want %s", path, got, s.Type)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v not in %v", path, v, s.Enum)
+		}
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := t[name]; !ok {
+				return fmt.Errorf("%s: missing required %q", path, name)
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if child, ok := t[name]; ok {
+				if err := s.Properties[name].validate(child, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(t) < *s.MinItems {
+			return fmt.Errorf("%s: %d items, want at least %d", path, len(t), *s.MinItems)
+		}
+		if s.Items != nil {
+			for i, child := range t {
+				if err := s.Items.validate(child, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonType(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if t == float64(int64(t)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestExpectationCheck(t *testing.T) {
+	e := &expectation{
+		Status:     []int{200, 404},
+		Schema:     listOf(orderSchema),
+		Contains:   []string{"ord-"},
+		MaxLatency: time.Second,
+	}
+	if err := e.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	good := `[{"id":"ord-001","user_id":"usr-100","status":"completed","total":9.5,"created_at":"2024-01-01T00:00:00Z"}]`
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		latency time.Duration
+		want    []string
+	}{
+		{"valid", 200, good, time.Millisecond, nil},
+		{"404 skips body checks", 404, `{"error":"not found"}`, time.Millisecond, nil},
+		{"unexpected status", 400, `oops`, time.Millisecond, []string{"status"}},
+		{"server error is not an assertion", 503, `oops`, 2 * time.Second, nil},
+		{"slow", 200, good, 2 * time.Second, []string{"latency"}},
+		{"not JSON", 200, `ord-<html>`, time.Millisecond, []string{"schema"}},
+		{"missing field", 200, `[{"id":"ord-001"}]`, time.Millisecond, []string{"schema"}},
+		{"wrong type", 200, strings.Replace(good, `9.5`, `"9.5"`, 1), time.Millisecond, []string{"schema"}},
+		{"object not array", 200, `{"id":"x"}`, time.Millisecond, []string{"contains", "schema"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range e.check(tt.status, []byte(tt.body), tt.latency) {
+				got = append(got, f.Check)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("failed checks %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var none *expectation
+	if none.check(500, nil, time.Hour) != nil || none.needsBody() {
+		t.Error("nil expectation should accept anything")
+	}
+}
+
+func TestCompileSchemaErrors(t *testing.T) {
+	for _, src := range []string{
+		`{"type":"obejct"}`,
+		`{"type":"object","requried":["id"]}`,
+		`{"type":"array","items":{"type":"strnig"}}`,
+		`not json`,
+	} {
+		if _, err := compileSchema(src); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+// brokenServer hijacks each connection, writes reply and closes it, with a
+// TCP reset if reset is set.
+func brokenServer(t *testing.T, reply string, reset bool) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		buf.WriteString(reply)
+		buf.Flush()
+		if reset {
+			conn.(*net.TCPConn).SetLinger(0)
+		}
+		conn.Close()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestErrorClasses(t *testing.T) {
+	lg := newTransportTestGenerator(t, transportConfig{})
+
+	tests := []struct {
+		name  string
+		url   string
+		want  string
+		after bool // the error surfaces while reading the body
+	}{
+		{"reset", brokenServer(t, "", true).URL, "reset", false},
+		{"eof", brokenServer(t, "", false).URL, "eof", false},
+		{"truncated body", brokenServer(t, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n{\"id\"", false).URL, "eof", true},
+		{"dns", "http://load-generator.invalid", "dns", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			resp, errType, err := lg.do("test", req)
+			if !tt.after {
+				if err == nil || errType != tt.want {
+					t.Errorf("got %q, %v, want %s", errType, err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("headers: %s: %v", errType, err)
+			}
+			defer resp.Body.Close()
+			_, err = io.ReadAll(resp.Body)
+			if got := classifyError(err); got != tt.want {
+				t.Errorf("body read: got %q, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendCountsBrokenBodiesAsInvalid(t *testing.T) {
+	body := `{"id":"usr-100","username":"alice","status":"active"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	lg := newTransportTestGenerator(t, transportConfig{})
+	target := targetService{Name: "user-service", BaseURL: srv.URL}
+	e := &expectation{Status: []int{200}, Schema: userSchema}
+	if err := e.compile(); err != nil {
+		t.Fatal(err)
+	}
+	send := func() {
+		req, _ := http.NewRequest("GET", srv.URL+"/api/users/usr-100", nil)
+		lg.send(target, "/api/users/{id}", req, e)
+	}
+
+	send()
+	body = `{"id":"usr-100"}`
+	send()
+
+	stats := lg.statsFor("user-service")
+	if got := stats.succeeded.Load(); got != 1 {
+		t.Errorf("succeeded = %d, want 1", got)
+	}
+	if got := stats.invalid.Load(); got != 1 {
+		t.Errorf("invalid = %d, want 1", got)
+	}
+}
+
+func TestSendCountsServerErrorsOnce(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	lg := newTransportTestGenerator(t, transportConfig{})
+	target := targetService{Name: "user-service", BaseURL: srv.URL}
+	e := &expectation{Status: []int{200}, Schema: userSchema}
+	if err := e.compile(); err != nil {
+		t.Fatal(err)
+	}
+	serverErrors := testutil.ToFloat64(requestErrors.WithLabelValues(target.Name, "server_error"))
+	assertions := testutil.ToFloat64(requestErrors.WithLabelValues(target.Name, "assertion"))
+	failures := testutil.CollectAndCount(validationFailures)
+	req, _ := http.NewRequest("GET", srv.URL+"/api/users/usr-100", nil)
+	lg.send(target, "/api/users/{id}", req, e)
+
+	if got := lg.statsFor(target.Name).serverErrors.Load(); got != 1 {
+		t.Errorf("server errors = %d, want 1", got)
+	}
+	if got := testutil.ToFloat64(requestErrors.WithLabelValues(target.Name, "server_error")) - serverErrors; got != 1 {
+		t.Errorf("server_error count rose by %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(requestErrors.WithLabelValues(target.Name, "assertion")); got != assertions {
+		t.Errorf("assertion count rose by %g, want 0", got-assertions)
+	}
+	if got := testutil.CollectAndCount(validationFailures); got != failures {
+		t.Errorf("%d new validation failure series, want none", got-failures)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// SLO verification
+// ---------------------------------------------------------------------------
+//
+// `load-generator verify` runs the load for a fixed time and judges the
+// services against their SLOs from what Prometheus recorded. It reads the
+// services' own counters before and after the run, so only requests served
+// during it count:
+//
+//   availability = 1 - http_requests_total{status=~"5.."} / http_requests_total
+//   latency      = http_request_duration_seconds_bucket{le="<threshold>"}
+//                  / http_request_duration_seconds_count
+//
+// Other traffic the services serve in the meantime is counted too, so run
+// it against an otherwise idle stack. It exits 0 if every service meets
+// both objectives, 1 if any misses one, and 2 if the check itself failed.
+
+type verifyConfig struct {
+	PrometheusURL    string
+	Duration         time.Duration // how long to send load
+	Settle           time.Duration // wait after the load for a final scrape
+	Availability     float64       // objective, e.g. 0.999
+	LatencyObjective float64       // share of requests within LatencyThreshold
+	LatencyThreshold float64       // seconds; must be a histogram bucket bound
+}
+
+// sliCounters are a service's counter values at one instant, or the
+// increase between two.
+type sliCounters struct {
+	Requests float64 // http_requests_total
+	Errors   float64 // the 5xx part of Requests
+	Observed float64 // http_request_duration_seconds_count
+	Fast     float64 // observations within the latency threshold
+}
+
+type sliResult struct {
+	Service      string
+	Counts       sliCounters
+	Availability float64
+	Latency      float64
+	Pass         bool
+	Reason       string
+}
+
+type verifier struct {
+	cfg     verifyConfig
+	prom    *promClient
+	client  *http.Client
+	targets []targetService
+	load    func(ctx context.Context) // sends load until ctx is done
+	out     io.Writer
+	logger  *slog.Logger
+}
+
+// runVerify is the verify subcommand. args are the arguments after "verify".
+func runVerify(logger *slog.Logger, args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	seedFlag := fs.String("seed", "", "random seed (overrides RANDOM_SEED; default: from the clock)")
+	promURL := fs.String("prometheus", getEnv("PROMETHEUS_URL", "http://prometheus:9090"), "Prometheus base URL")
+	duration := fs.Duration("duration", time.Minute, "how long to send load")
+	settle := fs.Duration("settle", 20*time.Second, "wait after the load so Prometheus scrapes the final counts")
+	rps := fs.Float64("rps", -1, "base requests per second per service (default: BASE_RPS)")
+	availability := fs.Float64("availability", 0.999, "availability objective")
+	latencyObjective := fs.Float64("latency-objective", 0.99, "share of requests that must be within -latency-threshold")
+	latencyThreshold := fs.Float64("latency-threshold", 0.5, "latency threshold in seconds; must be a histogram bucket bound")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg := loadConfig()
+	cfg.Mode = modeStandalone
+	if *rps >= 0 {
+		cfg.BaseRPS = *rps
+	}
+	seed, seedSource, err := randomSeed(*seedFlag)
+	if err == nil {
+		cfg.Clock, err = clockFromEnv()
+	}
+	if err != nil {
+		logger.Error("invalid load generator configuration", "error", err)
+		return 2
+	}
+	logger.Info("random seed", "seed", seed, "source", seedSource)
+	cfg.Seed = seed
+	lg, err := newLoadGenerator(logger, cfg)
+	if err != nil {
+		logger.Error("invalid load generator configuration", "error", err)
+		return 2
+	}
+
+	v := &verifier{
+		cfg: verifyConfig{
+			PrometheusURL:    *promURL,
+			Duration:         *duration,
+			Settle:           *settle,
+			Availability:     *availability,
+			LatencyObjective: *latencyObjective,
+			LatencyThreshold: *latencyThreshold,
+		},
+		prom:    &promClient{baseURL: strings.TrimRight(*promURL, "/"), client: &http.Client{Timeout: 10 * time.Second}},
+		client:  &http.Client{Timeout: 5 * time.Second},
+		targets: lg.targets,
+		load:    lg.run,
+		out:     os.Stdout,
+		logger:  logger,
+	}
+	pass, err := v.run(context.Background())
+	if err != nil {
+		logger.Error("verification failed", "error", err)
+		return 2
+	}
+	if !pass {
+		return 1
+	}
+	return 0
+}
+
+// run checks the targets are up, sends the load between two snapshots and
+// prints the verdict.
+func (v *verifier) run(ctx context.Context) (bool, error) {
+	for _, t := range v.targets {
+		if err := v.healthy(ctx, t); err != nil {
+			return false, fmt.Errorf("%s is not healthy: %w", t.Name, err)
+		}
+	}
+
+	before, err := v.snapshot(ctx)
+	if err != nil {
+		return false, fmt.Errorf("snapshot before the run: %w", err)
+	}
+
+	v.logger.Info("sending load", "duration", v.cfg.Duration.String())
+	loadCtx, cancel := context.WithTimeout(ctx, v.cfg.Duration)
+	v.load(loadCtx)
+	cancel()
+
+	v.logger.Info("waiting for the final scrape", "settle", v.cfg.Settle.String())
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-time.After(v.cfg.Settle):
+	}
+
+	after, err := v.snapshot(ctx)
+	if err != nil {
+		return false, fmt.Errorf("snapshot after the run: %w", err)
+	}
+
+	results, pass := v.verdict(before, after)
+	v.print(results, pass)
+	return pass, nil
+}
+
+func (v *verifier) healthy(ctx context.Context, t targetService) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.BaseURL+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("/healthz returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// snapshot reads each target's SLI counters from Prometheus.
+func (v *verifier) snapshot(ctx context.Context) (map[string]sliCounters, error) {
+	names := make([]string, len(v.targets))
+	for i, t := range v.targets {
+		names[i] = t.Name
+	}
+	svc := `service=~"` + strings.Join(names, "|") + `"`
+	le := strconv.FormatFloat(v.cfg.LatencyThreshold, 'f', -1, 64)
+
+	queries := []struct {
+		expr string
+		set  func(c *sliCounters, v float64)
+	}{
+		{`sum by (service) (http_requests_total{` + svc + `})`,
+			func(c *sliCounters, v float64) { c.Requests = v }},
+		{`sum by (service) (http_requests_total{` + svc + `,status=~"5.."})`,
+			func(c *sliCounters, v float64) { c.Errors = v }},
+		{`sum by (service) (http_request_duration_seconds_count{` + svc + `})`,
+			func(c *sliCounters, v float64) { c.Observed = v }},
+		{`sum by (service) (http_request_duration_seconds_bucket{` + svc + `,le="` + le + `"})`,
+			func(c *sliCounters, v float64) { c.Fast = v }},
+	}
+
+	out := make(map[string]sliCounters, len(names))
+	fastSeen := map[string]bool{}
+	for i, q := range queries {
+		values, err := v.prom.query(ctx, q.expr)
+		if err != nil {
+			return nil, err
+		}
+		for service, value := range values {
+			c := out[service]
+			q.set(&c, value)
+			out[service] = c
+			if i == len(queries)-1 {
+				fastSeen[service] = true
+			}
+		}
+	}
+	for service, c := range out {
+		if c.Observed > 0 && !fastSeen[service] {
+			return nil, fmt.Errorf("%s has no http_request_duration_seconds bucket le=%q; -latency-threshold must be a bucket bound", service, le)
+		}
+	}
+	return out, nil
+}
+
+// verdict judges each target on the increase between the snapshots. A
+// target that served nothing fails, since the run sent it traffic.
+func (v *verifier) verdict(before, after map[string]sliCounters) ([]sliResult, bool) {
+	var results []sliResult
+	var total sliCounters
+	pass := true
+	for _, t := range v.targets {
+		b, a := before[t.Name], after[t.Name]
+		c := sliCounters{
+			Requests: increase(b.Requests, a.Requests),
+			Errors:   increase(b.Errors, a.Errors),
+			Observed: increase(b.Observed, a.Observed),
+			Fast:     increase(b.Fast, a.Fast),
+		}
+		total.Requests += c.Requests
+		total.Errors += c.Errors
+		total.Observed += c.Observed
+		total.Fast += c.Fast
+
+		r := v.judge(t.Name, c)
+		pass = pass && r.Pass
+		results = append(results, r)
+	}
+	all := v.judge("all", total)
+	return append(results, all), pass && all.Pass
+}
+
+func (v *verifier) judge(name string, c sliCounters) sliResult {
+	r := sliResult{Service: name, Counts: c, Pass: true}
+	if c.Requests == 0 || c.Observed == 0 {
+		r.Pass, r.Reason = false, "no requests recorded"
+		return r
+	}
+	r.Availability = 1 - c.Errors/c.Requests
+	r.Latency = c.Fast / c.Observed
+	var reasons []string
+	if r.Availability < v.cfg.Availability {
+		reasons = append(reasons, "availability")
+	}
+	if r.Latency < v.cfg.LatencyObjective {
+		reasons = append(reasons, "latency")
+	}
+	if len(reasons) > 0 {
+		r.Pass, r.Reason = false, strings.Join(reasons, ", ")+" below objective"
+	}
+	return r
+}
+
+// increase is the growth of a counter between two readings. A counter that
+// went down was reset by a restart, so everything it holds is new.
+func increase(before, after float64) float64 {
+	if after < before {
+		return after
+	}
+	return after - before
+}
+
+func (v *verifier) print(results []sliResult, pass bool) {
+	w := tabwriter.NewWriter(v.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "SERVICE\tREQUESTS\t5XX\tAVAILABILITY (SLO %s)\tWITHIN %gs (SLO %s)\tRESULT\n",
+		percentString(v.cfg.Availability), v.cfg.LatencyThreshold, percentString(v.cfg.LatencyObjective))
+	for _, r := range results {
+		verdict := "PASS"
+		if !r.Pass {
+			verdict = "FAIL: " + r.Reason
+		}
+		availability, latency := "-", "-"
+		if r.Counts.Requests > 0 && r.Counts.Observed > 0 {
+			availability, latency = percentString(r.Availability), percentString(r.Latency)
+		}
+		fmt.Fprintf(w, "%s\t%.0f\t%.0f\t%s\t%s\t%s\n",
+			r.Service, r.Counts.Requests, r.Counts.Errors, availability, latency, verdict)
+	}
+	w.Flush()
+	if pass {
+		fmt.Fprintln(v.out, "\nverdict: PASS")
+	} else {
+		fmt.Fprintln(v.out, "\nverdict: FAIL")
+	}
+}
+
+// percentString formats a ratio as a percentage to three decimals at most.
+func percentString(f float64) string {
+	return strconv.FormatFloat(math.Round(f*1e5)/1e3, 'f', -1, 64) + "%"
+}
+
+// ---------------------------------------------------------------------------
+// Prometheus HTTP API
+// ---------------------------------------------------------------------------
+
+type promClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+type promResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  [2]any            `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// query runs an instant query whose result is grouped by service and
+// returns the values by service.
+func (p *promClient) query(ctx context.Context, expr string) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		p.baseURL+"/api/v1/query?"+url.Values{"query": {expr}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body promResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("query %s: %s: %w", expr, resp.Status, err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("query %s: %s: %s", expr, body.ErrorType, body.Error)
+	}
+	if body.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("query %s: want a vector, got %s", expr, body.Data.ResultType)
+	}
+	out := make(map[string]float64, len(body.Data.Result))
+	for _, s := range body.Data.Result {
+		raw, _ := s.Value[1].(string)
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("query %s: bad sample value %v", expr, s.Value[1])
+		}
+		out[s.Metric["service"]] = f
+	}
+	return out, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePrometheus answers instant queries from per-service counters, the way
+// the verify queries would be answered by a real server scraping the
+// services. Only the 0.5s bucket exists.
+type fakePrometheus struct {
+	mu       sync.Mutex
+	counters map[string]sliCounters
+	queries  []string
+	srv      *httptest.Server
+}
+
+func newFakePrometheus(t *testing.T) *fakePrometheus {
+	p := &fakePrometheus{counters: map[string]sliCounters{}}
+	p.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query().Get("query")
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.queries = append(p.queries, q)
+
+		type sample struct {
+			Metric map[string]string `json:"metric"`
+			Value  [2]any            `json:"value"`
+		}
+		result := []sample{}
+		for service, c := range p.counters {
+			var v float64
+			switch {
+			case strings.Contains(q, `status=~"5.."`):
+				v = c.Errors
+			case strings.Contains(q, `_bucket`):
+				if !strings.Contains(q, `le="0.5"`) {
+					continue
+				}
+				v = c.Fast
+			case strings.Contains(q, `_count`):
+				v = c.Observed
+			default:
+				v = c.Requests
+			}
+			result = append(result, sample{map[string]string{"service": service}, [2]any{1700000000.0, formatFloat(v)}})
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": "success",
+			"data":   map[string]any{"resultType": "vector", "result": result},
+		})
+	}))
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func formatFloat(f float64) string {
+	b, _ := json.Marshal(f)
+	return string(b)
+}
+
+// add records n requests to service, errors of them 5xx and fast of them
+// within the threshold.
+func (p *fakePrometheus) add(service string, n, errors, fast float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.counters[service]
+	c.Requests += n
+	c.Errors += errors
+	c.Observed += n
+	c.Fast += fast
+	p.counters[service] = c
+}
+
+func (p *fakePrometheus) set(service string, c sliCounters) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counters[service] = c
+}
+
+func healthyTarget(t *testing.T, name string) targetService {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"healthy"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return targetService{Name: name, BaseURL: srv.URL}
+}
+
+func newTestVerifier(t *testing.T, prom *fakePrometheus, load func(context.Context), out io.Writer) *verifier {
+	return &verifier{
+		cfg: verifyConfig{
+			Duration:         10 * time.Millisecond,
+			Availability:     0.999,
+			LatencyObjective: 0.99,
+			LatencyThreshold: 0.5,
+		},
+		prom:   &promClient{baseURL: prom.srv.URL, client: http.DefaultClient},
+		client: http.DefaultClient,
+		targets: []targetService{
+			healthyTarget(t, "order-service"),
+			healthyTarget(t, "payment-service"),
+			healthyTarget(t, "user-service"),
+		},
+		load:   load,
+		out:    out,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func TestVerifyVerdict(t *testing.T) {
+	prom := newFakePrometheus(t)
+	// Traffic from before the run must not count against it.
+	prom.add("order-service", 1000, 500, 0)
+	prom.add("payment-service", 1000, 0, 1000)
+	prom.set("user-service", sliCounters{Requests: 5000, Observed: 5000, Fast: 5000})
+
+	var out bytes.Buffer
+	v := newTestVerifier(t, prom, func(ctx context.Context) {
+		prom.add("order-service", 1000, 0, 995)
+		prom.add("payment-service", 1000, 5, 1000)
+		// user-service restarted during the run.
+		prom.set("user-service", sliCounters{Requests: 800, Observed: 800, Fast: 800})
+	}, &out)
+
+	pass, err := v.run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pass {
+		t.Error("payment-service missed its availability objective, want FAIL")
+	}
+	lines := map[string]string{}
+	for _, l := range strings.Split(out.String(), "\n") {
+		if f := strings.Fields(l); len(f) > 0 {
+			lines[f[0]] = l
+		}
+	}
+	for service, want := range map[string]string{
+		"order-service":   "PASS",
+		"payment-service": "FAIL: availability below objective",
+		"user-service":    "PASS",
+		"all":             "FAIL: availability below objective",
+	} {
+		if !strings.HasSuffix(lines[service], want) {
+			t.Errorf("%s: got %q, want it to end in %q", service, lines[service], want)
+		}
+	}
+	if !strings.Contains(lines["user-service"], " 800 ") {
+		t.Errorf("user-service should count all 800 requests after the reset: %q", lines["user-service"])
+	}
+	if !strings.Contains(out.String(), "verdict: FAIL") {
+		t.Errorf("missing verdict:\n%s", out.String())
+	}
+
+	// The queries must use the labels the services emit.
+	for _, q := range prom.queries {
+		if strings.Contains(q, "code") {
+			t.Errorf("query uses a label the services do not emit: %s", q)
+		}
+	}
+}
+
+func TestVerifyFailsWithoutTraffic(t *testing.T) {
+	prom := newFakePrometheus(t)
+	var out bytes.Buffer
+	v := newTestVerifier(t, prom, func(ctx context.Context) {
+		prom.add("order-service", 100, 0, 100)
+		prom.add("payment-service", 100, 0, 100)
+	}, &out)
+	pass, err := v.run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pass || !strings.Contains(out.String(), "FAIL: no requests recorded") {
+		t.Errorf("user-service served nothing, want FAIL:\n%s", out.String())
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	prom := newFakePrometheus(t)
+	prom.add("order-service", 10, 0, 10)
+
+	v := newTestVerifier(t, prom, func(context.Context) {}, io.Discard)
+	v.cfg.LatencyThreshold = 0.3
+	if _, err := v.run(context.Background()); err == nil || !strings.Contains(err.Error(), "bucket bound") {
+		t.Errorf("threshold that is not a bucket: got %v", err)
+	}
+
+	v = newTestVerifier(t, prom, func(context.Context) {}, io.Discard)
+	v.targets[1].BaseURL = "http://127.0.0.1:1"
+	if _, err := v.run(context.Background()); err == nil || !strings.Contains(err.Error(), "payment-service is not healthy") {
+		t.Errorf("unreachable target: got %v", err)
+	}
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+	}))
+	defer bad.Close()
+	p := &promClient{baseURL: bad.URL, client: http.DefaultClient}
+	if _, err := p.query(context.Background(), "up"); err == nil || !strings.Contains(err.Error(), "bad_data") {
+		t.Errorf("API error: got %v", err)
+	}
+}
+
+// TestVerifyEndToEnd drives real load at fake services whose request counts
+// the fake Prometheus reports.
+func TestVerifyEndToEnd(t *testing.T) {
+	prom := newFakePrometheus(t)
+	lg, err := newLoadGenerator(slog.New(slog.NewTextHandler(io.Discard, nil)), config{BaseRPS: 50, DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range lg.targets {
+		name := lg.targets[i].Name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/healthz" {
+				prom.add(name, 1, 0, 1)
+			}
+			w.Write([]byte(`{}`))
+		}))
+		defer srv.Close()
+		lg.targets[i].BaseURL = srv.URL
+	}
+
+	var out bytes.Buffer
+	v := newTestVerifier(t, prom, lg.run, &out)
+	v.targets = lg.targets
+	v.cfg.Duration = 500 * time.Millisecond
+	pass, err := v.run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pass {
+		t.Errorf("want PASS:\n%s", out.String())
+	}
+}
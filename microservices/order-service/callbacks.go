@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Payment callbacks
+// ---------------------------------------------------------------------------
+//
+// Slow payment types settle asynchronously, so payment-service reports the
+// outcome through a webhook. order-service registers one webhook at startup,
+// passes its ID with every payment, and moves the order to its final status
+// when the signed callback arrives.
+
+// maxCallbackSkew bounds how old a callback's signed timestamp may be, which
+// limits how long a captured delivery can be replayed.
+const maxCallbackSkew = 5 * time.Minute
+
+type paymentRequest struct {
+	OrderID   string  `json:"order_id"`
+	Amount    float64 `json:"amount"`
+	WebhookID string  `json:"webhook_id,omitempty"`
+}
+
+type paymentResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// paymentCallback is the body payment-service POSTs for every status change.
+type paymentCallback struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Payment    struct {
+		ID      string `json:"id"`
+		OrderID string `json:"order_id"`
+		Status  string `json:"status"`
+	} `json:"payment"`
+}
+
+// webhookRegistration holds the ID and signing secret payment-service issued.
+type webhookRegistration struct {
+	mu     sync.RWMutex
+	id     string
+	secret string
+}
+
+func (wr *webhookRegistration) get() (id, secret string) {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+	return wr.id, wr.secret
+}
+
+func (wr *webhookRegistration) set(id, secret string) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.id, wr.secret = id, secret
+}
+
+// registerPaymentWebhook keeps a webhook registered with payment-service.
+// Registrations live in payment-service's memory, so the loop re-checks
+// periodically and registers again after a payment-service restart.
+func (s *Server) registerPaymentWebhook(ctx context.Context, callbackURL string, interval time.Duration) {
+	for {
+		wait := interval
+		if err := s.ensurePaymentWebhook(ctx, callbackURL); err != nil {
+			s.logger.Warn("payment webhook registration failed", "error", err)
+			wait = 2 * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *Server) ensurePaymentWebhook(ctx context.Context, callbackURL string) error {
+	if id, _ := s.paymentWebhook.get(); id != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.paymentURL+"/api/webhooks/"+id, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Request-Source", "order-service")
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			return nil
+		}
+		s.logger.Warn("payment webhook no longer registered", "webhook_id", id)
+		s.paymentWebhook.set("", "")
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"url": callbackURL})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.paymentURL+"/api/webhooks", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-Source", "order-service")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("payment-service returned %d", resp.StatusCode)
+	}
+	var reg struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reg); err != nil {
+		return fmt.Errorf("decoding registration: %w", err)
+	}
+	s.paymentWebhook.set(reg.ID, reg.Secret)
+	s.logger.Info("payment webhook registered", "webhook_id", reg.ID, "url", callbackURL)
+	return nil
+}
+
+// verifyCallbackSignature checks an "X-Webhook-Signature: t=<unix>,v1=<hex>"
+// header against body.
+func verifyCallbackSignature(secret, header string, body []byte, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return fmt.Errorf("malformed signature header")
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > maxCallbackSkew || skew < -maxCallbackSkew {
+		return fmt.Errorf("signature timestamp outside tolerance")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func (s *Server) handlePaymentCallback(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		writeError(w, "reading body", http.StatusBadRequest)
+		return
+	}
+	_, secret := s.paymentWebhook.get()
+	if secret == "" {
+		paymentCallbacksTotal.WithLabelValues("unknown", "unregistered").Inc()
+		writeError(w, "no payment webhook registered", http.StatusServiceUnavailable)
+		return
+	}
+	if err := verifyCallbackSignature(secret, r.Header.Get("X-Webhook-Signature"), body, time.Now()); err != nil {
+		paymentCallbacksTotal.WithLabelValues("unknown", "invalid_signature").Inc()
+		s.logger.Warn("rejected payment callback", "error", err)
+		writeError(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var cb paymentCallback
+	if err := json.Unmarshal(body, &cb); err != nil {
+		writeError(w, "invalid callback body", http.StatusBadRequest)
+		return
+	}
+
+	result, code := s.applyPaymentCallback(cb)
+	paymentCallbacksTotal.WithLabelValues(cb.Event, result).Inc()
+	s.logger.Info("payment callback", "event", cb.Event, "payment_id", cb.Payment.ID,
+		"order_id", cb.Payment.OrderID, "result", result)
+	if code == http.StatusNotFound {
+		writeError(w, "order not found", code)
+		return
+	}
+	writeJSON(w, code, map[string]string{"result": result})
+}
+
+// applyPaymentCallback moves the order to the status implied by the payment
+// event. Transitions only apply from non-final statuses, so duplicate and
+// out-of-order deliveries are harmless.
+func (s *Server) applyPaymentCallback(cb paymentCallback) (string, int) {
+	open := []string{"created", "pending_payment"}
+	var (
+		to     string
+		from   []string
+		newEvt func(Order) Event
+	)
+	switch cb.Event {
+	case "payment.pending":
+		to, from = "pending_payment", []string{"created"}
+	case "payment.completed":
+		to, from = "paid", open
+		newEvt = func(o Order) Event { return s.newEvent(eventOrderPaid, o, "") }
+	case "payment.declined":
+		to, from = "failed", open
+		newEvt = func(o Order) Event { return s.newEvent(eventOrderFailed, o, "payment_declined") }
+	case "payment.failed", "payment.rejected":
+		to, from = "failed", open
+		newEvt = func(o Order) Event { return s.newEvent(eventOrderFailed, o, "payment_error") }
+	default:
+		return "ignored", http.StatusOK
+	}
+
+	if _, ok := s.orders.Get(cb.Payment.OrderID); !ok {
+		return "unknown_order", http.StatusNotFound
+	}
+	if _, applied := s.orders.Transition(cb.Payment.OrderID, to, from, newEvt); !applied {
+		return "duplicate", http.StatusOK
+	}
+	return "applied", http.StatusOK
+}
+
+// Transition moves an order to status to if its current status is one of
+// from, enqueuing the event built by newEvent (if any) in the same step as
+// Save does. It returns the order as stored afterwards and whether the
+// transition was applied.
+func (st *orderStore) Transition(id, to string, from []string, newEvent func(Order) Event) (Order, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	o, ok := st.orders[id]
+	if !ok {
+		return Order{}, false
+	}
+	if !slices.Contains(from, o.Status) {
+		return *o, false
+	}
+	updated := *o
+	updated.Status = to
+	st.orders[id] = &updated
+	if newEvent != nil {
+		st.enqueueLocked(newEvent(updated))
+	}
+	return updated, true
+}
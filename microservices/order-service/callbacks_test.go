@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signedCallback(t *testing.T, secret, body string, at time.Time) *http.Request {
+	t.Helper()
+	ts := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + body))
+	req := httptest.NewRequest("POST", "/internal/payment-callbacks", strings.NewReader(body))
+	req.Header.Set("X-Webhook-Signature", "t="+ts+",v1="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestPaymentCallbackCompletesPendingOrder(t *testing.T) {
+	srv := newTestServer(t)
+	srv.paymentWebhook.set("whk-000001", "s3cret")
+	srv.orders.Put(Order{ID: "ord-900", Status: "pending_payment", CreatedAt: time.Now()})
+	body := `{"id":"whd-1","event":"payment.completed","payment":{"id":"pay-1","order_id":"ord-900","status":"completed"}}`
+
+	for i, want := range []string{"applied", "duplicate"} {
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, signedCallback(t, "s3cret", body, time.Now()))
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), want) {
+			t.Fatalf("delivery %d: got %v %q, want 200 %q", i+1, rr.Code, rr.Body.String(), want)
+		}
+	}
+
+	if o, _ := srv.orders.Get("ord-900"); o.Status != "paid" {
+		t.Errorf("order status: got %q want paid", o.Status)
+	}
+	events := srv.orders.pendingEvents(10)
+	if len(events) != 1 || events[0].Type != eventOrderPaid {
+		t.Errorf("outbox: got %+v, want a single %s event", events, eventOrderPaid)
+	}
+}
+
+func TestPaymentCallbackRejectsBadSignatures(t *testing.T) {
+	srv := newTestServer(t)
+	srv.paymentWebhook.set("whk-000001", "s3cret")
+	srv.orders.Put(Order{ID: "ord-900", Status: "pending_payment", CreatedAt: time.Now()})
+	body := `{"event":"payment.declined","payment":{"order_id":"ord-900"}}`
+
+	tests := map[string]*http.Request{
+		"wrong secret": signedCallback(t, "other", body, time.Now()),
+		"stale":        signedCallback(t, "s3cret", body, time.Now().Add(-time.Hour)),
+		"missing":      httptest.NewRequest("POST", "/internal/payment-callbacks", strings.NewReader(body)),
+	}
+	for name, req := range tests {
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got status %v want 401", name, rr.Code)
+		}
+	}
+	if o, _ := srv.orders.Get("ord-900"); o.Status != "pending_payment" {
+		t.Errorf("order status changed to %q by an unsigned callback", o.Status)
+	}
+}
+
+func TestPaymentCallbackUnknownOrder(t *testing.T) {
+	srv := newTestServer(t)
+	srv.paymentWebhook.set("whk-000001", "s3cret")
+	body := `{"event":"payment.completed","payment":{"order_id":"ord-does-not-exist"}}`
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, signedCallback(t, "s3cret", body, time.Now()))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("got status %v want 404", rr.Code)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Order events
+// ---------------------------------------------------------------------------
+
+const (
+	eventOrderCreated = "order.created"
+	eventOrderPaid    = "order.paid"
+	eventOrderFailed  = "order.failed"
+)
+
+// Event is the payload published for every order state change. The subject
+// it is published on equals Type.
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OrderID    string    `json:"order_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Reason     string    `json:"reason,omitempty"`
+	Order      Order     `json:"order"`
+}
+
+// Publisher delivers raw event payloads to a broker subject.
+type Publisher interface {
+	Publish(ctx context.Context, subject string, data []byte) error
+	Close() error
+}
+
+// Subscriber registers a handler for every message whose subject matches
+// pattern. Patterns use NATS syntax: "*" matches one token, a trailing ">"
+// matches the rest.
+type Subscriber interface {
+	Subscribe(pattern string, handle func(subject string, data []byte)) error
+}
+
+// Broker is a Publisher that can also deliver to in-service consumers.
+type Broker interface {
+	Publisher
+	Subscriber
+}
+
+// newBroker selects the broker implementation from EVENT_BROKER.
+func newBroker(logger *slog.Logger) (Broker, error) {
+	switch kind := getEnv("EVENT_BROKER", "channel"); kind {
+	case "channel":
+		return newChannelBroker(1024), nil
+	case "nats":
+		return newNATSClient(getEnv("NATS_URL", "nats://nats:4222"), "order-service", logger), nil
+	default:
+		return nil, fmt.Errorf("unknown EVENT_BROKER %q (want channel or nats)", kind)
+	}
+}
+
+func subjectMatches(pattern, subject string) bool {
+	pt := strings.Split(pattern, ".")
+	st := strings.Split(subject, ".")
+	for i, p := range pt {
+		if p == ">" {
+			return i < len(st)
+		}
+		if i >= len(st) || (p != "*" && p != st[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
+
+// ---------------------------------------------------------------------------
+// In-process channel broker
+// ---------------------------------------------------------------------------
+
+var errBrokerFull = errors.New("broker buffer full")
+
+type channelSub struct {
+	pattern string
+	ch      chan channelMsg
+}
+
+type channelMsg struct {
+	subject string
+	data    []byte
+}
+
+// channelBroker fans messages out to in-process subscribers over buffered
+// channels. A full subscriber buffer fails the publish so that the outbox
+// retries instead of silently dropping the event.
+type channelBroker struct {
+	mu     sync.RWMutex
+	subs   []channelSub
+	buffer int
+	closed bool
+}
+
+func newChannelBroker(buffer int) *channelBroker {
+	return &channelBroker{buffer: buffer}
+}
+
+func (b *channelBroker) Publish(_ context.Context, subject string, data []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errors.New("broker closed")
+	}
+	for _, sub := range b.subs {
+		if !subjectMatches(sub.pattern, subject) {
+			continue
+		}
+		select {
+		case sub.ch <- channelMsg{subject: subject, data: data}:
+		default:
+			return errBrokerFull
+		}
+	}
+	return nil
+}
+
+func (b *channelBroker) Subscribe(pattern string, handle func(string, []byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errors.New("broker closed")
+	}
+	ch := make(chan channelMsg, b.buffer)
+	b.subs = append(b.subs, channelSub{pattern: pattern, ch: ch})
+	go func() {
+		for m := range ch {
+			handle(m.subject, m.data)
+		}
+	}()
+	return nil
+}
+
+func (b *channelBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for _, sub := range b.subs {
+		close(sub.ch)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// Transactional outbox
+// ---------------------------------------------------------------------------
+
+// The outbox holds at most maxOutboxEvents; when full the oldest event is
+// dead-lettered to make room for the new one. An event that fails to publish
+// maxPublishAttempts times in a row is dead-lettered too, so that one the
+// broker keeps refusing does not hold back every event behind it. The last
+// maxDeadLetters of them are kept for /admin/outbox.
+const (
+	maxOutboxEvents    = 10000
+	maxPublishAttempts = 40 // ten seconds at the default relay interval
+	maxDeadLetters     = 100
+)
+
+type outboxEntry struct {
+	event    Event
+	attempts int
+}
+
+// deadLetter is an event given up on, as reported by /admin/outbox.
+type deadLetter struct {
+	Event    Event     `json:"event"`
+	Reason   string    `json:"reason"`
+	Attempts int       `json:"attempts"`
+	At       time.Time `json:"dead_lettered_at"`
+}
+
+// Save writes the order and enqueues its event under the same lock, which is
+// this store's equivalent of a database transaction: either both become
+// visible or neither does.
+func (st *orderStore) Save(o Order, ev Event) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.putLocked(o)
+	st.enqueueLocked(ev)
+}
+
+// enqueueLocked appends ev to the outbox, dead-lettering the oldest event if
+// the outbox is full. The caller holds st.mu.
+func (st *orderStore) enqueueLocked(ev Event) {
+	if len(st.outbox) >= st.outboxLimit {
+		st.deadLetterLocked(st.outbox[0], "outbox_full")
+		st.outbox = st.outbox[1:]
+	}
+	st.outbox = append(st.outbox, &outboxEntry{event: ev})
+}
+
+func (st *orderStore) deadLetterLocked(e *outboxEntry, reason string) {
+	outboxEventsDeadLetteredTotal.WithLabelValues(e.event.Type, reason).Inc()
+	if len(st.deadLetters) >= maxDeadLetters {
+		st.deadLetters = st.deadLetters[1:]
+	}
+	st.deadLetters = append(st.deadLetters, deadLetter{
+		Event: e.event, Reason: reason, Attempts: e.attempts, At: time.Now(),
+	})
+}
+
+// pendingEvents returns up to max unpublished events, oldest first.
+func (st *orderStore) pendingEvents(max int) []Event {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	n := len(st.outbox)
+	if n > max {
+		n = max
+	}
+	out := make([]Event, n)
+	for i := 0; i < n; i++ {
+		out[i] = st.outbox[i].event
+	}
+	return out
+}
+
+// indexLocked returns the event's position in the outbox, or -1. Events are
+// published in order, so it is nearly always the first.
+func (st *orderStore) indexLocked(id string) int {
+	for i, e := range st.outbox {
+		if e.event.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// markPublished removes the event from the outbox.
+func (st *orderStore) markPublished(id string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	switch i := st.indexLocked(id); {
+	case i == 0:
+		st.outbox = st.outbox[1:]
+	case i > 0:
+		st.outbox = append(st.outbox[:i], st.outbox[i+1:]...)
+	}
+}
+
+// markFailed records a failed publish attempt and returns the attempt count.
+// After maxPublishAttempts the event is dead-lettered and removed.
+func (st *orderStore) markFailed(id string) (attempts int, deadLettered bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	i := st.indexLocked(id)
+	if i < 0 {
+		return 0, false
+	}
+	e := st.outbox[i]
+	e.attempts++
+	if e.attempts < maxPublishAttempts {
+		return e.attempts, false
+	}
+	st.deadLetterLocked(e, "max_attempts")
+	st.outbox = append(st.outbox[:i], st.outbox[i+1:]...)
+	return e.attempts, true
+}
+
+// deadLettered returns the events given up on, oldest first.
+func (st *orderStore) deadLettered() []deadLetter {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return slices.Clone(st.deadLetters)
+}
+
+// outboxStats reports the backlog size and the age of its oldest event.
+func (st *orderStore) outboxStats(now time.Time) (int, time.Duration) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if len(st.outbox) == 0 {
+		return 0, 0
+	}
+	return len(st.outbox), now.Sub(st.outbox[0].event.OccurredAt)
+}
+
+// outboxRelay drains the outbox to a Publisher. Events are published in
+// order; a failure stops the batch so later events never overtake it.
+type outboxRelay struct {
+	logger    *slog.Logger
+	store     *orderStore
+	publisher Publisher
+	interval  time.Duration
+	batchSize int
+}
+
+func (rl *outboxRelay) run(ctx context.Context) {
+	ticker := time.NewTicker(rl.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rl.flush(ctx)
+		}
+	}
+}
+
+func (rl *outboxRelay) flush(ctx context.Context) {
+	defer func() {
+		pending, age := rl.store.outboxStats(time.Now())
+		outboxEventsPending.Set(float64(pending))
+		outboxOldestEventAge.Set(age.Seconds())
+	}()
+
+	for _, ev := range rl.store.pendingEvents(rl.batchSize) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			// Cannot happen for Event, but never wedge the outbox on it.
+			rl.logger.Error("dropping unencodable event", "event_id", ev.ID, "error", err)
+			rl.store.markPublished(ev.ID)
+			continue
+		}
+
+		pubCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		start := time.Now()
+		err = rl.publisher.Publish(pubCtx, ev.Type, data)
+		cancel()
+		eventPublishDuration.Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			eventsPublishFailuresTotal.WithLabelValues(ev.Type).Inc()
+			attempts, dead := rl.store.markFailed(ev.ID)
+			if dead {
+				rl.logger.Error("event dead-lettered after repeated publish failures",
+					"event_id", ev.ID, "type", ev.Type, "attempts", attempts, "error", err)
+				return
+			}
+			rl.logger.Warn("event publish failed", "event_id", ev.ID, "type", ev.Type,
+				"attempts", attempts, "error", err)
+			return
+		}
+		rl.store.markPublished(ev.ID)
+		eventsPublishedTotal.WithLabelValues(ev.Type).Inc()
+	}
+}
+
+// newEvent builds the event for an order state change.
+func (s *Server) newEvent(eventType string, o Order, reason string) Event {
+	return Event{
+		ID:         fmt.Sprintf("evt-%s-%d", o.ID, s.eventCounter.Add(1)),
+		Type:       eventType,
+		OrderID:    o.ID,
+		OccurredAt: time.Now(),
+		Reason:     reason,
+		Order:      o,
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Consumers
+// ---------------------------------------------------------------------------
+
+// auditConsumer subscribes to every order event and records how far behind
+// the write it is running. It stands in for downstream consumers so the
+// async pipeline has a measurable end-to-end lag.
+func auditConsumer(logger *slog.Logger) func(string, []byte) {
+	const name = "order-audit"
+	return func(subject string, data []byte) {
+		var ev Event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			logger.Warn("undecodable event", "consumer", name, "subject", subject, "error", err)
+			return
+		}
+		eventsConsumedTotal.WithLabelValues(name, ev.Type).Inc()
+		eventConsumerLag.WithLabelValues(name).Observe(time.Since(ev.OccurredAt).Seconds())
+		logger.Debug("order event consumed", "consumer", name, "type", ev.Type, "order_id", ev.OrderID)
+	}
+}
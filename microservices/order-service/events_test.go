@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubjectMatches(t *testing.T) {
+	tests := []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"order.created", "order.created", true},
+		{"order.created", "order.paid", false},
+		{"order.*", "order.paid", true},
+		{"order.*", "order.paid.v2", false},
+		{"order.>", "order.paid.v2", true},
+		{"order.>", "order", false},
+	}
+	for _, tt := range tests {
+		if got := subjectMatches(tt.pattern, tt.subject); got != tt.want {
+			t.Errorf("subjectMatches(%q, %q) = %v, want %v", tt.pattern, tt.subject, got, tt.want)
+		}
+	}
+}
+
+// flakyPublisher fails the first failures calls and records the rest.
+type flakyPublisher struct {
+	mu        sync.Mutex
+	failures  int
+	published []string
+}
+
+func (p *flakyPublisher) Publish(_ context.Context, subject string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker unavailable")
+	}
+	var ev Event
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return err
+	}
+	if ev.Type != subject {
+		return errors.New("event published on wrong subject")
+	}
+	p.published = append(p.published, ev.ID)
+	return nil
+}
+
+func (p *flakyPublisher) Close() error { return nil }
+
+func TestOutboxRelayRetriesInOrder(t *testing.T) {
+	srv := newTestServer(t)
+	order := Order{ID: "ord-test", Status: "created"}
+	var want []string
+	for _, typ := range []string{eventOrderCreated, eventOrderPaid} {
+		ev := srv.newEvent(typ, order, "")
+		srv.orders.Save(order, ev)
+		want = append(want, ev.ID)
+	}
+
+	pub := &flakyPublisher{failures: 2}
+	relay := &outboxRelay{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)), store: srv.orders,
+		publisher: pub, batchSize: 10,
+	}
+	for i := 0; i < 3; i++ {
+		relay.flush(context.Background())
+	}
+
+	if len(pub.published) != len(want) || pub.published[0] != want[0] || pub.published[1] != want[1] {
+		t.Fatalf("published %v, want %v", pub.published, want)
+	}
+	if pending, _ := srv.orders.outboxStats(time.Now()); pending != 0 {
+		t.Errorf("outbox still has %d pending events", pending)
+	}
+}
+
+func TestChannelBrokerDeliversToMatchingSubscribers(t *testing.T) {
+	b := newChannelBroker(4)
+	defer b.Close()
+
+	got := make(chan string, 4)
+	if err := b.Subscribe("order.>", func(subject string, _ []byte) { got <- subject }); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Subscribe("user.>", func(subject string, _ []byte) { t.Errorf("unexpected delivery of %s", subject) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(context.Background(), eventOrderFailed, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case subject := <-got:
+		if subject != eventOrderFailed {
+			t.Errorf("got subject %q, want %q", subject, eventOrderFailed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+}
+
+func TestOutboxDeadLetters(t *testing.T) {
+	srv := newTestServer(t)
+	srv.orders.outboxLimit = 2
+	order := Order{ID: "ord-test", Status: "created"}
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ev := srv.newEvent(eventOrderCreated, order, "")
+		srv.orders.Save(order, ev)
+		ids = append(ids, ev.ID)
+	}
+	if pending, _ := srv.orders.outboxStats(time.Now()); pending != 2 {
+		t.Fatalf("pending after overflow: got %d, want 2", pending)
+	}
+
+	// The event at the head fails until it is given up on; the next one
+	// then goes out.
+	pub := &flakyPublisher{failures: maxPublishAttempts}
+	relay := &outboxRelay{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)), store: srv.orders,
+		publisher: pub, batchSize: 10,
+	}
+	for i := 0; i < maxPublishAttempts+1; i++ {
+		relay.flush(context.Background())
+	}
+	if len(pub.published) != 1 || pub.published[0] != ids[2] {
+		t.Errorf("published %v, want [%s]", pub.published, ids[2])
+	}
+
+	dead := srv.orders.deadLettered()
+	if len(dead) != 2 {
+		t.Fatalf("dead letters: got %d, want 2", len(dead))
+	}
+	if dead[0].Event.ID != ids[0] || dead[0].Reason != "outbox_full" {
+		t.Errorf("first dead letter: got %s (%s), want %s (outbox_full)", dead[0].Event.ID, dead[0].Reason, ids[0])
+	}
+	if dead[1].Event.ID != ids[1] || dead[1].Reason != "max_attempts" || dead[1].Attempts != maxPublishAttempts {
+		t.Errorf("second dead letter: got %s (%s, %d attempts), want %s (max_attempts, %d attempts)",
+			dead[1].Event.ID, dead[1].Reason, dead[1].Attempts, ids[1], maxPublishAttempts)
+	}
+}
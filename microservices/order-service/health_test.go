@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"github.com/sre-observability-platform/internal/platform"
+)
+
+func getReadiness(t *testing.T, srv *Server) (int, platform.HealthReport) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	var report platform.HealthReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return rr.Code, report
+}
+
+func TestReadyzBreakerStuckOpen(t *testing.T) {
+	srv := newTestServer(t)
+	srv.ready.Store(true)
+	for i := 0; i < 5; i++ {
+		srv.paymentBreaker.Execute(func() (interface{}, error) { return nil, errors.New("boom") })
+	}
+
+	// Just opened: a blip, still ready.
+	if code, report := getReadiness(t, srv); code != http.StatusOK || report.Status != "ready" {
+		t.Fatalf("breaker just opened: got %d %+v, want ready", code, report)
+	}
+
+	srv.breakerStuckAfter = 0
+	code, report := getReadiness(t, srv)
+	if code != http.StatusServiceUnavailable || report.Status != "not ready" {
+		t.Fatalf("breaker stuck open: got %d %+v, want not ready", code, report)
+	}
+	for _, c := range report.Checks {
+		if wantFail := c.Name == "payment_breaker"; (c.Status == "fail") != wantFail {
+			t.Errorf("check %s: got %s", c.Name, c.Status)
+		}
+	}
+
+	// Liveness is unaffected: restarting would not fix payment-service.
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("healthz: got %d, want 200", rr.Code)
+	}
+}
+
+func TestBreakerCheckRecovers(t *testing.T) {
+	srv := newTestServer(t)
+	srv.breakerStuckAfter = 0
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:    "test",
+		Timeout: 20 * time.Millisecond,
+		OnStateChange: func(name string, _, to gobreaker.State) {
+			srv.breakerTrips.record(name, to)
+		},
+	})
+	check := srv.breakerCheck(cb)
+	fail := func() (interface{}, error) { return nil, errors.New("down") }
+	succeed := func() (interface{}, error) { return nil, nil }
+
+	for i := 0; i < 6; i++ {
+		cb.Execute(fail)
+	}
+	if err := check(context.Background()); err == nil {
+		t.Fatal("open breaker: want the check to fail")
+	}
+
+	// Once the breaker goes half-open the instance is ready again, so that
+	// trial requests reach it. A failed trial takes it out again.
+	time.Sleep(30 * time.Millisecond)
+	if err := check(context.Background()); err != nil {
+		t.Fatalf("half-open breaker: %v", err)
+	}
+	cb.Execute(fail)
+	if err := check(context.Background()); err == nil {
+		t.Fatal("breaker reopened by a failed trial: want the check to fail")
+	}
+
+	// The dependency recovers: the trial succeeds and the breaker closes.
+	time.Sleep(30 * time.Millisecond)
+	if err := check(context.Background()); err != nil {
+		t.Fatalf("half-open breaker: %v", err)
+	}
+	cb.Execute(succeed)
+	if state := cb.State(); state != gobreaker.StateClosed {
+		t.Fatalf("after a successful trial: breaker %s, want closed", state)
+	}
+	if err := check(context.Background()); err != nil {
+		t.Errorf("closed breaker: %v", err)
+	}
+}
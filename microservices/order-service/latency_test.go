@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sre-observability-platform/internal/platform"
+)
+
+func TestLatencyModelsReplaceSimulatedDelays(t *testing.T) {
+	dir := t.TempDir()
+	hist := "# le count\n0.01 500\n0.05 900\n0.25 990\n1 1000\n+Inf 1000\n"
+	if err := os.WriteFile(filepath.Join(dir, "get.hist"), []byte(hist), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := `{"GET /api/orders": {"type": "empirical", "file": "get.hist"}}`
+	path := filepath.Join(dir, "latency.json")
+	if err := os.WriteFile(path, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LATENCY_MODELS", path)
+	models, err := platform.LatencyFromEnv(latencySites)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t)
+	s.latency = models
+	for i := 0; i < 100; i++ {
+		if d := s.simulateLatency("GET /api/orders", 5000, 0, 0); d > time.Second {
+			t.Fatalf("configured site drew %v, ignoring its model", d)
+		}
+	}
+}
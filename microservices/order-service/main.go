@@ -1,14 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -18,6 +26,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sony/gobreaker"
+	"github.com/sre-observability-platform/internal/platform"
 )
 
 // ---------------------------------------------------------------------------
@@ -49,6 +58,14 @@ var (
 		},
 	)
 
+	ordersRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_rejected_total",
+			Help: "Total number of orders rejected by a downstream business check.",
+		},
+		[]string{"reason"},
+	)
+
 	ordersInProgress = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "orders_in_progress",
@@ -72,6 +89,77 @@ var (
 		[]string{"service", "status"},
 	)
 
+	outboxEventsPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_events_pending",
+			Help: "Number of order events waiting in the outbox.",
+		},
+	)
+
+	outboxOldestEventAge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_oldest_event_age_seconds",
+			Help: "Age of the oldest unpublished event in the outbox.",
+		},
+	)
+
+	outboxEventsDeadLetteredTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outbox_events_dead_lettered_total",
+			Help: "Total order events given up on, by reason (outbox_full, max_attempts).",
+		},
+		[]string{"type", "reason"},
+	)
+
+	eventsPublishedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_published_total",
+			Help: "Total events published to the broker.",
+		},
+		[]string{"type"},
+	)
+
+	eventsPublishFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_publish_failures_total",
+			Help: "Total failed attempts to publish an event to the broker.",
+		},
+		[]string{"type"},
+	)
+
+	eventPublishDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "event_publish_duration_seconds",
+			Help:    "Duration of broker publish calls in seconds.",
+			Buckets: []float64{0.0005, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.0},
+		},
+	)
+
+	eventsConsumedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_consumed_total",
+			Help: "Total events handled by in-service consumers.",
+		},
+		[]string{"consumer", "type"},
+	)
+
+	eventConsumerLag = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "event_consumer_lag_seconds",
+			Help:    "Time between an event being recorded and a consumer handling it.",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 30.0, 60.0, 300.0},
+		},
+		[]string{"consumer"},
+	)
+
+	paymentCallbacksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payment_callbacks_total",
+			Help: "Total payment webhook callbacks received, by event and result.",
+		},
+		[]string{"event", "result"},
+	)
+
 	circuitBreakerState = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "circuit_breaker_state",
@@ -100,6 +188,106 @@ type ErrorResponse struct {
 	TraceID string `json:"trace_id,omitempty"`
 }
 
+type createOrderRequest struct {
+	UserID string   `json:"user_id"`
+	Items  []string `json:"items"`
+}
+
+// rejectionError is returned by callDownstream when a downstream service
+// answers with a 4xx other than 429. It is a business outcome, not a dependency failure, so
+// it does not count against the circuit breaker.
+type rejectionError struct {
+	Service    string
+	StatusCode int
+	Reason     string
+}
+
+func (e *rejectionError) Error() string {
+	return fmt.Sprintf("%s rejected request with %d: %s", e.Service, e.StatusCode, e.Reason)
+}
+
+// ---------------------------------------------------------------------------
+// Simple in-memory order store for simulation
+// ---------------------------------------------------------------------------
+
+// maxStoredOrders bounds the store. Past it the oldest orders are
+// forgotten, which keeps memory and the cost of sorting a list page flat
+// however long the service runs.
+const maxStoredOrders = 5000
+
+type orderStore struct {
+	mu     sync.RWMutex
+	orders map[string]*Order
+	ids    []string // in the order they were stored, oldest first
+	limit  int
+	outbox []*outboxEntry
+
+	outboxLimit int
+	deadLetters []deadLetter
+}
+
+func newOrderStore() *orderStore {
+	return &orderStore{orders: make(map[string]*Order), limit: maxStoredOrders, outboxLimit: maxOutboxEvents}
+}
+
+func (st *orderStore) Get(id string) (Order, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	o, ok := st.orders[id]
+	if !ok {
+		return Order{}, false
+	}
+	return *o, true
+}
+
+func (st *orderStore) Put(o Order) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.putLocked(o)
+}
+
+// putLocked stores o and evicts the oldest orders past the limit.
+func (st *orderStore) putLocked(o Order) {
+	if _, ok := st.orders[o.ID]; !ok {
+		st.ids = append(st.ids, o.ID)
+	}
+	st.orders[o.ID] = &o
+	for len(st.ids) > st.limit {
+		delete(st.orders, st.ids[0])
+		st.ids = st.ids[1:]
+	}
+}
+
+// List returns a snapshot of every order matching keep.
+func (st *orderStore) List(keep func(Order) bool) []Order {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	out := make([]Order, 0, len(st.orders))
+	for _, o := range st.orders {
+		if keep(*o) {
+			out = append(out, *o)
+		}
+	}
+	return out
+}
+
+// seedOrders fills the store with a month of historical orders so list
+// endpoints have realistic result sizes.
+func seedOrders(st *orderStore, n int, rng *rand.Rand) {
+	statuses := []string{"completed", "completed", "completed", "processing", "created", "cancelled"}
+	items := []string{"item-a", "item-b", "item-c", "item-d", "item-e"}
+	for i := 1; i <= n; i++ {
+		st.Put(Order{
+			ID:        fmt.Sprintf("ord-%03d", i),
+			UserID:    fmt.Sprintf("usr-%03d", rng.Intn(50)+100),
+			Items:     items[:rng.Intn(len(items))+1],
+			Total:     float64(rng.Intn(50000)) / 100.0,
+			Status:    statuses[rng.Intn(len(statuses))],
+			CreatedAt: time.Now().Add(-time.Duration(rng.Intn(30*24*60)) * time.Minute),
+		})
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Server
 // ---------------------------------------------------------------------------
@@ -111,11 +299,33 @@ type Server struct {
 	paymentURL     string
 	userURL        string
 	httpClient     *http.Client
+	orders         *orderStore
+	paymentWebhook webhookRegistration
 	ready          atomic.Bool
 	orderCounter   atomic.Int64
+	eventCounter   atomic.Int64
+	capture        *platform.CaptureWriter          // nil unless CAPTURE_FILE is set
+	rateLimiter    *platform.RateLimiter            // nil unless RATE_LIMITS is set
+	rng            *rand.Rand                       // all randomness, see platform.NewRand
+	latency        map[string]platform.LatencyModel // LATENCY_MODELS overrides, by site
+	health         *platform.HealthRegistry
+	drainer        *platform.Drainer
+	limiter        *platform.ConcurrencyLimiter
+	contention     *platform.Contention // nil unless SIMULATED_CAPACITY is set
+	adminToken     string               // ADMIN_TOKEN; /admin/ is not served without it
+	breakerTrips   breakerTrips
+	// A breaker that has not closed for this long fails readiness.
+	breakerStuckAfter time.Duration
 }
 
-func newServer(logger *slog.Logger) *Server {
+// latencySites names the simulated delays that LATENCY_MODELS can replace.
+var latencySites = []string{
+	"GET /api/orders",
+	"GET /api/orders/{orderID}",
+	"POST /api/orders",
+}
+
+func newServer(logger *slog.Logger, rng *rand.Rand) *Server {
 	paymentURL := getEnv("PAYMENT_SERVICE_URL", "http://payment-service:8082")
 	userURL := getEnv("USER_SERVICE_URL", "http://user-service:8083")
 
@@ -124,7 +334,18 @@ func newServer(logger *slog.Logger) *Server {
 		paymentURL: paymentURL,
 		userURL:    userURL,
 		httpClient: &http.Client{Timeout: 5 * time.Second},
+		orders:     newOrderStore(),
+		rng:        rng,
+		health:     platform.NewHealthRegistry(time.Second),
+
+		breakerStuckAfter: time.Minute,
+		adminToken:        getEnv("ADMIN_TOKEN", ""),
 	}
+	s.drainer = platform.NewDrainer(logger, &s.ready)
+	// Checkout and the payment result it waits for are what earn money, so
+	// they keep the reserve when the service sheds load.
+	s.limiter = platform.NewConcurrencyLimiter("POST /api/orders", "POST /internal/payment-callbacks")
+	seedOrders(s.orders, 250, rng)
 
 	cbSettings := func(name string) gobreaker.Settings {
 		return gobreaker.Settings{
@@ -139,6 +360,7 @@ func newServer(logger *slog.Logger) *Server {
 			OnStateChange: func(n string, from, to gobreaker.State) {
 				logger.Warn("circuit breaker state change",
 					"service", n, "from", from.String(), "to", to.String())
+				s.breakerTrips.record(n, to)
 				v := 0.0
 				switch to {
 				case gobreaker.StateHalfOpen:
@@ -148,11 +370,21 @@ func newServer(logger *slog.Logger) *Server {
 				}
 				circuitBreakerState.WithLabelValues(n).Set(v)
 			},
+			IsSuccessful: func(err error) bool {
+				var rej *rejectionError
+				return err == nil || errors.As(err, &rej)
+			},
 		}
 	}
 
 	s.paymentBreaker = gobreaker.NewCircuitBreaker(cbSettings("payment-service"))
 	s.userBreaker = gobreaker.NewCircuitBreaker(cbSettings("user-service"))
+
+	// Orders cannot be placed without either downstream, so a breaker stuck
+	// open takes the instance out of rotation until it recovers.
+	s.health.Register("order_store", platform.CheckLiveness, platform.LockCheck(s.orders.mu.RLocker()))
+	s.health.Register("payment_breaker", platform.CheckReadiness, s.breakerCheck(s.paymentBreaker))
+	s.health.Register("user_breaker", platform.CheckReadiness, s.breakerCheck(s.userBreaker))
 	return s
 }
 
@@ -165,42 +397,104 @@ func main() {
 
 	prometheus.MustRegister(
 		httpRequestsTotal, httpRequestDuration,
-		ordersCreatedTotal, ordersInProgress, orderProcessingDuration,
+		ordersCreatedTotal, ordersRejectedTotal, ordersInProgress, orderProcessingDuration,
 		downstreamRequestsTotal, circuitBreakerState,
+		outboxEventsPending, outboxOldestEventAge, outboxEventsDeadLetteredTotal,
+		eventsPublishedTotal, eventsPublishFailuresTotal, eventPublishDuration,
+		eventsConsumedTotal, eventConsumerLag, paymentCallbacksTotal,
 	)
+	prometheus.MustRegister(platform.Collectors()...)
+
+	seedFlag := flag.String("seed", "", "random seed (overrides RANDOM_SEED; default: from the clock)")
+	flag.Parse()
+	seed, seedSource, err := platform.RandomSeed(*seedFlag)
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("random seed", "seed", seed, "source", seedSource)
+	rng := platform.NewRand(seed)
+
+	srv := newServer(logger, rng)
+	capture, err := platform.CaptureFromEnv(logger, "order-service", rng)
+	if err != nil {
+		logger.Error("invalid traffic capture configuration", "error", err)
+		os.Exit(1)
+	}
+	srv.capture = capture
+	if srv.latency, err = platform.LatencyFromEnv(latencySites); err != nil {
+		logger.Error("invalid latency model configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.rateLimiter, err = platform.RateLimiterFromEnv(); err != nil {
+		logger.Error("invalid rate limit configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.health.Timeout, err = getEnvDuration("HEALTH_CHECK_TIMEOUT", srv.health.Timeout); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.breakerStuckAfter, err = getEnvDuration("BREAKER_STUCK_AFTER", srv.breakerStuckAfter); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.drainer.PreStopDelay, err = getEnvDuration("PRE_STOP_DELAY", srv.drainer.PreStopDelay); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.drainer.DrainTimeout, err = getEnvDuration("DRAIN_TIMEOUT", srv.drainer.DrainTimeout); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if v, ok := os.LookupEnv("LOAD_SHEDDING"); ok {
+		if srv.limiter.Shed, err = strconv.ParseBool(v); err != nil {
+			logger.Error("invalid configuration", "error", fmt.Errorf("LOAD_SHEDDING: %w", err))
+			os.Exit(1)
+		}
+	}
+	if v, ok := os.LookupEnv("SIMULATED_CAPACITY"); ok {
+		capacity, err := strconv.Atoi(v)
+		if err != nil {
+			logger.Error("invalid configuration", "error", fmt.Errorf("SIMULATED_CAPACITY: %w", err))
+			os.Exit(1)
+		}
+		srv.contention = platform.NewContention(capacity)
+	}
+	if srv.adminToken == "" {
+		logger.Warn("ADMIN_TOKEN is not set; /admin/ is not served")
+	}
 
-	srv := newServer(logger)
-
-	r := chi.NewRouter()
-	r.Use(middleware.RealIP)
-	r.Use(middleware.RequestID)
-	r.Use(srv.metricsMiddleware)
-	r.Use(middleware.Recoverer)
-
-	r.Get("/healthz", srv.handleHealthz)
-	r.Get("/readyz", srv.handleReadyz)
-	r.Handle("/metrics", promhttp.Handler())
+	broker, err := newBroker(logger)
+	if err != nil {
+		logger.Error("invalid event broker configuration", "error", err)
+		os.Exit(1)
+	}
+	if err := broker.Subscribe("order.>", auditConsumer(logger)); err != nil {
+		// The subscription is re-sent whenever the broker reconnects.
+		logger.Warn("subscribing to order events", "error", err)
+	}
+	relay := &outboxRelay{
+		logger: logger, store: srv.orders, publisher: broker,
+		interval: 250 * time.Millisecond, batchSize: 100,
+	}
+	srv.drainer.GoBackground("outbox_relay", relay.run)
 
-	r.Route("/api/orders", func(r chi.Router) {
-		r.Get("/", srv.handleListOrders)
-		r.Post("/", srv.handleCreateOrder)
-		r.Get("/{orderID}", srv.handleGetOrder)
-	})
+	if callbackURL := getEnv("PAYMENT_CALLBACK_URL", "http://order-service:8081/internal/payment-callbacks"); callbackURL != "" {
+		srv.drainer.GoBackground("payment_webhook_registration", func(ctx context.Context) {
+			srv.registerPaymentWebhook(ctx, callbackURL, 30*time.Second)
+		})
+	}
 
 	port := getEnv("PORT", "8081")
 	httpServer := &http.Server{
 		Addr:         ":" + port,
-		Handler:      r,
+		Handler:      srv.routes(),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	go func() {
-		time.Sleep(2 * time.Second)
-		srv.ready.Store(true)
-		logger.Info("service is ready")
-	}()
+	srv.ready.Store(true)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
@@ -215,20 +509,80 @@ func main() {
 
 	<-stop
 	logger.Info("shutting down")
-	srv.ready.Store(false)
+	srv.drainer.PreStop()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), srv.drainer.DrainTimeout)
 	defer cancel()
-	if err := httpServer.Shutdown(ctx); err != nil {
-		logger.Error("forced shutdown", "error", err)
+	srv.drainer.Drain(ctx, httpServer)
+	if srv.capture != nil {
+		srv.capture.Close()
 	}
+	// Give the relay one last chance to drain events from in-flight requests.
+	relay.flush(ctx)
+	broker.Close()
 	logger.Info("server stopped")
 }
 
+// routes builds the HTTP router. It is separate from main so tests can drive
+// the full middleware stack.
+func (s *Server) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(platform.RememberPeer) // before RealIP, for the rate limiter
+	r.Use(middleware.RealIP)
+	r.Use(middleware.RequestID)
+	r.Use(s.drainer.Middleware)
+	r.Use(s.metricsMiddleware)
+	if s.capture != nil {
+		r.Use(s.capture.Middleware)
+	}
+	if s.rateLimiter != nil {
+		r.Use(s.rateLimiter.Middleware(r))
+	}
+	r.Use(s.limiter.Middleware(r))
+	if s.contention != nil {
+		r.Use(s.contention.Middleware) // counts only the work the limiter admits
+	}
+	r.Use(middleware.Recoverer)
+
+	r.Get("/healthz", s.handleHealthz)
+	r.Get("/readyz", s.handleReadyz)
+	r.Handle("/metrics", promhttp.Handler())
+
+	r.Route("/api/orders", func(r chi.Router) {
+		r.Get("/", s.handleListOrders)
+		r.Post("/", s.handleCreateOrder)
+		r.Get("/{orderID}", s.handleGetOrder)
+	})
+
+	r.Post("/internal/payment-callbacks", s.handlePaymentCallback)
+	if s.adminToken != "" {
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(s.adminAuth)
+			r.Get("/breakers", s.handleBreakers)
+			r.Get("/outbox", s.handleOutbox)
+		})
+	}
+	return r
+}
+
 // ---------------------------------------------------------------------------
 // Middleware
 // ---------------------------------------------------------------------------
 
+// adminAuth requires "Authorization: Bearer $ADMIN_TOKEN", compared in
+// constant time. The admin endpoints show internal state, and are served on
+// the same port as the API.
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := "Bearer " + s.adminToken
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			writeError(w, "missing or invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
@@ -254,45 +608,71 @@ func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
 // Handlers
 // ---------------------------------------------------------------------------
 
-func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.health.Serve(w, r, platform.CheckLiveness, "healthy", "unhealthy")
 }
 
-func (s *Server) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+// handleReadyz is not ready before the server starts and once it begins
+// shutting down, whatever the checks say.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
 	if !s.ready.Load() {
 		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	s.health.Serve(w, r, platform.CheckReadiness, "ready", "not ready")
 }
 
 func (s *Server) handleListOrders(w http.ResponseWriter, r *http.Request) {
-	time.Sleep(simulateLatency(50, 20, 0.02))
+	q, err := parsePageQuery(r.URL.Query(), []string{"created_at", "total", "id"}, "-created_at")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := r.URL.Query().Get("status")
+
+	time.Sleep(s.simulateLatency("GET /api/orders", 50, 20, 0.02))
 
-	if rand.Float64() < 0.02 {
+	if s.rng.Float64() < 0.02 {
 		s.logger.Warn("simulated error listing orders",
 			"request_id", middleware.GetReqID(r.Context()))
 		writeError(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	orders := []Order{
-		{ID: "ord-001", UserID: "usr-100", Items: []string{"item-a", "item-b"}, Total: 99.99, Status: "completed", CreatedAt: time.Now().Add(-24 * time.Hour)},
-		{ID: "ord-002", UserID: "usr-101", Items: []string{"item-c"}, Total: 49.50, Status: "processing", CreatedAt: time.Now().Add(-1 * time.Hour)},
+	orders := s.orders.List(func(o Order) bool {
+		return (status == "" || o.Status == status) && q.inCreatedRange(o.CreatedAt)
+	})
+	page, next := paginate(orders, q, orderSortKey, func(o Order) string { return o.ID })
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, page)
+}
+
+func orderSortKey(o Order, field string) string {
+	switch field {
+	case "total":
+		return sortKeyAmount(o.Total)
+	case "id":
+		return o.ID
+	default:
+		return sortKeyTime(o.CreatedAt)
 	}
-	writeJSON(w, http.StatusOK, orders)
 }
 
 func (s *Server) handleGetOrder(w http.ResponseWriter, r *http.Request) {
 	orderID := chi.URLParam(r, "orderID")
-	time.Sleep(simulateLatency(30, 10, 0.01))
+	time.Sleep(s.simulateLatency("GET /api/orders/{orderID}", 30, 10, 0.01))
 
-	if rand.Float64() < 0.02 {
+	if s.rng.Float64() < 0.02 {
 		s.logger.Warn("simulated error getting order", "orderID", orderID)
 		writeError(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	if order, ok := s.orders.Get(orderID); ok {
+		writeJSON(w, http.StatusOK, order)
+		return
+	}
+
 	order := Order{
 		ID: orderID, UserID: "usr-100", Items: []string{"item-a", "item-b"},
 		Total: 99.99, Status: "completed", CreatedAt: time.Now().Add(-2 * time.Hour),
@@ -309,59 +689,138 @@ func (s *Server) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 	s.logger.Info("creating order", "seq", seq,
 		"request_id", middleware.GetReqID(r.Context()))
 
-	time.Sleep(simulateLatency(200, 80, 0.05))
-
-	// Validate user via user-service.
-	if err := s.callDownstream(r.Context(), s.userBreaker, s.userURL+"/api/users/validate", http.MethodGet, "user-service"); err != nil {
-		s.logger.Error("user validation failed", "error", err)
+	var body createOrderRequest
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			orderProcessingDuration.Observe(time.Since(start).Seconds())
+			writeError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if body.UserID == "" {
 		orderProcessingDuration.Observe(time.Since(start).Seconds())
-		writeError(w, "user validation failed", http.StatusBadGateway)
+		writeError(w, "user_id is required", http.StatusBadRequest)
 		return
 	}
+	if len(body.Items) == 0 {
+		body.Items = []string{"item-x", "item-y"}
+	}
+
+	time.Sleep(s.simulateLatency("POST /api/orders", 200, 80, 0.05))
 
-	// Process payment via payment-service.
-	if err := s.callDownstream(r.Context(), s.paymentBreaker, s.paymentURL+"/api/payments", http.MethodPost, "payment-service"); err != nil {
-		s.logger.Error("payment failed", "error", err)
+	// Validate user via user-service.
+	validateURL := s.userURL + "/api/users/validate?" + url.Values{"user_id": {body.UserID}}.Encode()
+	if err := s.callDownstream(r.Context(), s.userBreaker, validateURL, http.MethodGet, "user-service", nil, nil); err != nil {
 		orderProcessingDuration.Observe(time.Since(start).Seconds())
-		writeError(w, "payment processing failed", http.StatusBadGateway)
+		var rej *rejectionError
+		if errors.As(err, &rej) {
+			ordersRejectedTotal.WithLabelValues(rej.Reason).Inc()
+			s.logger.Info("order rejected: user not valid", "user_id", body.UserID,
+				"reason", rej.Reason, "status", rej.StatusCode)
+			writeError(w, "user rejected: "+rej.Reason, http.StatusUnprocessableEntity)
+			return
+		}
+		s.logger.Error("user validation failed", "error", err)
+		writeError(w, "user validation failed", http.StatusBadGateway)
 		return
 	}
 
-	// Simulate occasional internal errors (~2%).
-	if rand.Float64() < 0.02 {
+	// Simulate occasional internal errors (~2%). They strike before the order
+	// is stored or charged, so a client that retries a 500 does not pay twice.
+	if s.rng.Float64() < 0.02 {
 		s.logger.Warn("simulated internal error during order creation")
 		orderProcessingDuration.Observe(time.Since(start).Seconds())
 		writeError(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	ordersCreatedTotal.Inc()
-	orderProcessingDuration.Observe(time.Since(start).Seconds())
-
 	order := Order{
 		ID:        fmt.Sprintf("ord-%06d", seq),
-		UserID:    fmt.Sprintf("usr-%03d", rand.Intn(500)+100),
-		Items:     []string{"item-x", "item-y"},
-		Total:     float64(rand.Intn(50000)) / 100.0,
+		UserID:    body.UserID,
+		Items:     body.Items,
+		Total:     float64(s.rng.Intn(50000)) / 100.0,
 		Status:    "created",
 		CreatedAt: time.Now(),
 	}
-	s.logger.Info("order created", "id", order.ID, "total", order.Total,
+	s.orders.Save(order, s.newEvent(eventOrderCreated, order, ""))
+
+	// Process payment via payment-service. Transitions rather than plain
+	// saves are used from here on because the payment's webhook callback may
+	// race this handler to the final status.
+	webhookID, _ := s.paymentWebhook.get()
+	payReq := paymentRequest{OrderID: order.ID, Amount: order.Total, WebhookID: webhookID}
+	var payment paymentResponse
+	if err := s.callDownstream(r.Context(), s.paymentBreaker, s.paymentURL+"/api/payments", http.MethodPost, "payment-service", payReq, &payment); err != nil {
+		orderProcessingDuration.Observe(time.Since(start).Seconds())
+		// Only a 402 is a decline. Any other rejection means order-service
+		// sent something payment-service could not take, which is a failure.
+		var rej *rejectionError
+		if errors.As(err, &rej) && rej.StatusCode == http.StatusPaymentRequired {
+			ordersRejectedTotal.WithLabelValues("payment_declined").Inc()
+			s.orders.Transition(order.ID, "failed", []string{"created"}, func(o Order) Event {
+				return s.newEvent(eventOrderFailed, o, "payment_declined")
+			})
+			s.logger.Info("order rejected: payment declined", "id", order.ID, "user_id", body.UserID,
+				"reason", rej.Reason, "status", rej.StatusCode)
+			// The failed order is kept, so say which one it was.
+			writeJSON(w, http.StatusPaymentRequired, struct {
+				ErrorResponse
+				ID string `json:"id"`
+			}{ErrorResponse{Error: "payment declined: " + rej.Reason, Code: http.StatusPaymentRequired}, order.ID})
+			return
+		}
+		s.orders.Transition(order.ID, "failed", []string{"created", "pending_payment"}, func(o Order) Event {
+			return s.newEvent(eventOrderFailed, o, "payment_error")
+		})
+		s.logger.Error("payment failed", "id", order.ID, "error", err)
+		writeError(w, "payment processing failed", http.StatusBadGateway)
+		return
+	}
+	code := http.StatusCreated
+	if payment.Status == "pending" {
+		// Settled asynchronously; the payment.* callback finishes the order.
+		code = http.StatusAccepted
+		order, _ = s.orders.Transition(order.ID, "pending_payment", []string{"created"}, nil)
+		w.Header().Set("Location", "/api/orders/"+order.ID)
+	} else {
+		order, _ = s.orders.Transition(order.ID, "paid", []string{"created", "pending_payment"}, func(o Order) Event {
+			return s.newEvent(eventOrderPaid, o, "")
+		})
+	}
+
+	ordersCreatedTotal.Inc()
+	orderProcessingDuration.Observe(time.Since(start).Seconds())
+
+	s.logger.Info("order created", "id", order.ID, "total", order.Total, "status", order.Status,
 		"duration_ms", time.Since(start).Milliseconds())
-	writeJSON(w, http.StatusCreated, order)
+	writeJSON(w, code, order)
 }
 
 // ---------------------------------------------------------------------------
 // Downstream calls with circuit breaker
 // ---------------------------------------------------------------------------
 
-func (s *Server) callDownstream(ctx context.Context, cb *gobreaker.CircuitBreaker, url, method, label string) error {
+// callDownstream sends in (if non-nil) as a JSON body and decodes a successful
+// response into out (if non-nil).
+func (s *Server) callDownstream(ctx context.Context, cb *gobreaker.CircuitBreaker, target, method, label string, in, out interface{}) error {
 	_, err := cb.Execute(func() (interface{}, error) {
-		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		var body io.Reader
+		if in != nil {
+			data, err := json.Marshal(in)
+			if err != nil {
+				return nil, fmt.Errorf("encoding request: %w", err)
+			}
+			body = bytes.NewReader(data)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target, body)
 		if err != nil {
 			downstreamRequestsTotal.WithLabelValues(label, "error").Inc()
 			return nil, fmt.Errorf("creating request: %w", err)
 		}
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("X-Request-Source", "order-service")
 		resp, err := s.httpClient.Do(req)
 		if err != nil {
 			downstreamRequestsTotal.WithLabelValues(label, "error").Inc()
@@ -369,33 +828,165 @@ func (s *Server) callDownstream(ctx context.Context, cb *gobreaker.CircuitBreake
 		}
 		defer resp.Body.Close()
 
-		if resp.StatusCode >= 500 {
+		// A 429 says the dependency cannot take our traffic, not that the
+		// user or payment is bad: it fails like a 5xx and trips the breaker.
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
 			downstreamRequestsTotal.WithLabelValues(label, "error").Inc()
 			return nil, fmt.Errorf("%s returned %d", label, resp.StatusCode)
 		}
+		if resp.StatusCode >= 400 {
+			downstreamRequestsTotal.WithLabelValues(label, "rejected").Inc()
+			return nil, &rejectionError{
+				Service:    label,
+				StatusCode: resp.StatusCode,
+				Reason:     rejectionReason(resp),
+			}
+		}
 		downstreamRequestsTotal.WithLabelValues(label, "success").Inc()
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return nil, fmt.Errorf("decoding %s response: %w", label, err)
+			}
+		}
 		return nil, nil
 	})
 	return err
 }
 
+// breakerStatus is a circuit breaker's state as reported by /admin/breakers.
+type breakerStatus struct {
+	Name                 string `json:"name"`
+	State                string `json:"state"`
+	Requests             uint32 `json:"requests"`
+	TotalSuccesses       uint32 `json:"total_successes"`
+	TotalFailures        uint32 `json:"total_failures"`
+	ConsecutiveSuccesses uint32 `json:"consecutive_successes"`
+	ConsecutiveFailures  uint32 `json:"consecutive_failures"`
+}
+
+func newBreakerStatus(cb *gobreaker.CircuitBreaker) breakerStatus {
+	c := cb.Counts()
+	return breakerStatus{
+		Name:                 cb.Name(),
+		State:                cb.State().String(),
+		Requests:             c.Requests,
+		TotalSuccesses:       c.TotalSuccesses,
+		TotalFailures:        c.TotalFailures,
+		ConsecutiveSuccesses: c.ConsecutiveSuccesses,
+		ConsecutiveFailures:  c.ConsecutiveFailures,
+	}
+}
+
+// breakerTrips records since when each breaker has not been closed. An open
+// breaker goes half-open after its timeout and reopens if the trial requests
+// fail, so a breaker is stuck when it has not closed for longer than one such
+// cycle, rather than when it is open right now.
+type breakerTrips struct {
+	mu    sync.Mutex
+	since map[string]time.Time // by breaker; absent while closed
+}
+
+func (b *breakerTrips) record(name string, to gobreaker.State) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if to == gobreaker.StateClosed {
+		delete(b.since, name)
+		return
+	}
+	if b.since == nil {
+		b.since = make(map[string]time.Time)
+	}
+	if _, ok := b.since[name]; !ok {
+		b.since[name] = time.Now()
+	}
+}
+
+func (b *breakerTrips) notClosedSince(name string) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.since[name]
+	return t, ok
+}
+
+// breakerCheck fails while cb is open and has not closed for
+// breakerStuckAfter. A half-open breaker passes: it closes only on trial
+// requests, and an instance out of rotation would never get any, so every
+// replica would stay out after the dependency recovered. If the trials fail
+// the breaker reopens and the check fails again.
+func (s *Server) breakerCheck(cb *gobreaker.CircuitBreaker) func(context.Context) error {
+	return func(context.Context) error {
+		state := cb.State() // moves an expired open breaker to half-open
+		if state == gobreaker.StateHalfOpen {
+			return nil
+		}
+		if since, ok := s.breakerTrips.notClosedSince(cb.Name()); ok && time.Since(since) >= s.breakerStuckAfter {
+			return fmt.Errorf("%s breaker %s, not closed since %s", cb.Name(), state, since.UTC().Format(time.RFC3339))
+		}
+		return nil
+	}
+}
+
+// handleBreakers reports the circuit breakers for diagnosis. Counts cover the
+// current breaker interval only, as gobreaker resets them.
+func (s *Server) handleBreakers(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, []breakerStatus{
+		newBreakerStatus(s.paymentBreaker),
+		newBreakerStatus(s.userBreaker),
+	})
+}
+
+// handleOutbox reports the outbox backlog and the events dead-lettered from it.
+func (s *Server) handleOutbox(w http.ResponseWriter, _ *http.Request) {
+	pending, age := s.orders.outboxStats(time.Now())
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"pending":                  pending,
+		"oldest_event_age_seconds": age.Seconds(),
+		"dead_letters":             s.orders.deadLettered(),
+	})
+}
+
+// rejectionReason extracts a machine-readable reason from a 4xx response.
+// It understands both user-service's "reason" field and the generic "error"
+// field, falling back to the status text.
+func rejectionReason(resp *http.Response) string {
+	var body struct {
+		Reason string `json:"reason"`
+		Error  string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body); err == nil {
+		if body.Reason != "" {
+			return body.Reason
+		}
+		if body.Error != "" {
+			return body.Error
+		}
+	}
+	return http.StatusText(resp.StatusCode)
+}
+
 // ---------------------------------------------------------------------------
 // Helpers
 // ---------------------------------------------------------------------------
 
-// simulateLatency returns a duration drawn from a normal distribution.
-// baseMsec is the mean, jitterMsec is the standard deviation.
-// slowProb controls how often an extra-slow response occurs (P99 tail).
-func simulateLatency(baseMsec, jitterMsec, slowProb float64) time.Duration {
-	delay := baseMsec + jitterMsec*rand.NormFloat64()
+// simulateLatency returns the delay for site, from its LATENCY_MODELS model
+// if it has one and otherwise from a normal distribution: baseMsec is the
+// mean, jitterMsec is the standard deviation, and slowProb controls how
+// often an extra-slow response occurs (P99 tail).
+// Past SIMULATED_CAPACITY requests in flight it grows with load, see
+// platform.Contention.
+func (s *Server) simulateLatency(site string, baseMsec, jitterMsec, slowProb float64) time.Duration {
+	if m, ok := s.latency[site]; ok {
+		return s.contention.Stretch(m.Sample(s.rng))
+	}
+	delay := baseMsec + jitterMsec*s.rng.NormFloat64()
 	if delay < 1 {
 		delay = 1
 	}
 	// Occasionally inject a very slow response to simulate tail latency.
-	if rand.Float64() < slowProb {
-		delay += baseMsec * (3 + rand.Float64()*7) // 3x-10x slower
+	if s.rng.Float64() < slowProb {
+		delay += baseMsec * (3 + s.rng.Float64()*7) // 3x-10x slower
 	}
-	return time.Duration(delay) * time.Millisecond
+	return s.contention.Stretch(time.Duration(delay) * time.Millisecond)
 }
 
 func writeJSON(w http.ResponseWriter, code int, v interface{}) {
@@ -414,3 +1005,15 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
@@ -1,45 +1,338 @@
 package main
 
 import (
+	"encoding/json"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+
+	"github.com/sony/gobreaker"
+	"github.com/sre-observability-platform/internal/platform"
 )
 
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	return newServer(slog.New(slog.NewTextHandler(io.Discard, nil)), platform.NewRand(1))
+}
+
 func TestHealthzEndpoint(t *testing.T) {
 	req, err := http.NewRequest("GET", "/healthz", nil)
-	if err \!= nil {
+	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(healthzHandler)
+	handler := http.HandlerFunc(newTestServer(t).handleHealthz)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status \!= http.StatusOK {
+	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
 
 func TestReadyzEndpoint(t *testing.T) {
 	req, err := http.NewRequest("GET", "/readyz", nil)
-	if err \!= nil {
+	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(readyzHandler)
+	srv := newTestServer(t)
+	srv.ready.Store(true)
+	handler := http.HandlerFunc(srv.handleReadyz)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status \!= http.StatusOK {
+	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
 
 func TestMetricsEndpoint(t *testing.T) {
 	req, err := http.NewRequest("GET", "/metrics", nil)
-	if err \!= nil {
+	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	http.DefaultServeMux.ServeHTTP(rr, req)
-	if status := rr.Code; status \!= http.StatusOK {
+	newTestServer(t).routes().ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("metrics endpoint returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
+
+func TestCreateOrderRequiresUserID(t *testing.T) {
+	var validated atomic.Bool
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		validated.Store(true)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"valid": true})
+	}))
+	defer users.Close()
+
+	srv := newTestServer(t)
+	srv.userURL = users.URL
+	for _, body := range []string{"", `{}`, `{"items":["item-a"]}`} {
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, httptest.NewRequest("POST", "/api/orders", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "user_id is required") {
+			t.Errorf("body %q: got %d %s, want 400 user_id is required", body, rr.Code, rr.Body.String())
+		}
+	}
+	if validated.Load() {
+		t.Error("order without a user was sent to user-service")
+	}
+}
+
+func TestCreateOrderUserRejected(t *testing.T) {
+	var gotUserID string
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID = r.URL.Query().Get("user_id")
+		writeJSON(w, http.StatusLocked, map[string]interface{}{"valid": false, "reason": "user_locked"})
+	}))
+	defer users.Close()
+
+	srv := newTestServer(t)
+	srv.userURL = users.URL
+
+	// Enough rejections to trip the breaker if they were counted as failures.
+	for i := 0; i < 6; i++ {
+		req := httptest.NewRequest("POST", "/api/orders", strings.NewReader(`{"user_id":"usr-123"}`))
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("create order: got status %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+		if !strings.Contains(rr.Body.String(), "user_locked") {
+			t.Errorf("create order: body %q does not mention rejection reason", rr.Body.String())
+		}
+	}
+	if gotUserID != "usr-123" {
+		t.Errorf("user-service validated %q, want %q", gotUserID, "usr-123")
+	}
+	if state := srv.userBreaker.State(); state != gobreaker.StateClosed {
+		t.Errorf("user breaker state: got %v want %v", state, gobreaker.StateClosed)
+	}
+}
+
+func TestCreateOrderDownstreamRateLimited(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, "rate limit exceeded", http.StatusTooManyRequests)
+	}))
+	defer users.Close()
+	srv := newTestServer(t)
+	srv.userURL = users.URL
+
+	// Throttling is a dependency failure, not a rejected user.
+	for i := 0; i < 5; i++ {
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, httptest.NewRequest("POST", "/api/orders", strings.NewReader(`{"user_id":"usr-123"}`)))
+		if rr.Code != http.StatusBadGateway {
+			t.Fatalf("create order: got %d, want 502", rr.Code)
+		}
+	}
+	if state := srv.userBreaker.State(); state != gobreaker.StateOpen {
+		t.Errorf("user breaker state: got %v want %v", state, gobreaker.StateOpen)
+	}
+}
+
+func TestCreateOrderPaymentDeclined(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"valid": true})
+	}))
+	defer users.Close()
+	payments := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, "payment declined", http.StatusPaymentRequired)
+	}))
+	defer payments.Close()
+
+	srv := newTestServer(t)
+	srv.userURL, srv.paymentURL = users.URL, payments.URL
+	srv.latency = map[string]platform.LatencyModel{"POST /api/orders": platform.NormalLatency{}}
+
+	// Retry past the simulated internal errors.
+	rr := httptest.NewRecorder()
+	for i := 0; i < 10; i++ {
+		rr = httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, httptest.NewRequest("POST", "/api/orders", strings.NewReader(`{"user_id":"usr-100"}`)))
+		if rr.Code != http.StatusInternalServerError {
+			break
+		}
+	}
+	if rr.Code != http.StatusPaymentRequired {
+		t.Fatalf("create order: got %d, want 402", rr.Code)
+	}
+	var body struct{ ID, Error string }
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || body.ID == "" || body.Error == "" {
+		t.Fatalf("declined response: %+v, %v, want the error and the order id", body, err)
+	}
+	// The declined order can be read back, as the checkout probe does.
+	if o, ok := srv.orders.Get(body.ID); !ok || o.Status != "failed" {
+		t.Errorf("order %s: got %+v, %v, want it stored as failed", body.ID, o, ok)
+	}
+}
+
+func TestCreateOrderPaymentRejectedIsNotADecline(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"valid": true})
+	}))
+	defer users.Close()
+	payments := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+	}))
+	defer payments.Close()
+
+	srv := newTestServer(t)
+	srv.userURL, srv.paymentURL = users.URL, payments.URL
+	srv.latency = map[string]platform.LatencyModel{"POST /api/orders": platform.NormalLatency{}}
+
+	// The checkout probe takes a 402 as a working checkout, so it must
+	// mean a declined payment and nothing else.
+	rr := httptest.NewRecorder()
+	for i := 0; i < 10; i++ {
+		rr = httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, httptest.NewRequest("POST", "/api/orders", strings.NewReader(`{"user_id":"usr-100"}`)))
+		if rr.Code != http.StatusInternalServerError {
+			break
+		}
+	}
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("payment-service 400: got %d, want 502", rr.Code)
+	}
+}
+
+func TestCreateOrderPaymentUnavailable(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"valid": true})
+	}))
+	defer users.Close()
+	payments := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, "payment fraud_check_failed", http.StatusServiceUnavailable)
+	}))
+	defer payments.Close()
+
+	srv := newTestServer(t)
+	srv.userURL, srv.paymentURL = users.URL, payments.URL
+	srv.latency = map[string]platform.LatencyModel{"POST /api/orders": platform.NormalLatency{}}
+
+	// A payment-service that cannot check for fraud has failed, the payment
+	// has not been declined: 502, and the breaker counts it.
+	failed := 0
+	for i := 0; i < 20 && failed < 5; i++ {
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, httptest.NewRequest("POST", "/api/orders", strings.NewReader(`{"user_id":"usr-100"}`)))
+		switch rr.Code {
+		case http.StatusBadGateway:
+			failed++
+		case http.StatusInternalServerError: // simulated, before the payment
+		default:
+			t.Fatalf("create order: got %d %s, want 502", rr.Code, rr.Body.String())
+		}
+	}
+	if state := srv.paymentBreaker.State(); state != gobreaker.StateOpen {
+		t.Errorf("payment breaker state: got %v want %v", state, gobreaker.StateOpen)
+	}
+}
+
+func TestCreateOrderInternalErrorChargesNothing(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"valid": true})
+	}))
+	defer users.Close()
+	var charges atomic.Int64
+	payments := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		charges.Add(1)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"status": "completed"})
+	}))
+	defer payments.Close()
+
+	srv := newTestServer(t)
+	srv.userURL, srv.paymentURL = users.URL, payments.URL
+	srv.latency = map[string]platform.LatencyModel{"POST /api/orders": platform.NormalLatency{}}
+	stored := len(srv.orders.List(func(Order) bool { return true }))
+
+	created, failed := 0, 0
+	for i := 0; i < 500 && failed == 0; i++ {
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, httptest.NewRequest("POST", "/api/orders", strings.NewReader(`{"user_id":"usr-100"}`)))
+		switch rr.Code {
+		case http.StatusCreated:
+			created++
+		case http.StatusInternalServerError:
+			failed++
+		default:
+			t.Fatalf("create order: got %d", rr.Code)
+		}
+	}
+	if failed == 0 {
+		t.Fatal("no simulated internal error in 500 orders")
+	}
+	// A retried 500 must not pay twice: nothing was stored or charged.
+	if n := int(charges.Load()); n != created {
+		t.Errorf("payments charged: got %d, want %d, one per order created", n, created)
+	}
+	if n := len(srv.orders.List(func(Order) bool { return true })) - stored; n != created {
+		t.Errorf("orders stored: got %d, want %d", n, created)
+	}
+}
+
+func TestBreakersEndpoint(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer users.Close()
+	srv := newTestServer(t)
+	srv.userURL = users.URL
+
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("POST", "/api/orders", strings.NewReader(`{"user_id":"usr-123"}`))
+		srv.routes().ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	srv.adminToken = "test-token"
+	req := httptest.NewRequest("GET", "/admin/breakers", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("breakers endpoint returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var breakers []breakerStatus
+	if err := json.NewDecoder(rr.Body).Decode(&breakers); err != nil {
+		t.Fatal(err)
+	}
+	states := map[string]string{}
+	for _, b := range breakers {
+		states[b.Name] = b.State
+	}
+	if states["payment-service"] != "closed" || states["user-service"] != "open" {
+		t.Errorf("got states %v, want payment-service closed and user-service open", states)
+	}
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	srv := newTestServer(t)
+	call := func(path, auth string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, req)
+		return rr.Code
+	}
+	for _, path := range []string{"/admin/breakers", "/admin/outbox"} {
+		if code := call(path, "Bearer anything"); code != http.StatusNotFound {
+			t.Errorf("%s without ADMIN_TOKEN: got %d, want 404", path, code)
+		}
+	}
+
+	srv.adminToken = "test-token"
+	for _, path := range []string{"/admin/breakers", "/admin/outbox"} {
+		for _, auth := range []string{"", "Bearer wrong", "test-token"} {
+			if code := call(path, auth); code != http.StatusUnauthorized {
+				t.Errorf("%s with Authorization %q: got %d, want 401", path, auth, code)
+			}
+		}
+		if code := call(path, "Bearer test-token"); code != http.StatusOK {
+			t.Errorf("%s with the token: got %d, want 200", path, code)
+		}
+	}
+}
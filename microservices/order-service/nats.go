@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// NATS broker
+// ---------------------------------------------------------------------------
+//
+// natsClient speaks the NATS client protocol (INFO/CONNECT/PUB/SUB/MSG/
+// PING/PONG) over a plain TCP connection. Each publish is followed by a PING
+// so that Publish only returns once the server has processed the PUB, which
+// gives the outbox at-least-once delivery. Any broker that accepts the NATS
+// protocol, including Kafka bridges, can sit behind it.
+
+var errNATSClosed = errors.New("nats connection closed")
+
+type natsSub struct {
+	pattern string
+	handle  func(string, []byte)
+}
+
+type natsClient struct {
+	addr   string
+	name   string
+	logger *slog.Logger
+
+	// publishMu serialises publish+flush round trips; writeMu guards the
+	// connection writer, which the read loop also uses to answer PINGs.
+	publishMu sync.Mutex
+	writeMu   sync.Mutex
+
+	mu      sync.Mutex
+	conn    *natsConn
+	subs    map[int]natsSub
+	nextSID int
+	closed  bool
+}
+
+// natsConn is one physical connection. PONGs (nil) and -ERRs (non-nil)
+// arrive on pongs; done is closed when the connection is torn down.
+type natsConn struct {
+	net.Conn
+	w     *bufio.Writer
+	pongs chan error
+	done  chan struct{}
+}
+
+func newNATSClient(rawURL, name string, logger *slog.Logger) *natsClient {
+	addr := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		addr = u.Host
+	}
+	return &natsClient{addr: addr, name: name, logger: logger, subs: make(map[int]natsSub)}
+}
+
+func (c *natsClient) Publish(ctx context.Context, subject string, data []byte) error {
+	c.publishMu.Lock()
+	defer c.publishMu.Unlock()
+
+	nc, err := c.ensureConn(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.write(nc, fmt.Sprintf("PUB %s %d\r\n", subject, len(data)), data, []byte("\r\nPING\r\n")); err != nil {
+		c.disconnect(nc, err)
+		return err
+	}
+	select {
+	case err := <-nc.pongs:
+		return err
+	case <-nc.done:
+		return errNATSClosed
+	case <-ctx.Done():
+		// The PONG may still arrive; drop the connection so it cannot be
+		// mistaken for the acknowledgement of a later publish.
+		c.disconnect(nc, ctx.Err())
+		return ctx.Err()
+	}
+}
+
+func (c *natsClient) Subscribe(pattern string, handle func(string, []byte)) error {
+	c.mu.Lock()
+	c.nextSID++
+	sid := c.nextSID
+	c.subs[sid] = natsSub{pattern: pattern, handle: handle}
+	nc := c.conn
+	c.mu.Unlock()
+
+	if nc == nil {
+		// ensureConn sends SUB for every registered subscription.
+		_, err := c.ensureConn(context.Background())
+		return err
+	}
+	return c.write(nc, fmt.Sprintf("SUB %s %d\r\n", pattern, sid))
+}
+
+func (c *natsClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	nc := c.conn
+	c.mu.Unlock()
+	if nc != nil {
+		c.disconnect(nc, errNATSClosed)
+	}
+	return nil
+}
+
+// ensureConn returns the current connection, dialing the server if needed.
+func (c *natsClient) ensureConn(ctx context.Context) (*natsConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errNATSClosed
+	}
+	if c.conn != nil {
+		return c.conn, nil
+	}
+
+	var d net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	conn, err := d.DialContext(dialCtx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing nats %s: %w", c.addr, err)
+	}
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := r.ReadString('\n')
+	conn.SetReadDeadline(time.Time{})
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("nats handshake: expected INFO, got %q (%v)", strings.TrimSpace(line), err)
+	}
+
+	connect, _ := json.Marshal(map[string]interface{}{
+		"verbose": false, "pedantic": false, "name": c.name, "lang": "go", "version": "1.0.0", "protocol": 1,
+	})
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\n", connect)
+	for sid, sub := range c.subs {
+		fmt.Fprintf(w, "SUB %s %d\r\n", sub.pattern, sid)
+	}
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats handshake: %w", err)
+	}
+
+	nc := &natsConn{Conn: conn, w: w, pongs: make(chan error, 1), done: make(chan struct{})}
+	c.conn = nc
+	go c.readLoop(nc, r)
+	c.logger.Info("connected to nats", "addr", c.addr)
+	return nc, nil
+}
+
+func (c *natsClient) write(nc *natsConn, parts ...interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	w := nc.w
+	for _, p := range parts {
+		var err error
+		switch v := p.(type) {
+		case string:
+			_, err = w.WriteString(v)
+		case []byte:
+			_, err = w.Write(v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// disconnect tears down nc if it is still current; the next Publish redials.
+func (c *natsClient) disconnect(nc *natsConn, reason error) {
+	c.mu.Lock()
+	if c.conn != nc {
+		c.mu.Unlock()
+		return
+	}
+	c.conn = nil
+	c.mu.Unlock()
+	nc.Close()
+	close(nc.done)
+	if !errors.Is(reason, errNATSClosed) {
+		c.logger.Warn("nats connection lost", "addr", c.addr, "error", reason)
+	}
+}
+
+func (c *natsClient) readLoop(nc *natsConn, r *bufio.Reader) {
+	ack := func(err error) {
+		select {
+		case nc.pongs <- err:
+		case <-nc.done:
+		}
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			c.disconnect(nc, err)
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PING":
+			c.write(nc, "PONG\r\n")
+		case line == "PONG":
+			ack(nil)
+		case strings.HasPrefix(line, "-ERR"):
+			ack(fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR"))))
+		case strings.HasPrefix(line, "MSG "):
+			if err := c.deliver(line, r); err != nil {
+				c.disconnect(nc, err)
+				return
+			}
+		}
+	}
+}
+
+// deliver reads the payload announced by a "MSG <subject> <sid> [reply] <n>"
+// line and hands it to the subscription's handler.
+func (c *natsClient) deliver(line string, r *bufio.Reader) error {
+	f := strings.Fields(line)
+	if len(f) < 4 {
+		return fmt.Errorf("malformed MSG line %q", line)
+	}
+	n, err := strconv.Atoi(f[len(f)-1])
+	if err != nil {
+		return fmt.Errorf("malformed MSG line %q", line)
+	}
+	payload := make([]byte, n+2) // payload plus trailing CRLF
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+	sid, _ := strconv.Atoi(f[2])
+	c.mu.Lock()
+	sub, ok := c.subs[sid]
+	c.mu.Unlock()
+	if ok {
+		sub.handle(f[1], payload[:n])
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNATSServer is a local stand-in for a NATS server that implements just
+// enough of the protocol for natsClient: INFO, CONNECT, SUB, PUB, PING/PONG
+// and MSG fan-out. Publishing to rejectSubject returns -ERR.
+type fakeNATSServer struct {
+	ln            net.Listener
+	rejectSubject string
+
+	mu   sync.Mutex
+	subs map[net.Conn]map[string]string // conn -> sid -> pattern
+}
+
+func startFakeNATS(t *testing.T) *fakeNATSServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeNATSServer{ln: ln, subs: make(map[net.Conn]map[string]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeNATSServer) serve(conn net.Conn) {
+	defer conn.Close()
+	var wmu sync.Mutex
+	send := func(c net.Conn, msg string) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		io.WriteString(c, msg)
+	}
+	send(conn, `INFO {"server_id":"fake","max_payload":1048576}`+"\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		switch f[0] {
+		case "PING":
+			send(conn, "PONG\r\n")
+		case "SUB":
+			s.mu.Lock()
+			if s.subs[conn] == nil {
+				s.subs[conn] = make(map[string]string)
+			}
+			s.subs[conn][f[2]] = f[1]
+			s.mu.Unlock()
+		case "PUB":
+			n, _ := strconv.Atoi(f[len(f)-1])
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			if f[1] == s.rejectSubject {
+				send(conn, "-ERR 'Permissions Violation'\r\n")
+				continue
+			}
+			s.mu.Lock()
+			for c, sids := range s.subs {
+				for sid, pattern := range sids {
+					if subjectMatches(pattern, f[1]) {
+						send(c, fmt.Sprintf("MSG %s %s %d\r\n%s", f[1], sid, n, payload))
+					}
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func TestNATSClientPublishSubscribe(t *testing.T) {
+	srv := startFakeNATS(t)
+	client := newNATSClient("nats://"+srv.ln.Addr().String(), "test", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer client.Close()
+
+	got := make(chan string, 1)
+	if err := client.Subscribe("order.>", func(subject string, data []byte) {
+		got <- subject + " " + string(data)
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Publish(ctx, eventOrderPaid, []byte(`{"id":"evt-1"}`)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	select {
+	case msg := <-got:
+		if want := eventOrderPaid + ` {"id":"evt-1"}`; msg != want {
+			t.Errorf("got %q, want %q", msg, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not delivered")
+	}
+}
+
+func TestNATSClientReportsServerErrors(t *testing.T) {
+	srv := startFakeNATS(t)
+	srv.rejectSubject = eventOrderFailed
+	client := newNATSClient("nats://"+srv.ln.Addr().String(), "test", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Publish(ctx, eventOrderFailed, []byte("{}")); err == nil {
+		t.Fatal("expected publish to a rejected subject to fail")
+	}
+}
+
+func TestNATSClientUnreachableServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	client := newNATSClient("nats://"+addr, "test", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer client.Close()
+	if err := client.Publish(context.Background(), eventOrderCreated, []byte("{}")); err == nil {
+		t.Fatal("expected publish to an unreachable server to fail")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sre-observability-platform/internal/platform"
+)
+
+func TestPaginateWalksAllPages(t *testing.T) {
+	st := newOrderStore()
+	seedOrders(st, 47, platform.NewRand(1))
+	orders := st.List(func(Order) bool { return true })
+
+	v := url.Values{"limit": {"10"}, "sort": {"-total"}}
+	seen := map[string]bool{}
+	lastTotal := -1.0
+	pages := 0
+	for {
+		q, err := parsePageQuery(v, []string{"created_at", "total", "id"}, "-created_at")
+		if err != nil {
+			t.Fatalf("parsePageQuery: %v", err)
+		}
+		page, next := paginate(orders, q, orderSortKey, func(o Order) string { return o.ID })
+		pages++
+		for _, o := range page {
+			if seen[o.ID] {
+				t.Fatalf("order %s returned twice", o.ID)
+			}
+			seen[o.ID] = true
+			if lastTotal >= 0 && o.Total > lastTotal {
+				t.Fatalf("orders not sorted by -total: %v after %v", o.Total, lastTotal)
+			}
+			lastTotal = o.Total
+		}
+		if next == nil {
+			break
+		}
+		v.Set("cursor", encodeCursor(*next))
+	}
+	if len(seen) != 47 {
+		t.Errorf("got %d orders across pages, want 47", len(seen))
+	}
+	if pages != 5 {
+		t.Errorf("got %d pages, want 5", pages)
+	}
+}
+
+func TestParsePageQueryRejectsInvalidInput(t *testing.T) {
+	fields := []string{"created_at", "id"}
+	cursor := encodeCursor(pageCursor{SortSpec: "id", Key: "ord-001", ID: "ord-001"})
+	tests := []url.Values{
+		{"limit": {"0"}},
+		{"limit": {"101"}},
+		{"sort": {"status"}},
+		{"cursor": {"not-a-cursor"}},
+		{"cursor": {cursor}, "sort": {"-created_at"}},
+		{"created_after": {"yesterday"}},
+	}
+	for _, v := range tests {
+		if _, err := parsePageQuery(v, fields, "-created_at"); err == nil {
+			t.Errorf("parsePageQuery(%v): expected error", v)
+		}
+	}
+}
+
+func TestSetNextLinkKeepsFilters(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/orders?status=completed&limit=5", nil)
+	rr := httptest.NewRecorder()
+	setNextLink(rr, r, &pageCursor{SortSpec: "-created_at", Key: sortKeyTime(time.Now()), ID: "ord-001"})
+
+	link := rr.Header().Get("Link")
+	if !strings.HasPrefix(link, "</api/orders?") || !strings.HasSuffix(link, `>; rel="next"`) {
+		t.Fatalf("unexpected Link header %q", link)
+	}
+	for _, want := range []string{"status=completed", "limit=5", "cursor="} {
+		if !strings.Contains(link, want) {
+			t.Errorf("Link header %q missing %q", link, want)
+		}
+	}
+}
+
+func TestOrderStoreEvictsOldest(t *testing.T) {
+	st := newOrderStore()
+	st.limit = 3
+	for _, id := range []string{"ord-1", "ord-2", "ord-3", "ord-2", "ord-4"} {
+		st.Put(Order{ID: id})
+	}
+	for id, want := range map[string]bool{"ord-1": false, "ord-2": true, "ord-3": true, "ord-4": true} {
+		if _, ok := st.Get(id); ok != want {
+			t.Errorf("%s stored: got %v, want %v", id, ok, want)
+		}
+	}
+	if n := len(st.List(func(Order) bool { return true })); n != 3 {
+		t.Errorf("stored orders: got %d, want 3", n)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/sre-observability-platform/internal/platform"
+)
+
+func TestSameSeedSameInjectedBehaviour(t *testing.T) {
+	run := func(seed int64) ([]Order, []int64) {
+		s := newServer(slog.New(slog.NewTextHandler(io.Discard, nil)), platform.NewRand(seed))
+		var orders []Order
+		for i := 1; i <= 250; i++ {
+			o, _ := s.orders.Get(fmt.Sprintf("ord-%03d", i))
+			orders = append(orders, o)
+		}
+		var delays []int64
+		for i := 0; i < 20; i++ {
+			delays = append(delays, int64(s.simulateLatency("GET /api/orders", 50, 20, 0.1)))
+		}
+		return orders, delays
+	}
+	o1, d1 := run(7)
+	o2, d2 := run(7)
+	for i := range o1 {
+		if o1[i].UserID != o2[i].UserID || o1[i].Total != o2[i].Total || o1[i].Status != o2[i].Status {
+			t.Fatalf("seed data differs at %d: %+v vs %+v", i, o1[i], o2[i])
+		}
+	}
+	for i := range d1 {
+		if d1[i] != d2[i] {
+			t.Fatalf("latency %d differs: %d vs %d", i, d1[i], d2[i])
+		}
+	}
+	if _, d3 := run(8); d3[0] == d1[0] && d3[1] == d1[1] {
+		t.Error("seeds 7 and 8 gave the same latencies")
+	}
+}
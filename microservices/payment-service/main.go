@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -18,6 +25,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sony/gobreaker"
+	"github.com/sre-observability-platform/internal/platform"
 )
 
 // ---------------------------------------------------------------------------
@@ -73,6 +81,59 @@ var (
 		},
 	)
 
+	settlementQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "settlement_queue_depth",
+			Help: "Number of payments waiting for asynchronous settlement.",
+		},
+	)
+
+	settlementQueueOldestAge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "settlement_queue_oldest_age_seconds",
+			Help: "Age of the oldest payment waiting for settlement.",
+		},
+	)
+
+	settlementAttemptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "settlement_attempts_total",
+			Help: "Total settlement attempts by outcome.",
+		},
+		[]string{"result"},
+	)
+
+	settlementDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "settlement_duration_seconds",
+			Help:    "Time from a payment being queued to its final settlement outcome.",
+			Buckets: []float64{0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 30.0, 60.0, 120.0, 300.0},
+		},
+	)
+
+	webhookDeliveriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_deliveries_total",
+			Help: "Total webhook delivery attempts by event and result (success, retry, dead_letter).",
+		},
+		[]string{"event", "result"},
+	)
+
+	webhookDeliveryDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "webhook_delivery_duration_seconds",
+			Help:    "Latency of individual webhook delivery attempts.",
+			Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0},
+		},
+	)
+
+	webhookDeadLetters = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "webhook_dead_letters",
+			Help: "Webhook deliveries that exhausted their retries and are held on the dead-letter list.",
+		},
+	)
+
 	downstreamRequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "downstream_requests_total",
@@ -95,37 +156,193 @@ var (
 // ---------------------------------------------------------------------------
 
 type Payment struct {
-	ID            string    `json:"id"`
-	OrderID       string    `json:"order_id"`
-	Amount        float64   `json:"amount"`
-	Currency      string    `json:"currency"`
-	Status        string    `json:"status"`
-	Type          string    `json:"type"`
-	ProcessedAt   time.Time `json:"processed_at"`
+	ID                 string     `json:"id"`
+	OrderID            string     `json:"order_id"`
+	Amount             float64    `json:"amount"`
+	Currency           string     `json:"currency"`
+	Status             string     `json:"status"`
+	Type               string     `json:"type"`
+	ProcessedAt        time.Time  `json:"processed_at"`
+	SettlementAttempts int        `json:"settlement_attempts,omitempty"`
+	SettledAt          *time.Time `json:"settled_at,omitempty"`
+	WebhookID          string     `json:"webhook_id,omitempty"`
+}
+
+// paymentRequest is the optional body of POST /api/payments. Fields left
+// empty are filled in randomly, which is what the load generator relies on.
+type paymentRequest struct {
+	OrderID   string  `json:"order_id"`
+	Amount    float64 `json:"amount"`
+	Type      string  `json:"type"`
+	WebhookID string  `json:"webhook_id"`
 }
 
+var paymentTypes = []string{"credit_card", "debit_card", "bank_transfer", "digital_wallet"}
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 	Code  int    `json:"code"`
 }
 
+// ---------------------------------------------------------------------------
+// Simple in-memory payment store for simulation
+// ---------------------------------------------------------------------------
+
+// maxStoredPayments bounds the store. Past it the oldest payments are
+// forgotten, which keeps memory and the cost of sorting a list page flat
+// however long the service runs.
+const maxStoredPayments = 5000
+
+type paymentStore struct {
+	mu       sync.RWMutex
+	payments map[string]*Payment
+	ids      []string // in the order they were stored, oldest first
+	limit    int
+}
+
+func newPaymentStore() *paymentStore {
+	return &paymentStore{payments: make(map[string]*Payment), limit: maxStoredPayments}
+}
+
+func (st *paymentStore) Get(id string) (Payment, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	p, ok := st.payments[id]
+	if !ok {
+		return Payment{}, false
+	}
+	return *p, true
+}
+
+// Put stores p and evicts the oldest payments past the limit.
+func (st *paymentStore) Put(p Payment) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.payments[p.ID]; !ok {
+		st.ids = append(st.ids, p.ID)
+	}
+	st.payments[p.ID] = &p
+	for len(st.ids) > st.limit {
+		delete(st.payments, st.ids[0])
+		st.ids = st.ids[1:]
+	}
+}
+
+// Update applies fn to the stored payment. It reports false if id is unknown.
+func (st *paymentStore) Update(id string, fn func(*Payment)) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	p, ok := st.payments[id]
+	if ok {
+		fn(p)
+	}
+	return ok
+}
+
+// List returns a snapshot of every payment matching keep.
+func (st *paymentStore) List(keep func(Payment) bool) []Payment {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	out := make([]Payment, 0, len(st.payments))
+	for _, p := range st.payments {
+		if keep(*p) {
+			out = append(out, *p)
+		}
+	}
+	return out
+}
+
+// seedPayments fills the store with a month of historical payments so list
+// endpoints have realistic result sizes.
+func seedPayments(st *paymentStore, n int, rng *rand.Rand) {
+	statuses := []string{"completed", "completed", "completed", "completed", "pending", "declined"}
+	for i := 1; i <= n; i++ {
+		st.Put(Payment{
+			ID:          fmt.Sprintf("pay-%03d", i),
+			OrderID:     fmt.Sprintf("ord-%03d", i),
+			Amount:      float64(rng.Intn(100000)) / 100.0,
+			Currency:    "USD",
+			Status:      statuses[rng.Intn(len(statuses))],
+			Type:        paymentTypes[rng.Intn(len(paymentTypes))],
+			ProcessedAt: time.Now().Add(-time.Duration(rng.Intn(30*24*60)) * time.Minute),
+		})
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Server
 // ---------------------------------------------------------------------------
 
 type Server struct {
-	logger          *slog.Logger
-	fraudBreaker    *gobreaker.CircuitBreaker
-	httpClient      *http.Client
-	ready           atomic.Bool
-	paymentCounter  atomic.Int64
+	logger                *slog.Logger
+	fraudBreaker          *gobreaker.CircuitBreaker
+	httpClient            *http.Client
+	payments              *paymentStore
+	settlements           *settlementQueue
+	webhooks              *webhookDispatcher
+	asyncTypes            map[string]bool
+	settlementMaxAttempts int
+	ready                 atomic.Bool
+	paymentCounter        atomic.Int64
+	webhookCounter        atomic.Int64
+	capture               *platform.CaptureWriter          // nil unless CAPTURE_FILE is set
+	rateLimiter           *platform.RateLimiter            // nil unless RATE_LIMITS is set
+	rng                   *rand.Rand                       // all randomness, see platform.NewRand
+	latency               map[string]platform.LatencyModel // LATENCY_MODELS overrides, by site
+	health                *platform.HealthRegistry
+	drainer               *platform.Drainer
+	limiter               *platform.ConcurrencyLimiter
+	contention            *platform.Contention // nil unless SIMULATED_CAPACITY is set
+	adminToken            string               // ADMIN_TOKEN; /admin/ is not served without it
+	breakerTrips          breakerTrips
+	// A breaker that has not closed for this long fails readiness.
+	breakerStuckAfter time.Duration
+}
+
+// latencySites names the simulated delays that LATENCY_MODELS can replace.
+var latencySites = []string{
+	"GET /api/payments",
+	"GET /api/payments/{paymentID}",
+	"gateway credit_card",
+	"gateway debit_card",
+	"gateway bank_transfer",
+	"gateway digital_wallet",
+	"fraud check",
+	"settlement enqueue",
+	"settlement",
 }
 
-func newServer(logger *slog.Logger) *Server {
+func newServer(logger *slog.Logger, rng *rand.Rand) *Server {
+	queueCap, _ := strconv.Atoi(getEnv("SETTLEMENT_QUEUE_CAPACITY", "1000"))
+	maxAttempts, _ := strconv.Atoi(getEnv("SETTLEMENT_MAX_ATTEMPTS", "5"))
+	webhookAttempts, _ := strconv.Atoi(getEnv("WEBHOOK_MAX_ATTEMPTS", "6"))
+	webhookQueueCap, _ := strconv.Atoi(getEnv("WEBHOOK_QUEUE_CAPACITY", "1000"))
+	webhookHosts := strings.Split(getEnv("WEBHOOK_ALLOWED_HOSTS", "order-service"), ",")
+	asyncTypes := make(map[string]bool)
+	for _, t := range strings.Split(getEnv("ASYNC_PAYMENT_TYPES", "bank_transfer"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			asyncTypes[t] = true
+		}
+	}
+
 	s := &Server{
-		logger:     logger,
-		httpClient: &http.Client{Timeout: 5 * time.Second},
+		logger:                logger,
+		httpClient:            &http.Client{Timeout: 5 * time.Second},
+		payments:              newPaymentStore(),
+		settlements:           newSettlementQueue(queueCap),
+		webhooks:              newWebhookDispatcher(logger, webhookAttempts, webhookQueueCap, webhookHosts),
+		asyncTypes:            asyncTypes,
+		settlementMaxAttempts: maxAttempts,
+		rng:                   rng,
+		health:                platform.NewHealthRegistry(time.Second),
+		breakerStuckAfter:     time.Minute,
+		adminToken:            getEnv("ADMIN_TOKEN", ""),
 	}
+	s.drainer = platform.NewDrainer(logger, &s.ready)
+	// Taking a payment is what the service is for, so it keeps the reserve
+	// when the service sheds load.
+	s.limiter = platform.NewConcurrencyLimiter("POST /api/payments")
+	seedPayments(s.payments, 250, rng)
 
 	s.fraudBreaker = gobreaker.NewCircuitBreaker(gobreaker.Settings{
 		Name:        "fraud-detection",
@@ -139,6 +356,7 @@ func newServer(logger *slog.Logger) *Server {
 		OnStateChange: func(name string, from, to gobreaker.State) {
 			logger.Warn("circuit breaker state change",
 				"service", name, "from", from.String(), "to", to.String())
+			s.breakerTrips.record(name, to)
 			v := 0.0
 			switch to {
 			case gobreaker.StateHalfOpen:
@@ -150,6 +368,10 @@ func newServer(logger *slog.Logger) *Server {
 		},
 	})
 
+	// Every card payment goes through fraud detection, so an instance whose
+	// fraud breaker is stuck open would only decline them.
+	s.health.Register("payment_store", platform.CheckLiveness, platform.LockCheck(s.payments.mu.RLocker()))
+	s.health.Register("fraud_detection", platform.CheckReadiness, s.breakerCheck(s.fraudBreaker))
 	return s
 }
 
@@ -164,41 +386,99 @@ func main() {
 		httpRequestsTotal, httpRequestDuration,
 		paymentTransactionsTotal, paymentAmountTotal,
 		paymentProcessingDuration, paymentsInFlight,
+		settlementQueueDepth, settlementQueueOldestAge,
+		settlementAttemptsTotal, settlementDuration,
+		webhookDeliveriesTotal, webhookDeliveryDuration, webhookDeadLetters,
 		downstreamRequestsTotal, circuitBreakerState,
 	)
+	prometheus.MustRegister(platform.Collectors()...)
+
+	seedFlag := flag.String("seed", "", "random seed (overrides RANDOM_SEED; default: from the clock)")
+	flag.Parse()
+	seed, seedSource, err := platform.RandomSeed(*seedFlag)
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("random seed", "seed", seed, "source", seedSource)
+	rng := platform.NewRand(seed)
+
+	// newServer reads these unchecked; a bad value must stop the service
+	// rather than become 0.
+	for _, key := range []string{"SETTLEMENT_QUEUE_CAPACITY", "SETTLEMENT_MAX_ATTEMPTS", "WEBHOOK_MAX_ATTEMPTS", "WEBHOOK_QUEUE_CAPACITY"} {
+		if _, err := getEnvInt(key, 1, 1); err != nil {
+			logger.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+	}
+	srv := newServer(logger, rng)
+	capture, err := platform.CaptureFromEnv(logger, "payment-service", rng)
+	if err != nil {
+		logger.Error("invalid traffic capture configuration", "error", err)
+		os.Exit(1)
+	}
+	srv.capture = capture
+	if srv.latency, err = platform.LatencyFromEnv(latencySites); err != nil {
+		logger.Error("invalid latency model configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.rateLimiter, err = platform.RateLimiterFromEnv(); err != nil {
+		logger.Error("invalid rate limit configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.health.Timeout, err = getEnvDuration("HEALTH_CHECK_TIMEOUT", srv.health.Timeout); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.breakerStuckAfter, err = getEnvDuration("BREAKER_STUCK_AFTER", srv.breakerStuckAfter); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.drainer.PreStopDelay, err = getEnvDuration("PRE_STOP_DELAY", srv.drainer.PreStopDelay); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.drainer.DrainTimeout, err = getEnvDuration("DRAIN_TIMEOUT", srv.drainer.DrainTimeout); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if v, ok := os.LookupEnv("LOAD_SHEDDING"); ok {
+		if srv.limiter.Shed, err = strconv.ParseBool(v); err != nil {
+			logger.Error("invalid configuration", "error", fmt.Errorf("LOAD_SHEDDING: %w", err))
+			os.Exit(1)
+		}
+	}
+	if v, ok := os.LookupEnv("SIMULATED_CAPACITY"); ok {
+		capacity, err := strconv.Atoi(v)
+		if err != nil {
+			logger.Error("invalid configuration", "error", fmt.Errorf("SIMULATED_CAPACITY: %w", err))
+			os.Exit(1)
+		}
+		srv.contention = platform.NewContention(capacity)
+	}
+	if srv.adminToken == "" {
+		logger.Warn("ADMIN_TOKEN is not set; /admin/ is not served")
+	}
 
-	srv := newServer(logger)
-
-	r := chi.NewRouter()
-	r.Use(middleware.RealIP)
-	r.Use(middleware.RequestID)
-	r.Use(srv.metricsMiddleware)
-	r.Use(middleware.Recoverer)
-
-	r.Get("/healthz", srv.handleHealthz)
-	r.Get("/readyz", srv.handleReadyz)
-	r.Handle("/metrics", promhttp.Handler())
-
-	r.Route("/api/payments", func(r chi.Router) {
-		r.Get("/", srv.handleListPayments)
-		r.Post("/", srv.handleProcessPayment)
-		r.Get("/{paymentID}", srv.handleGetPayment)
+	workers, err := getEnvInt("SETTLEMENT_WORKERS", 4, 1)
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	srv.drainer.GoBackground("settlement_workers", func(ctx context.Context) {
+		srv.runSettlementWorkers(ctx, workers)
 	})
 
 	port := getEnv("PORT", "8082")
 	httpServer := &http.Server{
 		Addr:         ":" + port,
-		Handler:      r,
+		Handler:      srv.routes(),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	go func() {
-		time.Sleep(2 * time.Second)
-		srv.ready.Store(true)
-		logger.Info("service is ready")
-	}()
+	srv.ready.Store(true)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
@@ -213,20 +493,85 @@ func main() {
 
 	<-stop
 	logger.Info("shutting down")
-	srv.ready.Store(false)
+	srv.drainer.PreStop()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), srv.drainer.DrainTimeout)
 	defer cancel()
-	if err := httpServer.Shutdown(ctx); err != nil {
-		logger.Error("forced shutdown", "error", err)
+	srv.drainer.Drain(ctx, httpServer)
+	if srv.capture != nil {
+		srv.capture.Close()
 	}
+	srv.webhooks.drain(ctx)
 	logger.Info("server stopped")
 }
 
+// routes builds the HTTP router. It is separate from main so tests can drive
+// the full middleware stack.
+func (s *Server) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(platform.RememberPeer) // before RealIP, for the rate limiter
+	r.Use(middleware.RealIP)
+	r.Use(middleware.RequestID)
+	r.Use(s.drainer.Middleware)
+	r.Use(s.metricsMiddleware)
+	if s.capture != nil {
+		r.Use(s.capture.Middleware)
+	}
+	if s.rateLimiter != nil {
+		r.Use(s.rateLimiter.Middleware(r))
+	}
+	r.Use(s.limiter.Middleware(r))
+	if s.contention != nil {
+		r.Use(s.contention.Middleware) // counts only the work the limiter admits
+	}
+	r.Use(middleware.Recoverer)
+
+	r.Get("/healthz", s.handleHealthz)
+	r.Get("/readyz", s.handleReadyz)
+	r.Handle("/metrics", promhttp.Handler())
+
+	r.Route("/api/payments", func(r chi.Router) {
+		r.Get("/", s.handleListPayments)
+		r.Post("/", s.handleProcessPayment)
+		r.Get("/{paymentID}", s.handleGetPayment)
+		r.Get("/{paymentID}/status", s.handlePaymentStatus)
+	})
+
+	r.Route("/api/webhooks", func(r chi.Router) {
+		r.Get("/", s.handleListWebhooks)
+		r.Post("/", s.handleRegisterWebhook)
+		r.Get("/dead-letters", s.handleListDeadLetters)
+		r.Get("/{webhookID}", s.handleGetWebhook)
+		r.Delete("/{webhookID}", s.handleDeleteWebhook)
+	})
+
+	if s.adminToken != "" {
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(s.adminAuth)
+			r.Get("/breakers", s.handleBreakers)
+		})
+	}
+	return r
+}
+
 // ---------------------------------------------------------------------------
 // Middleware
 // ---------------------------------------------------------------------------
 
+// adminAuth requires "Authorization: Bearer $ADMIN_TOKEN", compared in
+// constant time. The admin endpoints show internal state, and are served on
+// the same port as the API.
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := "Bearer " + s.adminToken
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			writeError(w, "missing or invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
@@ -252,44 +597,73 @@ func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
 // Handlers
 // ---------------------------------------------------------------------------
 
-func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.health.Serve(w, r, platform.CheckLiveness, "healthy", "unhealthy")
 }
 
-func (s *Server) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+// handleReadyz is not ready before the server starts and once it begins
+// shutting down, whatever the checks say.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
 	if !s.ready.Load() {
 		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	s.health.Serve(w, r, platform.CheckReadiness, "ready", "not ready")
 }
 
 func (s *Server) handleListPayments(w http.ResponseWriter, r *http.Request) {
-	time.Sleep(simulateLatency(40, 15, 0.03))
+	q, err := parsePageQuery(r.URL.Query(), []string{"processed_at", "amount", "id"}, "-processed_at")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pType := r.URL.Query().Get("type")
+	status := r.URL.Query().Get("status")
+
+	time.Sleep(s.simulateLatency("GET /api/payments", 40, 15, 0.03))
 
-	if rand.Float64() < 0.05 {
+	if s.rng.Float64() < 0.05 {
 		s.logger.Warn("simulated error listing payments")
 		writeError(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	payments := []Payment{
-		{ID: "pay-001", OrderID: "ord-001", Amount: 99.99, Currency: "USD", Status: "completed", Type: "credit_card", ProcessedAt: time.Now().Add(-24 * time.Hour)},
-		{ID: "pay-002", OrderID: "ord-002", Amount: 49.50, Currency: "USD", Status: "pending", Type: "debit_card", ProcessedAt: time.Now().Add(-1 * time.Hour)},
+	payments := s.payments.List(func(p Payment) bool {
+		return (pType == "" || p.Type == pType) &&
+			(status == "" || p.Status == status) &&
+			q.inCreatedRange(p.ProcessedAt)
+	})
+	page, next := paginate(payments, q, paymentSortKey, func(p Payment) string { return p.ID })
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, page)
+}
+
+func paymentSortKey(p Payment, field string) string {
+	switch field {
+	case "amount":
+		return sortKeyAmount(p.Amount)
+	case "id":
+		return p.ID
+	default:
+		return sortKeyTime(p.ProcessedAt)
 	}
-	writeJSON(w, http.StatusOK, payments)
 }
 
 func (s *Server) handleGetPayment(w http.ResponseWriter, r *http.Request) {
 	paymentID := chi.URLParam(r, "paymentID")
-	time.Sleep(simulateLatency(25, 10, 0.02))
+	time.Sleep(s.simulateLatency("GET /api/payments/{paymentID}", 25, 10, 0.02))
 
-	if rand.Float64() < 0.05 {
+	if s.rng.Float64() < 0.05 {
 		s.logger.Warn("simulated error getting payment", "paymentID", paymentID)
 		writeError(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	if payment, ok := s.payments.Get(paymentID); ok {
+		writeJSON(w, http.StatusOK, payment)
+		return
+	}
+
 	payment := Payment{
 		ID: paymentID, OrderID: "ord-001", Amount: 99.99, Currency: "USD",
 		Status: "completed", Type: "credit_card", ProcessedAt: time.Now().Add(-2 * time.Hour),
@@ -302,46 +676,94 @@ func (s *Server) handleProcessPayment(w http.ResponseWriter, r *http.Request) {
 	defer paymentsInFlight.Dec()
 
 	start := time.Now()
+
+	var req paymentRequest
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Amount < 0 {
+		writeError(w, "amount must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.Type != "" && !slices.Contains(paymentTypes, req.Type) {
+		writeError(w, "unknown payment type "+req.Type, http.StatusBadRequest)
+		return
+	}
+	if req.WebhookID != "" {
+		if _, ok := s.webhooks.lookup(req.WebhookID); !ok {
+			// Registrations are in memory, so a restart forgets them. Carry on
+			// without callbacks rather than failing the payment.
+			s.logger.Warn("payment references unknown webhook", "webhook_id", req.WebhookID)
+		}
+	}
+
 	seq := s.paymentCounter.Add(1)
 
 	// Determine payment type randomly for realistic distribution.
-	paymentTypes := []string{"credit_card", "debit_card", "bank_transfer", "digital_wallet"}
-	pType := paymentTypes[rand.Intn(len(paymentTypes))]
-	amount := float64(rand.Intn(100000)) / 100.0
+	pType := req.Type
+	if pType == "" {
+		pType = paymentTypes[s.rng.Intn(len(paymentTypes))]
+	}
+	amount := req.Amount
+	if amount == 0 {
+		amount = float64(s.rng.Intn(100000)) / 100.0
+	}
+	orderID := req.OrderID
+	if orderID == "" {
+		orderID = fmt.Sprintf("ord-%06d", seq)
+	}
+	payment := Payment{
+		ID:        fmt.Sprintf("pay-%06d", seq),
+		OrderID:   orderID,
+		Amount:    amount,
+		Currency:  "USD",
+		Type:      pType,
+		WebhookID: req.WebhookID,
+	}
 
 	s.logger.Info("processing payment", "seq", seq, "type", pType, "amount", amount,
-		"request_id", middleware.GetReqID(r.Context()))
+		"order_id", orderID, "request_id", middleware.GetReqID(r.Context()))
+
+	if s.asyncTypes[pType] {
+		s.acceptForSettlement(w, r, payment, start)
+		return
+	}
 
 	// Simulate payment gateway latency -- credit cards are faster, bank transfers slower.
 	switch pType {
 	case "credit_card":
-		time.Sleep(simulateLatency(150, 50, 0.04))
+		time.Sleep(s.simulateLatency("gateway credit_card", 150, 50, 0.04))
 	case "debit_card":
-		time.Sleep(simulateLatency(180, 60, 0.04))
+		time.Sleep(s.simulateLatency("gateway debit_card", 180, 60, 0.04))
 	case "bank_transfer":
-		time.Sleep(simulateLatency(500, 200, 0.08))
+		time.Sleep(s.simulateLatency("gateway bank_transfer", 500, 200, 0.08))
 	case "digital_wallet":
-		time.Sleep(simulateLatency(100, 30, 0.03))
+		time.Sleep(s.simulateLatency("gateway digital_wallet", 100, 30, 0.03))
 	}
 
 	// Simulate fraud check via circuit breaker (internal call).
 	fraudErr := s.runFraudCheck()
 
-	// Simulate higher error rate (~5%) for interesting SLO data.
-	if rand.Float64() < 0.05 || fraudErr != nil {
-		status := "declined"
+	// Simulate higher error rate (~5%) for interesting SLO data. Only a
+	// decline is the payment's fault and a 402; an unreachable fraud service
+	// or a failing gateway is ours, so callers count it as a failure.
+	if s.rng.Float64() < 0.05 || fraudErr != nil {
+		status, code := "declined", http.StatusPaymentRequired
 		if fraudErr != nil {
-			status = "fraud_check_failed"
+			status, code = "fraud_check_failed", http.StatusServiceUnavailable
 			s.logger.Error("fraud check failed", "error", fraudErr)
-		} else if rand.Float64() < 0.3 {
-			status = "gateway_error"
+		} else if s.rng.Float64() < 0.3 {
+			status, code = "gateway_error", http.StatusBadGateway
 			s.logger.Warn("payment gateway error", "type", pType)
 		} else {
 			s.logger.Warn("payment declined", "type", pType, "amount", amount)
 		}
 		paymentTransactionsTotal.WithLabelValues(status, pType).Inc()
 		paymentProcessingDuration.Observe(time.Since(start).Seconds())
-		writeError(w, "payment "+status, http.StatusPaymentRequired)
+		writeError(w, "payment "+status, code)
 		return
 	}
 
@@ -349,21 +771,65 @@ func (s *Server) handleProcessPayment(w http.ResponseWriter, r *http.Request) {
 	paymentAmountTotal.WithLabelValues("USD").Add(amount)
 	paymentProcessingDuration.Observe(time.Since(start).Seconds())
 
-	payment := Payment{
-		ID:          fmt.Sprintf("pay-%06d", seq),
-		OrderID:     fmt.Sprintf("ord-%06d", seq),
-		Amount:      amount,
-		Currency:    "USD",
-		Status:      "completed",
-		Type:        pType,
-		ProcessedAt: time.Now(),
-	}
-
+	payment.Status = "completed"
+	payment.ProcessedAt = time.Now()
+	s.payments.Put(payment)
+	s.webhooks.notify(payment)
 	s.logger.Info("payment processed", "id", payment.ID, "amount", amount,
 		"type", pType, "duration_ms", time.Since(start).Milliseconds())
 	writeJSON(w, http.StatusCreated, payment)
 }
 
+// acceptForSettlement queues a slow payment type and answers 202 with the
+// pending payment. Clients poll /api/payments/{id}/status for the outcome.
+func (s *Server) acceptForSettlement(w http.ResponseWriter, r *http.Request, payment Payment, start time.Time) {
+	time.Sleep(s.simulateLatency("settlement enqueue", 30, 10, 0.01))
+
+	pType := payment.Type
+	payment.Status = "pending"
+	payment.ProcessedAt = time.Now()
+	// Store before queueing so a fast worker always finds the payment.
+	s.payments.Put(payment)
+	if err := s.settlements.Push(&settlementItem{PaymentID: payment.ID, EnqueuedAt: payment.ProcessedAt}); err != nil {
+		payment.Status = "rejected"
+		s.payments.Put(payment)
+		s.webhooks.notify(payment)
+		paymentTransactionsTotal.WithLabelValues("queue_full", pType).Inc()
+		paymentProcessingDuration.Observe(time.Since(start).Seconds())
+		s.logger.Warn("settlement queue full", "id", payment.ID)
+		w.Header().Set("Retry-After", "5")
+		writeError(w, "settlement queue full", http.StatusServiceUnavailable)
+		return
+	}
+
+	s.webhooks.notify(payment)
+
+	paymentTransactionsTotal.WithLabelValues("pending", pType).Inc()
+	paymentProcessingDuration.Observe(time.Since(start).Seconds())
+	s.logger.Info("payment accepted for settlement", "id", payment.ID, "amount", payment.Amount,
+		"type", pType, "request_id", middleware.GetReqID(r.Context()))
+	w.Header().Set("Location", "/api/payments/"+payment.ID)
+	writeJSON(w, http.StatusAccepted, payment)
+}
+
+func (s *Server) handlePaymentStatus(w http.ResponseWriter, r *http.Request) {
+	paymentID := chi.URLParam(r, "paymentID")
+	payment, ok := s.payments.Get(paymentID)
+	if !ok {
+		writeError(w, "payment not found", http.StatusNotFound)
+		return
+	}
+	if payment.Status == "pending" {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":                  payment.ID,
+		"status":              payment.Status,
+		"settlement_attempts": payment.SettlementAttempts,
+		"settled_at":          payment.SettledAt,
+	})
+}
+
 // ---------------------------------------------------------------------------
 // Internal services
 // ---------------------------------------------------------------------------
@@ -371,10 +837,10 @@ func (s *Server) handleProcessPayment(w http.ResponseWriter, r *http.Request) {
 func (s *Server) runFraudCheck() error {
 	_, err := s.fraudBreaker.Execute(func() (interface{}, error) {
 		// Simulate an internal fraud detection service call.
-		time.Sleep(simulateLatency(20, 10, 0.02))
+		time.Sleep(s.simulateLatency("fraud check", 20, 10, 0.02))
 
 		// Simulate occasional fraud service failures (~3%).
-		if rand.Float64() < 0.03 {
+		if s.rng.Float64() < 0.03 {
 			downstreamRequestsTotal.WithLabelValues("fraud-detection", "error").Inc()
 			return nil, fmt.Errorf("fraud detection service timeout")
 		}
@@ -384,19 +850,102 @@ func (s *Server) runFraudCheck() error {
 	return err
 }
 
+// breakerStatus is a circuit breaker's state as reported by /admin/breakers.
+type breakerStatus struct {
+	Name                 string `json:"name"`
+	State                string `json:"state"`
+	Requests             uint32 `json:"requests"`
+	TotalSuccesses       uint32 `json:"total_successes"`
+	TotalFailures        uint32 `json:"total_failures"`
+	ConsecutiveSuccesses uint32 `json:"consecutive_successes"`
+	ConsecutiveFailures  uint32 `json:"consecutive_failures"`
+}
+
+// breakerTrips records since when each breaker has not been closed. An open
+// breaker goes half-open after its timeout and reopens if the trial requests
+// fail, so a breaker is stuck when it has not closed for longer than one such
+// cycle, rather than when it is open right now.
+type breakerTrips struct {
+	mu    sync.Mutex
+	since map[string]time.Time // by breaker; absent while closed
+}
+
+func (b *breakerTrips) record(name string, to gobreaker.State) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if to == gobreaker.StateClosed {
+		delete(b.since, name)
+		return
+	}
+	if b.since == nil {
+		b.since = make(map[string]time.Time)
+	}
+	if _, ok := b.since[name]; !ok {
+		b.since[name] = time.Now()
+	}
+}
+
+func (b *breakerTrips) notClosedSince(name string) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.since[name]
+	return t, ok
+}
+
+// breakerCheck fails while cb is open and has not closed for
+// breakerStuckAfter. A half-open breaker passes: it closes only on trial
+// requests, and an instance out of rotation would never get any, so every
+// replica would stay out after the dependency recovered. If the trials fail
+// the breaker reopens and the check fails again.
+func (s *Server) breakerCheck(cb *gobreaker.CircuitBreaker) func(context.Context) error {
+	return func(context.Context) error {
+		state := cb.State() // moves an expired open breaker to half-open
+		if state == gobreaker.StateHalfOpen {
+			return nil
+		}
+		if since, ok := s.breakerTrips.notClosedSince(cb.Name()); ok && time.Since(since) >= s.breakerStuckAfter {
+			return fmt.Errorf("%s breaker %s, not closed since %s", cb.Name(), state, since.UTC().Format(time.RFC3339))
+		}
+		return nil
+	}
+}
+
+// handleBreakers reports the circuit breakers for diagnosis. Counts cover the
+// current breaker interval only, as gobreaker resets them.
+func (s *Server) handleBreakers(w http.ResponseWriter, _ *http.Request) {
+	c := s.fraudBreaker.Counts()
+	writeJSON(w, http.StatusOK, []breakerStatus{{
+		Name:                 s.fraudBreaker.Name(),
+		State:                s.fraudBreaker.State().String(),
+		Requests:             c.Requests,
+		TotalSuccesses:       c.TotalSuccesses,
+		TotalFailures:        c.TotalFailures,
+		ConsecutiveSuccesses: c.ConsecutiveSuccesses,
+		ConsecutiveFailures:  c.ConsecutiveFailures,
+	}})
+}
+
 // ---------------------------------------------------------------------------
 // Helpers
 // ---------------------------------------------------------------------------
 
-func simulateLatency(baseMsec, jitterMsec, slowProb float64) time.Duration {
-	delay := baseMsec + jitterMsec*rand.NormFloat64()
+// simulateLatency returns the delay for site, from its LATENCY_MODELS model
+// if it has one and otherwise from a normal distribution with mean baseMsec,
+// standard deviation jitterMsec and a slow tail with probability slowProb.
+// Past SIMULATED_CAPACITY requests in flight it grows with load, see
+// platform.Contention.
+func (s *Server) simulateLatency(site string, baseMsec, jitterMsec, slowProb float64) time.Duration {
+	if m, ok := s.latency[site]; ok {
+		return s.contention.Stretch(m.Sample(s.rng))
+	}
+	delay := baseMsec + jitterMsec*s.rng.NormFloat64()
 	if delay < 1 {
 		delay = 1
 	}
-	if rand.Float64() < slowProb {
-		delay += baseMsec * (3 + rand.Float64()*7)
+	if s.rng.Float64() < slowProb {
+		delay += baseMsec * (3 + s.rng.Float64()*7)
 	}
-	return time.Duration(delay) * time.Millisecond
+	return s.contention.Stretch(time.Duration(delay) * time.Millisecond)
 }
 
 func writeJSON(w http.ResponseWriter, code int, v interface{}) {
@@ -415,3 +964,31 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
+
+// getEnvInt reads key as an integer of at least min.
+func getEnvInt(key string, fallback, min int) (int, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	if n < min {
+		return 0, fmt.Errorf("%s: %d is less than %d", key, n, min)
+	}
+	return n, nil
+}
@@ -1,58 +1,208 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/sre-observability-platform/internal/platform"
 )
 
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	return newServer(slog.New(slog.NewTextHandler(io.Discard, nil)), platform.NewRand(1))
+}
+
+func newRequest(t *testing.T, method, target string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func serve(srv *Server, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, req)
+	return rr
+}
+
 func TestHealthzEndpoint(t *testing.T) {
 	req, err := http.NewRequest("GET", "/healthz", nil)
-	if err \!= nil {
+	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(healthzHandler)
+	handler := http.HandlerFunc(newTestServer(t).handleHealthz)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status \!= http.StatusOK {
+	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
 
 func TestReadyzEndpoint(t *testing.T) {
 	req, err := http.NewRequest("GET", "/readyz", nil)
-	if err \!= nil {
+	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(readyzHandler)
+	srv := newTestServer(t)
+	srv.ready.Store(true)
+	handler := http.HandlerFunc(srv.handleReadyz)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status \!= http.StatusOK {
+	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
 
 func TestMetricsEndpoint(t *testing.T) {
 	req, err := http.NewRequest("GET", "/metrics", nil)
-	if err \!= nil {
+	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	http.DefaultServeMux.ServeHTTP(rr, req)
-	if status := rr.Code; status \!= http.StatusOK {
+	newTestServer(t).routes().ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("metrics endpoint returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
 
 func TestPaymentProcessEndpoint(t *testing.T) {
-	req, err := http.NewRequest("POST", "/api/v1/payments/process", nil)
-	if err \!= nil {
+	req, err := http.NewRequest("POST", "/api/payments", nil)
+	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(processPaymentHandler)
-	handler.ServeHTTP(rr, req)
+	newTestServer(t).routes().ServeHTTP(rr, req)
 	if status := rr.Code; status == http.StatusInternalServerError {
 		t.Errorf("handler returned internal server error: got %v", status)
 	}
 }
+
+func TestPaymentFailureStatusCodes(t *testing.T) {
+	srv := newTestServer(t)
+	srv.latency = map[string]platform.LatencyModel{"gateway credit_card": platform.NormalLatency{}, "fraud check": platform.NormalLatency{}}
+	pay := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/payments", strings.NewReader(`{"type":"credit_card"}`))
+		return serve(srv, req)
+	}
+
+	// A decline is a 402; a failing gateway is the service's own failure.
+	want := map[string]int{
+		"payment declined":           http.StatusPaymentRequired,
+		"payment gateway_error":      http.StatusBadGateway,
+		"payment fraud_check_failed": http.StatusServiceUnavailable,
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 2000 && !(seen["payment declined"] && seen["payment gateway_error"]); i++ {
+		rr := pay()
+		if rr.Code == http.StatusCreated {
+			continue
+		}
+		var body ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if code, ok := want[body.Error]; !ok || rr.Code != code {
+			t.Fatalf("%q answered %d, want %d", body.Error, rr.Code, code)
+		}
+		seen[body.Error] = true
+	}
+	if !seen["payment declined"] || !seen["payment gateway_error"] {
+		t.Fatalf("outcomes seen: %v, want a decline and a gateway error", seen)
+	}
+
+	// With the fraud breaker open the fraud check cannot run at all.
+	srv = newTestServer(t)
+	for i := 0; i < 5; i++ {
+		srv.fraudBreaker.Execute(func() (interface{}, error) { return nil, errors.New("timeout") })
+	}
+	if rr := pay(); rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "fraud_check_failed") {
+		t.Errorf("fraud breaker open: got %d %s, want 503 fraud_check_failed", rr.Code, rr.Body.String())
+	}
+}
+
+func TestBreakersEndpoint(t *testing.T) {
+	srv := newTestServer(t)
+	srv.adminToken = "test-token"
+	req := newRequest(t, "GET", "/admin/breakers")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := serve(srv, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("breakers endpoint returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var breakers []breakerStatus
+	if err := json.NewDecoder(rr.Body).Decode(&breakers); err != nil {
+		t.Fatal(err)
+	}
+	if len(breakers) != 1 || breakers[0].Name != "fraud-detection" || breakers[0].State != "closed" {
+		t.Errorf("got %+v, want the closed fraud-detection breaker", breakers)
+	}
+}
+
+func TestReadyzFraudBreakerStuckOpen(t *testing.T) {
+	srv := newTestServer(t)
+	srv.ready.Store(true)
+	srv.breakerStuckAfter = 0
+	if rr := serve(srv, newRequest(t, "GET", "/readyz")); rr.Code != http.StatusOK {
+		t.Fatalf("breaker closed: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	for i := 0; i < 5; i++ {
+		srv.fraudBreaker.Execute(func() (interface{}, error) { return nil, errors.New("timeout") })
+	}
+	rr := serve(srv, newRequest(t, "GET", "/readyz"))
+	var report platform.HealthReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusServiceUnavailable || report.Status != "not ready" {
+		t.Fatalf("breaker stuck open: got %v %+v, want 503 not ready", rr.Code, report)
+	}
+	for _, c := range report.Checks {
+		if c.Name == "fraud_detection" && c.Status != "fail" {
+			t.Errorf("fraud_detection check: got %+v, want fail", c)
+		}
+	}
+	if rr := serve(srv, newRequest(t, "GET", "/healthz")); rr.Code != http.StatusOK {
+		t.Errorf("healthz: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestGetEnvInt(t *testing.T) {
+	if n, err := getEnvInt("SETTLEMENT_WORKERS", 4, 1); err != nil || n != 4 {
+		t.Errorf("unset: got %d, %v, want 4", n, err)
+	}
+	for _, v := range []string{"0", "-2", "four", ""} {
+		t.Setenv("SETTLEMENT_WORKERS", v)
+		if _, err := getEnvInt("SETTLEMENT_WORKERS", 4, 1); err == nil {
+			t.Errorf("SETTLEMENT_WORKERS=%q: no error", v)
+		}
+	}
+	t.Setenv("SETTLEMENT_WORKERS", "8")
+	if n, err := getEnvInt("SETTLEMENT_WORKERS", 4, 1); err != nil || n != 8 {
+		t.Errorf("8: got %d, %v", n, err)
+	}
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	srv := newTestServer(t)
+	if rr := serve(srv, newRequest(t, "GET", "/admin/breakers")); rr.Code != http.StatusNotFound {
+		t.Errorf("without ADMIN_TOKEN: got %d, want 404", rr.Code)
+	}
+
+	srv.adminToken = "test-token"
+	for _, auth := range []string{"", "Bearer wrong", "test-token"} {
+		req := newRequest(t, "GET", "/admin/breakers")
+		req.Header.Set("Authorization", auth)
+		if rr := serve(srv, req); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: got %d, want 401", auth, rr.Code)
+		}
+	}
+}
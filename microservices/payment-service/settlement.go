@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Asynchronous settlement
+// ---------------------------------------------------------------------------
+//
+// Slow payment types (bank_transfer by default) are accepted with 202 and a
+// "pending" status, then settled by a pool of workers. Transient gateway
+// failures are retried with exponential backoff; a payment that exhausts its
+// attempts ends up "failed".
+
+var errQueueFull = errors.New("settlement queue full")
+
+type settlementItem struct {
+	PaymentID  string
+	EnqueuedAt time.Time
+	Attempts   int
+	NotBefore  time.Time
+}
+
+// settlementQueue is a bounded FIFO whose items can be deferred until
+// NotBefore. Capacity applies to new work only; retries are always accepted
+// so that a full queue never loses a payment that was already acknowledged.
+type settlementQueue struct {
+	mu       sync.Mutex
+	items    []*settlementItem
+	capacity int
+	wake     chan struct{}
+}
+
+func newSettlementQueue(capacity int) *settlementQueue {
+	return &settlementQueue{capacity: capacity, wake: make(chan struct{}, 1)}
+}
+
+func (q *settlementQueue) Push(item *settlementItem) error {
+	q.mu.Lock()
+	if len(q.items) >= q.capacity {
+		q.mu.Unlock()
+		return errQueueFull
+	}
+	q.items = append(q.items, item)
+	q.mu.Unlock()
+	q.signal()
+	return nil
+}
+
+func (q *settlementQueue) retry(item *settlementItem) {
+	q.mu.Lock()
+	q.items = append(q.items, item)
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *settlementQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Pop blocks until an item is due or ctx is cancelled.
+func (q *settlementQueue) Pop(ctx context.Context) (*settlementItem, bool) {
+	for {
+		now := time.Now()
+		q.mu.Lock()
+		wait := time.Duration(math.MaxInt64)
+		for i, it := range q.items {
+			if !it.NotBefore.After(now) {
+				q.items = append(q.items[:i], q.items[i+1:]...)
+				more := len(q.items) > 0
+				q.mu.Unlock()
+				if more {
+					// Pass the wake-up on so idle workers pick up the rest.
+					q.signal()
+				}
+				return it, true
+			}
+			if d := it.NotBefore.Sub(now); d < wait {
+				wait = d
+			}
+		}
+		q.mu.Unlock()
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if wait != time.Duration(math.MaxInt64) {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return nil, false
+		}
+	}
+}
+
+// Stats returns the number of queued items and the age of the oldest one.
+func (q *settlementQueue) Stats(now time.Time) (int, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var oldest time.Duration
+	for _, it := range q.items {
+		if age := now.Sub(it.EnqueuedAt); age > oldest {
+			oldest = age
+		}
+	}
+	return len(q.items), oldest
+}
+
+// settlementBackoff returns the delay before retry number attempts, doubling
+// from one second up to 30 seconds with +/-20% jitter.
+func (s *Server) settlementBackoff(attempts int) time.Duration {
+	d := time.Second << uint(attempts-1)
+	if d > 30*time.Second || d <= 0 {
+		d = 30 * time.Second
+	}
+	return time.Duration(float64(d) * (0.8 + 0.4*s.rng.Float64()))
+}
+
+func (s *Server) runSettlementWorkers(ctx context.Context, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				item, ok := s.settlements.Pop(ctx)
+				if !ok {
+					return
+				}
+				s.settle(item)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			if depth, _ := s.settlements.Stats(time.Now()); depth > 0 {
+				s.logger.Warn("settlement workers stopped with queued payments", "queued", depth)
+			}
+			return
+		case <-ticker.C:
+			depth, oldest := s.settlements.Stats(time.Now())
+			settlementQueueDepth.Set(float64(depth))
+			settlementQueueOldestAge.Set(oldest.Seconds())
+		}
+	}
+}
+
+// settle makes one settlement attempt for a queued payment.
+func (s *Server) settle(item *settlementItem) {
+	item.Attempts++
+	payment, ok := s.payments.Get(item.PaymentID)
+	if !ok {
+		s.logger.Error("queued payment not found", "id", item.PaymentID)
+		return
+	}
+
+	// Simulate the bank's settlement round trip.
+	time.Sleep(s.simulateLatency("settlement", 500, 200, 0.08))
+	err := s.runFraudCheck()
+	if err == nil && s.rng.Float64() < 0.10 {
+		err = fmt.Errorf("settlement gateway timeout")
+	}
+
+	switch {
+	case err != nil && item.Attempts < s.settlementMaxAttempts:
+		settlementAttemptsTotal.WithLabelValues("retry").Inc()
+		delay := s.settlementBackoff(item.Attempts)
+		item.NotBefore = time.Now().Add(delay)
+		s.payments.Update(item.PaymentID, func(p *Payment) { p.SettlementAttempts = item.Attempts })
+		s.logger.Warn("settlement attempt failed, retrying", "id", item.PaymentID,
+			"attempt", item.Attempts, "retry_in", delay.String(), "error", err)
+		s.settlements.retry(item)
+		return
+	case err != nil:
+		payment.Status = "failed"
+		s.logger.Error("settlement failed permanently", "id", item.PaymentID,
+			"attempts", item.Attempts, "error", err)
+	case s.rng.Float64() < 0.05:
+		payment.Status = "declined"
+		s.logger.Warn("settlement declined", "id", item.PaymentID, "amount", payment.Amount)
+	default:
+		payment.Status = "completed"
+		paymentAmountTotal.WithLabelValues(payment.Currency).Add(payment.Amount)
+	}
+
+	now := time.Now()
+	payment.SettlementAttempts = item.Attempts
+	payment.SettledAt = &now
+	s.payments.Update(item.PaymentID, func(p *Payment) {
+		p.Status = payment.Status
+		p.SettlementAttempts = item.Attempts
+		p.SettledAt = &now
+	})
+	s.webhooks.notify(payment)
+	settlementAttemptsTotal.WithLabelValues(payment.Status).Inc()
+	settlementDuration.Observe(now.Sub(item.EnqueuedAt).Seconds())
+	txStatus := payment.Status
+	if txStatus == "completed" {
+		txStatus = "success" // match the synchronous path's label
+	}
+	paymentTransactionsTotal.WithLabelValues(txStatus, payment.Type).Inc()
+	s.logger.Info("payment settled", "id", item.PaymentID, "status", payment.Status,
+		"attempts", item.Attempts, "duration_ms", now.Sub(item.EnqueuedAt).Milliseconds())
+}
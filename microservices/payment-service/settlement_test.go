@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSettlementQueueCapacity(t *testing.T) {
+	q := newSettlementQueue(2)
+	for i := 0; i < 2; i++ {
+		if err := q.Push(&settlementItem{PaymentID: "pay", EnqueuedAt: time.Now()}); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+	}
+	if err := q.Push(&settlementItem{PaymentID: "pay"}); err != errQueueFull {
+		t.Fatalf("push over capacity: got %v want %v", err, errQueueFull)
+	}
+	// Retries bypass the capacity limit.
+	q.retry(&settlementItem{PaymentID: "pay"})
+	if depth, _ := q.Stats(time.Now()); depth != 3 {
+		t.Errorf("depth: got %d want 3", depth)
+	}
+}
+
+func TestSettlementQueueHonoursNotBefore(t *testing.T) {
+	q := newSettlementQueue(10)
+	now := time.Now()
+	q.Push(&settlementItem{PaymentID: "later", EnqueuedAt: now, NotBefore: now.Add(100 * time.Millisecond)})
+	q.Push(&settlementItem{PaymentID: "now", EnqueuedAt: now})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	first, ok := q.Pop(ctx)
+	if !ok || first.PaymentID != "now" {
+		t.Fatalf("first pop: got %+v want payment \"now\"", first)
+	}
+	second, ok := q.Pop(ctx)
+	if !ok || second.PaymentID != "later" {
+		t.Fatalf("second pop: got %+v want payment \"later\"", second)
+	}
+	if elapsed := time.Since(now); elapsed < 100*time.Millisecond {
+		t.Errorf("deferred item popped after %v, before its NotBefore", elapsed)
+	}
+}
+
+func TestSettlementQueuePopStopsOnCancel(t *testing.T) {
+	q := newSettlementQueue(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := q.Pop(ctx); ok {
+		t.Fatal("pop on an empty queue with a cancelled context returned an item")
+	}
+}
+
+func TestAcceptForSettlementReturnsPending(t *testing.T) {
+	srv := newTestServer(t)
+	srv.asyncTypes = map[string]bool{"credit_card": true, "debit_card": true, "bank_transfer": true, "digital_wallet": true}
+
+	req := newRequest(t, "POST", "/api/payments")
+	rr := serve(srv, req)
+	if rr.Code != 202 {
+		t.Fatalf("process payment: got status %v want 202", rr.Code)
+	}
+	loc := rr.Header().Get("Location")
+	if loc == "" {
+		t.Fatal("missing Location header")
+	}
+
+	rr = serve(srv, newRequest(t, "GET", loc+"/status"))
+	if rr.Code != 200 || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("status poll: got %v (Retry-After %q), want 200 with Retry-After", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if depth, _ := srv.settlements.Stats(time.Now()); depth != 1 {
+		t.Errorf("queue depth: got %d want 1", depth)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------------------------------------------------------------------------
+// Webhook callbacks
+// ---------------------------------------------------------------------------
+//
+// Callers register a URL with POST /api/webhooks and reference the returned
+// webhook ID when creating a payment. Every status change of that payment is
+// POSTed to the URL as a "payment.<status>" event. Deliveries carry
+//
+//   X-Webhook-Signature: t=<unix seconds>,v1=<hex HMAC-SHA256>
+//
+// where the HMAC is keyed with the webhook's secret and computed over
+// "<t>.<body>". Deliveries wait in a bounded queue for a fixed pool of
+// workers. Failed deliveries are retried with exponential backoff and end up
+// on the dead-letter list once the attempts are exhausted, as do deliveries
+// that find the queue full.
+//
+// Only hosts in WEBHOOK_ALLOWED_HOSTS can be registered, and redirects are
+// not followed, so a caller cannot point the service at anything else it can
+// reach.
+
+const (
+	maxDeadLetters = 1000
+	webhookWorkers = 32
+)
+
+var errWebhookQueueFull = errors.New("webhook queue full")
+
+type webhookSubscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// wants reports whether the subscription asked for event. No filter means
+// every event.
+func (sub *webhookSubscription) wants(event string) bool {
+	if len(sub.Events) == 0 {
+		return true
+	}
+	for _, e := range sub.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+type webhookDelivery struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Payment    Payment   `json:"payment"`
+}
+
+type deadLetter struct {
+	DeliveryID string    `json:"delivery_id"`
+	WebhookID  string    `json:"webhook_id"`
+	URL        string    `json:"url"`
+	Event      string    `json:"event"`
+	PaymentID  string    `json:"payment_id"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+type webhookJob struct {
+	sub      *webhookSubscription
+	delivery webhookDelivery
+}
+
+type webhookDispatcher struct {
+	logger       *slog.Logger
+	client       *http.Client
+	maxAttempts  int
+	baseBackoff  time.Duration
+	allowedHosts map[string]bool // WEBHOOK_ALLOWED_HOSTS
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	queue   chan webhookJob
+	workers sync.WaitGroup
+	seq     atomic.Int64
+
+	// stopping holds stopped while a delivery is queued, so that none is
+	// queued once the workers have been told to finish.
+	stopping sync.RWMutex
+	stopped  chan struct{}
+
+	mu          sync.RWMutex
+	subs        map[string]*webhookSubscription
+	deadLetters []deadLetter
+}
+
+func newWebhookDispatcher(logger *slog.Logger, maxAttempts, queueCap int, allowedHosts []string) *webhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &webhookDispatcher{
+		logger: logger,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxAttempts:  maxAttempts,
+		baseBackoff:  500 * time.Millisecond,
+		allowedHosts: make(map[string]bool),
+		ctx:          ctx,
+		cancel:       cancel,
+		queue:        make(chan webhookJob, queueCap),
+		stopped:      make(chan struct{}),
+		subs:         make(map[string]*webhookSubscription),
+	}
+	for _, h := range allowedHosts {
+		if h = strings.TrimSpace(h); h != "" {
+			d.allowedHosts[strings.ToLower(h)] = true
+		}
+	}
+	for i := 0; i < webhookWorkers; i++ {
+		d.workers.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// allows reports whether deliveries may be sent to rawURL: an absolute
+// http(s) URL without credentials on one of the allowed hosts.
+func (d *webhookDispatcher) allows(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return false
+	}
+	return d.allowedHosts[strings.ToLower(u.Hostname())]
+}
+
+func (d *webhookDispatcher) register(sub *webhookSubscription) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subs[sub.ID] = sub
+}
+
+func (d *webhookDispatcher) lookup(id string) (*webhookSubscription, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	sub, ok := d.subs[id]
+	return sub, ok
+}
+
+// notify queues a delivery of the payment's current status to the webhook it
+// references, if any. It never blocks the caller: with the queue full, or
+// after drain, the delivery is dead-lettered.
+func (d *webhookDispatcher) notify(p Payment) {
+	if p.WebhookID == "" {
+		return
+	}
+	sub, ok := d.lookup(p.WebhookID)
+	event := "payment." + p.Status
+	if !ok || !sub.wants(event) {
+		return
+	}
+	delivery := webhookDelivery{
+		ID:         fmt.Sprintf("whd-%06d", d.seq.Add(1)),
+		Event:      event,
+		OccurredAt: time.Now(),
+		Payment:    p,
+	}
+	d.stopping.RLock()
+	defer d.stopping.RUnlock()
+	select {
+	case <-d.stopped:
+		d.deadLetter(sub, delivery, 0, errors.New("shutting down"))
+		return
+	default:
+	}
+	select {
+	case d.queue <- webhookJob{sub, delivery}:
+	default:
+		d.deadLetter(sub, delivery, 0, errWebhookQueueFull)
+	}
+}
+
+// work delivers queued deliveries until drain stops it, then delivers what is
+// left in the queue.
+func (d *webhookDispatcher) work() {
+	defer d.workers.Done()
+	for {
+		select {
+		case job := <-d.queue:
+			d.deliver(job.sub, job.delivery)
+		case <-d.stopped:
+			for {
+				select {
+				case job := <-d.queue:
+					d.deliver(job.sub, job.delivery)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver POSTs the delivery until it succeeds or attempts run out.
+func (d *webhookDispatcher) deliver(sub *webhookSubscription, delivery webhookDelivery) {
+	body, _ := json.Marshal(delivery)
+	var lastErr error
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		start := time.Now()
+		lastErr = d.post(sub, delivery, body)
+		webhookDeliveryDuration.Observe(time.Since(start).Seconds())
+		if lastErr == nil {
+			webhookDeliveriesTotal.WithLabelValues(delivery.Event, "success").Inc()
+			return
+		}
+		if attempt == d.maxAttempts {
+			break
+		}
+		webhookDeliveriesTotal.WithLabelValues(delivery.Event, "retry").Inc()
+		backoff := d.baseBackoff << uint(attempt-1)
+		d.logger.Warn("webhook delivery failed, retrying", "delivery_id", delivery.ID,
+			"webhook_id", sub.ID, "attempt", attempt, "retry_in", backoff.String(), "error", lastErr)
+		select {
+		case <-time.After(backoff):
+		case <-d.ctx.Done():
+			d.deadLetter(sub, delivery, attempt, fmt.Errorf("shutdown during retry: %w", lastErr))
+			return
+		}
+	}
+	d.deadLetter(sub, delivery, d.maxAttempts, lastErr)
+}
+
+func (d *webhookDispatcher) post(sub *webhookSubscription, delivery webhookDelivery, body []byte) error {
+	ctx, cancel := context.WithTimeout(d.ctx, d.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-Source", "payment-service")
+	req.Header.Set("X-Webhook-ID", sub.ID)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID)
+	req.Header.Set("X-Webhook-Signature", "t="+ts+",v1="+signWebhook(sub.Secret, ts, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (d *webhookDispatcher) deadLetter(sub *webhookSubscription, delivery webhookDelivery, attempts int, err error) {
+	webhookDeliveriesTotal.WithLabelValues(delivery.Event, "dead_letter").Inc()
+	d.logger.Error("webhook delivery dead-lettered", "delivery_id", delivery.ID,
+		"webhook_id", sub.ID, "attempts", attempts, "error", err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deadLetters = append(d.deadLetters, deadLetter{
+		DeliveryID: delivery.ID,
+		WebhookID:  sub.ID,
+		URL:        sub.URL,
+		Event:      delivery.Event,
+		PaymentID:  delivery.Payment.ID,
+		Attempts:   attempts,
+		LastError:  err.Error(),
+		FailedAt:   time.Now(),
+	})
+	if len(d.deadLetters) > maxDeadLetters {
+		d.deadLetters = d.deadLetters[len(d.deadLetters)-maxDeadLetters:]
+	}
+	webhookDeadLetters.Set(float64(len(d.deadLetters)))
+}
+
+// drain waits for queued and in-flight deliveries until ctx expires, then
+// cancels the rest. Cancelled deliveries are dead-lettered rather than
+// dropped. It may be called only once.
+func (d *webhookDispatcher) drain(ctx context.Context) {
+	d.stopping.Lock()
+	close(d.stopped)
+	d.stopping.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+	}
+	d.cancel()
+}
+
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ---------------------------------------------------------------------------
+// Webhook handlers
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleRegisterWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !s.webhooks.allows(req.URL) {
+		writeError(w, "url must be an absolute http(s) URL on an allowed host", http.StatusBadRequest)
+		return
+	}
+	if req.Secret == "" {
+		b := make([]byte, 32)
+		rand.Read(b)
+		req.Secret = hex.EncodeToString(b)
+	}
+
+	sub := &webhookSubscription{
+		ID:        fmt.Sprintf("whk-%06d", s.webhookCounter.Add(1)),
+		URL:       req.URL,
+		Events:    req.Events,
+		Secret:    req.Secret,
+		CreatedAt: time.Now(),
+	}
+	s.webhooks.register(sub)
+	s.logger.Info("webhook registered", "id", sub.ID, "url", sub.URL, "events", sub.Events)
+	// The secret is only ever returned here.
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, _ *http.Request) {
+	s.webhooks.mu.RLock()
+	subs := make([]webhookSubscription, 0, len(s.webhooks.subs))
+	for _, sub := range s.webhooks.subs {
+		redacted := *sub
+		redacted.Secret = ""
+		subs = append(subs, redacted)
+	}
+	s.webhooks.mu.RUnlock()
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+	writeJSON(w, http.StatusOK, subs)
+}
+
+func (s *Server) handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	sub, ok := s.webhooks.lookup(chi.URLParam(r, "webhookID"))
+	if !ok {
+		writeError(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	redacted := *sub
+	redacted.Secret = ""
+	writeJSON(w, http.StatusOK, redacted)
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "webhookID")
+	s.webhooks.mu.Lock()
+	_, ok := s.webhooks.subs[id]
+	delete(s.webhooks.subs, id)
+	s.webhooks.mu.Unlock()
+	if !ok {
+		writeError(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListDeadLetters(w http.ResponseWriter, _ *http.Request) {
+	s.webhooks.mu.RLock()
+	out := make([]deadLetter, len(s.webhooks.deadLetters))
+	copy(out, s.webhooks.deadLetters)
+	s.webhooks.mu.RUnlock()
+	writeJSON(w, http.StatusOK, out)
+}
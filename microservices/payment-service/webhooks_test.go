@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records deliveries and fails the first failFirst of them.
+type webhookReceiver struct {
+	mu         sync.Mutex
+	failFirst  int
+	calls      int
+	deliveries []webhookDelivery
+	signatures []string
+	bodies     [][]byte
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.calls++
+	if rc.calls <= rc.failFirst {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var d webhookDelivery
+	json.Unmarshal(body, &d)
+	rc.deliveries = append(rc.deliveries, d)
+	rc.signatures = append(rc.signatures, r.Header.Get("X-Webhook-Signature"))
+	rc.bodies = append(rc.bodies, body)
+}
+
+func newTestDispatcher(maxAttempts int) *webhookDispatcher {
+	d := newWebhookDispatcher(slog.New(slog.NewTextHandler(io.Discard, nil)), maxAttempts, 10, []string{"127.0.0.1"})
+	d.baseBackoff = time.Millisecond
+	return d
+}
+
+func drainWithin(t *testing.T, d *webhookDispatcher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	d.drain(ctx)
+}
+
+func TestWebhookDeliveryRetriesAndSigns(t *testing.T) {
+	rc := &webhookReceiver{failFirst: 2}
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+
+	d := newTestDispatcher(5)
+	d.register(&webhookSubscription{ID: "whk-1", URL: ts.URL, Secret: "s3cret"})
+	d.notify(Payment{ID: "pay-1", OrderID: "ord-1", Status: "completed", WebhookID: "whk-1"})
+	drainWithin(t, d)
+
+	if rc.calls != 3 || len(rc.deliveries) != 1 {
+		t.Fatalf("got %d calls and %d deliveries, want 3 and 1", rc.calls, len(rc.deliveries))
+	}
+	if got := rc.deliveries[0].Event; got != "payment.completed" {
+		t.Errorf("event: got %q want payment.completed", got)
+	}
+	var ts1, sig string
+	for _, part := range strings.Split(rc.signatures[0], ",") {
+		if v, ok := strings.CutPrefix(part, "t="); ok {
+			ts1 = v
+		} else if v, ok := strings.CutPrefix(part, "v1="); ok {
+			sig = v
+		}
+	}
+	if want := signWebhook("s3cret", ts1, rc.bodies[0]); sig != want {
+		t.Errorf("signature mismatch: got %q want %q", sig, want)
+	}
+	if len(d.deadLetters) != 0 {
+		t.Errorf("unexpected dead letters: %+v", d.deadLetters)
+	}
+}
+
+func TestWebhookDeliveryDeadLetters(t *testing.T) {
+	rc := &webhookReceiver{failFirst: 100}
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+
+	d := newTestDispatcher(3)
+	d.register(&webhookSubscription{ID: "whk-1", URL: ts.URL, Secret: "s3cret"})
+	d.notify(Payment{ID: "pay-1", Status: "declined", WebhookID: "whk-1"})
+	drainWithin(t, d)
+
+	if rc.calls != 3 {
+		t.Errorf("got %d attempts want 3", rc.calls)
+	}
+	if len(d.deadLetters) != 1 {
+		t.Fatalf("got %d dead letters want 1", len(d.deadLetters))
+	}
+	if dl := d.deadLetters[0]; dl.PaymentID != "pay-1" || dl.Attempts != 3 || dl.Event != "payment.declined" {
+		t.Errorf("unexpected dead letter %+v", dl)
+	}
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	rc := &webhookReceiver{}
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+
+	d := newTestDispatcher(1)
+	d.register(&webhookSubscription{ID: "whk-1", URL: ts.URL, Events: []string{"payment.completed"}})
+	d.notify(Payment{ID: "pay-1", Status: "pending", WebhookID: "whk-1"})
+	d.notify(Payment{ID: "pay-1", Status: "completed", WebhookID: "whk-1"})
+	drainWithin(t, d)
+
+	if len(rc.deliveries) != 1 || rc.deliveries[0].Event != "payment.completed" {
+		t.Errorf("got deliveries %+v, want only payment.completed", rc.deliveries)
+	}
+}
+
+func TestProcessPaymentNotifiesRegisteredWebhook(t *testing.T) {
+	rc := &webhookReceiver{}
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+
+	srv := newTestServer(t)
+	srv.webhooks.allowedHosts["127.0.0.1"] = true
+	req := httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(`{"url":"`+ts.URL+`"}`))
+	rr := serve(srv, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("register webhook: got status %v want 201", rr.Code)
+	}
+	var sub webhookSubscription
+	json.NewDecoder(rr.Body).Decode(&sub)
+	if sub.ID == "" || sub.Secret == "" {
+		t.Fatalf("registration response missing id or secret: %+v", sub)
+	}
+
+	body := `{"order_id":"ord-42","amount":12.5,"type":"bank_transfer","webhook_id":"` + sub.ID + `"}`
+	rr = serve(srv, httptest.NewRequest("POST", "/api/payments", strings.NewReader(body)))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("process payment: got status %v want 202", rr.Code)
+	}
+	drainWithin(t, srv.webhooks)
+
+	if len(rc.deliveries) != 1 {
+		t.Fatalf("got %d deliveries want 1", len(rc.deliveries))
+	}
+	p := rc.deliveries[0].Payment
+	if rc.deliveries[0].Event != "payment.pending" || p.OrderID != "ord-42" || p.Amount != 12.5 {
+		t.Errorf("unexpected delivery %+v", rc.deliveries[0])
+	}
+}
+
+func TestWebhookQueueFullDeadLetters(t *testing.T) {
+	var inFlight atomic.Int64
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		<-release
+	}))
+	defer ts.Close()
+	defer releaseOnce.Do(func() { close(release) })
+
+	d := newWebhookDispatcher(slog.New(slog.NewTextHandler(io.Discard, nil)), 1, 1, []string{"127.0.0.1"})
+	d.register(&webhookSubscription{ID: "whk-1", URL: ts.URL})
+	notify := func() { d.notify(Payment{ID: "pay-1", Status: "completed", WebhookID: "whk-1"}) }
+
+	// Occupy every worker, one delivery at a time so none finds the queue full.
+	for i := 1; i <= webhookWorkers; i++ {
+		notify()
+		for deadline := time.Now().Add(5 * time.Second); inFlight.Load() != int64(i); {
+			if time.Now().After(deadline) {
+				t.Fatalf("in flight: got %d, want %d", inFlight.Load(), i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	notify() // queued
+	notify() // no room
+	releaseOnce.Do(func() { close(release) })
+	drainWithin(t, d)
+
+	if n := inFlight.Load(); n != webhookWorkers+1 {
+		t.Errorf("delivered %d, want %d", n, webhookWorkers+1)
+	}
+	if len(d.deadLetters) != 1 || d.deadLetters[0].LastError != errWebhookQueueFull.Error() {
+		t.Errorf("dead letters: got %+v, want one for the full queue", d.deadLetters)
+	}
+
+	// After drain nothing is queued for workers that are gone.
+	notify()
+	if len(d.deadLetters) != 2 {
+		t.Errorf("notify after drain: %d dead letters, want 2", len(d.deadLetters))
+	}
+}
+
+func TestRegisterWebhookRestrictsURLs(t *testing.T) {
+	srv := newTestServer(t)
+	for _, u := range []string{
+		"http://order-service:8081/internal/payment-callbacks",
+		"https://ORDER-SERVICE/hook",
+	} {
+		rr := serve(srv, httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(`{"url":"`+u+`"}`)))
+		if rr.Code != http.StatusCreated {
+			t.Errorf("%s: got %d, want 201", u, rr.Code)
+		}
+	}
+	for _, u := range []string{
+		"http://169.254.169.254/latest/meta-data/",
+		"http://localhost:8082/admin/breakers",
+		"http://user:pw@order-service/hook",
+		"file:///etc/passwd",
+		"order-service/hook",
+	} {
+		rr := serve(srv, httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(`{"url":"`+u+`"}`)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", u, rr.Code)
+		}
+	}
+}
+
+func TestWebhookDeliveryDoesNotFollowRedirects(t *testing.T) {
+	var followed atomic.Bool
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { followed.Store(true) }))
+	defer elsewhere.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, elsewhere.URL, http.StatusTemporaryRedirect)
+	}))
+	defer ts.Close()
+
+	d := newTestDispatcher(1)
+	d.register(&webhookSubscription{ID: "whk-1", URL: ts.URL})
+	d.notify(Payment{ID: "pay-1", Status: "completed", WebhookID: "whk-1"})
+	drainWithin(t, d)
+
+	if followed.Load() {
+		t.Error("delivery followed a redirect")
+	}
+	if len(d.deadLetters) != 1 {
+		t.Errorf("got %d dead letters, want the redirected delivery", len(d.deadLetters))
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ---------------------------------------------------------------------------
+// Probe configuration
+// ---------------------------------------------------------------------------
+//
+// PROBES_FILE is a YAML list of probes. Each probe has exactly one of an
+// http, flow, tls or dns block, which sets its type:
+//
+//   defaults:
+//     interval: 30s
+//     timeout: 5s
+//   probes:
+//     - name: order-list
+//       http:
+//         url: http://order-service:8081/api/orders?limit=1
+//         expect_status: [200]
+//         json: {"$[0].status": "..."}
+//     - name: checkout
+//       interval: 1m
+//       flow:
+//         - name: create
+//           method: POST
+//           url: http://order-service:8081/api/orders
+//           body: '{"user_id":"usr-100","items":["item-a"]}'
+//           extract: {order_id: $.id}
+//         - name: read
+//           url: http://order-service:8081/api/orders/{{.order_id}}
+//     - name: api-cert
+//       tls: {address: api.example.com:443, min_days_left: 14}
+//     - name: order-dns
+//       dns: {name: order-service, type: A}
+//
+// Flow steps see the values earlier steps extracted as {{.name}} in their
+// url, headers, body and json assertions. The JSONPath subset is the load
+// generator's.
+
+type config struct {
+	Defaults struct {
+		Interval time.Duration `yaml:"interval"`
+		Timeout  time.Duration `yaml:"timeout"`
+	} `yaml:"defaults"`
+	Probes []*probeSpec `yaml:"probes"`
+}
+
+type probeSpec struct {
+	Name     string        `yaml:"name"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+
+	HTTP *httpCheck   `yaml:"http"`
+	Flow []*httpCheck `yaml:"flow"`
+	TLS  *tlsCheck    `yaml:"tls"`
+	DNS  *dnsCheck    `yaml:"dns"`
+
+	typ string // http, flow, tls or dns
+}
+
+type httpCheck struct {
+	Name               string            `yaml:"name"` // flow steps only
+	Method             string            `yaml:"method"`
+	URL                string            `yaml:"url"`
+	Headers            map[string]string `yaml:"headers"`
+	Body               string            `yaml:"body"`
+	ExpectStatus       []int             `yaml:"expect_status"` // empty means any 2xx
+	BodyContains       string            `yaml:"body_contains"`
+	JSON               map[string]string `yaml:"json"`    // JSONPath -> expected value
+	Extract            map[string]string `yaml:"extract"` // variable -> JSONPath, flow steps only
+	InsecureSkipVerify bool              `yaml:"insecure_skip_verify"`
+}
+
+type tlsCheck struct {
+	Address     string `yaml:"address"` // host:port
+	ServerName  string `yaml:"server_name"`
+	MinDaysLeft int    `yaml:"min_days_left"`
+}
+
+type dnsCheck struct {
+	Name   string   `yaml:"name"`
+	Type   string   `yaml:"type"`   // A, AAAA, CNAME, MX or TXT; default A
+	Server string   `yaml:"server"` // host:port; default the system resolver
+	Expect []string `yaml:"expect"` // values that must all be in the answer
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// validate checks the config and fills in defaults.
+func (c *config) validate() error {
+	if c.Defaults.Interval == 0 {
+		c.Defaults.Interval = 30 * time.Second
+	}
+	if c.Defaults.Timeout == 0 {
+		c.Defaults.Timeout = 5 * time.Second
+	}
+	if len(c.Probes) == 0 {
+		return fmt.Errorf("no probes")
+	}
+	seen := map[string]bool{}
+	for i, p := range c.Probes {
+		if p.Name == "" {
+			return fmt.Errorf("probes[%d]: missing name", i)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("probe %s: duplicate name", p.Name)
+		}
+		seen[p.Name] = true
+		if p.Interval == 0 {
+			p.Interval = c.Defaults.Interval
+		}
+		if p.Timeout == 0 {
+			p.Timeout = c.Defaults.Timeout
+		}
+		if p.Interval < time.Second || p.Timeout <= 0 || p.Timeout > p.Interval {
+			return fmt.Errorf("probe %s: want interval >= 1s and 0 < timeout <= interval", p.Name)
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("probe %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *probeSpec) validate() error {
+	var types []string
+	if p.HTTP != nil {
+		types = append(types, "http")
+	}
+	if p.Flow != nil {
+		types = append(types, "flow")
+	}
+	if p.TLS != nil {
+		types = append(types, "tls")
+	}
+	if p.DNS != nil {
+		types = append(types, "dns")
+	}
+	if len(types) != 1 {
+		return fmt.Errorf("want exactly one of http, flow, tls and dns, got %d", len(types))
+	}
+	p.typ = types[0]
+
+	switch p.typ {
+	case "http":
+		if p.HTTP.Name != "" || len(p.HTTP.Extract) > 0 {
+			return fmt.Errorf("http: name and extract are for flow steps")
+		}
+		return p.HTTP.validate()
+	case "flow":
+		if len(p.Flow) == 0 {
+			return fmt.Errorf("flow: no steps")
+		}
+		steps := map[string]bool{}
+		for i, s := range p.Flow {
+			if s.Name == "" || steps[s.Name] {
+				return fmt.Errorf("flow[%d]: steps need unique names", i)
+			}
+			steps[s.Name] = true
+			if err := s.validate(); err != nil {
+				return fmt.Errorf("flow step %s: %w", s.Name, err)
+			}
+		}
+	case "tls":
+		if _, _, err := net.SplitHostPort(p.TLS.Address); err != nil {
+			return fmt.Errorf("tls: address: %w", err)
+		}
+		if p.TLS.MinDaysLeft < 0 {
+			return fmt.Errorf("tls: min_days_left must not be negative")
+		}
+	case "dns":
+		if p.DNS.Name == "" {
+			return fmt.Errorf("dns: missing name")
+		}
+		if p.DNS.Type == "" {
+			p.DNS.Type = "A"
+		}
+		p.DNS.Type = strings.ToUpper(p.DNS.Type)
+		switch p.DNS.Type {
+		case "A", "AAAA", "CNAME", "MX", "TXT":
+		default:
+			return fmt.Errorf("dns: unsupported type %q", p.DNS.Type)
+		}
+		if p.DNS.Server != "" {
+			if _, _, err := net.SplitHostPort(p.DNS.Server); err != nil {
+				return fmt.Errorf("dns: server: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *httpCheck) validate() error {
+	if h.Method == "" {
+		h.Method = http.MethodGet
+	}
+	h.Method = strings.ToUpper(h.Method)
+	// The URL may hold flow variables, so only its start is checked.
+	if u, err := url.Parse(strings.SplitN(h.URL, "{{", 2)[0]); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be an absolute http(s) URL", h.URL)
+	}
+	for path := range h.JSON {
+		if !strings.HasPrefix(path, "$") {
+			return fmt.Errorf("json: %q is not a JSONPath", path)
+		}
+	}
+	for name, path := range h.Extract {
+		if !strings.HasPrefix(path, "$") {
+			return fmt.Errorf("extract %s: %q is not a JSONPath", name, path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "probes.yml")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, `
+defaults:
+  interval: 20s
+probes:
+  - name: orders
+    http: {url: http://order-service:8081/api/orders}
+  - name: checkout
+    interval: 1m
+    timeout: 10s
+    flow:
+      - name: create
+        method: post
+        url: http://order-service:8081/api/orders
+        extract: {order_id: $.id}
+      - name: read
+        url: http://order-service:8081/api/orders/{{.order_id}}
+  - name: dns
+    dns: {name: order-service, type: aaaa}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, checkout, dns := cfg.Probes[0], cfg.Probes[1], cfg.Probes[2]
+	if orders.typ != "http" || orders.Interval != 20*time.Second || orders.Timeout != 5*time.Second || orders.HTTP.Method != "GET" {
+		t.Errorf("orders: got %s every %v timeout %v method %s", orders.typ, orders.Interval, orders.Timeout, orders.HTTP.Method)
+	}
+	if checkout.typ != "flow" || checkout.Interval != time.Minute || checkout.Timeout != 10*time.Second || checkout.Flow[0].Method != "POST" {
+		t.Errorf("checkout: got %s every %v timeout %v method %s", checkout.typ, checkout.Interval, checkout.Timeout, checkout.Flow[0].Method)
+	}
+	if dns.typ != "dns" || dns.DNS.Type != "AAAA" {
+		t.Errorf("dns: got %s type %s", dns.typ, dns.DNS.Type)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, tc := range []struct{ body, want string }{
+		{`probes: []`, "no probes"},
+		{"probes:\n  - http: {url: http://a}", "missing name"},
+		{"probes:\n  - {name: a, http: {url: http://a}}\n  - {name: a, http: {url: http://b}}", "duplicate name"},
+		{"probes:\n  - name: a", "exactly one of"},
+		{"probes:\n  - {name: a, http: {url: http://a}, dns: {name: a}}", "exactly one of"},
+		{"probes:\n  - {name: a, timeout: 1m, http: {url: http://a}}", "timeout <= interval"},
+		{"probes:\n  - {name: a, http: {url: /relative}}", "absolute http(s) URL"},
+		{"probes:\n  - {name: a, http: {url: http://a, json: {id: x}}}", "not a JSONPath"},
+		{"probes:\n  - {name: a, flow: [{url: http://a}]}", "unique names"},
+		{"probes:\n  - {name: a, tls: {address: example.com}}", "tls: address"},
+		{"probes:\n  - {name: a, dns: {name: a, type: SRV}}", "unsupported type"},
+		{"probes:\n  - {name: a, http: {url: http://a, retries: 3}}", "field retries not found"},
+	} {
+		_, err := loadConfig(writeConfig(t, tc.body))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got %v, want error containing %q", tc.body, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// JSONPath subset
+// ---------------------------------------------------------------------------
+//
+// The same subset the load generator's journeys use, so assertions can be
+// moved between the two.
+
+// extractJSONPath evaluates a JSONPath subset against a decoded JSON value:
+// "$" followed by any mix of .field, ['field'] and [index] (negative indexes
+// count from the end).
+func extractJSONPath(doc interface{}, path string) (interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("jsonpath %q must start with $", path)
+	}
+	cur := doc
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			var err error
+			if cur, err = jsonField(cur, rest[:end], path); err != nil {
+				return nil, err
+			}
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("jsonpath %q: unterminated [", path)
+			}
+			sel := rest[1:end]
+			rest = rest[end+1:]
+			if len(sel) >= 2 && sel[0] == '\'' && sel[len(sel)-1] == '\'' {
+				var err error
+				if cur, err = jsonField(cur, sel[1:len(sel)-1], path); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			idx, err := strconv.Atoi(sel)
+			if err != nil {
+				return nil, fmt.Errorf("jsonpath %q: bad index %q", path, sel)
+			}
+			arr, ok := cur.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("jsonpath %q: indexing a non-array", path)
+			}
+			if idx < 0 {
+				idx += len(arr)
+			}
+			if idx < 0 || idx >= len(arr) {
+				return nil, fmt.Errorf("jsonpath %q: index %s out of range (len %d)", path, sel, len(arr))
+			}
+			cur = arr[idx]
+		default:
+			return nil, fmt.Errorf("jsonpath %q: unexpected %q", path, rest[0])
+		}
+	}
+	return cur, nil
+}
+
+func jsonField(cur interface{}, key, path string) (interface{}, error) {
+	obj, ok := cur.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("jsonpath %q: field %q of a non-object", path, key)
+	}
+	v, ok := obj[key]
+	if !ok {
+		return nil, fmt.Errorf("jsonpath %q: no field %q", path, key)
+	}
+	return v, nil
+}
+
+// jsonString renders an extracted value for use in a template: strings
+// verbatim, everything else as JSON.
+func jsonString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
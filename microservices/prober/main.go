@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ---------------------------------------------------------------------------
+// Prometheus metrics
+// ---------------------------------------------------------------------------
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total number of HTTP requests processed.",
+		},
+		[]string{"method", "path", "status"},
+	)
+
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Duration of HTTP requests in seconds.",
+			Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0},
+		},
+		[]string{"method", "path"},
+	)
+
+	probeSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_success",
+			Help: "Whether the last run of a probe succeeded (1) or failed (0).",
+		},
+		[]string{"probe", "type"},
+	)
+
+	probeDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_duration_seconds",
+			Help: "Time the last run of a probe spent in each phase.",
+		},
+		[]string{"probe", "phase"},
+	)
+
+	probeRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "probe_runs_total",
+			Help: "Probe runs, by result (success or failure). The SLI for a probe is its success ratio.",
+		},
+		[]string{"probe", "type", "result"},
+	)
+
+	probeFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "probe_failures_total",
+			Help: "Failed probe runs, by the phase the failure happened in.",
+		},
+		[]string{"probe", "phase"},
+	)
+
+	probeRunDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "probe_run_duration_seconds",
+			Help:    "Duration of whole probe runs, successful or not.",
+			Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0},
+		},
+		[]string{"probe"},
+	)
+
+	probeStatusCode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_http_status_code",
+			Help: "Status code of the last HTTP response a probe got.",
+		},
+		[]string{"probe"},
+	)
+
+	probeCertExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_ssl_earliest_cert_expiry",
+			Help: "Unix time the earliest-expiring certificate a probe was served expires.",
+		},
+		[]string{"probe"},
+	)
+)
+
+// ---------------------------------------------------------------------------
+// Domain types
+// ---------------------------------------------------------------------------
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// ---------------------------------------------------------------------------
+// Server
+// ---------------------------------------------------------------------------
+
+type Server struct {
+	logger *slog.Logger
+	prober *prober
+	probes []*probeSpec
+	ready  atomic.Bool
+
+	mu      sync.Mutex
+	results map[string]probeResult // last run, by probe name
+}
+
+func newServer(logger *slog.Logger, cfg *config) *Server {
+	return &Server{
+		logger:  logger,
+		prober:  &prober{userAgent: "sre-prober/1.0"},
+		probes:  cfg.Probes,
+		results: make(map[string]probeResult),
+	}
+}
+
+// runProbe runs p once and records the result.
+func (s *Server) runProbe(ctx context.Context, p *probeSpec) probeResult {
+	res := s.prober.run(ctx, p)
+
+	probeDuration.DeletePartialMatch(prometheus.Labels{"probe": p.Name})
+	for _, ph := range res.Phases {
+		probeDuration.WithLabelValues(p.Name, ph.Name).Set(ph.Seconds)
+	}
+	probeRunDuration.WithLabelValues(p.Name).Observe(res.Seconds)
+	if res.StatusCode != 0 {
+		probeStatusCode.WithLabelValues(p.Name).Set(float64(res.StatusCode))
+	}
+	if res.CertExpiry != nil {
+		probeCertExpiry.WithLabelValues(p.Name).Set(float64(res.CertExpiry.Unix()))
+	}
+	if res.Success {
+		probeSuccess.WithLabelValues(p.Name, p.typ).Set(1)
+		probeRunsTotal.WithLabelValues(p.Name, p.typ, "success").Inc()
+	} else {
+		probeSuccess.WithLabelValues(p.Name, p.typ).Set(0)
+		probeRunsTotal.WithLabelValues(p.Name, p.typ, "failure").Inc()
+		probeFailuresTotal.WithLabelValues(p.Name, res.FailedPhase).Inc()
+		s.logger.Warn("probe failed", "probe", p.Name, "type", p.typ, "phase", res.FailedPhase, "error", res.Error)
+	}
+
+	s.mu.Lock()
+	s.results[p.Name] = res
+	s.mu.Unlock()
+	return res
+}
+
+// schedule runs every probe on its interval until ctx is done. Start times
+// are spread evenly over each probe's first interval so the probes do not
+// all fire together.
+func (s *Server) schedule(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i, p := range s.probes {
+		// Zero the counters so rate() sees a probe from its first run.
+		probeRunsTotal.WithLabelValues(p.Name, p.typ, "success")
+		probeRunsTotal.WithLabelValues(p.Name, p.typ, "failure")
+
+		offset := p.Interval * time.Duration(i) / time.Duration(len(s.probes))
+		wg.Add(1)
+		go func(p *probeSpec, offset time.Duration) {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(offset):
+			}
+			ticker := time.NewTicker(p.Interval)
+			defer ticker.Stop()
+			for {
+				s.runProbe(ctx, p)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(p, offset)
+	}
+	return &wg
+}
+
+// ---------------------------------------------------------------------------
+// main
+// ---------------------------------------------------------------------------
+
+func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	prometheus.MustRegister(
+		httpRequestsTotal, httpRequestDuration,
+		probeSuccess, probeDuration, probeRunsTotal, probeFailuresTotal,
+		probeRunDuration, probeStatusCode, probeCertExpiry,
+	)
+
+	path := getEnv("PROBES_FILE", "/etc/prober/probes.yml")
+	cfg, err := loadConfig(path)
+	if err != nil {
+		logger.Error("invalid probe config", "error", err)
+		os.Exit(2)
+	}
+	srv := newServer(logger, cfg)
+
+	ctx, cancelProbes := context.WithCancel(context.Background())
+	probing := srv.schedule(ctx)
+
+	port := getEnv("PORT", "8088")
+	httpServer := &http.Server{
+		Addr:         ":" + port,
+		Handler:      srv.routes(),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+	srv.ready.Store(true)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		logger.Info("prober starting", "port", port, "probes_file", path, "probes", len(cfg.Probes))
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-stop
+	logger.Info("shutting down")
+	srv.ready.Store(false)
+	cancelProbes()
+	probing.Wait()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("forced shutdown", "error", err)
+	}
+	logger.Info("server stopped")
+}
+
+// routes builds the HTTP router. It is separate from main so tests can drive
+// the full middleware stack.
+func (s *Server) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.RealIP)
+	r.Use(middleware.RequestID)
+	r.Use(s.metricsMiddleware)
+	r.Use(middleware.Recoverer)
+
+	r.Get("/healthz", s.handleHealthz)
+	r.Get("/readyz", s.handleReadyz)
+	r.Handle("/metrics", promhttp.Handler())
+
+	r.Get("/api/probes", s.handleListProbes)
+	r.Get("/api/probes/{name}", s.handleGetProbe)
+	return r
+}
+
+// ---------------------------------------------------------------------------
+// Middleware
+// ---------------------------------------------------------------------------
+
+func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		duration := time.Since(start).Seconds()
+		status := fmt.Sprintf("%d", ww.Status())
+		path := chi.RouteContext(r.Context()).RoutePattern()
+		if path == "" {
+			path = r.URL.Path
+		}
+		httpRequestsTotal.WithLabelValues(r.Method, path, status).Inc()
+		httpRequestDuration.WithLabelValues(r.Method, path).Observe(duration)
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Handlers
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	if !s.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// handleListProbes returns the last result of every probe that has run,
+// sorted by name.
+func (s *Server) handleListProbes(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	out := make([]probeResult, 0, len(s.results))
+	for _, res := range s.results {
+		out = append(out, res)
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Probe < out[j].Probe })
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleGetProbe returns the last result of one probe.
+func (s *Server) handleGetProbe(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	s.mu.Lock()
+	res, ok := s.results[name]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, "no results for probe "+name, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// ---------------------------------------------------------------------------
+// Helpers
+// ---------------------------------------------------------------------------
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, msg string, code int) {
+	writeJSON(w, code, ErrorResponse{Error: msg, Code: code})
+}
+
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
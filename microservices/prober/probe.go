@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Probes
+// ---------------------------------------------------------------------------
+//
+// A run of a probe times its phases and stops at the first failure, which is
+// attributed to the phase it happened in. HTTP requests go over a fresh
+// connection every run, so the dns, connect and tls phases are measured each
+// time rather than hidden by keep-alive.
+
+// phase is the time a run spent in one step of a probe.
+type phase struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+type probeResult struct {
+	Probe       string     `json:"probe"`
+	Type        string     `json:"type"`
+	Success     bool       `json:"success"`
+	Error       string     `json:"error,omitempty"`
+	FailedPhase string     `json:"failed_phase,omitempty"`
+	Phases      []phase    `json:"phases"`
+	Seconds     float64    `json:"duration_seconds"`
+	StatusCode  int        `json:"status_code,omitempty"` // last HTTP response
+	CertExpiry  *time.Time `json:"cert_expiry,omitempty"` // earliest in the chain
+	StartedAt   time.Time  `json:"started_at"`
+}
+
+func (r *probeResult) addPhase(name string, d time.Duration) {
+	r.Phases = append(r.Phases, phase{name, d.Seconds()})
+}
+
+// phaseError is a failure in a named phase of a run.
+type phaseError struct {
+	phase string
+	err   error
+}
+
+func (e *phaseError) Error() string { return e.phase + ": " + e.err.Error() }
+
+func failIn(phase string, err error) *phaseError { return &phaseError{phase, err} }
+
+type prober struct {
+	userAgent string
+	resolver  *net.Resolver // nil is the system resolver
+}
+
+// run executes one probe and returns its result.
+func (pr *prober) run(ctx context.Context, p *probeSpec) probeResult {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	res := probeResult{Probe: p.Name, Type: p.typ, StartedAt: time.Now().UTC(), Phases: []phase{}}
+	start := time.Now()
+	var err *phaseError
+	switch p.typ {
+	case "http":
+		err = pr.runHTTP(ctx, p.HTTP, nil, &res, true)
+	case "flow":
+		err = pr.runFlow(ctx, p.Flow, &res)
+	case "tls":
+		err = pr.runTLS(ctx, p.TLS, &res)
+	case "dns":
+		err = pr.runDNS(ctx, p.DNS, &res)
+	}
+	res.Seconds = time.Since(start).Seconds()
+	res.Success = err == nil
+	if err != nil {
+		res.Error = err.Error()
+		res.FailedPhase = err.phase
+	}
+	return res
+}
+
+// runHTTP makes one request and checks the response. With detailed set the
+// request's phases are recorded; otherwise the caller records it as one. It
+// stores extracted values in vars.
+func (pr *prober) runHTTP(ctx context.Context, h *httpCheck, vars map[string]string, res *probeResult, detailed bool) *phaseError {
+	target, err := render(h.URL, vars)
+	if err != nil {
+		return failIn("request", err)
+	}
+	body, err := render(h.Body, vars)
+	if err != nil {
+		return failIn("request", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, h.Method, target, strings.NewReader(body))
+	if err != nil {
+		return failIn("request", err)
+	}
+	req.Header.Set("User-Agent", pr.userAgent)
+	req.Header.Set("X-Request-Source", "prober")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range h.Headers {
+		if v, err = render(v, vars); err != nil {
+			return failIn("request", err)
+		}
+		req.Header.Set(k, v)
+	}
+
+	// Phase boundaries, from httptrace. A phase that did not happen (no DNS
+	// for an IP address, no TLS for http) is left out.
+	var dnsStart, dnsDone, connStart, connDone, tlsStart, tlsDone, wrote, firstByte time.Time
+	if detailed {
+		req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+			DNSDone:              func(httptrace.DNSDoneInfo) { dnsDone = time.Now() },
+			ConnectStart:         func(string, string) { connStart = time.Now() },
+			ConnectDone:          func(string, string, error) { connDone = time.Now() },
+			TLSHandshakeStart:    func() { tlsStart = time.Now() },
+			TLSHandshakeDone:     func(tls.ConnectionState, error) { tlsDone = time.Now() },
+			WroteRequest:         func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+			GotFirstResponseByte: func() { firstByte = time.Now() },
+		}))
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: h.InsecureSkipVerify},
+		Proxy:             http.ProxyFromEnvironment,
+	}}
+	resp, err := client.Do(req)
+	if detailed {
+		addSpan(res, "dns", dnsStart, dnsDone)
+		addSpan(res, "connect", connStart, connDone)
+		addSpan(res, "tls", tlsStart, tlsDone)
+	}
+	if err != nil {
+		return failIn("connect", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if detailed {
+		addSpan(res, "processing", wrote, firstByte)
+		addSpan(res, "transfer", firstByte, time.Now())
+	}
+	if err != nil {
+		return failIn("transfer", err)
+	}
+	res.StatusCode = resp.StatusCode
+	if resp.TLS != nil {
+		recordCertExpiry(res, resp.TLS)
+	}
+
+	if !statusExpected(h.ExpectStatus, resp.StatusCode) {
+		return failIn("assert", fmt.Errorf("status %d, want %s", resp.StatusCode, expectString(h.ExpectStatus)))
+	}
+	if h.BodyContains != "" && !bytes.Contains(data, []byte(h.BodyContains)) {
+		return failIn("assert", fmt.Errorf("body does not contain %q", h.BodyContains))
+	}
+	if len(h.JSON) == 0 && len(h.Extract) == 0 {
+		return nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return failIn("assert", fmt.Errorf("decoding response: %w", err))
+	}
+	for path, want := range h.JSON {
+		v, err := extractJSONPath(doc, path)
+		if err != nil {
+			return failIn("assert", err)
+		}
+		if want, err = render(want, vars); err != nil {
+			return failIn("assert", err)
+		}
+		if got := jsonString(v); got != want {
+			return failIn("assert", fmt.Errorf("%s = %q, want %q", path, got, want))
+		}
+	}
+	for name, path := range h.Extract {
+		v, err := extractJSONPath(doc, path)
+		if err != nil {
+			return failIn("assert", fmt.Errorf("extract %s: %w", name, err))
+		}
+		vars[name] = jsonString(v)
+	}
+	return nil
+}
+
+// runFlow runs the steps in order, each recorded as a phase named after it.
+func (pr *prober) runFlow(ctx context.Context, steps []*httpCheck, res *probeResult) *phaseError {
+	vars := map[string]string{}
+	for _, step := range steps {
+		start := time.Now()
+		err := pr.runHTTP(ctx, step, vars, res, false)
+		res.addPhase(step.Name, time.Since(start))
+		if err != nil {
+			return failIn(step.Name, err)
+		}
+	}
+	return nil
+}
+
+func (pr *prober) runTLS(ctx context.Context, c *tlsCheck, res *probeResult) *phaseError {
+	start := time.Now()
+	raw, err := (&net.Dialer{Resolver: pr.resolver}).DialContext(ctx, "tcp", c.Address)
+	res.addPhase("connect", time.Since(start))
+	if err != nil {
+		return failIn("connect", err)
+	}
+	defer raw.Close()
+
+	serverName := c.ServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(c.Address)
+	}
+	conn := tls.Client(raw, &tls.Config{ServerName: serverName})
+	start = time.Now()
+	err = conn.HandshakeContext(ctx)
+	res.addPhase("tls", time.Since(start))
+	if err != nil {
+		return failIn("tls", err)
+	}
+	state := conn.ConnectionState()
+	recordCertExpiry(res, &state)
+	if left := time.Until(*res.CertExpiry); left < time.Duration(c.MinDaysLeft)*24*time.Hour {
+		return failIn("assert", fmt.Errorf("certificate expires in %.1f days, want at least %d", left.Hours()/24, c.MinDaysLeft))
+	}
+	return nil
+}
+
+func (pr *prober) runDNS(ctx context.Context, c *dnsCheck, res *probeResult) *phaseError {
+	resolver := pr.resolver
+	if c.Server != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, c.Server)
+			},
+		}
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	start := time.Now()
+	answers, err := lookup(ctx, resolver, c.Type, c.Name)
+	res.addPhase("resolve", time.Since(start))
+	if err != nil {
+		return failIn("resolve", err)
+	}
+	if len(answers) == 0 {
+		return failIn("assert", fmt.Errorf("no %s records for %s", c.Type, c.Name))
+	}
+	for _, want := range c.Expect {
+		if !slices.Contains(answers, want) {
+			return failIn("assert", fmt.Errorf("%s %s answered %v, want %s among them", c.Name, c.Type, answers, want))
+		}
+	}
+	return nil
+}
+
+// lookup returns the answers to a query of type for name, as strings.
+func lookup(ctx context.Context, r *net.Resolver, typ, name string) ([]string, error) {
+	var out []string
+	switch typ {
+	case "A", "AAAA":
+		addrs, err := r.LookupIPAddr(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			if (a.IP.To4() != nil) == (typ == "A") {
+				out = append(out, a.IP.String())
+			}
+		}
+	case "CNAME":
+		cname, err := r.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, cname)
+	case "MX":
+		mxs, err := r.LookupMX(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxs {
+			out = append(out, mx.Host)
+		}
+	case "TXT":
+		return r.LookupTXT(ctx, name)
+	}
+	return out, nil
+}
+
+// ---------------------------------------------------------------------------
+// Helpers
+// ---------------------------------------------------------------------------
+
+func addSpan(res *probeResult, name string, start, end time.Time) {
+	if !start.IsZero() && !end.IsZero() {
+		res.addPhase(name, end.Sub(start))
+	}
+}
+
+func recordCertExpiry(res *probeResult, state *tls.ConnectionState) {
+	for _, cert := range state.PeerCertificates {
+		if res.CertExpiry == nil || cert.NotAfter.Before(*res.CertExpiry) {
+			expiry := cert.NotAfter
+			res.CertExpiry = &expiry
+		}
+	}
+}
+
+// render expands flow variables in s. Referencing a variable no earlier step
+// extracted is an error.
+func render(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	t, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func statusExpected(expect []int, code int) bool {
+	if len(expect) == 0 {
+		return code >= 200 && code < 300
+	}
+	return slices.Contains(expect, code)
+}
+
+func expectString(expect []int) string {
+	if len(expect) == 0 {
+		return "2xx"
+	}
+	s := make([]string, len(expect))
+	for i, c := range expect {
+		s[i] = strconv.Itoa(c)
+	}
+	return strings.Join(s, " or ")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func phaseNames(res probeResult) []string {
+	var names []string
+	for _, ph := range res.Phases {
+		names = append(names, ph.Name)
+	}
+	return names
+}
+
+func httpProbe(name string, h *httpCheck) *probeSpec {
+	p := &probeSpec{Name: name, Interval: time.Minute, Timeout: 5 * time.Second, HTTP: h}
+	if err := p.validate(); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestHTTPProbe(t *testing.T) {
+	var source string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source = r.Header.Get("X-Request-Source")
+		switch r.URL.Path {
+		case "/api/users/usr-100":
+			w.Write([]byte(`{"id":"usr-100","tier":"gold"}`))
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer backend.Close()
+	pr := &prober{userAgent: "test"}
+
+	res := pr.run(context.Background(), httpProbe("user", &httpCheck{
+		URL:  backend.URL + "/api/users/usr-100",
+		JSON: map[string]string{"$.id": "usr-100", "$.tier": "gold"},
+	}))
+	if !res.Success || res.StatusCode != 200 {
+		t.Fatalf("got %+v, want success", res)
+	}
+	if source != "prober" {
+		t.Errorf("X-Request-Source: got %q, want prober", source)
+	}
+	if got := strings.Join(phaseNames(res), ","); got != "connect,processing,transfer" {
+		t.Errorf("phases: got %s", got)
+	}
+
+	res = pr.run(context.Background(), httpProbe("user", &httpCheck{
+		URL:  backend.URL + "/api/users/usr-100",
+		JSON: map[string]string{"$.tier": "silver"},
+	}))
+	if res.Success || res.FailedPhase != "assert" || !strings.Contains(res.Error, `$.tier = "gold", want "silver"`) {
+		t.Errorf("json mismatch: got %+v", res)
+	}
+
+	res = pr.run(context.Background(), httpProbe("broken", &httpCheck{URL: backend.URL + "/nope"}))
+	if res.Success || res.StatusCode != 500 || res.Error != "assert: status 500, want 2xx" {
+		t.Errorf("bad status: got %+v", res)
+	}
+
+	res = pr.run(context.Background(), httpProbe("broken", &httpCheck{URL: backend.URL + "/nope", ExpectStatus: []int{500}, BodyContains: "boom"}))
+	if !res.Success {
+		t.Errorf("expected status: got %+v", res)
+	}
+
+	backend.Close()
+	res = pr.run(context.Background(), httpProbe("down", &httpCheck{URL: backend.URL}))
+	if res.Success || res.FailedPhase != "connect" {
+		t.Errorf("down: got %+v", res)
+	}
+}
+
+func TestFlowProbe(t *testing.T) {
+	orders := map[string]bool{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/orders":
+			id := fmt.Sprintf("ord-%d", len(orders)+1)
+			orders[id] = true
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]string{"id": id})
+		case r.Method == "GET" && orders[strings.TrimPrefix(r.URL.Path, "/api/orders/")]:
+			json.NewEncoder(w).Encode(map[string]string{"id": strings.TrimPrefix(r.URL.Path, "/api/orders/")})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer backend.Close()
+
+	p := &probeSpec{Name: "checkout", Interval: time.Minute, Timeout: 5 * time.Second, Flow: []*httpCheck{
+		{Name: "create", Method: "POST", URL: backend.URL + "/api/orders", Body: `{"user_id":"usr-100"}`,
+			ExpectStatus: []int{201}, Extract: map[string]string{"order_id": "$.id"}},
+		{Name: "read", URL: backend.URL + "/api/orders/{{.order_id}}", JSON: map[string]string{"$.id": "{{.order_id}}"}},
+	}}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	pr := &prober{userAgent: "test"}
+	res := pr.run(context.Background(), p)
+	if !res.Success {
+		t.Fatalf("got %+v, want success", res)
+	}
+	if got := strings.Join(phaseNames(res), ","); got != "create,read" {
+		t.Errorf("phases: got %s, want create,read", got)
+	}
+
+	p.Flow[1].URL = backend.URL + "/api/orders/{{.missing}}"
+	res = pr.run(context.Background(), p)
+	if res.Success || res.FailedPhase != "read" || !strings.Contains(res.Error, "missing") {
+		t.Errorf("unknown variable: got %+v", res)
+	}
+}
+
+func TestTLSProbe(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer backend.Close()
+	addr := strings.TrimPrefix(backend.URL, "https://")
+	pr := &prober{userAgent: "test"}
+
+	// The test certificate is not from a trusted CA, so verification fails
+	// in the tls phase.
+	p := &probeSpec{Name: "cert", Interval: time.Minute, Timeout: 5 * time.Second, TLS: &tlsCheck{Address: addr}}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := pr.run(context.Background(), p)
+	if res.Success || res.FailedPhase != "tls" {
+		t.Errorf("untrusted certificate: got %+v", res)
+	}
+
+	// Over HTTPS with verification off, the expiry is still recorded.
+	res = pr.run(context.Background(), httpProbe("https", &httpCheck{URL: backend.URL, InsecureSkipVerify: true}))
+	if !res.Success || res.CertExpiry == nil || !res.CertExpiry.After(time.Now()) {
+		t.Fatalf("got %+v, want success with a future expiry", res)
+	}
+	if got := strings.Join(phaseNames(res), ","); got != "connect,tls,processing,transfer" {
+		t.Errorf("phases: got %s", got)
+	}
+}
+
+func TestDNSProbe(t *testing.T) {
+	p := &probeSpec{Name: "localhost", Interval: time.Minute, Timeout: 5 * time.Second,
+		DNS: &dnsCheck{Name: "localhost", Expect: []string{"127.0.0.1"}}}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := (&prober{userAgent: "test"}).run(context.Background(), p)
+	if !res.Success {
+		t.Errorf("got %+v, want success", res)
+	}
+
+	p.DNS.Expect = []string{"10.9.9.9"}
+	res = (&prober{userAgent: "test"}).run(context.Background(), p)
+	if res.Success || res.FailedPhase != "assert" {
+		t.Errorf("unexpected answer: got %+v", res)
+	}
+}
+
+func TestProbesEndpoint(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer backend.Close()
+	cfg := &config{Probes: []*probeSpec{
+		httpProbe("b", &httpCheck{URL: backend.URL}),
+		httpProbe("a", &httpCheck{URL: backend.URL, ExpectStatus: []int{204}}),
+	}}
+	srv := newServer(slog.New(slog.NewTextHandler(io.Discard, nil)), cfg)
+	for _, p := range cfg.Probes {
+		srv.runProbe(context.Background(), p)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/api/probes", nil))
+	var results []probeResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Probe != "a" || results[0].Success || !results[1].Success {
+		t.Errorf("got %+v, want a failing then b succeeding", results)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/api/probes/nope", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown probe: got %d, want 404", rr.Code)
+	}
+}
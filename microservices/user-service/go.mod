@@ -5,6 +5,7 @@ go 1.22
 require (
 	github.com/go-chi/chi/v5 v5.1.0
 	github.com/prometheus/client_golang v1.20.0
+	github.com/sre-observability-platform/internal/platform v0.0.0
 )
 
 require (
@@ -18,3 +19,5 @@ require (
 	golang.org/x/sys v0.22.0 // indirect
 	google.golang.org/protobuf v1.34.2 // indirect
 )
+
+replace github.com/sre-observability-platform/internal/platform => ../internal/platform
@@ -3,12 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log/slog"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -18,6 +20,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sre-observability-platform/internal/platform"
 )
 
 // ---------------------------------------------------------------------------
@@ -82,6 +85,14 @@ var (
 		[]string{"operation"},
 	)
 
+	userValidationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "user_validations_total",
+			Help: "Total user validation outcomes.",
+		},
+		[]string{"result"},
+	)
+
 	userDBQueryDuration = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Name:    "user_db_query_duration_seconds",
@@ -108,84 +119,186 @@ type ErrorResponse struct {
 	Code  int    `json:"code"`
 }
 
+// ValidationResponse is returned by the validate endpoint. Reason is set only
+// when the user is rejected so callers can tell the rejection causes apart.
+type ValidationResponse struct {
+	Valid  bool   `json:"valid"`
+	UserID string `json:"user_id"`
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
 // ---------------------------------------------------------------------------
-// Simple in-memory cache for simulation
+// Simple in-memory user store for simulation
 // ---------------------------------------------------------------------------
 
-type userCache struct {
+// userStore holds users by ID. The service keeps two: the database, which
+// has every user, and the cache in front of it, which holds at most limit.
+type userStore struct {
 	mu    sync.RWMutex
 	store map[string]*User
+	limit int // 0 for no limit
 }
 
-func newUserCache() *userCache {
-	return &userCache{store: make(map[string]*User)}
+func newUserStore(limit int) *userStore {
+	return &userStore{store: make(map[string]*User), limit: limit}
 }
 
-func (c *userCache) Get(id string) (*User, bool) {
+func (c *userStore) Get(id string) (*User, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	u, ok := c.store[id]
 	return u, ok
 }
 
-func (c *userCache) Set(id string, u *User) {
+// Set stores u, evicting a random user first if the store is full.
+func (c *userStore) Set(id string, u *User) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if _, ok := c.store[id]; !ok && c.limit > 0 && len(c.store) >= c.limit {
+		for victim := range c.store {
+			delete(c.store, victim)
+			break
+		}
+	}
 	c.store[id] = u
 }
 
+func (c *userStore) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.store)
+}
+
+// List returns a snapshot of every stored user matching keep.
+func (c *userStore) List(keep func(User) bool) []User {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]User, 0, len(c.store))
+	for _, u := range c.store {
+		if keep(*u) {
+			out = append(out, *u)
+		}
+	}
+	return out
+}
+
 // ---------------------------------------------------------------------------
 // Server
 // ---------------------------------------------------------------------------
 
 type Server struct {
 	logger       *slog.Logger
-	cache        *userCache
+	users        *userStore // the database
+	cache        *userStore // the users read recently
 	ready        atomic.Bool
 	sessionCount atomic.Int64
+	capture      *platform.CaptureWriter          // nil unless CAPTURE_FILE is set
+	rateLimiter  *platform.RateLimiter            // nil unless RATE_LIMITS is set
+	rng          *rand.Rand                       // all randomness, see platform.NewRand
+	clock        *virtualClock                    // time of day for the session curve; nil is the wall clock
+	latency      map[string]platform.LatencyModel // LATENCY_MODELS overrides, by site
+	health       *platform.HealthRegistry
+	drainer      *platform.Drainer
+	limiter      *platform.ConcurrencyLimiter
+	contention   *platform.Contention // nil unless SIMULATED_CAPACITY is set
+}
+
+const (
+	seededUsers = 900 // usr-100 to usr-999, in the database
+	warmUsers   = 50  // the first of them, loaded into the cache at startup
+	cacheSize   = 200 // users the cache holds before it evicts
+)
+
+// latencySites names the simulated delays that LATENCY_MODELS can replace.
+// The db sites are the user store queries behind the routes.
+var latencySites = []string{
+	"GET /api/users",
+	"POST /api/users",
+	"GET /api/users/validate",
+	"POST /api/users/auth",
+	"db list",
+	"db get",
+	"db insert",
 }
 
-func newServer(logger *slog.Logger) *Server {
+func newServer(logger *slog.Logger, rng *rand.Rand, clock *virtualClock) *Server {
 	s := &Server{
 		logger: logger,
-		cache:  newUserCache(),
+		users:  newUserStore(0),
+		cache:  newUserStore(cacheSize),
+		rng:    rng,
+		clock:  clock,
+		health: platform.NewHealthRegistry(time.Second),
 	}
-
-	// Pre-populate cache with some users.
-	for i := 100; i < 150; i++ {
+	s.drainer = platform.NewDrainer(logger, &s.ready)
+	// Checkout validates the user and sign-in gates every session, so both keep
+	// the reserve when the service sheds load.
+	s.limiter = platform.NewConcurrencyLimiter("GET /api/users/validate", "POST /api/users/auth")
+
+	// Seed the database and warm the cache with the first few users. A
+	// handful are inactive or locked so that validation rejections show up in
+	// normal traffic.
+	for i := 100; i < 100+seededUsers; i++ {
 		id := fmt.Sprintf("usr-%03d", i)
-		s.cache.Set(id, &User{
+		status := "active"
+		switch {
+		case i%25 == 23:
+			status = "locked"
+		case i%10 == 7:
+			status = "inactive"
+		}
+		user := &User{
 			ID:        id,
 			Username:  fmt.Sprintf("user_%d", i),
 			Email:     fmt.Sprintf("user%d@example.com", i),
-			Status:    "active",
-			CreatedAt: time.Now().Add(-time.Duration(rand.Intn(365*24)) * time.Hour),
-		})
+			Status:    status,
+			CreatedAt: time.Now().Add(-time.Duration(s.rng.Intn(365*24)) * time.Hour),
+		}
+		s.users.Set(id, user)
+		if i < 100+warmUsers {
+			s.cache.Set(id, user)
+		}
 	}
 
+	// The warm users are the most looked up, so an instance without them
+	// would send most of its traffic to the database.
+	s.health.Register("user_cache", platform.CheckLiveness, platform.LockCheck(s.cache.mu.RLocker()))
+	s.health.Register("cache_warmed", platform.CheckReadiness, func(context.Context) error {
+		if n := s.cache.Len(); n < warmUsers {
+			return fmt.Errorf("cache holds %d users, want at least %d", n, warmUsers)
+		}
+		return nil
+	})
+
 	// Simulate session count fluctuations.
-	go s.simulateSessionGauge()
+	s.drainer.GoBackground("session_gauge", s.simulateSessionGauge)
 
 	return s
 }
 
-func (s *Server) simulateSessionGauge() {
+func (s *Server) simulateSessionGauge(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
 	baseSessions := int64(150)
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		// Simulate diurnal pattern in sessions.
-		hour := time.Now().Hour()
+		hour := s.clock.Now().Hour()
 		var multiplier float64
 		if hour >= 9 && hour <= 17 {
-			multiplier = 1.0 + rand.Float64()*0.5 // Business hours: higher
+			multiplier = 1.0 + s.rng.Float64()*0.5 // Business hours: higher
 		} else if hour >= 18 && hour <= 22 {
-			multiplier = 0.7 + rand.Float64()*0.3 // Evening: moderate
+			multiplier = 0.7 + s.rng.Float64()*0.3 // Evening: moderate
 		} else {
-			multiplier = 0.2 + rand.Float64()*0.2 // Night: low
+			multiplier = 0.2 + s.rng.Float64()*0.2 // Night: low
 		}
-		sessions := int64(float64(baseSessions)*multiplier) + int64(rand.NormFloat64()*20)
+		sessions := int64(float64(baseSessions)*multiplier) + int64(s.rng.NormFloat64()*20)
 		if sessions < 10 {
 			sessions = 10
 		}
@@ -205,43 +318,79 @@ func main() {
 		httpRequestsTotal, httpRequestDuration,
 		userRequestsTotal, userAuthAttemptsTotal,
 		activeSessions, cacheHitsTotal, cacheLatency,
-		userDBQueryDuration,
+		userValidationsTotal, userDBQueryDuration,
 	)
-
-	srv := newServer(logger)
-
-	r := chi.NewRouter()
-	r.Use(middleware.RealIP)
-	r.Use(middleware.RequestID)
-	r.Use(srv.metricsMiddleware)
-	r.Use(middleware.Recoverer)
-
-	r.Get("/healthz", srv.handleHealthz)
-	r.Get("/readyz", srv.handleReadyz)
-	r.Handle("/metrics", promhttp.Handler())
-
-	r.Route("/api/users", func(r chi.Router) {
-		r.Get("/", srv.handleListUsers)
-		r.Post("/", srv.handleCreateUser)
-		r.Get("/validate", srv.handleValidateUser)
-		r.Get("/{userID}", srv.handleGetUser)
-		r.Post("/auth", srv.handleAuthenticate)
-	})
+	prometheus.MustRegister(platform.Collectors()...)
+
+	seedFlag := flag.String("seed", "", "random seed (overrides RANDOM_SEED; default: from the clock)")
+	flag.Parse()
+	seed, seedSource, err := platform.RandomSeed(*seedFlag)
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("random seed", "seed", seed, "source", seedSource)
+	rng := platform.NewRand(seed)
+	clock, err := clockFromEnv()
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("virtual clock",
+		"start", clock.Now().Format(time.RFC3339), "compression", clock.compression)
+
+	srv := newServer(logger, rng, clock)
+	capture, err := platform.CaptureFromEnv(logger, "user-service", rng)
+	if err != nil {
+		logger.Error("invalid traffic capture configuration", "error", err)
+		os.Exit(1)
+	}
+	srv.capture = capture
+	if srv.latency, err = platform.LatencyFromEnv(latencySites); err != nil {
+		logger.Error("invalid latency model configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.rateLimiter, err = platform.RateLimiterFromEnv(); err != nil {
+		logger.Error("invalid rate limit configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.health.Timeout, err = getEnvDuration("HEALTH_CHECK_TIMEOUT", srv.health.Timeout); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.drainer.PreStopDelay, err = getEnvDuration("PRE_STOP_DELAY", srv.drainer.PreStopDelay); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if srv.drainer.DrainTimeout, err = getEnvDuration("DRAIN_TIMEOUT", srv.drainer.DrainTimeout); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if v, ok := os.LookupEnv("LOAD_SHEDDING"); ok {
+		if srv.limiter.Shed, err = strconv.ParseBool(v); err != nil {
+			logger.Error("invalid configuration", "error", fmt.Errorf("LOAD_SHEDDING: %w", err))
+			os.Exit(1)
+		}
+	}
+	if v, ok := os.LookupEnv("SIMULATED_CAPACITY"); ok {
+		capacity, err := strconv.Atoi(v)
+		if err != nil {
+			logger.Error("invalid configuration", "error", fmt.Errorf("SIMULATED_CAPACITY: %w", err))
+			os.Exit(1)
+		}
+		srv.contention = platform.NewContention(capacity)
+	}
 
 	port := getEnv("PORT", "8083")
 	httpServer := &http.Server{
 		Addr:         ":" + port,
-		Handler:      r,
+		Handler:      srv.routes(),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	go func() {
-		time.Sleep(1 * time.Second)
-		srv.ready.Store(true)
-		logger.Info("service is ready")
-	}()
+	srv.ready.Store(true)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
@@ -256,16 +405,52 @@ func main() {
 
 	<-stop
 	logger.Info("shutting down")
-	srv.ready.Store(false)
+	srv.drainer.PreStop()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), srv.drainer.DrainTimeout)
 	defer cancel()
-	if err := httpServer.Shutdown(ctx); err != nil {
-		logger.Error("forced shutdown", "error", err)
+	srv.drainer.Drain(ctx, httpServer)
+	if srv.capture != nil {
+		srv.capture.Close()
 	}
 	logger.Info("server stopped")
 }
 
+// routes builds the HTTP router. It is separate from main so tests can drive
+// the full middleware stack.
+func (s *Server) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(platform.RememberPeer) // before RealIP, for the rate limiter
+	r.Use(middleware.RealIP)
+	r.Use(middleware.RequestID)
+	r.Use(s.drainer.Middleware)
+	r.Use(s.metricsMiddleware)
+	if s.capture != nil {
+		r.Use(s.capture.Middleware)
+	}
+	if s.rateLimiter != nil {
+		r.Use(s.rateLimiter.Middleware(r))
+	}
+	r.Use(s.limiter.Middleware(r))
+	if s.contention != nil {
+		r.Use(s.contention.Middleware) // counts only the work the limiter admits
+	}
+	r.Use(middleware.Recoverer)
+
+	r.Get("/healthz", s.handleHealthz)
+	r.Get("/readyz", s.handleReadyz)
+	r.Handle("/metrics", promhttp.Handler())
+
+	r.Route("/api/users", func(r chi.Router) {
+		r.Get("/", s.handleListUsers)
+		r.Post("/", s.handleCreateUser)
+		r.Get("/validate", s.handleValidateUser)
+		r.Get("/{userID}", s.handleGetUser)
+		r.Post("/auth", s.handleAuthenticate)
+	})
+	return r
+}
+
 // ---------------------------------------------------------------------------
 // Middleware
 // ---------------------------------------------------------------------------
@@ -295,24 +480,33 @@ func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
 // Handlers
 // ---------------------------------------------------------------------------
 
-func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.health.Serve(w, r, platform.CheckLiveness, "healthy", "unhealthy")
 }
 
-func (s *Server) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+// handleReadyz is not ready before the server starts and once it begins
+// shutting down, whatever the checks say.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
 	if !s.ready.Load() {
 		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	s.health.Serve(w, r, platform.CheckReadiness, "ready", "not ready")
 }
 
 func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	q, err := parsePageQuery(r.URL.Query(), []string{"created_at", "username", "id"}, "-created_at")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := r.URL.Query().Get("status")
+
 	userRequestsTotal.WithLabelValues("list").Inc()
-	time.Sleep(simulateLatency(30, 10, 0.005))
+	time.Sleep(s.simulateLatency("GET /api/users", 30, 10, 0.005))
 
 	// Very low error rate (~0.1%).
-	if rand.Float64() < 0.001 {
+	if s.rng.Float64() < 0.001 {
 		s.logger.Warn("simulated error listing users")
 		writeError(w, "internal server error", http.StatusInternalServerError)
 		return
@@ -320,15 +514,26 @@ func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
 
 	// Simulate DB query.
 	dbStart := time.Now()
-	time.Sleep(simulateLatency(5, 2, 0.01))
+	time.Sleep(s.simulateLatency("db list", 5, 2, 0.01))
 	userDBQueryDuration.Observe(time.Since(dbStart).Seconds())
 
-	users := []User{
-		{ID: "usr-100", Username: "alice", Email: "alice@example.com", Status: "active", CreatedAt: time.Now().Add(-720 * time.Hour)},
-		{ID: "usr-101", Username: "bob", Email: "bob@example.com", Status: "active", CreatedAt: time.Now().Add(-360 * time.Hour)},
-		{ID: "usr-102", Username: "charlie", Email: "charlie@example.com", Status: "inactive", CreatedAt: time.Now().Add(-100 * time.Hour)},
+	users := s.users.List(func(u User) bool {
+		return (status == "" || u.Status == status) && q.inCreatedRange(u.CreatedAt)
+	})
+	page, next := paginate(users, q, userSortKey, func(u User) string { return u.ID })
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, page)
+}
+
+func userSortKey(u User, field string) string {
+	switch field {
+	case "username":
+		return u.Username
+	case "id":
+		return u.ID
+	default:
+		return sortKeyTime(u.CreatedAt)
 	}
-	writeJSON(w, http.StatusOK, users)
 }
 
 func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
@@ -347,50 +552,59 @@ func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
 	cacheLatency.WithLabelValues("get").Observe(time.Since(cacheStart).Seconds())
 	cacheHitsTotal.WithLabelValues("miss").Inc()
 
-	// Simulate DB query on cache miss.
-	dbStart := time.Now()
-	time.Sleep(simulateLatency(15, 5, 0.01))
-	userDBQueryDuration.Observe(time.Since(dbStart).Seconds())
-
-	if rand.Float64() < 0.001 {
+	if s.rng.Float64() < 0.001 {
 		writeError(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	user := User{
-		ID: userID, Username: "user_" + userID, Email: userID + "@example.com",
-		Status: "active", CreatedAt: time.Now().Add(-200 * time.Hour),
+	user, ok := s.loadUser(userID)
+	if !ok {
+		writeError(w, "user not found", http.StatusNotFound)
+		return
 	}
+	writeJSON(w, http.StatusOK, user)
+}
 
-	// Populate cache.
+// loadUser reads a user the cache missed from the database and caches it.
+// Users the database does not have are not cached, so a lookup of an unknown
+// ID cannot make it known.
+func (s *Server) loadUser(userID string) (*User, bool) {
+	dbStart := time.Now()
+	time.Sleep(s.simulateLatency("db get", 15, 5, 0.01))
+	userDBQueryDuration.Observe(time.Since(dbStart).Seconds())
+
+	user, ok := s.users.Get(userID)
+	if !ok {
+		return nil, false
+	}
 	cacheSetStart := time.Now()
-	s.cache.Set(userID, &user)
+	s.cache.Set(userID, user)
 	cacheLatency.WithLabelValues("set").Observe(time.Since(cacheSetStart).Seconds())
-
-	writeJSON(w, http.StatusOK, user)
+	return user, true
 }
 
 func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	userRequestsTotal.WithLabelValues("create").Inc()
-	time.Sleep(simulateLatency(50, 20, 0.01))
+	time.Sleep(s.simulateLatency("POST /api/users", 50, 20, 0.01))
 
-	if rand.Float64() < 0.001 {
+	if s.rng.Float64() < 0.001 {
 		writeError(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	dbStart := time.Now()
-	time.Sleep(simulateLatency(20, 8, 0.01))
+	time.Sleep(s.simulateLatency("db insert", 20, 8, 0.01))
 	userDBQueryDuration.Observe(time.Since(dbStart).Seconds())
 
 	user := User{
-		ID:        fmt.Sprintf("usr-%03d", rand.Intn(9000)+1000),
-		Username:  fmt.Sprintf("newuser_%d", rand.Intn(10000)),
-		Email:     fmt.Sprintf("newuser%d@example.com", rand.Intn(10000)),
+		ID:        fmt.Sprintf("usr-%03d", s.rng.Intn(9000)+1000),
+		Username:  fmt.Sprintf("newuser_%d", s.rng.Intn(10000)),
+		Email:     fmt.Sprintf("newuser%d@example.com", s.rng.Intn(10000)),
 		Status:    "active",
 		CreatedAt: time.Now(),
 	}
 
+	s.users.Set(user.ID, &user)
 	s.cache.Set(user.ID, &user)
 	s.logger.Info("user created", "id", user.ID, "username", user.Username)
 	writeJSON(w, http.StatusCreated, user)
@@ -398,26 +612,60 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleValidateUser(w http.ResponseWriter, r *http.Request) {
 	userRequestsTotal.WithLabelValues("validate").Inc()
-	time.Sleep(simulateLatency(10, 5, 0.005))
+	time.Sleep(s.simulateLatency("GET /api/users/validate", 10, 5, 0.005))
 
 	// Very reliable endpoint (~0.1% error rate).
-	if rand.Float64() < 0.001 {
+	if s.rng.Float64() < 0.001 {
 		writeError(w, "validation service error", http.StatusInternalServerError)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"valid":   true,
-		"user_id": fmt.Sprintf("usr-%03d", rand.Intn(500)+100),
-	})
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		userValidationsTotal.WithLabelValues("bad_request").Inc()
+		writeError(w, "user_id query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.cache.Get(userID)
+	if !ok {
+		user, ok = s.loadUser(userID)
+	}
+	if !ok {
+		userValidationsTotal.WithLabelValues("not_found").Inc()
+		writeJSON(w, http.StatusNotFound, ValidationResponse{
+			Valid: false, UserID: userID, Reason: "user_not_found",
+		})
+		return
+	}
+
+	switch user.Status {
+	case "active":
+		userValidationsTotal.WithLabelValues("valid").Inc()
+		writeJSON(w, http.StatusOK, ValidationResponse{
+			Valid: true, UserID: userID, Status: user.Status,
+		})
+	case "locked":
+		userValidationsTotal.WithLabelValues("locked").Inc()
+		s.logger.Info("validation rejected: user locked", "userID", userID)
+		writeJSON(w, http.StatusLocked, ValidationResponse{
+			Valid: false, UserID: userID, Status: user.Status, Reason: "user_locked",
+		})
+	default:
+		userValidationsTotal.WithLabelValues("inactive").Inc()
+		s.logger.Info("validation rejected: user inactive", "userID", userID, "status", user.Status)
+		writeJSON(w, http.StatusForbidden, ValidationResponse{
+			Valid: false, UserID: userID, Status: user.Status, Reason: "user_inactive",
+		})
+	}
 }
 
 func (s *Server) handleAuthenticate(w http.ResponseWriter, r *http.Request) {
 	userRequestsTotal.WithLabelValues("authenticate").Inc()
-	time.Sleep(simulateLatency(80, 30, 0.02))
+	time.Sleep(s.simulateLatency("POST /api/users/auth", 80, 30, 0.02))
 
 	// Simulate auth outcomes.
-	roll := rand.Float64()
+	roll := s.rng.Float64()
 	switch {
 	case roll < 0.85:
 		// Successful auth.
@@ -449,15 +697,23 @@ func (s *Server) handleAuthenticate(w http.ResponseWriter, r *http.Request) {
 // Helpers
 // ---------------------------------------------------------------------------
 
-func simulateLatency(baseMsec, jitterMsec, slowProb float64) time.Duration {
-	delay := baseMsec + jitterMsec*rand.NormFloat64()
+// simulateLatency returns the delay for site, from its LATENCY_MODELS model
+// if it has one and otherwise from a normal distribution with mean baseMsec,
+// standard deviation jitterMsec and a slow tail with probability slowProb.
+// Past SIMULATED_CAPACITY requests in flight it grows with load, see
+// platform.Contention.
+func (s *Server) simulateLatency(site string, baseMsec, jitterMsec, slowProb float64) time.Duration {
+	if m, ok := s.latency[site]; ok {
+		return s.contention.Stretch(m.Sample(s.rng))
+	}
+	delay := baseMsec + jitterMsec*s.rng.NormFloat64()
 	if delay < 0.5 {
 		delay = 0.5
 	}
-	if rand.Float64() < slowProb {
-		delay += baseMsec * (2 + rand.Float64()*5)
+	if s.rng.Float64() < slowProb {
+		delay += baseMsec * (2 + s.rng.Float64()*5)
 	}
-	return time.Duration(delay) * time.Millisecond
+	return s.contention.Stretch(time.Duration(delay) * time.Millisecond)
 }
 
 func writeJSON(w http.ResponseWriter, code int, v interface{}) {
@@ -476,3 +732,15 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
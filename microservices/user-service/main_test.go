@@ -1,58 +1,236 @@
 package main
 
 import (
+	"encoding/json"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/sre-observability-platform/internal/platform"
 )
 
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	return newServer(slog.New(slog.NewTextHandler(io.Discard, nil)), platform.NewRand(1), nil)
+}
+
 func TestHealthzEndpoint(t *testing.T) {
 	req, err := http.NewRequest("GET", "/healthz", nil)
-	if err \!= nil {
+	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(healthzHandler)
+	handler := http.HandlerFunc(newTestServer(t).handleHealthz)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status \!= http.StatusOK {
+	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
 
 func TestReadyzEndpoint(t *testing.T) {
 	req, err := http.NewRequest("GET", "/readyz", nil)
-	if err \!= nil {
+	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(readyzHandler)
+	srv := newTestServer(t)
+	srv.ready.Store(true)
+	handler := http.HandlerFunc(srv.handleReadyz)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status \!= http.StatusOK {
+	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
 
+func TestReadyzColdCache(t *testing.T) {
+	srv := newTestServer(t)
+	srv.ready.Store(true)
+	srv.cache.mu.Lock()
+	srv.cache.store = make(map[string]*User)
+	srv.cache.mu.Unlock()
+
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	var report platform.HealthReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusServiceUnavailable || report.Status != "not ready" || len(report.Checks) != 2 {
+		t.Fatalf("got %v %+v, want 503 not ready with two checks", rr.Code, report)
+	}
+	if c := report.Checks[1]; c.Name != "cache_warmed" || c.Status != "fail" || c.Error != "cache holds 0 users, want at least 50" {
+		t.Errorf("got %+v, want cache_warmed failing", c)
+	}
+}
+
 func TestMetricsEndpoint(t *testing.T) {
 	req, err := http.NewRequest("GET", "/metrics", nil)
-	if err \!= nil {
+	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	http.DefaultServeMux.ServeHTTP(rr, req)
-	if status := rr.Code; status \!= http.StatusOK {
+	newTestServer(t).routes().ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("metrics endpoint returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
 
 func TestUserProfileEndpoint(t *testing.T) {
-	req, err := http.NewRequest("GET", "/api/v1/users/profile", nil)
-	if err \!= nil {
+	req, err := http.NewRequest("GET", "/api/users/usr-100", nil)
+	if err != nil {
 		t.Fatal(err)
 	}
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getUserProfileHandler)
-	handler.ServeHTTP(rr, req)
+	newTestServer(t).routes().ServeHTTP(rr, req)
 	if status := rr.Code; status == http.StatusInternalServerError {
 		t.Errorf("handler returned internal server error: got %v", status)
 	}
 }
+
+func TestValidateUserEndpoint(t *testing.T) {
+	srv := newTestServer(t)
+	srv.cache.Set("usr-900", &User{ID: "usr-900", Status: "active"})
+	srv.cache.Set("usr-901", &User{ID: "usr-901", Status: "inactive"})
+	srv.cache.Set("usr-902", &User{ID: "usr-902", Status: "locked"})
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantReason string
+	}{
+		{"?user_id=usr-900", http.StatusOK, ""},
+		{"?user_id=usr-901", http.StatusForbidden, "user_inactive"},
+		{"?user_id=usr-902", http.StatusLocked, "user_locked"},
+		{"?user_id=usr-5000", http.StatusNotFound, "user_not_found"},
+		{"", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/users/validate"+tt.query, nil)
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, req)
+		if rr.Code != tt.wantStatus {
+			t.Errorf("validate%s: got status %v want %v", tt.query, rr.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantReason == "" {
+			continue
+		}
+		var body ValidationResponse
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatalf("validate%s: decoding body: %v", tt.query, err)
+		}
+		if body.Valid || body.Reason != tt.wantReason {
+			t.Errorf("validate%s: got valid=%v reason=%q want reason %q", tt.query, body.Valid, body.Reason, tt.wantReason)
+		}
+	}
+}
+
+func TestGetUserReadsThroughCache(t *testing.T) {
+	srv := newTestServer(t)
+	srv.latency = map[string]platform.LatencyModel{"db get": platform.NormalLatency{}}
+	if _, ok := srv.cache.Get("usr-500"); ok {
+		t.Fatal("usr-500 is cached before any lookup")
+	}
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 10; i++ {
+		rr = httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/api/users/usr-500", nil))
+		if rr.Code != http.StatusInternalServerError {
+			break
+		}
+	}
+	if rr.Code != http.StatusOK {
+		t.Fatalf("get seeded user: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if _, ok := srv.cache.Get("usr-500"); !ok {
+		t.Error("usr-500 not cached after a lookup")
+	}
+}
+
+func TestUserStoreEvicts(t *testing.T) {
+	c := newUserStore(2)
+	for _, id := range []string{"usr-1", "usr-2", "usr-2", "usr-3"} {
+		c.Set(id, &User{ID: id})
+	}
+	if n := c.Len(); n != 2 {
+		t.Errorf("full store holds %d users, want 2", n)
+	}
+	if _, ok := c.Get("usr-3"); !ok {
+		t.Error("newest user was evicted")
+	}
+}
+
+func TestGetUnknownUserDoesNotValidate(t *testing.T) {
+	srv := newTestServer(t)
+	srv.latency = map[string]platform.LatencyModel{"db get": platform.NormalLatency{}, "GET /api/users/validate": platform.NormalLatency{}}
+
+	// The 0.1% simulated store error answers 500 before the lookup; retry past it.
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 10; i++ {
+		rr = httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/api/users/usr-5000", nil))
+		if rr.Code != http.StatusInternalServerError {
+			break
+		}
+	}
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("get unknown user: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if _, ok := srv.cache.Get("usr-5000"); ok {
+		t.Fatal("unknown user was cached")
+	}
+
+	for i := 0; i < 10; i++ {
+		rr = httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/api/users/validate?user_id=usr-5000", nil))
+		if rr.Code != http.StatusInternalServerError {
+			break
+		}
+	}
+	var body ValidationResponse
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusNotFound || body.Valid || body.Reason != "user_not_found" {
+		t.Errorf("validate after get: got %v %+v, want 404 user_not_found", rr.Code, body)
+	}
+}
+
+func TestListUsersFiltersAndPaginates(t *testing.T) {
+	srv := newTestServer(t)
+
+	req := httptest.NewRequest("GET", "/api/users?status=active&sort=id&limit=10", nil)
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list users: got status %v want %v", rr.Code, http.StatusOK)
+	}
+	var users []User
+	if err := json.NewDecoder(rr.Body).Decode(&users); err != nil {
+		t.Fatalf("decoding body: %v", err)
+	}
+	if len(users) != 10 {
+		t.Fatalf("got %d users, want 10", len(users))
+	}
+	for i, u := range users {
+		if u.Status != "active" {
+			t.Errorf("user %s has status %q, want active", u.ID, u.Status)
+		}
+		if i > 0 && users[i-1].ID >= u.ID {
+			t.Errorf("users not sorted by id: %s before %s", users[i-1].ID, u.ID)
+		}
+	}
+	if link := rr.Header().Get("Link"); !strings.Contains(link, `rel="next"`) {
+		t.Errorf("expected next Link header, got %q", link)
+	}
+
+	req = httptest.NewRequest("GET", "/api/users?limit=1000", nil)
+	rr = httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("oversized limit: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Cursor pagination shared by list endpoints
+// ---------------------------------------------------------------------------
+//
+// List endpoints accept:
+//   limit          page size (default 20, max 100)
+//   cursor         opaque token taken from the previous page's Link header
+//   sort           field name, prefixed with "-" for descending order
+//   created_after  RFC 3339 lower bound (inclusive)
+//   created_before RFC 3339 upper bound (exclusive)
+//
+// Pages are keyset-based: the cursor records the sort key and ID of the last
+// item returned, so items created between requests do not shift later pages.
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+type pageQuery struct {
+	Limit         int
+	Sort          string // field name without the direction prefix
+	Desc          bool
+	Cursor        *pageCursor
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// pageCursor is serialised into the opaque cursor token. SortSpec pins the
+// cursor to the ordering it was issued for.
+type pageCursor struct {
+	SortSpec string `json:"s"`
+	Key      string `json:"k"`
+	ID       string `json:"i"`
+}
+
+func (q pageQuery) sortSpec() string {
+	if q.Desc {
+		return "-" + q.Sort
+	}
+	return q.Sort
+}
+
+// inCreatedRange reports whether t falls inside the requested created_after /
+// created_before window.
+func (q pageQuery) inCreatedRange(t time.Time) bool {
+	if !q.CreatedAfter.IsZero() && t.Before(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !t.Before(q.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// parsePageQuery validates the shared list parameters. sortFields lists the
+// fields the endpoint can sort on; defaultSort uses the same "-field" syntax
+// as the query parameter.
+func parsePageQuery(v url.Values, sortFields []string, defaultSort string) (pageQuery, error) {
+	q := pageQuery{Limit: defaultPageLimit}
+
+	if raw := v.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("limit must be a positive integer")
+		}
+		if n > maxPageLimit {
+			return q, fmt.Errorf("limit must not exceed %d", maxPageLimit)
+		}
+		q.Limit = n
+	}
+
+	spec := v.Get("sort")
+	if spec == "" {
+		spec = defaultSort
+	}
+	q.Desc = strings.HasPrefix(spec, "-")
+	q.Sort = strings.TrimPrefix(spec, "-")
+	known := false
+	for _, f := range sortFields {
+		if f == q.Sort {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return q, fmt.Errorf("sort must be one of %s (prefix with - for descending)", strings.Join(sortFields, ", "))
+	}
+
+	var err error
+	if q.CreatedAfter, err = parseTimeParam(v, "created_after"); err != nil {
+		return q, err
+	}
+	if q.CreatedBefore, err = parseTimeParam(v, "created_before"); err != nil {
+		return q, err
+	}
+
+	if raw := v.Get("cursor"); raw != "" {
+		c, err := decodeCursor(raw)
+		if err != nil {
+			return q, err
+		}
+		if c.SortSpec != q.sortSpec() {
+			return q, fmt.Errorf("cursor was issued for sort=%s", c.SortSpec)
+		}
+		q.Cursor = c
+	}
+	return q, nil
+}
+
+func parseTimeParam(v url.Values, name string) (time.Time, error) {
+	raw := v.Get(name)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+	}
+	return t, nil
+}
+
+func encodeCursor(c pageCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(raw string) (*pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c pageCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.SortSpec == "" {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// paginate orders items by (sort key, ID), skips past the cursor and returns
+// at most q.Limit items. key must return strings whose lexical order matches
+// the field's natural order (see sortKeyTime). The returned
+// cursor is nil on the last page.
+func paginate[T any](items []T, q pageQuery, key func(T, string) string, id func(T) string) ([]T, *pageCursor) {
+	compare := func(ka, ia, kb, ib string) int {
+		c := strings.Compare(ka, kb)
+		if c == 0 {
+			c = strings.Compare(ia, ib)
+		}
+		if q.Desc {
+			c = -c
+		}
+		return c
+	}
+
+	sorted := make([]T, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compare(key(sorted[i], q.Sort), id(sorted[i]), key(sorted[j], q.Sort), id(sorted[j])) < 0
+	})
+
+	start := 0
+	if q.Cursor != nil {
+		start = sort.Search(len(sorted), func(i int) bool {
+			return compare(key(sorted[i], q.Sort), id(sorted[i]), q.Cursor.Key, q.Cursor.ID) > 0
+		})
+	}
+	end := start + q.Limit
+	if end >= len(sorted) {
+		return sorted[start:], nil
+	}
+	page := sorted[start:end]
+	last := page[len(page)-1]
+	return page, &pageCursor{SortSpec: q.sortSpec(), Key: key(last, q.Sort), ID: id(last)}
+}
+
+// setNextLink advertises the next page with an RFC 8288 Link header that
+// repeats the caller's filters and replaces the cursor.
+func setNextLink(w http.ResponseWriter, r *http.Request, next *pageCursor) {
+	if next == nil {
+		return
+	}
+	v := r.URL.Query()
+	v.Set("cursor", encodeCursor(*next))
+	u := url.URL{Path: r.URL.Path, RawQuery: v.Encode()}
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", u.String()))
+}
+
+// sortKeyTime encodes a timestamp as a fixed-width string so that lexical
+// order matches chronological order.
+func sortKeyTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Rule generation
+// ---------------------------------------------------------------------------
+//
+// Every service gets one recording group per SLO and one alert group:
+//
+//   slo:<sli>:ratio_rate<window>             SLI at each alert window and the
+//                                            compliance window
+//   slo:error_budget:<sli>_remaining         1 = untouched, 0 = exhausted
+//   slo:error_budget:<sli>_burn_rate<window> budget burn rate at each long
+//                                            alert window
+//
+// The recorded series keep the service and namespace labels, so dashboards
+// query one name across all services.
+
+type group struct {
+	Comment  string
+	Name     string
+	Interval string
+	Rules    []rule
+}
+
+type rule struct {
+	Comment     string
+	Record      string
+	Alert       string
+	Expr        string
+	For         string
+	Labels      []label
+	Annotations []label
+}
+
+type label struct{ Name, Value string }
+
+const sliGrouping = "sum by (service, namespace)"
+
+// generate renders the rules file for s.
+func generate(s *spec) string {
+	var groups []group
+	var summary []string
+	for _, svc := range s.Services {
+		alerts := group{
+			Comment: svc.Name + ": SLO alerts",
+			Name:    "slo." + svc.Name + ".alerts",
+		}
+		for _, o := range svc.SLOs {
+			window := o.Window
+			if window == "" {
+				window = s.Window
+			}
+			x := s.SLIs[o.SLI]
+			summary = append(summary, fmt.Sprintf("%s %s: %s of %s over %s",
+				svc.Name, o.SLI, percent(o.Objective), x.Description, window))
+			groups = append(groups, recordingGroup(s, svc, o, x, window))
+			alerts.Rules = append(alerts.Rules, burnRateAlerts(s, svc, o, x, window)...)
+			alerts.Rules = append(alerts.Rules, budgetAlerts(s, svc, o, x, window)...)
+		}
+		groups = append(groups, alerts)
+	}
+
+	var b strings.Builder
+	banner := "# " + strings.Repeat("=", 77) + "\n"
+	b.WriteString(banner)
+	b.WriteString("# SLO/SLI Recording Rules and Alerts - SRE Observability Platform\n")
+	b.WriteString(banner)
+	b.WriteString("# Code generated by tools/slo-gen from monitoring/slo/slos.yml. DO NOT EDIT.\n")
+	b.WriteString("# Edit the spec and run `make slo-rules` instead.\n")
+	b.WriteString("#\n")
+	b.WriteString("# Implements multi-window, multi-burn-rate alerting based on the Google SRE\n")
+	b.WriteString("# workbook methodology.\n")
+	b.WriteString("#\n")
+	b.WriteString("# SLOs:\n")
+	for _, line := range summary {
+		b.WriteString("#   - " + line + "\n")
+	}
+	b.WriteString(banner)
+	b.WriteString("\ngroups:\n")
+	for i, g := range groups {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		writeGroup(&b, g)
+	}
+	return b.String()
+}
+
+func recordingGroup(s *spec, svc serviceSpec, o sloSpec, x *sli, window string) group {
+	good, _ := withService(x.Good, svc.Name)
+	total, _ := withService(x.Total, svc.Name)
+	target := number(o.Objective)
+	sel := `{service="` + svc.Name + `"}`
+
+	g := group{
+		Comment:  fmt.Sprintf("%s: %s SLO (%s over %s)", svc.Name, o.SLI, percent(o.Objective), window),
+		Name:     "slo." + svc.Name + "." + o.SLI,
+		Interval: "30s",
+	}
+	for i, w := range sortedWindows(append(alertWindows(s, false), window)) {
+		r := rule{
+			Record: fmt.Sprintf("slo:%s:ratio_rate%s", o.SLI, w),
+			Expr: fmt.Sprintf("%s (rate(%s[%s]))\n/\n%s (rate(%s[%s]))",
+				sliGrouping, good, w, sliGrouping, total, w),
+		}
+		if i == 0 {
+			r.Comment = "SLI: ratio of " + x.Description
+		}
+		g.Rules = append(g.Rules, r)
+	}
+
+	g.Rules = append(g.Rules, rule{
+		Comment: "Error budget remaining: 1.0 = full budget, 0.0 = exhausted, negative = over budget",
+		Record:  fmt.Sprintf("slo:error_budget:%s_remaining", o.SLI),
+		Expr: fmt.Sprintf("1 - (\n  (1 - slo:%s:ratio_rate%s%s)\n  /\n  (1 - %s)\n)",
+			o.SLI, window, sel, target),
+		Labels: []label{{"slo_target", target}, {"slo_type", o.SLI}},
+	})
+	for i, w := range sortedWindows(alertWindows(s, true)) {
+		r := rule{
+			Record: fmt.Sprintf("slo:error_budget:%s_burn_rate%s", o.SLI, w),
+			Expr:   fmt.Sprintf("(1 - slo:%s:ratio_rate%s%s) / (1 - %s)", o.SLI, w, sel, target),
+			Labels: []label{{"slo_target", target}, {"window", w}},
+		}
+		if i == 0 {
+			r.Comment = "Burn rate: > 1.0 means the budget is consumed faster than sustainable"
+		}
+		g.Rules = append(g.Rules, r)
+	}
+	return g
+}
+
+func burnRateAlerts(s *spec, svc serviceSpec, o sloSpec, x *sli, window string) []rule {
+	target := number(o.Objective)
+	sel := `{service="` + svc.Name + `"}`
+	var rules []rule
+	for _, a := range s.BurnRateAlerts {
+		var clauses, rates []string
+		for _, w := range a.Windows {
+			threshold := fmt.Sprintf("< (1 - %s * (1 - %s))", number(w.Factor), target)
+			clauses = append(clauses, fmt.Sprintf("(\n  slo:%[1]s:ratio_rate%[2]s%[4]s %[5]s\n  and\n  slo:%[1]s:ratio_rate%[3]s%[4]s %[5]s\n)",
+				o.SLI, w.Long, w.Short, sel, threshold))
+			rates = append(rates, fmt.Sprintf("%sx over %s/%s", number(w.Factor), w.Long, w.Short))
+		}
+		r := rule{
+			Comment: fmt.Sprintf("%s %s budget burn: %s", title(a.Severity), o.SLI, strings.Join(rates, " or ")),
+			Alert:   x.AlertPrefix + a.Name,
+			Expr:    strings.Join(clauses, "\nor\n"),
+			For:     a.For,
+			Labels:  alertLabels(a.Severity, svc, o),
+			Annotations: []label{
+				{"summary", fmt.Sprintf("%s %s budget burn rate for {{ $labels.service }}", title(a.Severity), o.SLI)},
+				{"description", fmt.Sprintf("Service {{ $labels.service }} in namespace {{ $labels.namespace }} is\n"+
+					"burning its %s error budget at more than %s.\n"+
+					"Current %s SLI (%s): {{ $value | humanizePercentage }}\n"+
+					"SLO target: %s of %s over %s\n",
+					o.SLI, strings.Join(rates, " or "), o.SLI, a.Windows[0].Long, percent(o.Objective), x.Description, window)},
+			},
+		}
+		r.Annotations = append(r.Annotations, links(s, a.Runbook, svc)...)
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func budgetAlerts(s *spec, svc serviceSpec, o sloSpec, x *sli, window string) []rule {
+	alerts := append([]budgetAlert(nil), s.BudgetAlerts...)
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].Below < alerts[j].Below })
+
+	remaining := fmt.Sprintf(`slo:error_budget:%s_remaining{service="%s"}`, o.SLI, svc.Name)
+	var rules []rule
+	for i, a := range alerts {
+		expr := remaining + " < " + number(a.Below)
+		state := fmt.Sprintf("has less than %s of its %s %s error budget left", percent(a.Below), window, o.SLI)
+		if i > 0 {
+			expr += "\nand\n" + remaining + " >= " + number(alerts[i-1].Below)
+		}
+		if a.Below == 0 {
+			state = fmt.Sprintf("has exhausted its %s %s error budget", window, o.SLI)
+		}
+		r := rule{
+			Comment: fmt.Sprintf("%s error budget below %s", title(o.SLI), percent(a.Below)),
+			Alert:   x.AlertPrefix + a.Name,
+			Expr:    expr,
+			For:     a.For,
+			Labels:  alertLabels(a.Severity, svc, o),
+			Annotations: []label{
+				{"summary", fmt.Sprintf("%s error budget %s for {{ $labels.service }}", title(o.SLI), strings.ToLower(a.Name))},
+				{"description", fmt.Sprintf("Service {{ $labels.service }} in namespace {{ $labels.namespace }}\n"+
+					"%s (SLO: %s of %s).\n"+
+					"Remaining budget: {{ $value | humanizePercentage }}.\n",
+					state, percent(o.Objective), x.Description)},
+			},
+		}
+		r.Annotations = append(r.Annotations, links(s, a.Runbook, svc)...)
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func alertLabels(severity string, svc serviceSpec, o sloSpec) []label {
+	team := svc.Team
+	if team == "" {
+		team = "platform"
+	}
+	return []label{
+		{"severity", severity},
+		{"team", team},
+		{"category", "slo"},
+		{"slo", o.SLI},
+		{"slo_target", percent(o.Objective)},
+	}
+}
+
+func links(s *spec, runbook string, svc serviceSpec) []label {
+	var out []label
+	if s.RunbookBaseURL != "" {
+		out = append(out, label{"runbook_url", strings.TrimSuffix(s.RunbookBaseURL, "/") + "/" + runbook})
+	}
+	if s.DashboardURL != "" {
+		out = append(out, label{"dashboard_url", s.DashboardURL + "?var-service=" + svc.Name})
+	}
+	return out
+}
+
+// alertWindows lists the burn-rate windows, only the long ones if longOnly.
+func alertWindows(s *spec, longOnly bool) []string {
+	var out []string
+	for _, a := range s.BurnRateAlerts {
+		for _, w := range a.Windows {
+			out = append(out, w.Long)
+			if !longOnly {
+				out = append(out, w.Short)
+			}
+		}
+	}
+	return out
+}
+
+// sortedWindows dedupes windows and orders them from shortest to longest.
+// The spec has been validated, so every window parses.
+func sortedWindows(windows []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, w := range windows {
+		if !seen[w] {
+			seen[w] = true
+			out = append(out, w)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, _ := parseDuration(out[i])
+		b, _ := parseDuration(out[j])
+		return a < b
+	})
+	return out
+}
+
+func number(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// percent formats a ratio as a percentage, e.g. 0.999 as 99.9%.
+func percent(f float64) string {
+	return number(math.Round(f*1e6)/1e4) + "%"
+}
+
+func title(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// ---------------------------------------------------------------------------
+// YAML output
+// ---------------------------------------------------------------------------
+
+var plainScalar = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+func writeGroup(b *strings.Builder, g group) {
+	b.WriteString("  # " + strings.Repeat("-", 75) + "\n")
+	b.WriteString("  # " + g.Comment + "\n")
+	b.WriteString("  # " + strings.Repeat("-", 75) + "\n")
+	b.WriteString("  - name: " + g.Name + "\n")
+	if g.Interval != "" {
+		b.WriteString("    interval: " + g.Interval + "\n")
+	}
+	b.WriteString("    rules:\n")
+	for i, r := range g.Rules {
+		if i > 0 && r.Comment != "" {
+			b.WriteString("\n")
+		}
+		if r.Comment != "" {
+			b.WriteString("      # " + r.Comment + "\n")
+		}
+		if r.Record != "" {
+			b.WriteString("      - record: " + r.Record + "\n")
+		} else {
+			b.WriteString("      - alert: " + r.Alert + "\n")
+		}
+		b.WriteString("        expr: |\n")
+		for _, line := range strings.Split(r.Expr, "\n") {
+			b.WriteString("          " + line + "\n")
+		}
+		if r.For != "" {
+			b.WriteString("        for: " + r.For + "\n")
+		}
+		writeLabels(b, "labels", r.Labels)
+		writeLabels(b, "annotations", r.Annotations)
+	}
+}
+
+func writeLabels(b *strings.Builder, key string, labels []label) {
+	if len(labels) == 0 {
+		return
+	}
+	b.WriteString("        " + key + ":\n")
+	for _, l := range labels {
+		switch {
+		case strings.Contains(l.Value, "\n"):
+			b.WriteString("          " + l.Name + ": |\n")
+			for _, line := range strings.Split(strings.TrimSuffix(l.Value, "\n"), "\n") {
+				b.WriteString("            " + line + "\n")
+			}
+		case plainScalar.MatchString(l.Value):
+			b.WriteString("          " + l.Name + ": " + l.Value + "\n")
+		default:
+			b.WriteString("          " + l.Name + ": " + strconv.Quote(l.Value) + "\n")
+		}
+	}
+}
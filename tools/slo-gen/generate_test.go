@@ -0,0 +1,181 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	specFile  = "../../monitoring/slo/slos.yml"
+	rulesFile = "../../monitoring/prometheus/rules/slo-rules.yml"
+)
+
+func TestCommittedRulesUpToDate(t *testing.T) {
+	s, err := loadSpec(specFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := os.ReadFile(rulesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := diff(string(committed), generate(s)); d != "" {
+		t.Errorf("%s is out of date; run `make slo-rules`:\n%s", rulesFile, d)
+	}
+}
+
+type ruleFile struct {
+	Groups []struct {
+		Name  string `yaml:"name"`
+		Rules []struct {
+			Record string            `yaml:"record"`
+			Alert  string            `yaml:"alert"`
+			Expr   string            `yaml:"expr"`
+			Labels map[string]string `yaml:"labels"`
+		} `yaml:"rules"`
+	} `yaml:"groups"`
+}
+
+// recordedRef matches a reference to a generated recording rule.
+var recordedRef = regexp.MustCompile(`(slo:[a-z_]+:[a-z_0-9]+)\{service="([^"]+)"\}`)
+
+func TestGeneratedRulesReferenceRecordedSeries(t *testing.T) {
+	s, err := loadSpec(specFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A service added to the spec gets rules without any PromQL of its own.
+	s.Services = append(s.Services, serviceSpec{
+		Name: "inventory-service",
+		SLOs: []sloSpec{{SLI: "availability", Objective: 0.995, Window: "7d"}},
+	})
+	if err := s.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var f ruleFile
+	if err := yaml.Unmarshal([]byte(generate(s)), &f); err != nil {
+		t.Fatalf("generated rules are not valid YAML: %v", err)
+	}
+
+	// Group names must be unique and every referenced series recorded for
+	// the same service.
+	groups := map[string]bool{}
+	recorded := map[string]bool{}
+	for _, g := range f.Groups {
+		if groups[g.Name] {
+			t.Errorf("duplicate group %s", g.Name)
+		}
+		groups[g.Name] = true
+		svc := strings.Split(g.Name, ".")[1]
+		for _, r := range g.Rules {
+			if r.Record != "" {
+				recorded[r.Record+"/"+svc] = true
+			}
+		}
+	}
+	alerts := map[string]int{}
+	for _, g := range f.Groups {
+		for _, r := range g.Rules {
+			if r.Expr == "" {
+				t.Errorf("%s%s: empty expr", r.Record, r.Alert)
+			}
+			for _, m := range recordedRef.FindAllStringSubmatch(r.Expr, -1) {
+				if !recorded[m[1]+"/"+m[2]] {
+					t.Errorf("%s%s references %s for %s, which is not recorded", r.Record, r.Alert, m[1], m[2])
+				}
+			}
+			if r.Alert != "" {
+				alerts[r.Alert]++
+			}
+		}
+	}
+
+	for _, name := range []string{"slo.inventory-service.availability", "slo.inventory-service.alerts"} {
+		if !groups[name] {
+			t.Errorf("missing group %s", name)
+		}
+	}
+	if !recorded["slo:availability:ratio_rate7d/inventory-service"] {
+		t.Error("the SLO's own window is not recorded")
+	}
+	// One of each alert per service and SLO: 4 services hold availability.
+	for _, name := range []string{"ErrorBudgetBurnRateCritical", "ErrorBudgetSlowBurn", "ErrorBudgetExhausted", "ErrorBudgetLow"} {
+		if alerts[name] != 4 {
+			t.Errorf("%s generated %d times, want 4", name, alerts[name])
+		}
+	}
+	if alerts["LatencyBudgetBurnRateCritical"] != 3 {
+		t.Errorf("LatencyBudgetBurnRateCritical generated %d times, want 3", alerts["LatencyBudgetBurnRateCritical"])
+	}
+}
+
+func TestWithService(t *testing.T) {
+	tests := []struct {
+		sel, want string
+		err       bool
+	}{
+		{"http_requests_total", `http_requests_total{service="svc"}`, false},
+		{`http_requests_total{status!~"5.."}`, `http_requests_total{service="svc",status!~"5.."}`, false},
+		{`http_requests_total{}`, `http_requests_total{service="svc"}`, false},
+		{`http_requests_total{service="other"}`, "", true},
+		{`http_requests_total{status="500"`, "", true},
+		{`rate(http_requests_total[5m])`, "", true},
+		{`http_requests_total{status_code=~"5.."}`, "", true},
+		{`http_request_duration_seconds_bucket{le="0.5",path="/api/orders"}`, `http_request_duration_seconds_bucket{service="svc",le="0.5",path="/api/orders"}`, false},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := withService(tt.sel, "svc")
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("withService(%q) = %q, %v", tt.sel, got, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*spec)
+		want   string
+	}{
+		{"unknown sli", func(s *spec) { s.Services[0].SLOs[0].SLI = "throughput" }, `unknown sli "throughput"`},
+		{"objective as percent", func(s *spec) { s.Services[0].SLOs[0].Objective = 99.9 }, "objective must be a ratio"},
+		{"duplicate service", func(s *spec) { s.Services = append(s.Services, s.Services[0]) }, "name must be set and unique"},
+		{"bad window", func(s *spec) { s.Window = "30 days" }, "invalid duration"},
+		{"inverted windows", func(s *spec) { s.BurnRateAlerts[0].Windows[0].Short = "2h" }, "short window must be shorter"},
+		{"unfireable burn rate", func(s *spec) { s.Services[0].SLOs[0].Objective = 0.9 }, "can never fire"},
+		{"bad selector", func(s *spec) { s.SLIs["availability"].Good = `sum(http_requests_total)` }, "want a metric name"},
+		{"label the services lack", func(s *spec) { s.SLIs["availability"].Good = `http_requests_total{status_code!~"5.."}` }, `do not emit a "status_code" label`},
+		{"unnamed burn rate alert", func(s *spec) { s.BurnRateAlerts[1].Name = "" }, "must be a unique CamelCase word"},
+		{"burn rate alert named like a budget alert", func(s *spec) { s.BurnRateAlerts[0].Name = "Low" }, "must be a unique CamelCase word"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := loadSpec(specFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.mutate(s)
+			err = s.validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	if d := diff("a\nb\n", "a\nb\n"); d != "" {
+		t.Errorf("equal inputs: %q", d)
+	}
+	got := diff("a\nb\nc\nd\n", "a\nB\nc\nd\ne\n")
+	want := "@@ -2,1 +2,1 @@\n-b\n+B\n@@ -5,0 +5,1 @@\n+e\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
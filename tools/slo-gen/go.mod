@@ -0,0 +1,5 @@
+module github.com/sre-observability-platform/slo-gen
+
+go 1.22
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,112 @@
+// Command slo-gen generates the Prometheus SLO recording rules and
+// multi-window multi-burn-rate alerts from the declarative SLO spec.
+//
+// Usage, from this directory:
+//
+//	go run .           rewrite the rules file from the spec
+//	go run . --check   exit non-zero, with a diff, if the committed rules
+//	                   file differs from what the spec generates
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	specPath := flag.String("spec", "../../monitoring/slo/slos.yml", "SLO spec to read")
+	outPath := flag.String("out", "../../monitoring/prometheus/rules/slo-rules.yml", "rules file to write or check")
+	check := flag.Bool("check", false, "compare the rules file with the generated output instead of writing it")
+	flag.Parse()
+
+	s, err := loadSpec(*specPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "slo-gen:", err)
+		os.Exit(2)
+	}
+	out := generate(s)
+
+	if !*check {
+		if err := os.WriteFile(*outPath, []byte(out), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, "slo-gen:", err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	committed, err := os.ReadFile(*outPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "slo-gen:", err)
+		os.Exit(2)
+	}
+	if d := diff(string(committed), out); d != "" {
+		fmt.Fprintf(os.Stderr, "slo-gen: %s is out of date with %s; run `make slo-rules`\n", *outPath, *specPath)
+		fmt.Fprintf(os.Stderr, "--- %s (committed)\n+++ %s (generated)\n%s", *outPath, *outPath, d)
+		os.Exit(1)
+	}
+}
+
+// diff returns the lines that differ between a and b as unified-diff hunks
+// without context, or "" if they are equal.
+func diff(a, b string) string {
+	if a == b {
+		return ""
+	}
+	x, y := strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	var del, add []string
+	hunkX, hunkY := 0, 0
+	flush := func() {
+		if len(del)+len(add) > 0 {
+			fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", hunkX+1, len(del), hunkY+1, len(add))
+			for _, l := range del {
+				out.WriteString("-" + strings.TrimSuffix(l, "\n") + "\n")
+			}
+			for _, l := range add {
+				out.WriteString("+" + strings.TrimSuffix(l, "\n") + "\n")
+			}
+		}
+		del, add = nil, nil
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			flush()
+			i, j = i+1, j+1
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			if len(del)+len(add) == 0 {
+				hunkX, hunkY = i, j
+			}
+			del = append(del, x[i])
+			i++
+		default:
+			if len(del)+len(add) == 0 {
+				hunkX, hunkY = i, j
+			}
+			add = append(add, y[j])
+			j++
+		}
+	}
+	flush()
+	return out.String()
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ---------------------------------------------------------------------------
+// SLO spec
+// ---------------------------------------------------------------------------
+//
+// The spec (monitoring/slo/slos.yml) names the SLIs once, as a good and a
+// total selector, and lists the services held to them with an objective.
+// Burn-rate and budget alert policies are shared by every SLO.
+
+type spec struct {
+	Window         string          `yaml:"window"`
+	RunbookBaseURL string          `yaml:"runbook_base_url"`
+	DashboardURL   string          `yaml:"dashboard_url"`
+	BurnRateAlerts []burnRateAlert `yaml:"burn_rate_alerts"`
+	BudgetAlerts   []budgetAlert   `yaml:"budget_alerts"`
+	SLIs           map[string]*sli `yaml:"slis"`
+	Services       []serviceSpec   `yaml:"services"`
+}
+
+type burnRateAlert struct {
+	Name     string       `yaml:"name"`
+	Severity string       `yaml:"severity"`
+	For      string       `yaml:"for"`
+	Runbook  string       `yaml:"runbook"`
+	Windows  []burnWindow `yaml:"windows"`
+}
+
+type burnWindow struct {
+	Long   string  `yaml:"long"`
+	Short  string  `yaml:"short"`
+	Factor float64 `yaml:"factor"`
+}
+
+type budgetAlert struct {
+	Name     string  `yaml:"name"`
+	Severity string  `yaml:"severity"`
+	Below    float64 `yaml:"below"`
+	For      string  `yaml:"for"`
+	Runbook  string  `yaml:"runbook"`
+}
+
+type sli struct {
+	Description string `yaml:"description"`
+	AlertPrefix string `yaml:"alert_prefix"`
+	Good        string `yaml:"good"`
+	Total       string `yaml:"total"`
+}
+
+type serviceSpec struct {
+	Name string    `yaml:"name"`
+	Team string    `yaml:"team"`
+	SLOs []sloSpec `yaml:"slos"`
+}
+
+type sloSpec struct {
+	SLI       string  `yaml:"sli"`
+	Objective float64 `yaml:"objective"`
+	Window    string  `yaml:"window"`
+}
+
+func loadSpec(path string) (*spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var s spec
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &s, nil
+}
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	sliNameRe    = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	alertNameRe  = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+	serviceRe    = regexp.MustCompile(`(^|,)\s*service\s*(=|!=|=~|!~)`)
+	matcherRe    = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*"`)
+)
+
+// httpLabels are the labels on the services' http_request* metrics, plus
+// the target labels Prometheus adds. A matcher on any other label selects
+// nothing, and an SLI built on it never moves.
+var httpLabels = map[string]bool{
+	"method": true, "path": true, "status": true, "le": true,
+	"namespace": true, "job": true, "instance": true,
+}
+
+func (s *spec) validate() error {
+	if _, err := parseDuration(s.Window); err != nil {
+		return fmt.Errorf("window: %w", err)
+	}
+	if len(s.BurnRateAlerts) == 0 {
+		return fmt.Errorf("burn_rate_alerts: at least one alert is required")
+	}
+	seen := map[string]bool{}
+	for i, a := range s.BurnRateAlerts {
+		if err := checkAlertPolicy(a.Severity, a.For, a.Runbook); err != nil {
+			return fmt.Errorf("burn_rate_alerts[%d]: %w", i, err)
+		}
+		if !alertNameRe.MatchString(a.Name) || seen[a.Name] {
+			return fmt.Errorf("burn_rate_alerts[%d]: name %q must be a unique CamelCase word", i, a.Name)
+		}
+		seen[a.Name] = true
+		if len(a.Windows) == 0 {
+			return fmt.Errorf("burn_rate_alerts[%d]: at least one window is required", i)
+		}
+		for j, w := range a.Windows {
+			long, err := parseDuration(w.Long)
+			if err != nil {
+				return fmt.Errorf("burn_rate_alerts[%d].windows[%d].long: %w", i, j, err)
+			}
+			short, err := parseDuration(w.Short)
+			if err != nil {
+				return fmt.Errorf("burn_rate_alerts[%d].windows[%d].short: %w", i, j, err)
+			}
+			if short >= long {
+				return fmt.Errorf("burn_rate_alerts[%d].windows[%d]: short window must be shorter than long", i, j)
+			}
+			if w.Factor <= 0 {
+				return fmt.Errorf("burn_rate_alerts[%d].windows[%d]: factor must be positive", i, j)
+			}
+		}
+	}
+	for i, a := range s.BudgetAlerts {
+		if err := checkAlertPolicy(a.Severity, a.For, a.Runbook); err != nil {
+			return fmt.Errorf("budget_alerts[%d]: %w", i, err)
+		}
+		if !alertNameRe.MatchString(a.Name) || seen[a.Name] {
+			return fmt.Errorf("budget_alerts[%d]: name %q must be a unique CamelCase word", i, a.Name)
+		}
+		seen[a.Name] = true
+		if a.Below < 0 || a.Below >= 1 {
+			return fmt.Errorf("budget_alerts[%d]: below must be in [0, 1)", i)
+		}
+	}
+
+	for name, x := range s.SLIs {
+		if !sliNameRe.MatchString(name) {
+			return fmt.Errorf("slis: %q is not a valid recording rule name part", name)
+		}
+		if x == nil || x.Description == "" || !alertNameRe.MatchString(x.AlertPrefix) {
+			return fmt.Errorf("slis.%s: description and a CamelCase alert_prefix are required", name)
+		}
+		for field, sel := range map[string]string{"good": x.Good, "total": x.Total} {
+			if _, err := withService(sel, "x"); err != nil {
+				return fmt.Errorf("slis.%s.%s: %w", name, field, err)
+			}
+		}
+	}
+
+	names := map[string]bool{}
+	for i, svc := range s.Services {
+		if svc.Name == "" || names[svc.Name] {
+			return fmt.Errorf("services[%d]: name must be set and unique", i)
+		}
+		names[svc.Name] = true
+		if len(svc.SLOs) == 0 {
+			return fmt.Errorf("services.%s: no slos", svc.Name)
+		}
+		slis := map[string]bool{}
+		for _, o := range svc.SLOs {
+			if s.SLIs[o.SLI] == nil {
+				return fmt.Errorf("services.%s: unknown sli %q", svc.Name, o.SLI)
+			}
+			if slis[o.SLI] {
+				return fmt.Errorf("services.%s: sli %q is listed twice", svc.Name, o.SLI)
+			}
+			slis[o.SLI] = true
+			if o.Objective <= 0 || o.Objective >= 1 {
+				return fmt.Errorf("services.%s.%s: objective must be a ratio in (0, 1), got %v", svc.Name, o.SLI, o.Objective)
+			}
+			if o.Window != "" {
+				if _, err := parseDuration(o.Window); err != nil {
+					return fmt.Errorf("services.%s.%s: window: %w", svc.Name, o.SLI, err)
+				}
+			}
+			// A burn rate whose threshold is at or below zero can never fire.
+			for _, a := range s.BurnRateAlerts {
+				for _, w := range a.Windows {
+					if w.Factor*(1-o.Objective) >= 1 {
+						return fmt.Errorf("services.%s.%s: a %vx burn rate can never fire at objective %v", svc.Name, o.SLI, w.Factor, o.Objective)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkAlertPolicy(severity, forDuration, runbook string) error {
+	switch severity {
+	case "critical", "warning", "info":
+	default:
+		return fmt.Errorf("severity must be critical, warning or info, got %q", severity)
+	}
+	if _, err := parseDuration(forDuration); err != nil {
+		return fmt.Errorf("for: %w", err)
+	}
+	if runbook == "" {
+		return fmt.Errorf("runbook is required")
+	}
+	return nil
+}
+
+// withService returns sel with a service="name" matcher added in front of
+// any it already has. sel must be a metric name with optional matchers.
+func withService(sel, name string) (string, error) {
+	metric, matchers := sel, ""
+	if i := strings.IndexByte(sel, '{'); i >= 0 {
+		if !strings.HasSuffix(sel, "}") {
+			return "", fmt.Errorf("selector %q: unterminated matchers", sel)
+		}
+		metric, matchers = sel[:i], strings.TrimSpace(sel[i+1:len(sel)-1])
+	}
+	if !metricNameRe.MatchString(metric) {
+		return "", fmt.Errorf("selector %q: want a metric name with optional {matchers}", sel)
+	}
+	if serviceRe.MatchString(matchers) {
+		return "", fmt.Errorf("selector %q: the service matcher is added per service", sel)
+	}
+	if strings.HasPrefix(metric, "http_request") {
+		for _, m := range matcherRe.FindAllStringSubmatch(matchers, -1) {
+			if !httpLabels[m[1]] {
+				return "", fmt.Errorf("selector %q: the services do not emit a %q label", sel, m[1])
+			}
+		}
+	}
+	out := metric + `{service="` + name + `"`
+	if matchers != "" {
+		out += "," + matchers
+	}
+	return out + "}", nil
+}
+
+// parseDuration reads a Prometheus duration such as 5m, 6h or 30d. Only a
+// single unit is accepted, so the string can be used in rule names as is.
+func parseDuration(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		's': time.Second,
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+	if len(s) < 2 || units[s[len(s)-1]] == 0 {
+		return 0, fmt.Errorf("invalid duration %q (want e.g. 5m, 6h or 30d)", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid duration %q (want e.g. 5m, 6h or 30d)", s)
+	}
+	return time.Duration(n) * units[s[len(s)-1]], nil
+}